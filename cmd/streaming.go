@@ -3,10 +3,14 @@ package main
 import (
 	"flag"
 	"log"
+	"net/http"
 	"os"
 
+	"github.com/juanvallejo/streaming-server/pkg/account"
+	"github.com/juanvallejo/streaming-server/pkg/account/oidc"
 	"github.com/juanvallejo/streaming-server/pkg/playback"
 	"github.com/juanvallejo/streaming-server/pkg/server"
+	"github.com/juanvallejo/streaming-server/pkg/server/cors"
 	"github.com/juanvallejo/streaming-server/pkg/socket"
 	"github.com/juanvallejo/streaming-server/pkg/socket/client"
 	"github.com/juanvallejo/streaming-server/pkg/socket/cmd"
@@ -18,8 +22,52 @@ import (
 func main() {
 	port := flag.String("port", "8080", "default port to listen on")
 	authz := flag.Bool("rbac", false, "enable role-based access control for request commands.")
+	roleFile := flag.String("roles", "", "path to a JSON file defining additional rbac roles and rules (requires --rbac).")
+	accountFile := flag.String("accounts", "", "enable user accounts, stored in an embedded database at the given path.")
+	oidcFile := flag.String("oidc", "", "path to a JSON file configuring an OpenID Connect identity provider to log in to user accounts with.")
+	oidcMock := flag.String("oidc-mock", "", "serve a mock OpenID Connect identity provider on the given address (host:port), and log in through it.")
+	pingInterval := flag.Duration("ping-interval", connection.PingInterval, "interval between heartbeat pings sent to each socket connection (0 disables heartbeats).")
+	pongTimeout := flag.Duration("pong-timeout", connection.PongTimeout, "amount of time a socket connection may go without answering a heartbeat before it is disconnected.")
+	resumeGrace := flag.Duration("resume-grace", connection.ResumeGracePeriod, "amount of time a disconnected client may reconnect within and resume its session (0 disables resuming).")
+	rateLimits := flag.String("rate-limits", "", "comma-separated per-connection message rate limits of the form <event>=<rate>/<burst>, overriding the defaults (\"*\" applies to every event, \"command\" to chat commands, and a rate of 0 lifts a limit).")
+	ipRateLimits := flag.String("ip-rate-limits", "", "comma-separated message rate limits shared by every connection from the same address, of the same form as --rate-limits (defaults to 4 times the per-connection limits).")
+	floodMuteStrikes := flag.Int("flood-mute-strikes", connection.FloodMuteStrikes, "amount of rate limited messages within 10 seconds after which a connection is muted (0 disables muting).")
+	floodMuteDuration := flag.Duration("flood-mute-duration", connection.FloodMuteDuration, "amount of time connections are muted for flooding.")
+	floodDisconnectStrikes := flag.Int("flood-disconnect-strikes", connection.FloodDisconnectStrikes, "amount of rate limited messages within 10 seconds after which a connection is disconnected (0 disables disconnecting).")
+	allowedOrigins := flag.String("allowed-origins", "", "comma-separated origins (scheme://host[:port]), besides the server's own, allowed to open socket connections and request the api with credentials; \"*\" allows any other origin, without credentials.")
 	flag.Parse()
 
+	if *pingInterval > 0 && *pongTimeout <= *pingInterval {
+		log.Fatalf("ERR SOCKET --pong-timeout (%v) must be greater than --ping-interval (%v)\n", *pongTimeout, *pingInterval)
+	}
+	connection.PingInterval = *pingInterval
+	connection.PongTimeout = *pongTimeout
+	connection.ResumeGracePeriod = *resumeGrace
+
+	limits, err := connection.ParseRateLimits(*rateLimits)
+	if err != nil {
+		log.Fatalf("ERR SOCKET --rate-limits: %v\n", err)
+	}
+	for event, l := range limits {
+		connection.RateLimits[event] = l
+	}
+	if len(*ipRateLimits) > 0 {
+		ipLimits, err := connection.ParseRateLimits(*ipRateLimits)
+		if err != nil {
+			log.Fatalf("ERR SOCKET --ip-rate-limits: %v\n", err)
+		}
+		connection.IPRateLimits = ipLimits
+	}
+	connection.FloodMuteStrikes = *floodMuteStrikes
+	connection.FloodMuteDuration = *floodMuteDuration
+	connection.FloodDisconnectStrikes = *floodDisconnectStrikes
+
+	origins, err := cors.ParseOrigins(*allowedOrigins)
+	if err != nil {
+		log.Fatalf("ERR HTTP --allowed-origins: %v\n", err)
+	}
+	cors.AllowedOrigins = origins
+
 	nsHandler := connection.NewNamespaceHandler()
 	connHandler := connection.NewHandler(nsHandler)
 	cmdHandler := cmd.NewHandler()
@@ -30,9 +78,77 @@ func main() {
 		authorizer := rbac.NewAuthorizer()
 		cmd.AddDefaultRoles(authorizer)
 
+		if len(*roleFile) > 0 {
+			defs, err := rbac.LoadRoleDefinitions(*roleFile)
+			if err != nil {
+				log.Fatalf("ERR AUTHZ unable to load role definitions: %v\n", err)
+			}
+			if err := rbac.ApplyRoleDefinitions(authorizer, defs); err != nil {
+				log.Fatalf("ERR AUTHZ invalid role definitions in %q: %v\n", *roleFile, err)
+			}
+			log.Printf("INF AUTHZ loaded %v role definitions from %q\n", len(defs), *roleFile)
+		}
+
 		connHandler = connection.NewHandlerWithRBAC(authorizer, nsHandler)
 		cmdHandler = cmd.NewHandlerWithRBAC(authorizer)
 
+	} else if len(*roleFile) > 0 {
+		log.Printf("WRN AUTHZ role definitions in %q ignored: rbac authorization is not enabled.\n", *roleFile)
+	}
+
+	if len(*accountFile) > 0 {
+		store, err := account.NewBoltStore(*accountFile)
+		if err != nil {
+			log.Fatalf("ERR ACCOUNT unable to open account store: %v\n", err)
+		}
+
+		accounts := account.NewManager(store)
+		connHandler.SetAccounts(accounts)
+		cmdHandler.SetAccounts(accounts)
+		log.Printf("INF ACCOUNT user accounts enabled, stored in %q.\n", *accountFile)
+	}
+
+	if len(*oidcFile) > 0 || len(*oidcMock) > 0 {
+		var config *oidc.Config
+		if len(*oidcFile) > 0 {
+			c, err := oidc.LoadConfig(*oidcFile)
+			if err != nil {
+				log.Fatalf("ERR OIDC unable to load identity provider configuration: %v\n", err)
+			}
+			config = c
+		}
+
+		if len(*oidcMock) > 0 {
+			clientId, clientSecret := oidc.MOCK_CLIENT_ID, oidc.MOCK_CLIENT_SECRET
+			if config != nil {
+				clientId, clientSecret = config.ClientID, config.ClientSecret
+			}
+
+			mock, err := oidc.NewMockProvider("http://"+*oidcMock, clientId, clientSecret, nil)
+			if err != nil {
+				log.Fatalf("ERR OIDC unable to start mock identity provider: %v\n", err)
+			}
+			if config == nil {
+				config = mock.Config("http://localhost:" + *port + "/api/auth/oidc/callback")
+			}
+
+			go func() {
+				log.Fatal(http.ListenAndServe(*oidcMock, mock))
+			}()
+			log.Printf("WRN OIDC serving a mock identity provider at %q. Anyone can log in as any of its users.\n", mock.Issuer())
+		}
+
+		// accounts logged in to through a provider are kept
+		// in memory if no account store was given
+		if connHandler.Accounts() == nil {
+			accounts := account.NewManager(account.NewMemoryStore())
+			connHandler.SetAccounts(accounts)
+			cmdHandler.SetAccounts(accounts)
+			log.Printf("WRN ACCOUNT user accounts enabled for identity provider logins, but not persisted: no account store given (--accounts).\n")
+		}
+
+		connHandler.SetIdentityProvider(oidc.NewProvider(config))
+		log.Printf("INF OIDC logging in through identity provider %q enabled.\n", config.Issuer)
 	}
 
 	socketHandler := socket.NewHandler(
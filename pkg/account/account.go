@@ -0,0 +1,253 @@
+package account
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/juanvallejo/streaming-server/pkg/validation"
+)
+
+const (
+	// SESSION_COOKIE_NAME is the name of the cookie holding a session token
+	SESSION_COOKIE_NAME = "flicktrack_io_session"
+
+	MIN_PASSWORD_LENGTH = 8
+	// bcrypt ignores any input past 72 bytes
+	MAX_PASSWORD_LENGTH = 72
+)
+
+// SessionLifetime is the amount of time a session token is valid for
+var SessionLifetime = 24 * time.Hour * 30
+
+// Session associates a token with a logged-in account
+type Session struct {
+	Token    string    `json:"token"`
+	Username string    `json:"username"`
+	Expires  time.Time `json:"expires"`
+}
+
+// Manager registers accounts, and logs them in and out
+type Manager interface {
+	// Register receives a username and password and creates an account.
+	// Returns an error if the username is taken or either value is invalid.
+	Register(string, string) (*Account, error)
+	// Login receives a username and password and returns a new session
+	// for the account. Returns an error if the credentials do not match.
+	Login(string, string) (*Session, error)
+	// LoginExternal receives an identity provider's issuer url, the subject
+	// and username of an identity it asserted, and the names of the roles
+	// mapped to the identity's groups. It creates an account for the identity
+	// if none exists, updates its provider roles, and returns a new session.
+	// Returns an error if the username belongs to a different account.
+	LoginExternal(string, string, string, []string) (*Session, error)
+	// Logout receives a session token and ends the session.
+	// Returns a boolean (false) if no such session exists.
+	Logout(string) bool
+	// Authenticate receives a session token and returns the account it
+	// belongs to, or a boolean (false) if the session is not valid.
+	Authenticate(string) (*Account, bool)
+	// Account receives a username and returns the account by that name
+	Account(string) (*Account, bool)
+	// IsReserved returns a boolean (true) if the given username
+	// belongs to an account, and may only be used by its owner.
+	IsReserved(string) bool
+	// RoomRoles receives a username and a room name and returns the
+	// names of the roles bound to the account in the room, if any,
+	// or else the roles mapped from its identity provider groups
+	RoomRoles(string, string) ([]string, bool)
+	// SetRoomRoles receives a username, room name, and role names,
+	// and stores the roles as bound to the account in the room
+	SetRoomRoles(string, string, []string) error
+}
+
+// ManagerSpec implements Manager. Accounts are persisted through a
+// Store; sessions are kept in memory and end when the server restarts.
+type ManagerSpec struct {
+	store Store
+
+	mux      sync.Mutex
+	sessions map[string]*Session
+}
+
+func (m *ManagerSpec) Register(username, password string) (*Account, error) {
+	if err := validation.ValidateClientUsername(username); err != nil {
+		return nil, err
+	}
+	if len(password) < MIN_PASSWORD_LENGTH || len(password) > MAX_PASSWORD_LENGTH {
+		return nil, fmt.Errorf("passwords must be between %v and %v characters long", MIN_PASSWORD_LENGTH, MAX_PASSWORD_LENGTH)
+	}
+
+	if _, exists := m.store.Get(username); exists {
+		return nil, fmt.Errorf("the username %q is taken", username)
+	}
+
+	// hashing is slow by design; do not hold the lock while hashing
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	// the username may have been taken while hashing
+	if _, exists := m.store.Get(username); exists {
+		return nil, fmt.Errorf("the username %q is taken", username)
+	}
+
+	a := &Account{
+		Username:     username,
+		PasswordHash: hash,
+		Created:      time.Now(),
+		Roles:        make(map[string][]string),
+	}
+	if err := m.store.Put(a); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func (m *ManagerSpec) Login(username, password string) (*Session, error) {
+	a, exists := m.store.Get(username)
+	if !exists {
+		// compare against a fixed hash anyway, so that response
+		// times do not reveal whether an account exists
+		bcrypt.CompareHashAndPassword(unknownAccountHash, []byte(password))
+		return nil, fmt.Errorf("incorrect username or password")
+	}
+	if err := bcrypt.CompareHashAndPassword(a.PasswordHash, []byte(password)); err != nil {
+		return nil, fmt.Errorf("incorrect username or password")
+	}
+
+	return m.newSession(a.Username)
+}
+
+func (m *ManagerSpec) LoginExternal(issuer, subject, username string, roles []string) (*Session, error) {
+	if err := validation.ValidateClientUsername(username); err != nil {
+		return nil, err
+	}
+	if len(issuer) == 0 || len(subject) == 0 {
+		return nil, fmt.Errorf("external identities must have an issuer and subject")
+	}
+
+	m.mux.Lock()
+	a, exists := m.store.Get(username)
+	if !exists {
+		a = &Account{
+			Username: username,
+			Created:  time.Now(),
+			Roles:    make(map[string][]string),
+			Issuer:   issuer,
+			Subject:  subject,
+		}
+	} else if a.Issuer != issuer || a.Subject != subject {
+		m.mux.Unlock()
+		return nil, fmt.Errorf("the username %q belongs to another account", username)
+	}
+
+	a.ProviderRoles = append([]string{}, roles...)
+	err := m.store.Put(a)
+	m.mux.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	return m.newSession(a.Username)
+}
+
+// newSession starts a session for the account by the given name
+func (m *ManagerSpec) newSession(username string) (*Session, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	s := &Session{
+		Token:    hex.EncodeToString(b),
+		Username: username,
+		Expires:  time.Now().Add(SessionLifetime),
+	}
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.sessions[s.Token] = s
+
+	return s, nil
+}
+
+func (m *ManagerSpec) Logout(token string) bool {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if _, exists := m.sessions[token]; !exists {
+		return false
+	}
+	delete(m.sessions, token)
+	return true
+}
+
+func (m *ManagerSpec) Authenticate(token string) (*Account, bool) {
+	m.mux.Lock()
+	s, exists := m.sessions[token]
+	if exists && time.Now().After(s.Expires) {
+		delete(m.sessions, token)
+		exists = false
+	}
+	m.mux.Unlock()
+
+	if !exists {
+		return nil, false
+	}
+
+	// the account may have been removed since the session began
+	return m.store.Get(s.Username)
+}
+
+func (m *ManagerSpec) Account(username string) (*Account, bool) {
+	return m.store.Get(username)
+}
+
+func (m *ManagerSpec) IsReserved(username string) bool {
+	_, exists := m.store.Get(username)
+	return exists
+}
+
+func (m *ManagerSpec) RoomRoles(username, room string) ([]string, bool) {
+	a, exists := m.store.Get(username)
+	if !exists {
+		return nil, false
+	}
+
+	if roles, exists := a.Roles[room]; exists && len(roles) > 0 {
+		return roles, true
+	}
+	return a.ProviderRoles, len(a.ProviderRoles) > 0
+}
+
+func (m *ManagerSpec) SetRoomRoles(username, room string, roles []string) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	a, exists := m.store.Get(username)
+	if !exists {
+		return fmt.Errorf("account %q not found", username)
+	}
+
+	a.Roles[room] = append([]string{}, roles...)
+	return m.store.Put(a)
+}
+
+func NewManager(store Store) Manager {
+	return &ManagerSpec{
+		store:    store,
+		sessions: make(map[string]*Session),
+	}
+}
+
+var unknownAccountHash, _ = bcrypt.GenerateFromPassword([]byte("unknown account"), bcrypt.DefaultCost)
@@ -0,0 +1,147 @@
+package oidc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/juanvallejo/streaming-server/pkg/validation"
+)
+
+const (
+	DEFAULT_USERNAME_CLAIM = "email"
+	DEFAULT_GROUPS_CLAIM   = "groups"
+)
+
+var invalidUsernameChars = regexp.MustCompile("[^a-zA-Z_0-9]+")
+
+// Config describes an OpenID Connect identity provider, and how
+// the claims it issues are mapped to usernames and rbac roles.
+//
+// Example configuration file:
+//
+//	{
+//	  "issuer": "https://accounts.example.com",
+//	  "clientId": "streaming-server",
+//	  "clientSecret": "...",
+//	  "redirectUrl": "https://stream.example.com/api/auth/oidc/callback",
+//	  "usernameClaim": "email",
+//	  "groupsClaim": "groups",
+//	  "groupRoles": {
+//	    "engineering": ["user"],
+//	    "moderators": ["moderator", "user"]
+//	  },
+//	  "defaultRoles": ["viewer"]
+//	}
+type Config struct {
+	// Issuer is the provider's issuer url; its discovery document
+	// is served at <issuer>/.well-known/openid-configuration
+	Issuer       string `json:"issuer"`
+	ClientID     string `json:"clientId"`
+	ClientSecret string `json:"clientSecret"`
+	// RedirectURL is this server's callback endpoint, as
+	// registered with the provider
+	RedirectURL string   `json:"redirectUrl"`
+	Scopes      []string `json:"scopes,omitempty"`
+	// UsernameClaim names the claim a username is derived from.
+	// Email addresses are reduced to their local part. Defaults to "email".
+	UsernameClaim string `json:"usernameClaim,omitempty"`
+	// GroupsClaim names the claim listing a user's groups. Defaults to "groups".
+	GroupsClaim string `json:"groupsClaim,omitempty"`
+	// GroupRoles maps group names to the names of the rbac roles
+	// bound to members of that group in every room
+	GroupRoles map[string][]string `json:"groupRoles,omitempty"`
+	// DefaultRoles are bound to users who belong to no mapped group
+	DefaultRoles []string `json:"defaultRoles,omitempty"`
+}
+
+// Validate returns an error if a required field is missing
+func (c *Config) Validate() error {
+	missing := []string{}
+	if len(c.Issuer) == 0 {
+		missing = append(missing, "issuer")
+	}
+	if len(c.ClientID) == 0 {
+		missing = append(missing, "clientId")
+	}
+	if len(c.RedirectURL) == 0 {
+		missing = append(missing, "redirectUrl")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required fields: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// Username derives a username from a set of claims. Characters not
+// allowed in usernames are replaced with underscores. Returns an error
+// if the configured claim is missing or yields an empty username.
+func (c *Config) Username(claims Claims) (string, error) {
+	claim := c.UsernameClaim
+	if len(claim) == 0 {
+		claim = DEFAULT_USERNAME_CLAIM
+	}
+
+	value, ok := claims.String(claim)
+	if !ok || len(value) == 0 {
+		return "", fmt.Errorf("identity token has no %q claim", claim)
+	}
+
+	if idx := strings.Index(value, "@"); idx > 0 {
+		value = value[:idx]
+	}
+
+	username := strings.Trim(invalidUsernameChars.ReplaceAllString(value, "_"), "_")
+	if len(username) > validation.MaxClientUsernameLength {
+		username = username[:validation.MaxClientUsernameLength]
+	}
+	if len(username) == 0 {
+		return "", fmt.Errorf("unable to derive a username from claim %q", claim)
+	}
+	return username, nil
+}
+
+// Roles returns the names of the rbac roles mapped to the groups
+// listed in a set of claims, or the default roles if none are mapped.
+func (c *Config) Roles(claims Claims) []string {
+	claim := c.GroupsClaim
+	if len(claim) == 0 {
+		claim = DEFAULT_GROUPS_CLAIM
+	}
+
+	roles := []string{}
+	seen := make(map[string]bool)
+	for _, group := range claims.Strings(claim) {
+		for _, role := range c.GroupRoles[group] {
+			if !seen[role] {
+				seen[role] = true
+				roles = append(roles, role)
+			}
+		}
+	}
+
+	if len(roles) == 0 {
+		return append(roles, c.DefaultRoles...)
+	}
+	return roles
+}
+
+// LoadConfig reads a JSON provider configuration file.
+// Returns an error if the file cannot be read, parsed, or is incomplete.
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Config{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("unable to parse identity provider configuration %q: %v", path, err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid identity provider configuration %q: %v", path, err)
+	}
+	return c, nil
+}
@@ -0,0 +1,313 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MOCK_AUTHORIZE_PATH = "/authorize"
+	MOCK_TOKEN_PATH     = "/token"
+	MOCK_KEYS_PATH      = "/keys"
+
+	MOCK_CLIENT_ID     = "streaming-server"
+	MOCK_CLIENT_SECRET = "mock-secret"
+
+	// amount of time an authorization code may be exchanged for
+	mockCodeLifetime = time.Minute
+	// amount of time an identity token issued by the mock is valid for
+	mockTokenLifetime = 5 * time.Minute
+	mockKeyId         = "mock"
+)
+
+// MockUser is an identity known to a MockProvider
+type MockUser struct {
+	Subject string   `json:"sub"`
+	Email   string   `json:"email"`
+	Name    string   `json:"name"`
+	Groups  []string `json:"groups"`
+}
+
+// DefaultMockUsers are the identities served by a mock
+// provider when no other users are given
+var DefaultMockUsers = []MockUser{
+	{Subject: "mock-alice", Email: "alice@example.com", Name: "Alice", Groups: []string{"admins"}},
+	{Subject: "mock-bob", Email: "bob@example.com", Name: "Bob", Groups: []string{"users"}},
+	{Subject: "mock-carol", Email: "carol@example.com", Name: "Carol"},
+}
+
+// mockGrant is an authorization code issued and not yet exchanged
+type mockGrant struct {
+	user        *MockUser
+	nonce       string
+	redirectURI string
+	expires     time.Time
+}
+
+// MockProvider is an in-process OpenID Connect provider, for signing in
+// and testing the login flow without access to a real provider. It
+// serves a discovery document, an authorization endpoint that signs in
+// any known user without a password, a token endpoint, and its signing
+// keys. Implements http.Handler.
+//
+// A user is chosen with the "login_hint" authorization parameter, by
+// email address or subject; if none is given, the user is shown a list
+// of known users to pick from.
+type MockProvider struct {
+	issuer       string
+	clientId     string
+	clientSecret string
+	key          *rsa.PrivateKey
+	users        []MockUser
+
+	mux    sync.Mutex
+	grants map[string]*mockGrant
+}
+
+func (m *MockProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case DISCOVERY_PATH:
+		m.serveDiscovery(w, r)
+	case MOCK_AUTHORIZE_PATH:
+		m.serveAuthorize(w, r)
+	case MOCK_TOKEN_PATH:
+		m.serveToken(w, r)
+	case MOCK_KEYS_PATH:
+		writeMockJSON(w, http.StatusOK, &JSONWebKeySet{
+			Keys: []JSONWebKey{NewJSONWebKey(mockKeyId, &m.key.PublicKey)},
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (m *MockProvider) serveDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeMockJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                m.issuer,
+		"authorization_endpoint":                m.issuer + MOCK_AUTHORIZE_PATH,
+		"token_endpoint":                        m.issuer + MOCK_TOKEN_PATH,
+		"jwks_uri":                              m.issuer + MOCK_KEYS_PATH,
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (m *MockProvider) serveAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("response_type") != "code" {
+		http.Error(w, "unsupported response_type", http.StatusBadRequest)
+		return
+	}
+	if q.Get("client_id") != m.clientId {
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	}
+
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || !redirectURI.IsAbs() {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	hint := q.Get("login_hint")
+	if len(hint) == 0 {
+		m.serveUserList(w, r)
+		return
+	}
+
+	user, found := m.user(hint)
+	if !found {
+		http.Error(w, fmt.Sprintf("unknown user %q", hint), http.StatusBadRequest)
+		return
+	}
+
+	code, err := randomToken()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	m.mux.Lock()
+	m.grants[code] = &mockGrant{
+		user:        user,
+		nonce:       q.Get("nonce"),
+		redirectURI: redirectURI.String(),
+		expires:     time.Now().Add(mockCodeLifetime),
+	}
+	m.mux.Unlock()
+
+	log.Printf("INF OIDC MOCK signed in user %q\n", user.Email)
+
+	params := redirectURI.Query()
+	params.Set("code", code)
+	params.Set("state", q.Get("state"))
+	redirectURI.RawQuery = params.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+// serveUserList shows a page with a sign-in link for each known user
+func (m *MockProvider) serveUserList(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, "<!DOCTYPE html><html><head><title>Mock identity provider</title></head><body><h1>Sign in as</h1><ul>")
+	for _, u := range m.users {
+		q := r.URL.Query()
+		q.Set("login_hint", u.Email)
+		link := MOCK_AUTHORIZE_PATH + "?" + q.Encode()
+		fmt.Fprintf(w, "<li><a href=\"%s\">%s</a> (%s) %s</li>", html.EscapeString(link), html.EscapeString(u.Name), html.EscapeString(u.Email), html.EscapeString(strings.Join(u.Groups, ", ")))
+	}
+	fmt.Fprint(w, "</ul></body></html>")
+}
+
+func (m *MockProvider) serveToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMockTokenError(w, "invalid_request", "unsupported method "+r.Method)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeMockTokenError(w, "invalid_request", err.Error())
+		return
+	}
+
+	clientId, clientSecret, ok := r.BasicAuth()
+	if ok {
+		clientId, _ = url.QueryUnescape(clientId)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientId, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientId != m.clientId || subtle.ConstantTimeCompare([]byte(clientSecret), []byte(m.clientSecret)) != 1 {
+		writeMockTokenError(w, "invalid_client", "unknown client or incorrect secret")
+		return
+	}
+
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		writeMockTokenError(w, "unsupported_grant_type", "")
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	m.mux.Lock()
+	grant, exists := m.grants[code]
+	delete(m.grants, code)
+	m.mux.Unlock()
+
+	if !exists || time.Now().After(grant.expires) {
+		writeMockTokenError(w, "invalid_grant", "unknown or expired authorization code")
+		return
+	}
+	if r.PostForm.Get("redirect_uri") != grant.redirectURI {
+		writeMockTokenError(w, "invalid_grant", "redirect_uri does not match")
+		return
+	}
+
+	now := time.Now()
+	claims := Claims{
+		"iss":            m.issuer,
+		"sub":            grant.user.Subject,
+		"aud":            m.clientId,
+		"iat":            now.Unix(),
+		"exp":            now.Add(mockTokenLifetime).Unix(),
+		"email":          grant.user.Email,
+		"email_verified": true,
+		"name":           grant.user.Name,
+		"groups":         grant.user.Groups,
+	}
+	if len(grant.nonce) > 0 {
+		claims["nonce"] = grant.nonce
+	}
+
+	token, err := SignToken(mockKeyId, m.key, claims)
+	if err != nil {
+		writeMockTokenError(w, "server_error", err.Error())
+		return
+	}
+
+	writeMockJSON(w, http.StatusOK, &tokenResponse{
+		AccessToken: code,
+		IDToken:     token,
+		TokenType:   "Bearer",
+	})
+}
+
+func (m *MockProvider) user(hint string) (*MockUser, bool) {
+	for i := range m.users {
+		if strings.EqualFold(m.users[i].Email, hint) || m.users[i].Subject == hint {
+			return &m.users[i], true
+		}
+	}
+	return nil, false
+}
+
+// Issuer returns the mock provider's issuer url
+func (m *MockProvider) Issuer() string {
+	return m.issuer
+}
+
+// Config returns a provider configuration for signing in through the mock
+// provider, mapping the "admins" and "users" groups of DefaultMockUsers
+// to the "admin" and "user" roles.
+func (m *MockProvider) Config(redirectURL string) *Config {
+	return &Config{
+		Issuer:       m.issuer,
+		ClientID:     m.clientId,
+		ClientSecret: m.clientSecret,
+		RedirectURL:  redirectURL,
+		GroupRoles: map[string][]string{
+			"admins": {"admin"},
+			"users":  {"user"},
+		},
+		DefaultRoles: []string{"viewer"},
+	}
+}
+
+// NewMockProvider receives the url the provider is served at, and the
+// credentials and users it accepts. DefaultMockUsers are used if no users
+// are given. Returns an error if a signing key cannot be generated.
+func NewMockProvider(issuer, clientId, clientSecret string, users []MockUser) (*MockProvider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		users = DefaultMockUsers
+	}
+
+	return &MockProvider{
+		issuer:       strings.TrimSuffix(issuer, "/"),
+		clientId:     clientId,
+		clientSecret: clientSecret,
+		key:          key,
+		users:        users,
+		grants:       make(map[string]*mockGrant),
+	}, nil
+}
+
+func writeMockJSON(w http.ResponseWriter, status int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+func writeMockTokenError(w http.ResponseWriter, code, description string) {
+	writeMockJSON(w, http.StatusBadRequest, &tokenResponse{
+		Error:            code,
+		ErrorDescription: description,
+	})
+}
@@ -0,0 +1,317 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DISCOVERY_PATH = "/.well-known/openid-configuration"
+
+	// LoginTimeout is the amount of time a user has to complete
+	// a login at the provider before its state expires
+	LoginTimeout = 10 * time.Minute
+
+	// how long a provider's key set is cached for before it is fetched again
+	keySetLifetime = time.Hour
+	// max size of a response from a provider
+	maxResponseBytes = 1 << 20
+)
+
+// Identity is a user identity asserted by a provider
+type Identity struct {
+	Issuer   string
+	Subject  string
+	Username string
+	// Roles are the names of the rbac roles mapped to the user's groups
+	Roles  []string
+	Claims Claims
+}
+
+// Provider performs the OpenID Connect authorization code flow
+type Provider interface {
+	// Issuer returns the provider's issuer url
+	Issuer() string
+	// Begin receives a path to return to once the login completes,
+	// and returns the provider url to send the user to
+	Begin(string) (string, error)
+	// Complete receives the state and authorization code passed to the
+	// callback endpoint, exchanges the code for an identity token, and
+	// returns the identity it asserts along with the path to return to.
+	// Returns an error if the state is unknown or expired, or if the
+	// token cannot be verified.
+	Complete(string, string) (*Identity, string, error)
+}
+
+// discoveryDocument holds the fields of a provider's
+// discovery document used by this server
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token,omitempty"`
+	IDToken          string `json:"id_token,omitempty"`
+	TokenType        string `json:"token_type,omitempty"`
+	Error            string `json:"error,omitempty"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// pendingLogin is a login started by Begin and not yet completed
+type pendingLogin struct {
+	nonce      string
+	returnPath string
+	expires    time.Time
+}
+
+// ProviderSpec implements Provider. The provider's discovery document
+// is fetched on first use; its signing keys are cached, and fetched again
+// when a token is signed by an unknown key.
+type ProviderSpec struct {
+	config *Config
+	client *http.Client
+
+	mux         sync.Mutex
+	discovery   *discoveryDocument
+	keys        *JSONWebKeySet
+	keysFetched time.Time
+	pending     map[string]*pendingLogin
+}
+
+func (p *ProviderSpec) Issuer() string {
+	return p.config.Issuer
+}
+
+func (p *ProviderSpec) Begin(returnPath string) (string, error) {
+	doc, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+
+	state, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	p.mux.Lock()
+	now := time.Now()
+	for s, l := range p.pending {
+		if now.After(l.expires) {
+			delete(p.pending, s)
+		}
+	}
+	p.pending[state] = &pendingLogin{
+		nonce:      nonce,
+		returnPath: returnPath,
+		expires:    now.Add(LoginTimeout),
+	}
+	p.mux.Unlock()
+
+	scopes := p.config.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.config.ClientID)
+	q.Set("redirect_uri", p.config.RedirectURL)
+	q.Set("scope", strings.Join(scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+
+	sep := "?"
+	if strings.Contains(doc.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return doc.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+func (p *ProviderSpec) Complete(state, code string) (*Identity, string, error) {
+	p.mux.Lock()
+	login, exists := p.pending[state]
+	// a state may only be used once
+	delete(p.pending, state)
+	p.mux.Unlock()
+
+	if !exists || time.Now().After(login.expires) {
+		return nil, "", fmt.Errorf("unknown or expired login state")
+	}
+	if len(code) == 0 {
+		return nil, "", fmt.Errorf("missing authorization code")
+	}
+
+	doc, err := p.discover()
+	if err != nil {
+		return nil, "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+
+	req, err := http.NewRequest(http.MethodPost, doc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+
+	res := &tokenResponse{}
+	if err := p.do(req, res); err != nil {
+		return nil, "", fmt.Errorf("unable to exchange authorization code: %v", err)
+	}
+	if len(res.Error) > 0 {
+		return nil, "", fmt.Errorf("unable to exchange authorization code: %s %s", res.Error, res.ErrorDescription)
+	}
+	if len(res.IDToken) == 0 {
+		return nil, "", fmt.Errorf("provider returned no identity token")
+	}
+
+	claims, err := p.verify(res.IDToken, doc)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := validateClaims(claims, doc.Issuer, p.config.ClientID, login.nonce, time.Now()); err != nil {
+		return nil, "", err
+	}
+
+	username, err := p.config.Username(claims)
+	if err != nil {
+		return nil, "", err
+	}
+
+	sub, _ := claims.String("sub")
+	return &Identity{
+		Issuer:   doc.Issuer,
+		Subject:  sub,
+		Username: username,
+		Roles:    p.config.Roles(claims),
+		Claims:   claims,
+	}, login.returnPath, nil
+}
+
+// verify checks a token's signature, fetching the provider's key
+// set again if the token was not signed by a cached key.
+func (p *ProviderSpec) verify(token string, doc *discoveryDocument) (Claims, error) {
+	keys, err := p.keySet(doc, false)
+	if err != nil {
+		return nil, err
+	}
+	if claims, err := VerifyToken(token, keys); err == nil {
+		return claims, nil
+	}
+
+	keys, err = p.keySet(doc, true)
+	if err != nil {
+		return nil, err
+	}
+	return VerifyToken(token, keys)
+}
+
+func (p *ProviderSpec) keySet(doc *discoveryDocument, refresh bool) (*JSONWebKeySet, error) {
+	p.mux.Lock()
+	keys, fetched := p.keys, p.keysFetched
+	p.mux.Unlock()
+
+	if keys != nil && !refresh && time.Since(fetched) < keySetLifetime {
+		return keys, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, doc.JwksURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	keys = &JSONWebKeySet{}
+	if err := p.do(req, keys); err != nil {
+		return nil, fmt.Errorf("unable to fetch provider signing keys: %v", err)
+	}
+
+	p.mux.Lock()
+	p.keys, p.keysFetched = keys, time.Now()
+	p.mux.Unlock()
+	return keys, nil
+}
+
+func (p *ProviderSpec) discover() (*discoveryDocument, error) {
+	p.mux.Lock()
+	doc := p.discovery
+	p.mux.Unlock()
+	if doc != nil {
+		return doc, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(p.config.Issuer, "/")+DISCOVERY_PATH, nil)
+	if err != nil {
+		return nil, err
+	}
+	doc = &discoveryDocument{}
+	if err := p.do(req, doc); err != nil {
+		return nil, fmt.Errorf("unable to fetch provider discovery document: %v", err)
+	}
+	if doc.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("provider discovery document names issuer %q, expected %q", doc.Issuer, p.config.Issuer)
+	}
+	if len(doc.AuthorizationEndpoint) == 0 || len(doc.TokenEndpoint) == 0 || len(doc.JwksURI) == 0 {
+		return nil, fmt.Errorf("provider discovery document is incomplete")
+	}
+
+	p.mux.Lock()
+	p.discovery = doc
+	p.mux.Unlock()
+	return doc, nil
+}
+
+// do sends a request and decodes its JSON response into v
+func (p *ProviderSpec) do(req *http.Request, v interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, maxResponseBytes))
+	if err != nil {
+		return err
+	}
+	// token errors are returned as json with a 400 status
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusBadRequest {
+		return fmt.Errorf("unexpected response status %q", res.Status)
+	}
+	return json.Unmarshal(body, v)
+}
+
+// NewProvider receives a provider configuration and returns a Provider
+func NewProvider(config *Config) Provider {
+	return &ProviderSpec{
+		config:  config,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		pending: make(map[string]*pendingLogin),
+	}
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,233 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// allowed difference between this server's clock and the provider's
+const clockSkew = time.Minute
+
+// Claims is the decoded payload of an identity token
+type Claims map[string]interface{}
+
+// String returns the string value of the named claim
+func (c Claims) String(name string) (string, bool) {
+	v, ok := c[name].(string)
+	return v, ok
+}
+
+// Strings returns the value of the named claim as a list of
+// strings. A claim holding a single string is returned as a
+// list of one; values of any other type are ignored.
+func (c Claims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		items := []string{}
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				items = append(items, s)
+			}
+		}
+		return items
+	}
+	return []string{}
+}
+
+// Time returns the value of a numeric date claim
+func (c Claims) Time(name string) (time.Time, bool) {
+	v, ok := c[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(v), 0), true
+}
+
+// Audience returns a boolean (true) if the token was issued to the given client
+func (c Claims) Audience(clientId string) bool {
+	for _, aud := range c.Strings("aud") {
+		if aud == clientId {
+			return true
+		}
+	}
+	return false
+}
+
+// JSONWebKey is a public key, as served in a provider's key set
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg,omitempty"`
+	Use string `json:"use,omitempty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JSONWebKeySet is a provider's list of token signing keys
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// RSAPublicKey decodes an "RSA" key
+func (k *JSONWebKey) RSAPublicKey() (*rsa.PublicKey, error) {
+	if k.Kty != "RSA" {
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key modulus: %v", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key exponent: %v", err)
+	}
+
+	exp := new(big.Int).SetBytes(e)
+	if !exp.IsInt64() || exp.Int64() > 1<<31-1 || exp.Int64() < 3 {
+		return nil, fmt.Errorf("invalid key exponent")
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(exp.Int64()),
+	}, nil
+}
+
+// NewJSONWebKey returns the serializable form of an RSA public key
+func NewJSONWebKey(kid string, key *rsa.PublicKey) JSONWebKey {
+	return JSONWebKey{
+		Kty: "RSA",
+		Kid: kid,
+		Alg: "RS256",
+		Use: "sig",
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+type tokenHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// SignToken encodes and signs a set of claims as an RS256 JSON web token
+func SignToken(kid string, key *rsa.PrivateKey, claims Claims) (string, error) {
+	header, err := json.Marshal(&tokenHeader{Alg: "RS256", Kid: kid, Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// VerifyToken checks an RS256 JSON web token's signature against a key set,
+// and returns its claims. Only the signature is verified; callers must check
+// the token's issuer, audience, expiry and nonce.
+func VerifyToken(token string, keys *JSONWebKeySet) (Claims, error) {
+	segs := strings.Split(token, ".")
+	if len(segs) != 3 {
+		return nil, fmt.Errorf("malformed identity token")
+	}
+
+	rawHeader, err := base64.RawURLEncoding.DecodeString(segs[0])
+	if err != nil {
+		return nil, fmt.Errorf("malformed identity token header: %v", err)
+	}
+	header := &tokenHeader{}
+	if err := json.Unmarshal(rawHeader, header); err != nil {
+		return nil, fmt.Errorf("malformed identity token header: %v", err)
+	}
+	// never trust a token to choose its own verification method
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("unsupported identity token algorithm %q", header.Alg)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(segs[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed identity token signature: %v", err)
+	}
+
+	digest := sha256.Sum256([]byte(segs[0] + "." + segs[1]))
+	verified := false
+	for _, k := range keys.Keys {
+		if len(header.Kid) > 0 && k.Kid != header.Kid {
+			continue
+		}
+		if len(k.Use) > 0 && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.RSAPublicKey()
+		if err != nil {
+			continue
+		}
+		if rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig) == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, fmt.Errorf("identity token signature could not be verified")
+	}
+
+	rawPayload, err := base64.RawURLEncoding.DecodeString(segs[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed identity token payload: %v", err)
+	}
+	claims := Claims{}
+	if err := json.Unmarshal(rawPayload, &claims); err != nil {
+		return nil, fmt.Errorf("malformed identity token payload: %v", err)
+	}
+	return claims, nil
+}
+
+// validateClaims checks the issuer, audience, expiry and nonce of a verified token
+func validateClaims(claims Claims, issuer, clientId, nonce string, now time.Time) error {
+	if iss, _ := claims.String("iss"); iss != issuer {
+		return fmt.Errorf("identity token was issued by %q, expected %q", iss, issuer)
+	}
+	if !claims.Audience(clientId) {
+		return fmt.Errorf("identity token was not issued to this client")
+	}
+
+	exp, ok := claims.Time("exp")
+	if !ok {
+		return fmt.Errorf("identity token has no expiry")
+	}
+	if now.After(exp.Add(clockSkew)) {
+		return fmt.Errorf("identity token has expired")
+	}
+	if iat, ok := claims.Time("iat"); ok && iat.After(now.Add(clockSkew)) {
+		return fmt.Errorf("identity token was issued in the future")
+	}
+
+	if n, _ := claims.String("nonce"); n != nonce {
+		return fmt.Errorf("identity token nonce does not match")
+	}
+	if sub, _ := claims.String("sub"); len(sub) == 0 {
+		return fmt.Errorf("identity token has no subject")
+	}
+	return nil
+}
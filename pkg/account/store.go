@@ -0,0 +1,179 @@
+package account
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Account is a persistent user identity
+type Account struct {
+	Username     string    `json:"username"`
+	PasswordHash []byte    `json:"passwordHash"`
+	Created      time.Time `json:"created"`
+	// Roles maps room names to the names of the
+	// rbac roles bound to the account in that room
+	Roles map[string][]string `json:"roles"`
+	// Issuer and Subject identify the external identity an account
+	// was created for, if it signs in through an identity provider.
+	// Such accounts have no password.
+	Issuer  string `json:"issuer,omitempty"`
+	Subject string `json:"subject,omitempty"`
+	// ProviderRoles are the names of the rbac roles mapped to the
+	// account's identity provider groups, bound in every room
+	// the account has no roles stored for
+	ProviderRoles []string `json:"providerRoles,omitempty"`
+}
+
+// Store persists accounts, keyed by case-insensitive username
+type Store interface {
+	// Get receives a username and returns a copy of the
+	// account by that name, or a boolean (false) if none exists
+	Get(string) (*Account, bool)
+	// Put creates or replaces an account
+	Put(*Account) error
+	// Delete removes an account by username
+	Delete(string) error
+}
+
+// MemoryStore implements Store and keeps accounts in memory only
+type MemoryStore struct {
+	mux      sync.Mutex
+	accounts map[string]*Account
+}
+
+func (s *MemoryStore) Get(username string) (*Account, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	a, exists := s.accounts[storeKey(username)]
+	if !exists {
+		return nil, false
+	}
+	return copyAccount(a), true
+}
+
+func (s *MemoryStore) Put(a *Account) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.accounts[storeKey(a.Username)] = copyAccount(a)
+	return nil
+}
+
+func (s *MemoryStore) Delete(username string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if _, exists := s.accounts[storeKey(username)]; !exists {
+		return fmt.Errorf("account %q not found", username)
+	}
+	delete(s.accounts, storeKey(username))
+	return nil
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		accounts: make(map[string]*Account),
+	}
+}
+
+// accountBucket names the bucket BoltStore keeps accounts in
+var accountBucket = []byte("accounts")
+
+// BoltStore implements Store and persists accounts in an embedded
+// bolt database. Each account is stored as a JSON document, keyed by
+// its case-insensitive username; every change is its own transaction.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+func (s *BoltStore) Get(username string) (*Account, bool) {
+	var a *Account
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(accountBucket).Get([]byte(storeKey(username)))
+		if data == nil {
+			return nil
+		}
+
+		a = &Account{}
+		return json.Unmarshal(data, a)
+	})
+	if err != nil {
+		log.Printf("ERR ACCOUNT unable to read account %q: %v\n", username, err)
+		return nil, false
+	}
+
+	return a, a != nil
+}
+
+func (s *BoltStore) Put(a *Account) error {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(accountBucket).Put([]byte(storeKey(a.Username)), data)
+	})
+}
+
+func (s *BoltStore) Delete(username string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(accountBucket)
+		key := []byte(storeKey(username))
+		if b.Get(key) == nil {
+			return fmt.Errorf("account %q not found", username)
+		}
+		return b.Delete(key)
+	})
+}
+
+// Close releases the database file
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+// NewBoltStore receives a path and returns a BoltStore holding the
+// accounts saved in the database at that path, creating it if it does
+// not exist. Returns an error if the database cannot be opened, such
+// as when another process holds it.
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("unable to open account store %q: %v", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(accountBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to initialize account store %q: %v", path, err)
+	}
+
+	return &BoltStore{
+		db: db,
+	}, nil
+}
+
+func storeKey(username string) string {
+	return strings.ToLower(username)
+}
+
+func copyAccount(a *Account) *Account {
+	c := *a
+	c.PasswordHash = append([]byte{}, a.PasswordHash...)
+	c.Roles = make(map[string][]string)
+	for room, roles := range a.Roles {
+		c.Roles[room] = append([]string{}, roles...)
+	}
+	c.ProviderRoles = append([]string{}, a.ProviderRoles...)
+	return &c
+}
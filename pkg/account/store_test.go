@@ -0,0 +1,93 @@
+package account
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBoltStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "account")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "accounts.db")
+	store, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatalf("unable to open store: %v", err)
+	}
+
+	a := &Account{
+		Username:     "Alice",
+		PasswordHash: []byte("hash"),
+		Created:      time.Unix(1500000000, 0).UTC(),
+		Roles:        map[string][]string{"room-a": {"admin"}},
+	}
+	if err := store.Put(a); err != nil {
+		t.Fatalf("unable to store account: %v", err)
+	}
+	if _, exists := store.Get("bob"); exists {
+		t.Fatalf("expected no account by an unknown username")
+	}
+
+	// accounts persist once the database is reopened
+	if err := store.Close(); err != nil {
+		t.Fatalf("unable to close store: %v", err)
+	}
+	store, err = NewBoltStore(path)
+	if err != nil {
+		t.Fatalf("unable to reopen store: %v", err)
+	}
+	defer store.Close()
+
+	stored, exists := store.Get("alice")
+	if !exists {
+		t.Fatalf("expected the account to be found by a case-insensitive username")
+	}
+	if !reflect.DeepEqual(stored, a) {
+		t.Fatalf("expected stored account %+v, got %+v", a, stored)
+	}
+
+	if err := store.Delete("ALICE"); err != nil {
+		t.Fatalf("unable to delete account: %v", err)
+	}
+	if _, exists := store.Get("alice"); exists {
+		t.Fatalf("expected the account to be deleted")
+	}
+	if err := store.Delete("alice"); err == nil {
+		t.Fatalf("expected an error deleting an account that does not exist")
+	}
+}
+
+func TestRegisterConcurrently(t *testing.T) {
+	m := NewManager(NewMemoryStore())
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := m.Register("alice", "correct horse")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	registered := 0
+	for err := range errs {
+		if err == nil {
+			registered++
+		}
+	}
+	if registered != 1 {
+		t.Fatalf("expected exactly one registration to succeed, got %v", registered)
+	}
+}
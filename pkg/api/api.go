@@ -10,6 +10,8 @@ import (
 
 	"github.com/juanvallejo/streaming-server/pkg/api/discovery"
 	"github.com/juanvallejo/streaming-server/pkg/api/endpoint"
+	"github.com/juanvallejo/streaming-server/pkg/playback"
+	"github.com/juanvallejo/streaming-server/pkg/server/cors"
 	"github.com/juanvallejo/streaming-server/pkg/socket/connection"
 )
 
@@ -33,6 +35,7 @@ type Handler interface {
 type ApiHandler struct {
 	endpoints   map[string]endpoint.ApiEndpoint
 	connections connection.ConnectionHandler
+	playbacks   playback.PlaybackHandler
 }
 
 func (h *ApiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -43,6 +46,10 @@ func (h *ApiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	ip, _, _ := net.SplitHostPort(r.RemoteAddr)
 	log.Printf("INF API Serving request from %s for endpoint %q\n", ip, r.URL.Path)
 
+	if !cors.Handle(w, r) {
+		return
+	}
+
 	h.HandleEndpoint(r.URL, w, r)
 }
 
@@ -91,10 +98,11 @@ func (h *ApiHandler) RegisterEndpoint(e endpoint.ApiEndpoint) {
 
 }
 
-func NewHandler(connHandler connection.ConnectionHandler) Handler {
+func NewHandler(connHandler connection.ConnectionHandler, playbackHandler playback.PlaybackHandler) Handler {
 	handler := &ApiHandler{
 		endpoints:   make(map[string]endpoint.ApiEndpoint),
 		connections: connHandler,
+		playbacks:   playbackHandler,
 	}
 	handler.registerDefaultEndpoints()
 	return handler
@@ -106,4 +114,9 @@ func (h *ApiHandler) registerDefaultEndpoints() {
 	h.RegisterEndpoint(endpoint.NewTwitchEndpoint())
 	h.RegisterEndpoint(endpoint.NewAuthEndpoint())
 	h.RegisterEndpoint(endpoint.NewSoundCloudEndpoint())
+	h.RegisterEndpoint(endpoint.NewRoomEndpoint(h.playbacks))
+	h.RegisterEndpoint(endpoint.NewRoleEndpoint())
+	h.RegisterEndpoint(endpoint.NewAccountEndpoint())
+	h.RegisterEndpoint(endpoint.NewMetricsEndpoint())
+	h.RegisterEndpoint(endpoint.NewProtocolEndpoint())
 }
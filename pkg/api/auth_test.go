@@ -0,0 +1,88 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/juanvallejo/streaming-server/pkg/account"
+	"github.com/juanvallejo/streaming-server/pkg/api/endpoint/query"
+	"github.com/juanvallejo/streaming-server/pkg/socket/cmd/rbac"
+)
+
+// sessionCookie registers an account and returns a cookie holding a new session for it
+func (s *rbacTestServer) sessionCookie(t *testing.T, username string) *http.Cookie {
+	t.Helper()
+
+	if _, err := s.accounts.Register(username, "correct horse"); err != nil {
+		t.Fatalf("unable to register account %q: %v", username, err)
+	}
+	session, err := s.accounts.Login(username, "correct horse")
+	if err != nil {
+		t.Fatalf("unable to log in to account %q: %v", username, err)
+	}
+	return &http.Cookie{
+		Name:  account.SESSION_COOKIE_NAME,
+		Value: session.Token,
+	}
+}
+
+func TestAuthEndpointRequiresApiToken(t *testing.T) {
+	s := newRBACTestServer(t)
+	admin := s.join(t, "room-a")
+	user := s.join(t, "room-a")
+
+	for _, seg := range []string{"init", "cookie"} {
+		for _, params := range []string{
+			query.CONN_ID_KEY + "=" + url.QueryEscape(admin.id),
+			admin.params(user.token),
+		} {
+			if res := s.request(t, http.MethodGet, ApiPrefix+"/auth/"+seg+"?"+params); len(res.Error) == 0 {
+				t.Fatalf("expected /auth/%s?%s to be refused, got %q", seg, params, res.Message)
+			}
+		}
+		if res := s.request(t, http.MethodGet, ApiPrefix+"/auth/"+seg+"?"+admin.params(admin.token)); len(res.Error) > 0 {
+			t.Fatalf("expected /auth/%s to succeed with the connection's token, got %q", seg, res.Error)
+		}
+	}
+}
+
+func TestAuthCookieSavesAccountRolesOnlyWithSession(t *testing.T) {
+	s := newRBACTestServer(t)
+	alice := s.sessionCookie(t, "alice")
+	bob := s.sessionCookie(t, "bob")
+
+	// alice's connection is logged in, and the first in the room
+	admin := s.join(t, "room-a", alice)
+
+	tests := []struct {
+		name    string
+		cookies []*http.Cookie
+		saved   bool
+	}{
+		{name: "no session"},
+		{name: "another account's session", cookies: []*http.Cookie{bob}},
+		{name: "own session", cookies: []*http.Cookie{alice}, saved: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if res := s.request(t, http.MethodGet, ApiPrefix+"/auth/cookie?"+admin.params(admin.token), tt.cookies...); len(res.Error) > 0 {
+				t.Fatalf("unable to save roles: %v", res.Error)
+			}
+
+			roles, saved := s.accounts.RoomRoles("alice", "room-a")
+			if saved != tt.saved {
+				t.Fatalf("expected roles saved for the account to be %v, got %v (%v)", tt.saved, saved, roles)
+			}
+			if saved && !reflect.DeepEqual(roles, []string{rbac.ADMIN_ROLE}) {
+				t.Fatalf("expected the account's roles to be %v, got %v", []string{rbac.ADMIN_ROLE}, roles)
+			}
+		})
+	}
+
+	if _, saved := s.accounts.RoomRoles("bob", "room-a"); saved {
+		t.Fatalf("expected no roles to be saved for an account not logged in on the connection")
+	}
+}
@@ -0,0 +1,260 @@
+package endpoint
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/juanvallejo/streaming-server/pkg/account"
+	api "github.com/juanvallejo/streaming-server/pkg/api/types"
+	"github.com/juanvallejo/streaming-server/pkg/socket/connection"
+)
+
+const (
+	ACCOUNT_ENDPOINT_PREFIX = "/account"
+
+	maxCredentialBytes = 1 << 12
+)
+
+var (
+	// LoginAttemptLimit limits register and login attempts per address
+	LoginAttemptLimit = connection.RateLimit{Rate: 1.0 / 6, Burst: 10}
+	// LoginFailureLimit limits failed logins per username. Once exhausted,
+	// logins to the account are refused until a token is refilled.
+	LoginFailureLimit = connection.RateLimit{Rate: 1.0 / 60, Burst: 5}
+)
+
+// AccountEndpoint implements ApiEndpoint
+type AccountEndpoint struct {
+	*ApiEndpointSchema
+
+	// attempts limits register and login attempts per address, and
+	// failures limits failed logins per username, so that passwords
+	// cannot be guessed online
+	attempts *connection.AttemptLimiter
+	failures *connection.AttemptLimiter
+}
+
+// accountCredentials is the request body of register and login requests
+type accountCredentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// AccountInfo is a serializable representation of an account,
+// without its credentials
+type AccountInfo struct {
+	Kind     string              `json:"kind"`
+	Username string              `json:"username"`
+	Created  time.Time           `json:"created"`
+	Roles    map[string][]string `json:"roles"`
+	Expires  *time.Time          `json:"expires,omitempty"`
+}
+
+// Handle registers accounts, and logs them in and out.
+// Requests are of the form:
+//
+//	GET  /api/account
+//	POST /api/account/register  {"username": "...", "password": "..."}
+//	POST /api/account/login     {"username": "...", "password": "..."}
+//	POST /api/account/logout
+//
+// Register and login requests set a session cookie. Socket connections
+// made with a session cookie are logged in to its account; connections
+// opened before logging in must reconnect to be logged in.
+func (e *AccountEndpoint) Handle(connHandler connection.ConnectionHandler, segments []string, w http.ResponseWriter, r *http.Request) {
+	accounts := connHandler.Accounts()
+	if accounts == nil {
+		HandleEndpointError(fmt.Errorf("user accounts not enabled; endpoint unavailable"), w)
+		return
+	}
+
+	if len(segments) < 2 {
+		if r.Method != http.MethodGet {
+			HandleEndpointError(fmt.Errorf("unsupported method %v", r.Method), w)
+			return
+		}
+
+		a, ok := sessionAccount(accounts, r)
+		if !ok {
+			HandleEndpointError(fmt.Errorf("not logged in"), w)
+			return
+		}
+
+		writeAccountResponse(a, nil, w)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		HandleEndpointError(fmt.Errorf("unsupported method %v", r.Method), w)
+		return
+	}
+
+	switch segments[1] {
+	case "register":
+		creds, err := readCredentials(w, r)
+		if err != nil {
+			HandleEndpointError(err, w)
+			return
+		}
+		if !e.allowAttempt(creds, w, r) {
+			return
+		}
+
+		if _, err := accounts.Register(creds.Username, creds.Password); err != nil {
+			HandleEndpointError(err, w)
+			return
+		}
+
+		log.Printf("INF API ACCOUNT registered account %q from %q\n", creds.Username, r.RemoteAddr)
+		login(accounts, creds, w, r)
+		return
+	case "login":
+		creds, err := readCredentials(w, r)
+		if err != nil {
+			HandleEndpointError(err, w)
+			return
+		}
+		if !e.allowAttempt(creds, w, r) {
+			return
+		}
+
+		if !login(accounts, creds, w, r) {
+			e.failures.Take(time.Now(), strings.ToLower(creds.Username))
+		}
+		return
+	case "logout":
+		cookie, err := r.Cookie(account.SESSION_COOKIE_NAME)
+		if err != nil || !accounts.Logout(cookie.Value) {
+			HandleEndpointError(fmt.Errorf("not logged in"), w)
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     account.SESSION_COOKIE_NAME,
+			Value:    "",
+			Path:     "/",
+			HttpOnly: true,
+			MaxAge:   -1,
+		})
+		HandleEndpointSuccess("successfully logged out", w)
+		return
+	}
+
+	HandleEndpointNotFound(w)
+}
+
+// allowAttempt returns a boolean (true) if a register or login request may
+// be attempted: if its address has attempts left, and the username it
+// names has not been locked out of logins by failed attempts. Otherwise,
+// the request is answered with a 429 status.
+func (e *AccountEndpoint) allowAttempt(creds *accountCredentials, w http.ResponseWriter, r *http.Request) bool {
+	now := time.Now()
+	if ok, retry := e.failures.Allowed(now, strings.ToLower(creds.Username)); !ok {
+		log.Printf("WRN API ACCOUNT refusing login attempt for locked out account %q from %q\n", creds.Username, r.RemoteAddr)
+		HandleEndpointTooManyRequests(fmt.Errorf("too many failed logins for this account; try again in %v", retry), retry, w)
+		return false
+	}
+	if ok, retry := e.attempts.Take(now, connection.RemoteAddress(r)); !ok {
+		log.Printf("WRN API ACCOUNT refusing account request from %q: too many attempts\n", r.RemoteAddr)
+		HandleEndpointTooManyRequests(fmt.Errorf("too many attempts; try again in %v", retry), retry, w)
+		return false
+	}
+	return true
+}
+
+// login starts a session for the given credentials and sets a session
+// cookie. Returns a boolean (false) if the credentials were refused.
+func login(accounts account.Manager, creds *accountCredentials, w http.ResponseWriter, r *http.Request) bool {
+	session, err := accounts.Login(creds.Username, creds.Password)
+	if err != nil {
+		log.Printf("WRN API ACCOUNT failed login attempt for account %q from %q\n", creds.Username, r.RemoteAddr)
+		HandleEndpointError(err, w)
+		return false
+	}
+
+	a, _ := accounts.Account(session.Username)
+
+	setSessionCookie(session, w)
+
+	log.Printf("INF API ACCOUNT account %q logged in from %q\n", session.Username, r.RemoteAddr)
+	writeAccountResponse(a, &session.Expires, w)
+	return true
+}
+
+// setSessionCookie sets a cookie holding a session's token
+func setSessionCookie(session *account.Session, w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     account.SESSION_COOKIE_NAME,
+		Value:    session.Token,
+		Path:     "/",
+		HttpOnly: true,
+		Expires:  session.Expires,
+	})
+}
+
+// sessionAccount returns the account logged in with a request's session cookie
+func sessionAccount(accounts account.Manager, r *http.Request) (*account.Account, bool) {
+	cookie, err := r.Cookie(account.SESSION_COOKIE_NAME)
+	if err != nil {
+		return nil, false
+	}
+	return accounts.Authenticate(cookie.Value)
+}
+
+// readCredentials parses a username and password from a JSON
+// request body, or from form values if the body is not JSON
+func readCredentials(w http.ResponseWriter, r *http.Request) (*accountCredentials, error) {
+	creds := &accountCredentials{}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxCredentialBytes))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read request body: %v", err)
+		}
+		if err := json.Unmarshal(body, creds); err != nil {
+			return nil, fmt.Errorf("unable to parse credentials: %v", err)
+		}
+	} else {
+		r.Body = http.MaxBytesReader(w, r.Body, maxCredentialBytes)
+		if err := r.ParseForm(); err != nil {
+			return nil, fmt.Errorf("unable to parse credentials: %v", err)
+		}
+		creds.Username = r.PostForm.Get("username")
+		creds.Password = r.PostForm.Get("password")
+	}
+
+	if len(creds.Username) == 0 || len(creds.Password) == 0 {
+		return nil, fmt.Errorf("missing required fields: username, password")
+	}
+	return creds, nil
+}
+
+func writeAccountResponse(a *account.Account, expires *time.Time, w http.ResponseWriter) {
+	b, err := json.Marshal(&AccountInfo{
+		Kind:     api.API_TYPE_ACCOUNT,
+		Username: a.Username,
+		Created:  a.Created,
+		Roles:    a.Roles,
+		Expires:  expires,
+	})
+	if err != nil {
+		HandleEndpointError(err, w)
+		return
+	}
+
+	w.Write(b)
+}
+
+func NewAccountEndpoint() ApiEndpoint {
+	return &AccountEndpoint{
+		ApiEndpointSchema: &ApiEndpointSchema{
+			path: ACCOUNT_ENDPOINT_PREFIX,
+		},
+		attempts: connection.NewAttemptLimiter(LoginAttemptLimit),
+		failures: connection.NewAttemptLimiter(LoginFailureLimit),
+	}
+}
@@ -4,8 +4,9 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 
-	"github.com/juanvallejo/streaming-server/pkg/api/endpoint/query"
+	"github.com/juanvallejo/streaming-server/pkg/audit"
 	"github.com/juanvallejo/streaming-server/pkg/socket/cmd/rbac"
 	"github.com/juanvallejo/streaming-server/pkg/socket/connection"
 	"github.com/juanvallejo/streaming-server/pkg/socket/util"
@@ -20,13 +21,19 @@ type AuthEndpoint struct {
 	*ApiEndpointSchema
 }
 
-// sets a cookie with rbac roles from the given connection id
+// sets a cookie with rbac roles from the given connection id and api
+// token, or logs in to a user account through an identity provider
 func (e *AuthEndpoint) Handle(connHandler connection.ConnectionHandler, segments []string, w http.ResponseWriter, r *http.Request) {
 	if len(segments) < 2 {
 		HandleEndpointError(fmt.Errorf("unimplemented endpoint"), w)
 		return
 	}
 
+	if segments[1] == "oidc" {
+		handleOIDCReq(connHandler, segments[2:], w, r)
+		return
+	}
+
 	// no-op if authorizer does not exist
 	authorizer := connHandler.Authorizer()
 	if authorizer == nil {
@@ -34,21 +41,15 @@ func (e *AuthEndpoint) Handle(connHandler connection.ConnectionHandler, segments
 		return
 	}
 
-	connId := r.URL.Query().Get(query.CONN_ID_KEY)
-	if len(connId) == 0 {
-		HandleEndpointError(fmt.Errorf("missing required parameter: id"), w)
-		return
-	}
-
 	referrer := r.Referer()
 	if len(referrer) == 0 {
 		HandleEndpointError(fmt.Errorf("requests to this endpoint must be made from a different endpoint"), w)
 		return
 	}
 
-	conn, exists := connHandler.Connection(connId)
-	if !exists {
-		HandleEndpointError(fmt.Errorf("unable to find connection by id %v", connId), w)
+	conn, err := authenticateConnection(connHandler, r)
+	if err != nil {
+		HandleEndpointError(err, w)
 		return
 	}
 
@@ -78,8 +79,9 @@ func handleInitReq(conn connection.Connection, handler connection.ConnectionHand
 		return
 	}
 
-	roles, err := util.DefaultRoles(r, handler.Authorizer(), conn.UUID(), ns)
+	roles, err := util.DefaultRoles(r, handler.Authorizer(), handler.Accounts(), conn.UUID(), ns)
 	if err != nil {
+		recordAuthRequest(ns, conn, "auth/init", false, err.Error())
 		HandleEndpointError(err, w)
 		return
 	}
@@ -91,18 +93,22 @@ func handleInitReq(conn connection.Connection, handler connection.ConnectionHand
 	}
 
 	// bind roles to connection
+	bound := []string{}
 	for _, r := range roles {
 		if handler.Authorizer().Bind(r, conn) {
 			log.Printf("INF API AUTHZ bound role %q to connection with id (%s)", r.Name(), conn.UUID())
+			bound = append(bound, r.Name())
 		}
 	}
+	recordAuthRequest(ns, conn, "auth/init", true, fmt.Sprintf("bound roles %v", bound))
 
 	HandleEndpointSuccess(fmt.Sprintf("successfully initialized roles for id %v", conn.UUID()), w)
 }
 
-// TODO: this endpoint must be accessible to non-privileged clients.
-// secure by having one-time tokens that must be provided as part of
-// a request, via a "token" parameter.
+// handleCookieReq saves the rbac roles bound to the given connection in
+// an auth cookie. If the request is made with the session cookie of the
+// account logged in on the connection, the roles are also stored for
+// that account in the connection's namespace.
 func handleCookieReq(conn connection.Connection, handler connection.ConnectionHandler, w http.ResponseWriter, r *http.Request) {
 	ns, exists := conn.Namespace()
 	if !exists {
@@ -135,9 +141,37 @@ func handleCookieReq(conn connection.Connection, handler connection.ConnectionHa
 	r.AddCookie(cookie)
 	w.Header().Set("Cookie", fmt.Sprintf("%s=%s", cookie.Name, cookie.Value))
 
+	// roles follow a logged-in account to other browsers, but are only
+	// saved for the account if the request proves it is logged in to it
+	if accounts := handler.Accounts(); accounts != nil {
+		if username, loggedIn := conn.Metadata().Account(); loggedIn {
+			if a, authenticated := sessionAccount(accounts, r); authenticated && strings.EqualFold(a.Username, username) {
+				if err := accounts.SetRoomRoles(username, ns.Name(), roleNames); err != nil {
+					log.Printf("ERR API AUTHZ unable to save roles %v for account %q: %v", roleNames, username, err)
+				}
+			} else {
+				log.Printf("WRN API AUTHZ not saving roles %v for account %q: the request was not made with its session", roleNames, username)
+			}
+		}
+	}
+
+	recordAuthRequest(ns, conn, "auth/cookie", true, fmt.Sprintf("saved roles %v", roleNames))
 	HandleEndpointSuccess(fmt.Sprintf("successfully saved roles (%v) for id %v", roleNames, conn.UUID()), w)
 }
 
+// recordAuthRequest adds an entry to a namespace's audit
+// log describing a request made to the auth endpoint
+func recordAuthRequest(ns connection.Namespace, conn connection.Connection, action string, allowed bool, detail string) {
+	ns.Audit().Record(audit.Entry{
+		Kind:    audit.KIND_AUTH,
+		Actor:   conn.UUID(),
+		ActorId: conn.UUID(),
+		Action:  action,
+		Allowed: allowed,
+		Detail:  detail,
+	})
+}
+
 func NewAuthEndpoint() ApiEndpoint {
 	return &AuthEndpoint{
 		&ApiEndpointSchema{
@@ -4,8 +4,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/juanvallejo/streaming-server/pkg/api/endpoint/query"
 	"github.com/juanvallejo/streaming-server/pkg/socket/connection"
 )
 
@@ -82,6 +86,24 @@ func HandleEndpointError(err error, w http.ResponseWriter) {
 	w.Write(b)
 }
 
+// HandleEndpointTooManyRequests answers a request refused for exceeding
+// a limit, telling the client when it may retry
+func HandleEndpointTooManyRequests(err error, retryAfter time.Duration, w http.ResponseWriter) {
+	res := &ApiResponse{
+		Error:    fmt.Sprintf("%v", err),
+		HTTPCode: http.StatusTooManyRequests,
+	}
+
+	b, err := json.Marshal(res)
+	if err != nil {
+		log.Panic("unable to marshal api error response")
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write(b)
+}
+
 func HandleEndpointNotFound(w http.ResponseWriter) {
 	res := &ApiResponse{
 		Error:    "endpoint not found",
@@ -95,3 +117,27 @@ func HandleEndpointNotFound(w http.ResponseWriter) {
 
 	w.Write(b)
 }
+
+// authenticateConnection returns the connection an api request was made
+// on behalf of. Connection ids are shared with every client in a namespace,
+// so requests must also carry the connection's api token, which is only
+// ever sent to the connection's own client.
+// Returns an error if either is missing, or if they do not match.
+func authenticateConnection(connHandler connection.ConnectionHandler, r *http.Request) (connection.Connection, error) {
+	connId := r.URL.Query().Get(query.CONN_ID_KEY)
+	if len(connId) == 0 {
+		return nil, fmt.Errorf("missing required parameter: %s", query.CONN_ID_KEY)
+	}
+
+	token := r.URL.Query().Get(query.TOKEN_KEY)
+	if len(token) == 0 {
+		return nil, fmt.Errorf("missing required parameter: %s", query.TOKEN_KEY)
+	}
+
+	conn, exists := connHandler.Connection(connId)
+	if !exists || !connection.VerifyApiToken(conn, token) {
+		return nil, fmt.Errorf("unable to authenticate connection with id %v", connId)
+	}
+
+	return conn, nil
+}
@@ -0,0 +1,61 @@
+package endpoint
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	api "github.com/juanvallejo/streaming-server/pkg/api/types"
+	"github.com/juanvallejo/streaming-server/pkg/socket/connection"
+)
+
+const METRICS_ENDPOINT_PREFIX = "/metrics"
+
+// MetricsEndpoint implements ApiEndpoint
+type MetricsEndpoint struct {
+	*ApiEndpointSchema
+}
+
+// ServerMetrics is a serializable schema representing server-wide counters
+type ServerMetrics struct {
+	Kind       string                       `json:"kind"`
+	Outbound   *connection.WriterMetrics    `json:"outbound"`
+	RateLimits *connection.RateLimitMetrics `json:"rateLimits"`
+}
+
+// Handle returns counters of the messages queued, written,
+// coalesced and dropped by connection writers, and of the
+// messages dropped by rate limits, along with the limits.
+// Requests are of the form:
+//
+//	GET /api/metrics
+func (e *MetricsEndpoint) Handle(connHandler connection.ConnectionHandler, segments []string, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		HandleEndpointError(fmt.Errorf("unsupported method %v", r.Method), w)
+		return
+	}
+	if len(segments) > 1 {
+		HandleEndpointNotFound(w)
+		return
+	}
+
+	b, err := json.Marshal(&ServerMetrics{
+		Kind:       api.API_TYPE_METRICS,
+		Outbound:   connection.OutboundMetrics(),
+		RateLimits: connection.RateLimitStats(),
+	})
+	if err != nil {
+		HandleEndpointError(err, w)
+		return
+	}
+
+	w.Write(b)
+}
+
+func NewMetricsEndpoint() ApiEndpoint {
+	return &MetricsEndpoint{
+		&ApiEndpointSchema{
+			path: METRICS_ENDPOINT_PREFIX,
+		},
+	}
+}
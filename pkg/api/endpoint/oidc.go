@@ -0,0 +1,95 @@
+package endpoint
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/juanvallejo/streaming-server/pkg/socket/connection"
+)
+
+const (
+	OIDC_RETURN_KEY = "return"
+)
+
+// handleOIDCReq logs in to a user account through an OpenID Connect
+// identity provider. Requests are of the form:
+//
+//	GET /api/auth/oidc/login?return=/v/room
+//	GET /api/auth/oidc/callback?state=...&code=...
+//
+// A login request redirects to the provider. Once the user signs in, the
+// provider redirects to the callback, which creates an account for the
+// identity if none exists, binds the roles mapped to its groups to the
+// account, sets a session cookie, and redirects to the "return" path.
+func handleOIDCReq(connHandler connection.ConnectionHandler, segments []string, w http.ResponseWriter, r *http.Request) {
+	provider := connHandler.IdentityProvider()
+	if provider == nil {
+		HandleEndpointError(fmt.Errorf("identity provider not enabled; endpoint unavailable"), w)
+		return
+	}
+	accounts := connHandler.Accounts()
+	if accounts == nil {
+		HandleEndpointError(fmt.Errorf("user accounts not enabled; endpoint unavailable"), w)
+		return
+	}
+
+	if len(segments) == 0 {
+		HandleEndpointNotFound(w)
+		return
+	}
+	if r.Method != http.MethodGet {
+		HandleEndpointError(fmt.Errorf("unsupported method %v", r.Method), w)
+		return
+	}
+
+	q := r.URL.Query()
+	switch segments[0] {
+	case "login":
+		authURL, err := provider.Begin(localReturnPath(q.Get(OIDC_RETURN_KEY)))
+		if err != nil {
+			log.Printf("ERR API OIDC unable to begin login with provider %q: %v\n", provider.Issuer(), err)
+			HandleEndpointError(fmt.Errorf("unable to reach identity provider"), w)
+			return
+		}
+
+		http.Redirect(w, r, authURL, http.StatusFound)
+		return
+	case "callback":
+		if providerErr := q.Get("error"); len(providerErr) > 0 {
+			HandleEndpointError(fmt.Errorf("identity provider returned error %q: %s", providerErr, q.Get("error_description")), w)
+			return
+		}
+
+		identity, returnPath, err := provider.Complete(q.Get("state"), q.Get("code"))
+		if err != nil {
+			log.Printf("WRN API OIDC failed login from %q: %v\n", r.RemoteAddr, err)
+			HandleEndpointError(err, w)
+			return
+		}
+
+		session, err := accounts.LoginExternal(identity.Issuer, identity.Subject, identity.Username, identity.Roles)
+		if err != nil {
+			log.Printf("WRN API OIDC unable to log in identity %q as account %q: %v\n", identity.Subject, identity.Username, err)
+			HandleEndpointError(err, w)
+			return
+		}
+
+		setSessionCookie(session, w)
+		log.Printf("INF API OIDC account %q logged in through %q with roles %v from %q\n", session.Username, identity.Issuer, identity.Roles, r.RemoteAddr)
+		http.Redirect(w, r, returnPath, http.StatusFound)
+		return
+	}
+
+	HandleEndpointNotFound(w)
+}
+
+// localReturnPath returns the given path if it refers to this
+// server, so that logins cannot redirect to other sites, or "/"
+func localReturnPath(p string) string {
+	if !strings.HasPrefix(p, "/") || strings.HasPrefix(p, "//") || strings.Contains(p, "\\") {
+		return "/"
+	}
+	return p
+}
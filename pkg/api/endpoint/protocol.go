@@ -0,0 +1,99 @@
+package endpoint
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/juanvallejo/streaming-server/pkg/playback"
+	"github.com/juanvallejo/streaming-server/pkg/playback/queue"
+	"github.com/juanvallejo/streaming-server/pkg/socket/client"
+	"github.com/juanvallejo/streaming-server/pkg/socket/connection"
+	"github.com/juanvallejo/streaming-server/pkg/socket/protocol"
+	"github.com/juanvallejo/streaming-server/pkg/stream"
+)
+
+const PROTOCOL_ENDPOINT_PREFIX = "/protocol"
+
+// ProtocolEndpoint implements ApiEndpoint
+type ProtocolEndpoint struct {
+	*ApiEndpointSchema
+
+	document []byte
+}
+
+// Handle returns a JSON Schema document describing the payload
+// of every event of the socket protocol.
+// Requests are of the form:
+//
+//	GET /api/protocol
+func (e *ProtocolEndpoint) Handle(connHandler connection.ConnectionHandler, segments []string, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		HandleEndpointError(fmt.Errorf("unsupported method %v", r.Method), w)
+		return
+	}
+	if len(segments) > 1 {
+		HandleEndpointNotFound(w)
+		return
+	}
+
+	w.Write(e.document)
+}
+
+// ProtocolDocument describes every event of the socket protocol
+func ProtocolDocument() *protocol.Document {
+	status := &playback.PlaybackStatus{
+		Stream:      &stream.StreamSchema{},
+		TimerStatus: &playback.TimerStatus{},
+	}
+	response := func(extra interface{}) *client.Response {
+		return &client.Response{Extra: extra}
+	}
+
+	doc := protocol.NewDocument()
+
+	doc.AddInbound(protocol.EVENT_REQUEST_AUTHORIZATION, "requests an authorization event, naming the endpoint to obtain the client's roles from", &protocol.EmptyRequest{})
+	doc.AddInbound(protocol.EVENT_REQUEST_CHATMESSAGE, "sends a chat message to the client's room, or runs a command if the message begins with \"/\"", &protocol.ChatMessageRequest{})
+	doc.AddInbound(protocol.EVENT_REQUEST_QUEUESYNC, "requests a queuesync event with the room's queue", &protocol.EmptyRequest{})
+	doc.AddInbound(protocol.EVENT_REQUEST_STACKSYNC, "requests a stacksync event with the client's own queue", &protocol.EmptyRequest{})
+	doc.AddInbound(protocol.EVENT_REQUEST_STREAMSYNC, "requests a streamsync event with the room's playback status", &protocol.EmptyRequest{})
+	doc.AddInbound(protocol.EVENT_REQUEST_UPDATEUSERNAME, "changes the client's username", &protocol.UpdateUsernameRequest{})
+	doc.AddInbound(protocol.EVENT_REQUEST_USERLIST, "requests a userlist event with the clients in the room", &protocol.EmptyRequest{})
+	doc.AddInbound(protocol.EVENT_STREAMDATA, "updates information about the current stream once it has loaded on the client", &protocol.StreamDataRequest{})
+
+	doc.AddOutbound(protocol.EVENT_ACK, "acknowledges a request sent with a message id", &client.Reply{})
+	doc.AddOutbound(protocol.EVENT_AUTHORIZATION, "names the endpoint the client should request to obtain its roles", response(&protocol.AuthorizationRequest{}))
+	doc.AddOutbound(protocol.EVENT_CHATMESSAGE, "a chat message, or a system message", response(&protocol.ChatMessageExtra{}))
+	doc.AddOutbound(protocol.EVENT_CHATMETHODACTION, "asks the client to call a method of its chat", response(&protocol.ChatMethodAction{}))
+	doc.AddOutbound(protocol.EVENT_ERROR, "answers a request sent with a message id that could not be handled", &client.Reply{Error: &client.Error{}})
+	doc.AddOutbound(protocol.EVENT_INFO_CLIENTERROR, "an error caused by a request sent without a message id", response(nil))
+	doc.AddOutbound(protocol.EVENT_INFO_CLIENTJOINED, "a client joined the room", response(nil))
+	doc.AddOutbound(protocol.EVENT_INFO_CLIENTLEFT, "a client left the room", response(nil))
+	doc.AddOutbound(protocol.EVENT_INFO_SUBTITLES, "subtitles were added to or removed from the stream", response(&protocol.SubtitlesUpdate{}))
+	doc.AddOutbound(protocol.EVENT_INFO_UPDATEUSERNAME, "a client in the room changed its username", response(&protocol.UsernameUpdate{}))
+	doc.AddOutbound(protocol.EVENT_INFO_USERLISTUPDATED, "the clients in the room, or their roles, changed", response(nil))
+	doc.AddOutbound(protocol.EVENT_PROTOCOL, "sent once a client connects, naming the protocol version negotiated for the connection", &protocol.ProtocolInfo{})
+	doc.AddOutbound(protocol.EVENT_QUEUESYNC, "the room's queue", response(&queue.QueueSchema{}))
+	doc.AddOutbound(protocol.EVENT_RESUMETOKEN, "a token the client may reconnect with to resume its session", response(&protocol.ResumeToken{}))
+	doc.AddOutbound(protocol.EVENT_STACKSYNC, "the client's own queue", response(&queue.QueueSchema{}))
+	doc.AddOutbound(protocol.EVENT_STREAMLOAD, "a new stream was loaded in the room", response(status))
+	doc.AddOutbound(protocol.EVENT_STREAMSCHEDULE, "counts down to a scheduled stream start", response(&protocol.StreamSchedule{}))
+	doc.AddOutbound(protocol.EVENT_STREAMSYNC, "the room's playback status", response(status))
+	doc.AddOutbound(protocol.EVENT_UPDATEUSERNAME, "the client's username changed", response(nil))
+	doc.AddOutbound(protocol.EVENT_USERLIST, "the clients in the room", &client.SerializableClientList{})
+
+	return doc
+}
+
+func NewProtocolEndpoint() ApiEndpoint {
+	b, err := ProtocolDocument().Serialize()
+	if err != nil {
+		panic(fmt.Sprintf("unable to serialize socket protocol document: %v", err))
+	}
+
+	return &ProtocolEndpoint{
+		ApiEndpointSchema: &ApiEndpointSchema{
+			path: PROTOCOL_ENDPOINT_PREFIX,
+		},
+		document: b,
+	}
+}
@@ -2,4 +2,7 @@ package query
 
 const (
 	CONN_ID_KEY = "id"
+	// TOKEN_KEY names the parameter carrying the api token
+	// of the connection identified by CONN_ID_KEY
+	TOKEN_KEY = "token"
 )
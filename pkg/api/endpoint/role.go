@@ -0,0 +1,216 @@
+package endpoint
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+
+	api "github.com/juanvallejo/streaming-server/pkg/api/types"
+	"github.com/juanvallejo/streaming-server/pkg/socket/cmd/rbac"
+	"github.com/juanvallejo/streaming-server/pkg/socket/connection"
+)
+
+const (
+	ROLE_ENDPOINT_PREFIX = "/roles"
+
+	maxRoleDefinitionBytes = 1 << 16
+)
+
+// RoleEndpoint implements ApiEndpoint
+type RoleEndpoint struct {
+	*ApiEndpointSchema
+}
+
+// Handle lists, creates, deletes, and edits rbac roles.
+// Requests are of the form:
+//
+//	GET    /api/roles[?id=<connId>&token=<token>]
+//	GET    /api/roles/<name>[?id=<connId>&token=<token>]
+//	POST   /api/roles/<name>?id=<connId>&token=<token>            (optional RoleDefinition body)
+//	DELETE /api/roles/<name>?id=<connId>&token=<token>
+//	POST   /api/roles/<name>/grant?id=<connId>&token=<token>&action=<action>[&action=...]
+//	POST   /api/roles/<name>/deny?id=<connId>&token=<token>&action=<action>[&action=...]
+//	POST   /api/roles/<name>/revoke?id=<connId>&token=<token>&action=<action>
+//
+// Requests that change roles must be made on behalf of a connection, with
+// its api token, bound to a role allowing the equivalent /role command.
+// Roles created this way belong to the connection's room: they may only
+// be used and changed there. Roles shared by every room are read-only.
+// Lists include the roles of the requesting connection's room, if any.
+func (e *RoleEndpoint) Handle(connHandler connection.ConnectionHandler, segments []string, w http.ResponseWriter, r *http.Request) {
+	authorizer := connHandler.Authorizer()
+	if authorizer == nil {
+		HandleEndpointError(fmt.Errorf("authorizer not enabled; endpoint unavailable"), w)
+		return
+	}
+
+	if len(segments) < 2 {
+		if r.Method != http.MethodGet {
+			HandleEndpointError(fmt.Errorf("unsupported method %v", r.Method), w)
+			return
+		}
+
+		list := &rbac.RoleDefinitionList{
+			Kind:  api.API_TYPE_ROLE_LIST,
+			Roles: []rbac.RoleDefinition{},
+		}
+		for _, role := range rbac.NamespaceRoles(authorizer, requestNamespaceName(connHandler, r)) {
+			list.Roles = append(list.Roles, rbac.DefinitionForRole(role))
+		}
+
+		writeRoleResponse(list, w)
+		return
+	}
+
+	roleName := segments[1]
+
+	if len(segments) == 2 && r.Method == http.MethodGet {
+		role, exists := rbac.NamespaceRole(authorizer, requestNamespaceName(connHandler, r), roleName)
+		if !exists {
+			HandleEndpointError(fmt.Errorf("role %q not found", roleName), w)
+			return
+		}
+
+		writeRoleResponse(&rbac.RoleDefinitionList{
+			Kind:  api.API_TYPE_ROLE_LIST,
+			Roles: []rbac.RoleDefinition{rbac.DefinitionForRole(role)},
+		}, w)
+		return
+	}
+
+	verb := ""
+	switch {
+	case len(segments) == 2 && r.Method == http.MethodPost:
+		verb = "create"
+	case len(segments) == 2 && r.Method == http.MethodDelete:
+		verb = "delete"
+	case len(segments) == 3 && r.Method == http.MethodPost && (segments[2] == "grant" || segments[2] == "deny" || segments[2] == "revoke"):
+		verb = segments[2]
+	default:
+		HandleEndpointNotFound(w)
+		return
+	}
+
+	actions := r.URL.Query()["action"]
+	if (verb == "grant" || verb == "deny" || verb == "revoke") && len(actions) == 0 {
+		HandleEndpointError(fmt.Errorf("missing required parameter: action"), w)
+		return
+	}
+
+	conn, err := authorizeRoleRequest(connHandler, r, append([]string{"role", verb, roleName}, actions...))
+	if err != nil {
+		HandleEndpointError(err, w)
+		return
+	}
+
+	ns, exists := conn.Namespace()
+	if !exists {
+		HandleEndpointError(fmt.Errorf("the connection specified has not been bound to a namespace"), w)
+		return
+	}
+
+	switch verb {
+	case "create":
+		def := rbac.RoleDefinition{Name: roleName}
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRoleDefinitionBytes))
+		if err != nil {
+			HandleEndpointError(fmt.Errorf("unable to read request body: %v", err), w)
+			return
+		}
+		if len(strings.TrimSpace(string(body))) > 0 {
+			if err := json.Unmarshal(body, &def); err != nil {
+				HandleEndpointError(fmt.Errorf("unable to parse role definition: %v", err), w)
+				return
+			}
+			def.Name = roleName
+		}
+		def.Namespace = ns.Name()
+
+		if _, exists := authorizer.Role(roleName); exists {
+			HandleEndpointError(fmt.Errorf("a role with the name %q already exists", roleName), w)
+			return
+		}
+		if err := rbac.ApplyRoleDefinitions(authorizer, []rbac.RoleDefinition{def}); err != nil {
+			HandleEndpointError(err, w)
+			return
+		}
+	case "delete":
+		if err := rbac.DeleteRole(authorizer, ns.Name(), roleName); err != nil {
+			HandleEndpointError(err, w)
+			return
+		}
+	case "grant":
+		if err := rbac.Grant(authorizer, ns.Name(), roleName, actions...); err != nil {
+			HandleEndpointError(err, w)
+			return
+		}
+	case "deny":
+		if err := rbac.Deny(authorizer, ns.Name(), roleName, actions...); err != nil {
+			HandleEndpointError(err, w)
+			return
+		}
+	case "revoke":
+		for _, action := range actions {
+			if _, err := rbac.Revoke(authorizer, ns.Name(), roleName, action); err != nil {
+				HandleEndpointError(err, w)
+				return
+			}
+		}
+	}
+
+	log.Printf("INF API AUTHZ connection with id (%s) in room %q performed %q on role %q %v\n", conn.UUID(), ns.Name(), verb, roleName, actions)
+	HandleEndpointSuccess(fmt.Sprintf("successfully performed %q on role %q", verb, roleName), w)
+}
+
+// authorizeRoleRequest returns the connection a request was made on behalf of,
+// or an error if the request could not be authenticated, or that connection
+// is not allowed to perform the given action.
+func authorizeRoleRequest(connHandler connection.ConnectionHandler, r *http.Request, action []string) (connection.Connection, error) {
+	conn, err := authenticateConnection(connHandler, r)
+	if err != nil {
+		return nil, err
+	}
+
+	if !connHandler.Authorizer().VerifyAction(conn, strings.Join(action, "/")) {
+		return nil, fmt.Errorf("connection with id %v is not authorized to perform that action", conn.UUID())
+	}
+
+	return conn, nil
+}
+
+// requestNamespaceName returns the name of the namespace of the connection
+// a request was made on behalf of, or an empty string if the request
+// could not be authenticated, or the connection is not in a namespace.
+func requestNamespaceName(connHandler connection.ConnectionHandler, r *http.Request) string {
+	conn, err := authenticateConnection(connHandler, r)
+	if err != nil {
+		return ""
+	}
+
+	ns, exists := conn.Namespace()
+	if !exists {
+		return ""
+	}
+	return ns.Name()
+}
+
+func writeRoleResponse(list *rbac.RoleDefinitionList, w http.ResponseWriter) {
+	b, err := list.Serialize()
+	if err != nil {
+		HandleEndpointError(err, w)
+		return
+	}
+
+	w.Write(b)
+}
+
+func NewRoleEndpoint() ApiEndpoint {
+	return &RoleEndpoint{
+		&ApiEndpointSchema{
+			path: ROLE_ENDPOINT_PREFIX,
+		},
+	}
+}
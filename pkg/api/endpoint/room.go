@@ -0,0 +1,125 @@
+package endpoint
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/juanvallejo/streaming-server/pkg/audit"
+	"github.com/juanvallejo/streaming-server/pkg/playback"
+	"github.com/juanvallejo/streaming-server/pkg/socket/connection"
+)
+
+const ROOM_ENDPOINT_PREFIX = "/room"
+
+// RoomEndpoint implements ApiEndpoint
+type RoomEndpoint struct {
+	*ApiEndpointSchema
+
+	playbackHandler playback.PlaybackHandler
+}
+
+// Handle serves information about a room's playback.
+// Requests are of the form /api/room/<name>/<resource>.
+// The audit resource must be requested on behalf of a connection
+// in the room, given as ?id=<connId>&token=<token>, and accepts the filters
+// user, action, kind, since, until and limit as query parameters.
+func (e *RoomEndpoint) Handle(connHandler connection.ConnectionHandler, segments []string, w http.ResponseWriter, r *http.Request) {
+	if len(segments) < 3 {
+		HandleEndpointError(fmt.Errorf("not enough arguments: /room/name/resource"), w)
+		return
+	}
+
+	ns, exists := connHandler.NamespaceByName(segments[1])
+	if !exists {
+		HandleEndpointError(fmt.Errorf("unable to find room with name %q", segments[1]), w)
+		return
+	}
+
+	p, exists := e.playbackHandler.PlaybackByNamespace(ns)
+	if !exists {
+		HandleEndpointError(fmt.Errorf("no playback found for room with name %q", segments[1]), w)
+		return
+	}
+
+	switch segments[2] {
+	case "audit":
+		if err := authorizeAuditRequest(connHandler, ns, r); err != nil {
+			HandleEndpointError(err, w)
+			return
+		}
+
+		filter, err := audit.FilterFromValues(r.URL.Query())
+		if err != nil {
+			HandleEndpointError(err, w)
+			return
+		}
+
+		b, err := ns.Audit().SerializeFiltered(filter)
+		if err != nil {
+			HandleEndpointError(err, w)
+			return
+		}
+
+		w.Write(b)
+		return
+	case "history":
+		b, err := p.History().Serialize()
+		if err != nil {
+			HandleEndpointError(err, w)
+			return
+		}
+
+		w.Write(b)
+		return
+	case "schedules":
+		b, err := p.Scheduler().Serialize()
+		if err != nil {
+			HandleEndpointError(err, w)
+			return
+		}
+
+		w.Write(b)
+		return
+	case "limits":
+		b, err := p.Limits().Serialize()
+		if err != nil {
+			HandleEndpointError(err, w)
+			return
+		}
+
+		w.Write(b)
+		return
+	}
+
+	HandleEndpointNotFound(w)
+}
+
+// authorizeAuditRequest returns an error if the request could not be
+// authenticated, if the connection making it is not in the given namespace,
+// or, if rbac is enabled, is not bound to a role allowing the /audit command.
+func authorizeAuditRequest(connHandler connection.ConnectionHandler, ns connection.Namespace, r *http.Request) error {
+	conn, err := authenticateConnection(connHandler, r)
+	if err != nil {
+		return err
+	}
+
+	if connNs, exists := conn.Namespace(); !exists || connNs.Name() != ns.Name() {
+		return fmt.Errorf("connection with id %v is not in room %q", conn.UUID(), ns.Name())
+	}
+
+	if authorizer := connHandler.Authorizer(); authorizer != nil && !authorizer.VerifyAction(conn, "audit") {
+		return fmt.Errorf("connection with id %v is not authorized to view the audit log", conn.UUID())
+	}
+
+	return nil
+}
+
+func NewRoomEndpoint(playbackHandler playback.PlaybackHandler) ApiEndpoint {
+	return &RoomEndpoint{
+		ApiEndpointSchema: &ApiEndpointSchema{
+			path: ROOM_ENDPOINT_PREFIX,
+		},
+
+		playbackHandler: playbackHandler,
+	}
+}
@@ -0,0 +1,187 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/juanvallejo/streaming-server/pkg/account"
+	"github.com/juanvallejo/streaming-server/pkg/account/oidc"
+	"github.com/juanvallejo/streaming-server/pkg/socket/connection"
+)
+
+// oidcTestServer serves the api, logging in to accounts through
+// an in-process mock identity provider
+type oidcTestServer struct {
+	api      *httptest.Server
+	idp      *httptest.Server
+	accounts account.Manager
+}
+
+func newOIDCTestServer(t *testing.T) *oidcTestServer {
+	var mock *oidc.MockProvider
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mock.ServeHTTP(w, r)
+	}))
+	t.Cleanup(idp.Close)
+
+	var err error
+	mock, err = oidc.NewMockProvider(idp.URL, oidc.MOCK_CLIENT_ID, oidc.MOCK_CLIENT_SECRET, nil)
+	if err != nil {
+		t.Fatalf("unable to create mock identity provider: %v", err)
+	}
+
+	accounts := account.NewManager(account.NewMemoryStore())
+	connHandler := connection.NewHandler(connection.NewNamespaceHandler())
+	connHandler.SetAccounts(accounts)
+
+	handler := NewHandler(connHandler, nil)
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	connHandler.SetIdentityProvider(oidc.NewProvider(mock.Config(srv.URL + ApiPrefix + "/auth/oidc/callback")))
+
+	return &oidcTestServer{
+		api:      srv,
+		idp:      idp,
+		accounts: accounts,
+	}
+}
+
+// noRedirects is an http client that returns redirect responses
+// instead of following them
+var noRedirects = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// expectRedirect requests a url and returns the location it redirects to
+func expectRedirect(t *testing.T, u string) (*url.URL, *http.Response) {
+	t.Helper()
+
+	res, err := noRedirects.Get(u)
+	if err != nil {
+		t.Fatalf("unable to request %q: %v", u, err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("expected %q to redirect, got status %v", u, res.StatusCode)
+	}
+	location, err := res.Location()
+	if err != nil {
+		t.Fatalf("expected %q to redirect to a location: %v", u, err)
+	}
+	return location, res
+}
+
+// login runs the authorization code flow, signing in to the mock provider
+// as the given user. Returns the callback url the provider redirected to.
+func (s *oidcTestServer) login(t *testing.T, user, returnPath string) *url.URL {
+	t.Helper()
+
+	authorize, _ := expectRedirect(t, s.api.URL+ApiPrefix+"/auth/oidc/login?return="+url.QueryEscape(returnPath))
+	if authorize.Scheme+"://"+authorize.Host != s.idp.URL || authorize.Path != oidc.MOCK_AUTHORIZE_PATH {
+		t.Fatalf("expected login to redirect to the provider's authorization endpoint, got %q", authorize)
+	}
+	for _, param := range []string{"state", "nonce", "redirect_uri"} {
+		if len(authorize.Query().Get(param)) == 0 {
+			t.Fatalf("expected the authorization request to carry a %q parameter", param)
+		}
+	}
+
+	q := authorize.Query()
+	q.Set("login_hint", user)
+	authorize.RawQuery = q.Encode()
+
+	callback, _ := expectRedirect(t, authorize.String())
+	if callback.Scheme+"://"+callback.Host != s.api.URL || callback.Path != ApiPrefix+"/auth/oidc/callback" {
+		t.Fatalf("expected the provider to redirect to the callback endpoint, got %q", callback)
+	}
+	return callback
+}
+
+func TestOIDCLoginMapsClaimsToRoles(t *testing.T) {
+	s := newOIDCTestServer(t)
+
+	tests := []struct {
+		user     string
+		username string
+		roles    []string
+	}{
+		{user: "alice@example.com", username: "alice", roles: []string{"admin"}},
+		{user: "bob@example.com", username: "bob", roles: []string{"user"}},
+		// carol belongs to no mapped group
+		{user: "carol@example.com", username: "carol", roles: []string{"viewer"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.username, func(t *testing.T) {
+			callback := s.login(t, tt.user, "/v/room")
+
+			returned, res := expectRedirect(t, callback.String())
+			if returned.Path != "/v/room" {
+				t.Fatalf("expected the callback to return to %q, got %q", "/v/room", returned.Path)
+			}
+
+			var session *http.Cookie
+			for _, c := range res.Cookies() {
+				if c.Name == account.SESSION_COOKIE_NAME {
+					session = c
+				}
+			}
+			if session == nil {
+				t.Fatalf("expected the callback to set a session cookie")
+			}
+
+			a, ok := s.accounts.Authenticate(session.Value)
+			if !ok {
+				t.Fatalf("expected the session cookie to log in to an account")
+			}
+			if a.Username != tt.username {
+				t.Fatalf("expected to be logged in as %q, got %q", tt.username, a.Username)
+			}
+			if a.Issuer != s.idp.URL || len(a.Subject) == 0 {
+				t.Fatalf("expected the account to be linked to the provider's identity, got issuer %q, subject %q", a.Issuer, a.Subject)
+			}
+			if !reflect.DeepEqual(a.ProviderRoles, tt.roles) {
+				t.Fatalf("expected roles %v to be mapped from the identity's groups, got %v", tt.roles, a.ProviderRoles)
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackRejectsReplayedState(t *testing.T) {
+	s := newOIDCTestServer(t)
+
+	callback := s.login(t, "alice@example.com", "/")
+	expectRedirect(t, callback.String())
+
+	// a state, and an authorization code, may only be used once
+	res, err := noRedirects.Get(callback.String())
+	if err != nil {
+		t.Fatalf("unable to request %q: %v", callback, err)
+	}
+	res.Body.Close()
+	if res.StatusCode == http.StatusFound {
+		t.Fatalf("expected a replayed callback to be refused")
+	}
+	for _, c := range res.Cookies() {
+		if c.Name == account.SESSION_COOKIE_NAME {
+			t.Fatalf("expected a replayed callback not to set a session cookie")
+		}
+	}
+}
+
+func TestOIDCLoginOnlyReturnsToLocalPaths(t *testing.T) {
+	s := newOIDCTestServer(t)
+
+	callback := s.login(t, "bob@example.com", "https://example.com/phish")
+	returned, _ := expectRedirect(t, callback.String())
+	if returned.Host == "example.com" || returned.Path != "/" {
+		t.Fatalf("expected an external return path to be replaced with %q, got %q", "/", returned)
+	}
+}
@@ -0,0 +1,248 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/juanvallejo/streaming-server/pkg/account"
+	"github.com/juanvallejo/streaming-server/pkg/api/endpoint"
+	"github.com/juanvallejo/streaming-server/pkg/api/endpoint/query"
+	"github.com/juanvallejo/streaming-server/pkg/playback"
+	"github.com/juanvallejo/streaming-server/pkg/socket"
+	"github.com/juanvallejo/streaming-server/pkg/socket/client"
+	"github.com/juanvallejo/streaming-server/pkg/socket/cmd"
+	"github.com/juanvallejo/streaming-server/pkg/socket/cmd/rbac"
+	"github.com/juanvallejo/streaming-server/pkg/socket/connection"
+	"github.com/juanvallejo/streaming-server/pkg/socket/protocol"
+	"github.com/juanvallejo/streaming-server/pkg/stream"
+)
+
+// rbacTestServer serves the socket handler and the api, sharing
+// connections the way the server shares them, with rbac enabled
+type rbacTestServer struct {
+	socket   *httptest.Server
+	api      *httptest.Server
+	accounts account.Manager
+}
+
+func newRBACTestServer(t *testing.T) *rbacTestServer {
+	authorizer := rbac.NewAuthorizer()
+	cmd.AddDefaultRoles(authorizer)
+
+	nsHandler := connection.NewNamespaceHandler()
+	connHandler := connection.NewHandlerWithRBAC(authorizer, nsHandler)
+	accounts := account.NewManager(account.NewMemoryStore())
+	connHandler.SetAccounts(accounts)
+	playbackHandler := playback.NewGarbageCollectedHandler(nsHandler)
+
+	socketSrv := httptest.NewServer(socket.NewHandler(
+		nsHandler,
+		connHandler,
+		cmd.NewHandlerWithRBAC(authorizer),
+		client.NewHandler(),
+		playbackHandler,
+		stream.NewGarbageCollectedHandler(),
+	))
+	t.Cleanup(socketSrv.Close)
+
+	apiSrv := httptest.NewServer(NewHandler(connHandler, playbackHandler))
+	t.Cleanup(apiSrv.Close)
+
+	return &rbacTestServer{
+		socket:   socketSrv,
+		api:      apiSrv,
+		accounts: accounts,
+	}
+}
+
+// rbacTestClient is a browser connected to a room, with its roles initialized
+type rbacTestClient struct {
+	id    string
+	token string
+}
+
+// join connects a client to a room, asks for its authorization endpoint,
+// and requests the endpoint it is sent, the way a browser would. The
+// given cookies are sent when connecting, but not with the api request.
+func (s *rbacTestServer) join(t *testing.T, room string, cookies ...*http.Cookie) *rbacTestClient {
+	t.Helper()
+
+	header := http.Header{}
+	for _, cookie := range cookies {
+		header.Add("Cookie", cookie.String())
+	}
+
+	u := "ws" + strings.TrimPrefix(s.socket.URL, "http") + "/v/" + room + "?" + protocol.PROTOCOL_VERSION_KEY + "=" + fmt.Sprintf("%v", protocol.VERSION)
+	ws, _, err := websocket.DefaultDialer.Dial(u, header)
+	if err != nil {
+		t.Fatalf("unable to connect to room %q: %v", room, err)
+	}
+	t.Cleanup(func() {
+		ws.Close()
+	})
+
+	if err := ws.WriteJSON(map[string]interface{}{
+		"event": protocol.EVENT_REQUEST_AUTHORIZATION,
+		"data":  &protocol.EmptyRequest{},
+	}); err != nil {
+		t.Fatalf("unable to request authorization information: %v", err)
+	}
+
+	ws.SetReadDeadline(time.Now().Add(10 * time.Second))
+	for {
+		_, b, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatalf("unable to read an authorization event: %v", err)
+		}
+
+		message := struct {
+			Event string `json:"event"`
+			Data  struct {
+				Id    string                        `json:"id"`
+				Extra protocol.AuthorizationRequest `json:"extra"`
+			} `json:"data"`
+		}{}
+		if err := json.Unmarshal(b, &message); err != nil || message.Event != protocol.EVENT_AUTHORIZATION {
+			continue
+		}
+
+		c := &rbacTestClient{
+			id:    message.Data.Id,
+			token: message.Data.Extra.Token,
+		}
+		if len(c.token) == 0 || !strings.Contains(message.Data.Extra.Endpoint, query.TOKEN_KEY+"="+c.token) {
+			t.Fatalf("expected the authorization event to carry the connection's api token, got %+v", message.Data.Extra)
+		}
+		if res := s.request(t, http.MethodGet, message.Data.Extra.Endpoint); len(res.Error) > 0 {
+			t.Fatalf("unable to initialize roles: %v", res.Error)
+		}
+		return c
+	}
+}
+
+// params returns query parameters identifying the client, with the given api token
+func (c *rbacTestClient) params(token string) string {
+	return fmt.Sprintf("%s=%s&%s=%s", query.CONN_ID_KEY, url.QueryEscape(c.id), query.TOKEN_KEY, url.QueryEscape(token))
+}
+
+func (s *rbacTestServer) request(t *testing.T, method, path string, cookies ...*http.Cookie) *endpoint.ApiResponse {
+	t.Helper()
+
+	req, err := http.NewRequest(method, s.api.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Referer", s.socket.URL)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unable to request %q: %v", path, err)
+	}
+	defer res.Body.Close()
+
+	apiRes := &endpoint.ApiResponse{}
+	if err := json.NewDecoder(res.Body).Decode(apiRes); err != nil {
+		t.Fatalf("unable to decode response to %q: %v", path, err)
+	}
+	return apiRes
+}
+
+func (s *rbacTestServer) roleNames(t *testing.T, params string) []string {
+	t.Helper()
+
+	res, err := http.Get(s.api.URL + ApiPrefix + "/roles?" + params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	list := &rbac.RoleDefinitionList{}
+	if err := json.NewDecoder(res.Body).Decode(list); err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+	for _, role := range list.Roles {
+		names = append(names, role.Name)
+	}
+	return names
+}
+
+func TestRoleEndpointRequiresApiToken(t *testing.T) {
+	s := newRBACTestServer(t)
+	admin := s.join(t, "room-a")
+	user := s.join(t, "room-a")
+
+	for _, tt := range []struct {
+		name   string
+		params string
+	}{
+		{name: "no token", params: query.CONN_ID_KEY + "=" + url.QueryEscape(admin.id)},
+		{name: "another connection's token", params: admin.params(user.token)},
+		{name: "own token, without admin", params: user.params(user.token)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if res := s.request(t, http.MethodPost, ApiPrefix+"/roles/dj?"+tt.params); len(res.Error) == 0 {
+				t.Fatalf("expected role creation to be refused, got %q", res.Message)
+			}
+		})
+	}
+
+	if res := s.request(t, http.MethodPost, ApiPrefix+"/roles/dj?"+admin.params(admin.token)); len(res.Error) > 0 {
+		t.Fatalf("expected an admin to create a role, got error %q", res.Error)
+	}
+	if res := s.request(t, http.MethodPost, ApiPrefix+"/roles/dj/grant?"+admin.params(admin.token)+"&action=stream/skip"); len(res.Error) > 0 {
+		t.Fatalf("expected an admin to edit a role created by their room, got error %q", res.Error)
+	}
+	if res := s.request(t, http.MethodPost, ApiPrefix+"/roles/"+rbac.USER_ROLE+"/grant?"+admin.params(admin.token)+"&action=stream/skip"); len(res.Error) == 0 {
+		t.Fatalf("expected a room admin not to edit a role shared by every room")
+	}
+}
+
+func TestRoleEndpointScopesRolesToRooms(t *testing.T) {
+	s := newRBACTestServer(t)
+	adminA := s.join(t, "room-a")
+	adminB := s.join(t, "room-b")
+
+	if res := s.request(t, http.MethodPost, ApiPrefix+"/roles/dj?"+adminA.params(adminA.token)); len(res.Error) > 0 {
+		t.Fatalf("unable to create role: %v", res.Error)
+	}
+
+	for _, verb := range []string{"grant", "deny", "revoke"} {
+		if res := s.request(t, http.MethodPost, ApiPrefix+"/roles/dj/"+verb+"?"+adminB.params(adminB.token)+"&action=stream/skip"); len(res.Error) == 0 {
+			t.Fatalf("expected the admin of another room not to %s actions of the role", verb)
+		}
+	}
+	if res := s.request(t, http.MethodDelete, ApiPrefix+"/roles/dj?"+adminB.params(adminB.token)); len(res.Error) == 0 {
+		t.Fatalf("expected the admin of another room not to delete the role")
+	}
+
+	hasRole := func(names []string) bool {
+		for _, name := range names {
+			if name == "dj" {
+				return true
+			}
+		}
+		return false
+	}
+	if !hasRole(s.roleNames(t, adminA.params(adminA.token))) {
+		t.Fatalf("expected the role to be listed for the room that created it")
+	}
+	if hasRole(s.roleNames(t, adminB.params(adminB.token))) {
+		t.Fatalf("expected the role not to be listed for another room")
+	}
+	if hasRole(s.roleNames(t, "")) {
+		t.Fatalf("expected the role not to be listed without a connection")
+	}
+}
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/juanvallejo/streaming-server/pkg/api/endpoint/query"
+)
+
+func TestAuditEndpointRequiresApiToken(t *testing.T) {
+	s := newRBACTestServer(t)
+	admin := s.join(t, "room-a")
+	user := s.join(t, "room-a")
+	otherAdmin := s.join(t, "room-b")
+
+	tests := []struct {
+		name      string
+		params    string
+		expectErr bool
+	}{
+		{name: "admin with own token", params: admin.params(admin.token)},
+		{name: "no token", params: query.CONN_ID_KEY + "=" + url.QueryEscape(admin.id), expectErr: true},
+		{name: "another connection's token", params: admin.params(user.token), expectErr: true},
+		{name: "without the audit action", params: user.params(user.token), expectErr: true},
+		{name: "admin of another room", params: otherAdmin.params(otherAdmin.token), expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := s.request(t, http.MethodGet, ApiPrefix+"/room/room-a/audit?"+tt.params)
+			if (len(res.Error) > 0) != tt.expectErr {
+				t.Fatalf("expected error %v, got %q", tt.expectErr, res.Error)
+			}
+		})
+	}
+}
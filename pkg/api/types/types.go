@@ -1,7 +1,14 @@
 package types
 
 const (
-	API_TYPE_STREAM_LIST = "streamList"
+	API_TYPE_STREAM_LIST   = "streamList"
+	API_TYPE_HISTORY_LIST  = "historyList"
+	API_TYPE_SCHEDULE_LIST = "scheduleList"
+	API_TYPE_ROOM_LIMITS   = "roomLimits"
+	API_TYPE_ROLE_LIST     = "roleList"
+	API_TYPE_AUDIT_LOG     = "auditLog"
+	API_TYPE_ACCOUNT       = "account"
+	API_TYPE_METRICS       = "metrics"
 )
 
 // ApiCodec provides methods of serializing and de-serializing
@@ -0,0 +1,216 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	api "github.com/juanvallejo/streaming-server/pkg/api/types"
+)
+
+const (
+	DefaultRetention = 500   // default max number of audit entries to keep per room
+	MaxRetention     = 10000 // max number of audit entries a room may be configured to keep
+
+	KIND_COMMAND = "command"
+	KIND_ROLE    = "role"
+	KIND_AUTH    = "auth"
+)
+
+// Entry is a serializable record of a privileged action
+type Entry struct {
+	Time time.Time `json:"time"`
+	Kind string    `json:"kind"`
+	// Actor is the username, or id, of the client that
+	// performed the action, or "system" for actions taken
+	// by the server on its own.
+	Actor   string `json:"actor"`
+	ActorId string `json:"actorId,omitempty"`
+	// Action is an rbac action ("queue/clear/room"),
+	// or a description of a role change ("bind/admin").
+	Action  string `json:"action"`
+	Allowed bool   `json:"allowed"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+// Filter selects audit entries. Empty fields match every entry.
+type Filter struct {
+	// Actor matches entries whose actor name or id equals the given value
+	Actor string
+	// Action matches entries whose action begins with the given value
+	Action string
+	Kind   string
+	Since  time.Time
+	Until  time.Time
+	// Limit is the max number of entries to return, or 0 for no limit
+	Limit int
+}
+
+// Matches returns a boolean (true) if the entry is selected by the filter
+func (f *Filter) Matches(e *Entry) bool {
+	if len(f.Actor) > 0 && e.Actor != f.Actor && e.ActorId != f.Actor {
+		return false
+	}
+	if len(f.Action) > 0 && !strings.HasPrefix(e.Action, f.Action) {
+		return false
+	}
+	if len(f.Kind) > 0 && e.Kind != f.Kind {
+		return false
+	}
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && e.Time.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// Log is an append-only record of privileged actions taken in a room.
+// Entries cannot be changed or removed once recorded; once a Log's
+// retention is exceeded, its oldest entries are discarded.
+// Implements api.ApiCodec.
+type Log struct {
+	entries   []*Entry
+	retention int
+
+	mux sync.Mutex
+}
+
+// Record appends an entry to the log, stamping it with the current time
+// if it has none, and discarding the oldest entry if retention is exceeded.
+func (l *Log) Record(e Entry) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	l.entries = append(l.entries, &e)
+	if len(l.entries) > l.retention {
+		l.entries = l.entries[len(l.entries)-l.retention:]
+	}
+}
+
+// List returns a copy of the entries selected by
+// the given filter, most recent first
+func (l *Log) List(f Filter) []Entry {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	items := []Entry{}
+	for i := len(l.entries) - 1; i >= 0; i-- {
+		if f.Limit > 0 && len(items) >= f.Limit {
+			break
+		}
+		if f.Matches(l.entries[i]) {
+			items = append(items, *l.entries[i])
+		}
+	}
+	return items
+}
+
+// Retention returns the max amount of entries kept by the log
+func (l *Log) Retention() int {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	return l.retention
+}
+
+// SetRetention receives a max amount of entries to keep, between 1 and
+// MaxRetention, and discards the oldest entries beyond it.
+func (l *Log) SetRetention(n int) {
+	if n < 1 {
+		n = 1
+	}
+	if n > MaxRetention {
+		n = MaxRetention
+	}
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	l.retention = n
+	if len(l.entries) > l.retention {
+		l.entries = l.entries[len(l.entries)-l.retention:]
+	}
+}
+
+// EntryList is a serializable schema representing a room's audit log
+type EntryList struct {
+	Kind      string  `json:"kind"`
+	Retention int     `json:"retention"`
+	Items     []Entry `json:"items"`
+}
+
+// Serialize returns every entry in the log, most recent first
+func (l *Log) Serialize() ([]byte, error) {
+	return l.SerializeFiltered(Filter{})
+}
+
+// SerializeFiltered returns the entries selected by the given filter
+func (l *Log) SerializeFiltered(f Filter) ([]byte, error) {
+	b, err := json.Marshal(&EntryList{
+		Kind:      api.API_TYPE_AUDIT_LOG,
+		Retention: l.Retention(),
+		Items:     l.List(f),
+	})
+	if err != nil {
+		return []byte{}, err
+	}
+
+	return b, nil
+}
+
+func NewLog(retention int) *Log {
+	l := &Log{
+		entries: []*Entry{},
+	}
+	l.SetRetention(retention)
+	return l
+}
+
+// FilterFromValues receives a set of values keyed by filter field
+// ("user", "action", "kind", "since", "until", "limit") and returns
+// the Filter they describe. Times may be given as RFC3339 timestamps,
+// or as durations ("90m") counting back from the current time.
+// Returns an error if a value cannot be parsed.
+func FilterFromValues(values url.Values) (Filter, error) {
+	f := Filter{
+		Actor:  values.Get("user"),
+		Action: strings.Trim(values.Get("action"), "/"),
+		Kind:   values.Get("kind"),
+	}
+
+	var err error
+	if f.Since, err = parseFilterTime(values.Get("since")); err != nil {
+		return Filter{}, fmt.Errorf("invalid value for \"since\": %v", err)
+	}
+	if f.Until, err = parseFilterTime(values.Get("until")); err != nil {
+		return Filter{}, fmt.Errorf("invalid value for \"until\": %v", err)
+	}
+
+	if limit := values.Get("limit"); len(limit) > 0 {
+		if f.Limit, err = strconv.Atoi(limit); err != nil || f.Limit < 0 {
+			return Filter{}, fmt.Errorf("invalid value for \"limit\": %q", limit)
+		}
+	}
+
+	return f, nil
+}
+
+func parseFilterTime(v string) (time.Time, error) {
+	if len(v) == 0 {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
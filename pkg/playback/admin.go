@@ -3,58 +3,173 @@ package playback
 import (
 	"fmt"
 	"log"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/juanvallejo/streaming-server/pkg/audit"
 	"github.com/juanvallejo/streaming-server/pkg/socket/client"
 	"github.com/juanvallejo/streaming-server/pkg/socket/cmd/rbac"
 	"github.com/juanvallejo/streaming-server/pkg/socket/connection"
+	"github.com/juanvallejo/streaming-server/pkg/socket/protocol"
 )
 
+// SelectionTimePeriod is the amount of time to wait after the
+// last admin leaves a room before electing the next admin
 var SelectionTimePeriod = 3 * time.Minute
 
+// ElectionPeriod is the amount of time between admin elections
+var ElectionPeriod = 1 * time.Minute
+
+const (
+	ADMIN_POLICY_OLDEST   = "oldest"
+	ADMIN_POLICY_ACTIVITY = "activity"
+	ADMIN_POLICY_VOTE     = "vote"
+	ADMIN_POLICY_OWNER    = "owner"
+)
+
+// AdminPolicies lists the names of every admin election policy
+var AdminPolicies = []string{
+	ADMIN_POLICY_OLDEST,
+	ADMIN_POLICY_ACTIVITY,
+	ADMIN_POLICY_VOTE,
+	ADMIN_POLICY_OWNER,
+}
+
 // TimeGate receives a time and returns a boolean indicating
 // whether or not the time received was "valid" based on a given
 // time period.
 type TimeGate func(time.Time, time.Duration) bool
 
+// AdminPicker elects a connection in a room to bind to the "admin"
+// rbac role whenever the room is left without an admin. Pickers
+// differ only in their election policy, implemented by Pick.
 type AdminPicker interface {
+	// Policy returns the name of the picker's election policy
+	Policy() string
 	// Stop cancels all pending admin selections
 	Stop() bool
-	// Clear clears the currently chosen candidate (if any)
-	// Returns the selected candidate for "admin" from the given connections.
+	// Pick returns the selected candidate for "admin" from the given connections.
+	// Returns a boolean (false) if no candidate could be selected.
 	Pick([]connection.Connection) (connection.Connection, bool)
 	// Init initializes the AdminPicker loop
 	Init(connection.Namespace, rbac.Authorizer, client.SocketClientHandler, PlaybackHandler) error
+	// Elect holds an election without waiting for the next election period
+	Elect()
 }
 
-// LeastRecentAdminHandler implements AdminHandler
-// and selects the connection with the most recent
-// timestamp to bind to the admin rbac role.
-type LeastRecentAdminPicker struct {
-	cancellable bool
-	cancelChan  chan bool
+// AdminReclaimer is implemented by AdminPickers whose candidates
+// are bound to the "admin" role even if the room already has an admin.
+type AdminReclaimer interface {
+	// Reclaims returns a boolean (true) if the given
+	// candidate is to be made admin unconditionally
+	Reclaims(connection.Connection) bool
 }
 
-func (p *LeastRecentAdminPicker) Init(ns connection.Namespace, authorizer rbac.Authorizer, clientHandler client.SocketClientHandler, playbackHandler PlaybackHandler) error {
+// adminElection runs the election loop shared by every AdminPicker
+type adminElection struct {
+	clock Clock
+
+	mux     sync.Mutex
+	running bool
+	stop    chan bool
+	wake    chan bool
+}
+
+func (e *adminElection) start(picker AdminPicker, ns connection.Namespace, authorizer rbac.Authorizer, clientHandler client.SocketClientHandler, playbackHandler PlaybackHandler) error {
 	if authorizer == nil {
 		return fmt.Errorf("no authorizer provided")
 	}
-	p.cancellable = true
 
-	go pickAdmin(p, authorizer, ns, clientHandler, playbackHandler, p.cancelChan)
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	if e.running {
+		return fmt.Errorf("admin picker already running")
+	}
+	e.running = true
+
+	go pickAdmin(picker, e.clock, authorizer, ns, clientHandler, playbackHandler, e.stop, e.wake)
 	return nil
 }
 
+func (e *adminElection) Stop() bool {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	if e.running {
+		e.stop <- true
+		e.running = false
+		return true
+	}
+	return false
+}
+
+func (e *adminElection) Elect() {
+	select {
+	case e.wake <- true:
+	default:
+		// an election is already pending
+	}
+}
+
+func newAdminElection(clock Clock) adminElection {
+	return adminElection{
+		clock: clock,
+		stop:  make(chan bool, 2),
+		wake:  make(chan bool, 1),
+	}
+}
+
+// LeastRecentAdminPicker implements AdminPicker
+// and selects the connection with the oldest
+// creation timestamp to bind to the admin rbac role.
+type LeastRecentAdminPicker struct {
+	adminElection
+}
+
+func (p *LeastRecentAdminPicker) Policy() string {
+	return ADMIN_POLICY_OLDEST
+}
+
+func (p *LeastRecentAdminPicker) Init(ns connection.Namespace, authorizer rbac.Authorizer, clientHandler client.SocketClientHandler, playbackHandler PlaybackHandler) error {
+	return p.start(p, ns, authorizer, clientHandler, playbackHandler)
+}
+
 func (p *LeastRecentAdminPicker) Pick(conns []connection.Connection) (connection.Connection, bool) {
+	return oldestConnection(conns)
+}
+
+func NewLeastRecentAdminPicker(clock Clock) AdminPicker {
+	return &LeastRecentAdminPicker{
+		adminElection: newAdminElection(clock),
+	}
+}
+
+// MostActiveAdminPicker implements AdminPicker and selects the
+// connection that most recently sent a message. Ties go to the
+// oldest connection.
+type MostActiveAdminPicker struct {
+	adminElection
+}
+
+func (p *MostActiveAdminPicker) Policy() string {
+	return ADMIN_POLICY_ACTIVITY
+}
+
+func (p *MostActiveAdminPicker) Init(ns connection.Namespace, authorizer rbac.Authorizer, clientHandler client.SocketClientHandler, playbackHandler PlaybackHandler) error {
+	return p.start(p, ns, authorizer, clientHandler, playbackHandler)
+}
+
+func (p *MostActiveAdminPicker) Pick(conns []connection.Connection) (connection.Connection, bool) {
 	if len(conns) == 0 {
 		return nil, false
 	}
 
 	pick := conns[0]
-
-	// select connection with most recent timestamp
-	for _, c := range conns {
-		if c.Metadata().CreationTimestamp().Sub(pick.Metadata().CreationTimestamp()) < 0 {
+	for _, c := range conns[1:] {
+		active, pickActive := c.Metadata().LastActivity(), pick.Metadata().LastActivity()
+		if active.After(pickActive) || (active.Equal(pickActive) && c.Metadata().CreationTimestamp().Before(pick.Metadata().CreationTimestamp())) {
 			pick = c
 		}
 	}
@@ -62,32 +177,203 @@ func (p *LeastRecentAdminPicker) Pick(conns []connection.Connection) (connection
 	return pick, true
 }
 
-func (p *LeastRecentAdminPicker) Stop() bool {
-	if p.cancellable {
-		p.cancelChan <- true
-		p.cancellable = false
-		return true
+func NewMostActiveAdminPicker(clock Clock) AdminPicker {
+	return &MostActiveAdminPicker{
+		adminElection: newAdminElection(clock),
 	}
-	return false
 }
 
-func NewLeastRecentAdminPicker() AdminPicker {
-	return &LeastRecentAdminPicker{
-		cancelChan: make(chan bool, 2),
+// VoteAdminPicker implements AdminPicker and selects the connection
+// with the most votes from other connections in the room. Ties go to
+// the oldest connection; if no votes have been cast, the oldest
+// connection is selected.
+type VoteAdminPicker struct {
+	adminElection
+
+	voteMux sync.Mutex
+	// votes maps voter connection ids to candidate connection ids
+	votes map[string]string
+}
+
+func (p *VoteAdminPicker) Policy() string {
+	return ADMIN_POLICY_VOTE
+}
+
+func (p *VoteAdminPicker) Init(ns connection.Namespace, authorizer rbac.Authorizer, clientHandler client.SocketClientHandler, playbackHandler PlaybackHandler) error {
+	return p.start(p, ns, authorizer, clientHandler, playbackHandler)
+}
+
+// Vote receives the connection ids of a voter and their candidate, and
+// records the vote, replacing any vote previously cast by the voter.
+func (p *VoteAdminPicker) Vote(voter, candidate string) {
+	p.voteMux.Lock()
+	defer p.voteMux.Unlock()
+
+	p.votes[voter] = candidate
+}
+
+// Tally returns the number of votes cast for each of the given
+// connections, by voters among them, keyed by connection id.
+// Votes cast by voters who have left are discarded.
+func (p *VoteAdminPicker) Tally(conns []connection.Connection) map[string]int {
+	present := make(map[string]bool)
+	for _, c := range conns {
+		present[c.UUID()] = true
+	}
+
+	p.voteMux.Lock()
+	defer p.voteMux.Unlock()
+
+	tally := make(map[string]int)
+	for voter, candidate := range p.votes {
+		if !present[voter] {
+			delete(p.votes, voter)
+			continue
+		}
+		if present[candidate] {
+			tally[candidate]++
+		}
 	}
+	return tally
 }
 
-func pickAdmin(picker AdminPicker, authorizer rbac.Authorizer, ns connection.Namespace, clientHandler client.SocketClientHandler, playbackHandler PlaybackHandler, stop chan bool) {
-	loopPeriod := 1 * time.Minute
+func (p *VoteAdminPicker) Pick(conns []connection.Connection) (connection.Connection, bool) {
+	tally := p.Tally(conns)
+	if len(tally) == 0 {
+		return oldestConnection(conns)
+	}
 
-	for {
-		time.Sleep(loopPeriod)
+	leaders := []connection.Connection{}
+	most := 0
+	for _, c := range conns {
+		switch n := tally[c.UUID()]; {
+		case n > most:
+			most = n
+			leaders = []connection.Connection{c}
+		case n == most && n > 0:
+			leaders = append(leaders, c)
+		}
+	}
+
+	return oldestConnection(leaders)
+}
+
+func NewVoteAdminPicker(clock Clock) AdminPicker {
+	return &VoteAdminPicker{
+		adminElection: newAdminElection(clock),
+		votes:         make(map[string]string),
+	}
+}
+
+// OwnerAdminPicker implements AdminPicker and AdminReclaimer. It
+// selects the room's owner, identified by account if they were logged
+// in when made owner, or else by browser identity. The owner regains
+// admin whenever they rejoin the room, even if it has another admin.
+// While the owner is away, the oldest connection is selected.
+type OwnerAdminPicker struct {
+	adminElection
+
+	account  string
+	identity string
+}
+
+func (p *OwnerAdminPicker) Policy() string {
+	return ADMIN_POLICY_OWNER
+}
+
+func (p *OwnerAdminPicker) Init(ns connection.Namespace, authorizer rbac.Authorizer, clientHandler client.SocketClientHandler, playbackHandler PlaybackHandler) error {
+	return p.start(p, ns, authorizer, clientHandler, playbackHandler)
+}
+
+// Owner returns a description of the room's owner
+func (p *OwnerAdminPicker) Owner() string {
+	if len(p.account) > 0 {
+		return fmt.Sprintf("account %q", p.account)
+	}
+	return "a browser identity"
+}
+
+// IsOwner returns a boolean (true) if the given connection belongs to the room's owner
+func (p *OwnerAdminPicker) IsOwner(conn connection.Connection) bool {
+	if len(p.account) > 0 {
+		username, loggedIn := conn.Metadata().Account()
+		return loggedIn && strings.EqualFold(username, p.account)
+	}
+	return len(p.identity) > 0 && conn.Metadata().Identity() == p.identity
+}
+
+func (p *OwnerAdminPicker) Reclaims(conn connection.Connection) bool {
+	return p.IsOwner(conn)
+}
+
+func (p *OwnerAdminPicker) Pick(conns []connection.Connection) (connection.Connection, bool) {
+	for _, c := range conns {
+		if p.IsOwner(c) {
+			return c, true
+		}
+	}
+	return oldestConnection(conns)
+}
+
+// NewOwnerAdminPicker receives the connection of the room's owner.
+// Returns an error if the connection has neither an account nor
+// a browser identity to recognize the owner by.
+func NewOwnerAdminPicker(clock Clock, owner connection.Connection) (AdminPicker, error) {
+	p := &OwnerAdminPicker{
+		adminElection: newAdminElection(clock),
+	}
+
+	if username, loggedIn := owner.Metadata().Account(); loggedIn {
+		p.account = username
+	} else {
+		p.identity = owner.Metadata().Identity()
+	}
+	if len(p.account) == 0 && len(p.identity) == 0 {
+		return nil, fmt.Errorf("unable to identify the owner's connection")
+	}
+
+	return p, nil
+}
+
+// NewAdminPicker receives the name of an election policy and returns an
+// AdminPicker implementing it. Returns an error if the policy is unknown,
+// or is the "owner" policy, whose pickers are created by NewOwnerAdminPicker.
+func NewAdminPicker(policy string, clock Clock) (AdminPicker, error) {
+	switch policy {
+	case ADMIN_POLICY_OLDEST:
+		return NewLeastRecentAdminPicker(clock), nil
+	case ADMIN_POLICY_ACTIVITY:
+		return NewMostActiveAdminPicker(clock), nil
+	case ADMIN_POLICY_VOTE:
+		return NewVoteAdminPicker(clock), nil
+	case ADMIN_POLICY_OWNER:
+		return nil, fmt.Errorf("an owner is required for the %q admin policy", policy)
+	}
+
+	return nil, fmt.Errorf("unknown admin policy %q: must be one of %s", policy, strings.Join(AdminPolicies, ", "))
+}
+
+// oldestConnection returns the connection with the oldest creation timestamp
+func oldestConnection(conns []connection.Connection) (connection.Connection, bool) {
+	if len(conns) == 0 {
+		return nil, false
+	}
 
+	sorted := append([]connection.Connection{}, conns...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Metadata().CreationTimestamp().Before(sorted[j].Metadata().CreationTimestamp())
+	})
+	return sorted[0], true
+}
+
+func pickAdmin(picker AdminPicker, clock Clock, authorizer rbac.Authorizer, ns connection.Namespace, clientHandler client.SocketClientHandler, playbackHandler PlaybackHandler, stop, wake chan bool) {
+	for {
 		select {
 		case <-stop:
 			log.Printf("INF PLAYBACK ADMIN-PICKER terminated for room %q.\n", ns.Name())
 			return
-		default:
+		case <-clock.After(ElectionPeriod):
+		case <-wake:
 		}
 
 		p, pExists := playbackHandler.PlaybackByNamespace(ns)
@@ -96,85 +382,121 @@ func pickAdmin(picker AdminPicker, authorizer rbac.Authorizer, ns connection.Nam
 			return
 		}
 
-		// define adminBindings before the rest of the admin-picking process
-		// to ensure we work with the same data throughout
-		adminBindings := []rbac.RoleBinding{}
-
-		for _, b := range authorizer.Bindings() {
-			if b.Role().Name() == rbac.ADMIN_ROLE {
-				adminBindings = append(adminBindings, b)
+		// elections are held on the room's event loop; a picker
+		// replaced while its election was pending holds no election
+		if !p.Do(func(p *Playback) {
+			if current, exists := p.AdminPicker(); exists && current == picker {
+				electAdmin(picker, clock, authorizer, ns, p, clientHandler)
 			}
+		}) {
+			log.Printf("INF PLAYBACK ADMIN-PICKER room %q has been cleaned up; terminating admin picker...\n", ns.Name())
+			return
 		}
+	}
+}
 
-		// give a buffer of at least SelectionTimerPeriod after the last admin leaves
-		// before attempting to select the next admin
-		if !p.LastAdminDepartureTime().Equal(time.Time{}) && time.Now().Sub(p.LastAdminDepartureTime()) < SelectionTimePeriod {
-			continue
+// electAdmin binds the admin role to the picker's candidate if the room
+// has no admin, and the last admin left at least SelectionTimePeriod ago,
+// or if the picker reclaims admin for its candidate.
+// Connections that have given up admin are never candidates.
+// Returns a boolean (true) if a candidate was bound to the admin role.
+func electAdmin(picker AdminPicker, clock Clock, authorizer rbac.Authorizer, ns connection.Namespace, p *Playback, clientHandler client.SocketClientHandler) bool {
+	// define adminBindings before the rest of the admin-picking process
+	// to ensure we work with the same data throughout
+	adminBindings := []rbac.RoleBinding{}
+
+	for _, b := range authorizer.Bindings() {
+		if b.Role().Name() == rbac.ADMIN_ROLE {
+			adminBindings = append(adminBindings, b)
 		}
+	}
 
-		candidate, exists := picker.Pick(ns.Connections())
-		if !exists {
-			continue
+	candidates := []connection.Connection{}
+	for _, c := range ns.Connections() {
+		if !p.AdminDeclined(c.UUID()) {
+			candidates = append(candidates, c)
 		}
+	}
 
-		// determine if at least one admin in namespace
-		if len(adminBindings) > 0 {
-			hasAdmin := false
-			for _, admins := range adminBindings {
-				for _, admin := range admins.Subjects() {
-					if findAdmin(ns.Connections(), admin) {
-						hasAdmin = true
-						break
-					}
-				}
-				if hasAdmin {
-					break
-				}
-			}
+	candidate, exists := picker.Pick(candidates)
+	if !exists {
+		return false
+	}
 
-			if hasAdmin {
-				continue
+	isAdmin := func(conn connection.Connection) bool {
+		for _, admins := range adminBindings {
+			for _, admin := range admins.Subjects() {
+				if admin.UUID() == conn.UUID() {
+					return true
+				}
 			}
 		}
+		return false
+	}
 
-		after := time.Now().Sub(p.LastAdminDepartureTime())
-		if p.LastAdminDepartureTime().Equal(time.Time{}) {
-			after = loopPeriod
-		}
-
-		log.Printf("INF PLAYBACK ADMIN-PICKER elected connection with id (%q) as admin candidate after %v...\n", candidate.UUID(), after)
+	reclaimer, reclaims := picker.(AdminReclaimer)
+	reclaims = reclaims && reclaimer.Reclaims(candidate)
 
-		adminRole, exists := authorizer.Role(rbac.ADMIN_ROLE)
-		if !exists {
-			log.Printf("WRN PLAYBACK ADMIN-PICKER admin role did not exist - creating empty role\n")
-			adminRole = rbac.NewRole(rbac.ADMIN_ROLE, []rbac.Rule{})
-			authorizer.AddRole(adminRole)
+	if reclaims {
+		if isAdmin(candidate) {
+			return false
+		}
+	} else {
+		// give a buffer of at least SelectionTimerPeriod after the last admin leaves
+		// before attempting to select the next admin
+		if !p.LastAdminDepartureTime().Equal(time.Time{}) && clock.Now().Sub(p.LastAdminDepartureTime()) < SelectionTimePeriod {
+			return false
 		}
 
-		if authorizer.Bind(adminRole, candidate) {
-			log.Printf("INF PLAYBACK ADMIN-PICKER bound connection with id (%s) to rbac role %q\n", candidate.UUID(), "admin")
-
-			// broadcast info to client
-			if c, err := clientHandler.GetClient(candidate.UUID()); err == nil {
-				c.BroadcastAuthRequestTo("cookie")
-				c.BroadcastSystemMessageTo("You have been selected as the new admin for this room.")
-				c.BroadcastAll("info_userlistupdated", &client.Response{
-					Id: c.UUID(),
-				})
-			} else {
-				log.Printf("ERR PLAYBACK ADMIN-PICKER unable to broadcast admin-picker events to client - no client found wih id %q\n", candidate.UUID())
+		// determine if at least one admin in namespace
+		for _, c := range ns.Connections() {
+			if isAdmin(c) {
+				return false
 			}
 		}
 	}
-}
 
-// findAdmin determines if a given subject exists within a given set of connections
-func findAdmin(subjects []connection.Connection, subject rbac.Subject) bool {
-	for _, c := range subjects {
-		if c.UUID() == subject.UUID() {
-			return true
+	after := clock.Now().Sub(p.LastAdminDepartureTime())
+	if p.LastAdminDepartureTime().Equal(time.Time{}) {
+		after = ElectionPeriod
+	}
+
+	log.Printf("INF PLAYBACK ADMIN-PICKER elected connection with id (%q) as admin candidate by %q policy after %v...\n", candidate.UUID(), picker.Policy(), after)
+
+	adminRole, exists := authorizer.Role(rbac.ADMIN_ROLE)
+	if !exists {
+		log.Printf("WRN PLAYBACK ADMIN-PICKER admin role did not exist - creating empty role\n")
+		adminRole = rbac.NewRole(rbac.ADMIN_ROLE, []rbac.Rule{})
+		authorizer.AddRole(adminRole)
+	}
+
+	if !authorizer.Bind(adminRole, candidate) {
+		return false
+	}
+
+	log.Printf("INF PLAYBACK ADMIN-PICKER bound connection with id (%s) to rbac role %q\n", candidate.UUID(), "admin")
+	ns.Audit().Record(audit.Entry{
+		Kind:    audit.KIND_ROLE,
+		Actor:   client.USER_SYSTEM,
+		Action:  "bind/" + rbac.ADMIN_ROLE,
+		Allowed: true,
+		Detail:  fmt.Sprintf("admin picker elected subject (%s) by %q policy", candidate.UUID(), picker.Policy()),
+	})
+
+	// broadcast info to client
+	if c, err := clientHandler.GetClient(candidate.UUID()); err == nil {
+		c.BroadcastAuthRequestTo("cookie")
+		if reclaims {
+			c.BroadcastSystemMessageTo("Welcome back. As the owner of this room, you are its admin.")
+		} else {
+			c.BroadcastSystemMessageTo("You have been selected as the new admin for this room.")
 		}
+		c.BroadcastAll(protocol.EVENT_INFO_USERLISTUPDATED, &client.Response{
+			Id: c.UUID(),
+		})
+	} else {
+		log.Printf("ERR PLAYBACK ADMIN-PICKER unable to broadcast admin-picker events to client - no client found wih id %q\n", candidate.UUID())
 	}
 
-	return false
+	return true
 }
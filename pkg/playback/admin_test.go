@@ -0,0 +1,305 @@
+package playback
+
+import (
+	"testing"
+	"time"
+
+	"github.com/juanvallejo/streaming-server/pkg/socket/client"
+	"github.com/juanvallejo/streaming-server/pkg/socket/cmd/rbac"
+	"github.com/juanvallejo/streaming-server/pkg/socket/connection"
+)
+
+// newFakeConns returns connections created a minute apart, in the given order
+func newFakeConns(clock *FakeClock, ids ...string) []*fakeConn {
+	conns := []*fakeConn{}
+	for _, id := range ids {
+		conns = append(conns, newFakeConn(id, clock))
+		clock.Advance(time.Minute)
+	}
+	return conns
+}
+
+// candidates returns the given connections in the given order
+func candidates(conns ...*fakeConn) []connection.Connection {
+	c := []connection.Connection{}
+	for _, conn := range conns {
+		c = append(c, conn)
+	}
+	return c
+}
+
+func expectPick(t *testing.T, picker AdminPicker, conns []connection.Connection, expected string) {
+	t.Helper()
+
+	pick, ok := picker.Pick(conns)
+	if len(expected) == 0 {
+		if ok {
+			t.Fatalf("expected no candidate, got %q", pick.UUID())
+		}
+		return
+	}
+	if !ok {
+		t.Fatalf("expected %q to be picked, got no candidate", expected)
+	}
+	if pick.UUID() != expected {
+		t.Fatalf("expected %q to be picked, got %q", expected, pick.UUID())
+	}
+}
+
+func TestLeastRecentAdminPicker(t *testing.T) {
+	clock := NewFakeClock()
+	picker := NewLeastRecentAdminPicker(clock)
+	c := newFakeConns(clock, "alice", "bob", "carol")
+
+	expectPick(t, picker, candidates(), "")
+	expectPick(t, picker, candidates(c[2], c[1], c[0]), "alice")
+	expectPick(t, picker, candidates(c[2], c[1]), "bob")
+
+	// activity does not affect the oldest connection policy
+	clock.Advance(time.Minute)
+	c[2].markActivity(clock)
+	expectPick(t, picker, candidates(c[2], c[1]), "bob")
+}
+
+func TestMostActiveAdminPicker(t *testing.T) {
+	clock := NewFakeClock()
+	picker := NewMostActiveAdminPicker(clock)
+	c := newFakeConns(clock, "alice", "bob", "carol")
+
+	expectPick(t, picker, candidates(), "")
+
+	// no activity since connecting: the most recent connection was active last
+	expectPick(t, picker, candidates(c...), "carol")
+
+	clock.Advance(time.Minute)
+	c[1].markActivity(clock)
+	expectPick(t, picker, candidates(c...), "bob")
+
+	clock.Advance(time.Second)
+	c[0].markActivity(clock)
+	expectPick(t, picker, candidates(c...), "alice")
+
+	// ties go to the oldest connection
+	c[2].markActivity(clock)
+	expectPick(t, picker, candidates(c[2], c[1], c[0]), "alice")
+	expectPick(t, picker, candidates(c[2], c[1]), "carol")
+}
+
+func TestVoteAdminPicker(t *testing.T) {
+	clock := NewFakeClock()
+	picker := NewVoteAdminPicker(clock).(*VoteAdminPicker)
+	c := newFakeConns(clock, "alice", "bob", "carol", "dave")
+
+	expectPick(t, picker, candidates(), "")
+
+	// no votes: the oldest connection is picked
+	expectPick(t, picker, candidates(c[3], c[2], c[1]), "bob")
+
+	picker.Vote("alice", "carol")
+	picker.Vote("bob", "dave")
+	picker.Vote("carol", "dave")
+	expectPick(t, picker, candidates(c...), "dave")
+
+	// a voter may change their vote; ties go to the oldest candidate
+	picker.Vote("carol", "carol")
+	expectPick(t, picker, candidates(c...), "carol")
+	if tally := picker.Tally(candidates(c...)); tally["carol"] != 2 || tally["dave"] != 1 {
+		t.Fatalf("expected carol to have 2 votes and dave 1, got %v", tally)
+	}
+
+	// votes cast by voters who left are discarded
+	expectPick(t, picker, candidates(c[1], c[2], c[3]), "carol")
+	if tally := picker.Tally(candidates(c...)); tally["carol"] != 1 || tally["dave"] != 1 {
+		t.Fatalf("expected alice's vote to have been discarded, got %v", tally)
+	}
+
+	// votes for candidates who left are not counted
+	expectPick(t, picker, candidates(c[0], c[1], c[2]), "carol")
+	picker.Vote("alice", "bob")
+	expectPick(t, picker, candidates(c[0], c[1], c[2]), "bob")
+}
+
+func TestOwnerAdminPicker(t *testing.T) {
+	clock := NewFakeClock()
+	c := newFakeConns(clock, "alice", "bob", "carol")
+
+	t.Run("by identity", func(t *testing.T) {
+		picker, err := NewOwnerAdminPicker(clock, c[1])
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		expectPick(t, picker, candidates(c...), "bob")
+		// the owner is away: the oldest connection is picked
+		expectPick(t, picker, candidates(c[2], c[0]), "alice")
+
+		// the owner reconnects from the same browser
+		returned := newFakeConn("bob-2", clock)
+		returned.metadata.identity = c[1].metadata.identity
+		expectPick(t, picker, candidates(c[0], c[2], returned), "bob-2")
+
+		reclaimer := picker.(AdminReclaimer)
+		if !reclaimer.Reclaims(returned) || reclaimer.Reclaims(c[0]) {
+			t.Fatalf("expected only the owner's connections to reclaim admin")
+		}
+	})
+
+	t.Run("by account", func(t *testing.T) {
+		owner := newFakeConn("dave", clock)
+		owner.metadata.account = "Dave"
+		picker, err := NewOwnerAdminPicker(clock, owner)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// the owner logs in from another browser
+		other := newFakeConn("dave-2", clock)
+		other.metadata.account = "dave"
+		expectPick(t, picker, candidates(c[0], other), "dave-2")
+
+		// the owner's browser, logged out, is not the owner
+		loggedOut := newFakeConn("dave-3", clock)
+		loggedOut.metadata.identity = owner.metadata.identity
+		expectPick(t, picker, candidates(loggedOut, c[0]), "alice")
+	})
+
+	t.Run("unidentifiable owner", func(t *testing.T) {
+		anonymous := newFakeConn("eve", clock)
+		anonymous.metadata.identity = ""
+		if _, err := NewOwnerAdminPicker(clock, anonymous); err == nil {
+			t.Fatalf("expected an error for an owner with no account or identity")
+		}
+	})
+}
+
+// newElectionRoom returns a room with the given connections,
+// and an authorizer with an empty admin role
+func newElectionRoom(t *testing.T, clock *FakeClock, conns ...*fakeConn) (*Playback, *fakeNamespace, rbac.Authorizer, rbac.Role) {
+	ns := newFakeNamespace("room")
+	ns.add(candidates(conns...)...)
+
+	p := NewPlaybackWithClock(ns, clock)
+	t.Cleanup(p.Close)
+
+	authorizer := rbac.NewAuthorizer()
+	adminRole := rbac.NewRole(rbac.ADMIN_ROLE, []rbac.Rule{})
+	authorizer.AddRole(adminRole)
+	return p, ns, authorizer, adminRole
+}
+
+// leave disconnects a connection from a room
+func leave(p *Playback, ns *fakeNamespace, authorizer rbac.Authorizer, conn *fakeConn) {
+	p.Do(func(p *Playback) {
+		p.HandleDisconnection(conn, authorizer, nil)
+	})
+	ns.remove(conn)
+	for _, b := range authorizer.Bindings() {
+		b.RemoveSubject(conn)
+	}
+}
+
+func elect(p *Playback, picker AdminPicker, clock Clock, authorizer rbac.Authorizer, ns connection.Namespace) bool {
+	var elected bool
+	p.Do(func(p *Playback) {
+		elected = electAdmin(picker, clock, authorizer, ns, p, client.NewHandler())
+	})
+	return elected
+}
+
+func TestElectAdminWaitsForSelectionTimePeriod(t *testing.T) {
+	clock := NewFakeClock()
+	c := newFakeConns(clock, "alice", "bob", "carol")
+	p, ns, authorizer, _ := newElectionRoom(t, clock, c...)
+	picker := NewLeastRecentAdminPicker(clock)
+
+	// a room that never had an admin elects one right away
+	if !elect(p, picker, clock, authorizer, ns) || !isBound(authorizer, c[0]) {
+		t.Fatalf("expected %q to be elected", "alice")
+	}
+
+	// a room with an admin holds no election
+	if elect(p, picker, clock, authorizer, ns) {
+		t.Fatalf("expected no election while the room has an admin")
+	}
+
+	leave(p, ns, authorizer, c[0])
+	if !p.LastAdminDepartureTime().Equal(clock.Now()) {
+		t.Fatalf("expected the admin's departure to be recorded at %v, got %v", clock.Now(), p.LastAdminDepartureTime())
+	}
+
+	clock.Advance(SelectionTimePeriod - time.Second)
+	if elect(p, picker, clock, authorizer, ns) {
+		t.Fatalf("expected no election within %v of the admin leaving", SelectionTimePeriod)
+	}
+
+	clock.Advance(time.Second)
+	if !elect(p, picker, clock, authorizer, ns) || !isBound(authorizer, c[1]) {
+		t.Fatalf("expected %q to be elected once %v elapsed", "bob", SelectionTimePeriod)
+	}
+
+	// the owner reclaims admin without waiting, alongside the current admin
+	if !isBound(authorizer, c[1]) {
+		t.Fatalf("expected %q to still be admin", "bob")
+	}
+	picker2, err := NewOwnerAdminPicker(clock, c[2])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !elect(p, picker2, clock, authorizer, ns) || !isBound(authorizer, c[2]) {
+		t.Fatalf("expected the owner to reclaim admin")
+	}
+	if elect(p, picker2, clock, authorizer, ns) {
+		t.Fatalf("expected an owner who is already admin not to be elected again")
+	}
+}
+
+func TestElectAdminSkipsDeclinedConnections(t *testing.T) {
+	clock := NewFakeClock()
+	c := newFakeConns(clock, "alice", "bob")
+	p, ns, authorizer, _ := newElectionRoom(t, clock, c...)
+
+	p.Do(func(p *Playback) {
+		p.DeclineAdmin(c[0].UUID())
+	})
+	if !elect(p, NewLeastRecentAdminPicker(clock), clock, authorizer, ns) || !isBound(authorizer, c[1]) || isBound(authorizer, c[0]) {
+		t.Fatalf("expected %q, who did not decline admin, to be elected", "bob")
+	}
+}
+
+func TestAdminPickerElectsEveryElectionPeriod(t *testing.T) {
+	clock := NewFakeClock()
+	c := newFakeConns(clock, "alice", "bob")
+	p, ns, authorizer, adminRole := newElectionRoom(t, clock, c...)
+	authorizer.Bind(adminRole, c[0])
+
+	picker := NewMostActiveAdminPicker(clock)
+	p.SetAdminPicker(picker)
+	if err := picker.Init(ns, authorizer, client.NewHandler(), &fakePlaybackHandler{p: p}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := picker.Init(ns, authorizer, client.NewHandler(), &fakePlaybackHandler{p: p}); err == nil {
+		t.Fatalf("expected a running picker not to be started again")
+	}
+
+	leave(p, ns, authorizer, c[0])
+
+	// an election is held every ElectionPeriod, but none
+	// succeeds until SelectionTimePeriod has elapsed
+	for elapsed := time.Duration(0); elapsed < SelectionTimePeriod; elapsed += ElectionPeriod {
+		if isBound(authorizer, c[1]) {
+			t.Fatalf("expected no admin to be elected %v after the last admin left", elapsed)
+		}
+		clock.BlockUntil(t, 1)
+		clock.Advance(ElectionPeriod)
+	}
+
+	// the loop waits for the next election period once an election is held
+	clock.BlockUntil(t, 1)
+	if !isBound(authorizer, c[1]) {
+		t.Fatalf("expected %q to be elected %v after the last admin left", "bob", SelectionTimePeriod)
+	}
+
+	if !picker.Stop() || picker.Stop() {
+		t.Fatalf("expected a running picker to stop once")
+	}
+}
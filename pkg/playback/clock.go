@@ -0,0 +1,29 @@
+package playback
+
+import "time"
+
+// Clock provides the current time and timer channels.
+// It allows time-dependent playback components to be
+// driven by a fake implementation.
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+	// After waits for the given duration to elapse and
+	// then sends the current time on the returned channel.
+	After(time.Duration) <-chan time.Time
+}
+
+// RealClock implements Clock using the time package
+type RealClock struct{}
+
+func (c *RealClock) Now() time.Time {
+	return time.Now()
+}
+
+func (c *RealClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func NewClock() Clock {
+	return &RealClock{}
+}
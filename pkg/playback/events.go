@@ -0,0 +1,146 @@
+package playback
+
+import (
+	"log"
+	"sync"
+)
+
+// Event is a unit of work run against a room's Playback by its event loop
+type Event func(*Playback)
+
+// eventLoop runs a room's events one at a time, in the order they were
+// submitted, on a single goroutine. Commands, timer ticks, scheduled starts,
+// fetched stream metadata, disconnections and admin elections are all
+// submitted as events, so that room state is only ever touched by the loop.
+//
+// Pending events are kept in an unbounded list, so that submitting an event
+// never blocks - including from within an event already being run.
+type eventLoop struct {
+	mux sync.Mutex
+	// pending events are called with a boolean (false)
+	// if they are discarded instead of being run
+	pending []func(bool)
+	stopped bool
+
+	wake chan bool
+	done chan bool
+}
+
+// submit queues an event to be run by the loop. Returns
+// a boolean (false) if the loop has already been stopped.
+func (l *eventLoop) submit(fn func()) bool {
+	return l.enqueue(func(run bool) {
+		if run {
+			fn()
+		}
+	})
+}
+
+func (l *eventLoop) enqueue(fn func(bool)) bool {
+	l.mux.Lock()
+	if l.stopped {
+		l.mux.Unlock()
+		return false
+	}
+	l.pending = append(l.pending, fn)
+	l.mux.Unlock()
+
+	select {
+	case l.wake <- true:
+	default:
+	}
+	return true
+}
+
+// run processes events until the loop is stopped
+func (l *eventLoop) run(name string) {
+	for {
+		select {
+		case <-l.done:
+			log.Printf("INF PLAYBACK EVENT-LOOP terminated for room %q.\n", name)
+			return
+		case <-l.wake:
+		}
+
+		for {
+			l.mux.Lock()
+			if len(l.pending) == 0 || l.stopped {
+				l.mux.Unlock()
+				break
+			}
+			fn := l.pending[0]
+			l.pending[0] = nil
+			l.pending = l.pending[1:]
+			l.mux.Unlock()
+
+			fn(true)
+		}
+	}
+}
+
+// stop discards any pending events and terminates the loop
+func (l *eventLoop) stop() {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	if l.stopped {
+		return
+	}
+	l.stopped = true
+	for _, fn := range l.pending {
+		fn(false)
+	}
+	l.pending = nil
+	close(l.done)
+}
+
+func newEventLoop() *eventLoop {
+	return &eventLoop{
+		wake: make(chan bool, 1),
+		done: make(chan bool),
+	}
+}
+
+// Submit queues an event to be run by the room's event loop, and returns
+// without waiting for it to run. Returns a boolean (false) if the room
+// has been cleaned up and no longer runs events.
+// Safe to call from any goroutine, including from within an event.
+func (p *Playback) Submit(event Event) bool {
+	return p.loop.submit(func() {
+		event(p)
+	})
+}
+
+// Do runs an event on the room's event loop and waits for it to complete.
+// Returns a boolean (false) if the room has been cleaned up, in which case
+// the event is not run.
+// A panic in the event is raised again in the caller's goroutine, leaving
+// the room's loop running.
+// Do must not be called from within an event, as the loop would wait on itself.
+func (p *Playback) Do(event Event) bool {
+	ran := make(chan bool, 1)
+	var failure interface{}
+	if !p.loop.enqueue(func(run bool) {
+		defer func() {
+			failure = recover()
+			ran <- run
+		}()
+		if run {
+			event(p)
+		}
+	}) {
+		return false
+	}
+
+	run := <-ran
+	if failure != nil {
+		panic(failure)
+	}
+	return run
+}
+
+// dispatch queues a function on the room's event loop; used by
+// the room's timer and scheduler to deliver ticks and callbacks
+func (p *Playback) dispatch(fn func()) {
+	p.loop.submit(fn)
+}
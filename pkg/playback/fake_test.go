@@ -0,0 +1,223 @@
+package playback
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/juanvallejo/streaming-server/pkg/audit"
+	"github.com/juanvallejo/streaming-server/pkg/socket/connection"
+)
+
+// FakeClock implements Clock. Its time only moves when advanced,
+// firing the channels of every After call whose duration elapsed.
+type FakeClock struct {
+	mux     sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+type fakeWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	return c.now
+}
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+
+	c.waiters = append(c.waiters, &fakeWaiter{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// Advance moves the clock forward, firing every waiter that is due
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.now = c.now.Add(d)
+	pending := []*fakeWaiter{}
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			pending = append(pending, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	c.waiters = pending
+}
+
+// Waiters returns the amount of After calls that have not fired yet
+func (c *FakeClock) Waiters() int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	return len(c.waiters)
+}
+
+// BlockUntil waits for n After calls to be pending, so that a goroutine
+// driven by the clock is known to be waiting before the clock advances
+func (c *FakeClock) BlockUntil(t *testing.T, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Waiters() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %v clock waiters; %v pending", n, c.Waiters())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func NewFakeClock() *FakeClock {
+	return &FakeClock{
+		now: time.Date(2020, time.January, 1, 20, 0, 0, 0, time.UTC),
+	}
+}
+
+// fakeConn implements the parts of connection.Connection used by
+// rooms and admin pickers; any other method panics
+type fakeConn struct {
+	connection.Connection
+
+	id       string
+	metadata *fakeMetadata
+}
+
+func (c *fakeConn) UUID() string {
+	return c.id
+}
+
+func (c *fakeConn) Metadata() connection.ConnectionMetadata {
+	return c.metadata
+}
+
+type fakeMetadata struct {
+	connection.ConnectionMetadata
+
+	mux      sync.Mutex
+	created  time.Time
+	active   time.Time
+	identity string
+	account  string
+}
+
+func (m *fakeMetadata) CreationTimestamp() time.Time {
+	return m.created
+}
+
+func (m *fakeMetadata) LastActivity() time.Time {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	return m.active
+}
+
+func (m *fakeMetadata) Identity() string {
+	return m.identity
+}
+
+func (m *fakeMetadata) Account() (string, bool) {
+	return m.account, len(m.account) > 0
+}
+
+// markActivity records activity on the connection at the clock's time
+func (c *fakeConn) markActivity(clock Clock) {
+	c.metadata.mux.Lock()
+	defer c.metadata.mux.Unlock()
+
+	c.metadata.active = clock.Now()
+}
+
+// newFakeConn returns a connection created at the clock's current time
+func newFakeConn(id string, clock Clock) *fakeConn {
+	return &fakeConn{
+		id: id,
+		metadata: &fakeMetadata{
+			created:  clock.Now(),
+			active:   clock.Now(),
+			identity: "identity-" + id,
+		},
+	}
+}
+
+// fakeNamespace implements the parts of connection.Namespace
+// used by rooms and admin pickers; any other method panics
+type fakeNamespace struct {
+	connection.Namespace
+
+	name  string
+	log   *audit.Log
+	mux   sync.Mutex
+	conns []connection.Connection
+}
+
+func (n *fakeNamespace) Name() string {
+	return n.name
+}
+
+func (n *fakeNamespace) UUID() string {
+	return "uuid-" + n.name
+}
+
+func (n *fakeNamespace) Audit() *audit.Log {
+	return n.log
+}
+
+func (n *fakeNamespace) Connections() []connection.Connection {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	return append([]connection.Connection{}, n.conns...)
+}
+
+func (n *fakeNamespace) add(conns ...connection.Connection) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	n.conns = append(n.conns, conns...)
+}
+
+func (n *fakeNamespace) remove(conn connection.Connection) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	for i, c := range n.conns {
+		if c.UUID() == conn.UUID() {
+			n.conns = append(n.conns[:i], n.conns[i+1:]...)
+			return
+		}
+	}
+}
+
+func newFakeNamespace(name string) *fakeNamespace {
+	return &fakeNamespace{
+		name: name,
+		log:  audit.NewLog(100),
+	}
+}
+
+// fakePlaybackHandler implements the parts of PlaybackHandler used
+// by admin pickers, serving a single room; any other method panics
+type fakePlaybackHandler struct {
+	PlaybackHandler
+
+	p *Playback
+}
+
+func (h *fakePlaybackHandler) PlaybackByNamespace(connection.Namespace) (*Playback, bool) {
+	return h.p, h.p != nil
+}
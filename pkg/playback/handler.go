@@ -2,6 +2,7 @@ package playback
 
 import (
 	"log"
+	"sync"
 
 	"github.com/juanvallejo/streaming-server/pkg/socket/client"
 	"github.com/juanvallejo/streaming-server/pkg/socket/cmd/rbac"
@@ -13,6 +14,10 @@ type PlaybackHandler interface {
 	// object used to keep track of individual user-created stream sessions.
 	// A playback id should be a fully-qualified room name.
 	NewPlayback(connection.Namespace, rbac.Authorizer, client.SocketClientHandler) *Playback
+	// GetOrCreatePlayback receives a connection.Namespace and retrieves its Playback
+	// object, or creates one if none exists. Returns a boolean (true) if a new
+	// Playback was created.
+	GetOrCreatePlayback(connection.Namespace, rbac.Authorizer, client.SocketClientHandler) (*Playback, bool)
 	// PlaybackByNamespace receives a connection.Namespace and retrieves a Playback object
 	// corresponding to that room. Returns a boolean (false) if a Playback object
 	// does not exist by the given roomName.
@@ -26,35 +31,68 @@ type PlaybackHandler interface {
 	IsReapable(*Playback) bool
 }
 
-// Handler implements StreamPlaybackHandler
+// Handler implements StreamPlaybackHandler. Playbacks are created by
+// connection goroutines, read by admin pickers and timers, and removed
+// by the playback reaper; streamplaybacks is guarded by mux.
 type Handler struct {
 	isGarbageCollected bool
 	garbageCollector   *PlaybackReaper
+
+	mux sync.RWMutex
 	// map of stream ids to Playback objects
 	streamplaybacks  map[string]*Playback
 	namespaceHandler connection.NamespaceHandler
 }
 
 func (h *Handler) NewPlayback(ns connection.Namespace, authorizer rbac.Authorizer, clientHandler client.SocketClientHandler) *Playback {
-	var s *Playback
-	if authorizer == nil {
-		s = NewPlayback(ns)
-	} else {
-		s = NewPlaybackWithAdminPicker(ns, authorizer, clientHandler, h)
-	}
+	s := h.newPlayback(ns, authorizer, clientHandler)
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
 
 	h.streamplaybacks[ns.Name()] = s
 	return s
 }
 
+func (h *Handler) GetOrCreatePlayback(ns connection.Namespace, authorizer rbac.Authorizer, clientHandler client.SocketClientHandler) (*Playback, bool) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	if s, exists := h.streamplaybacks[ns.Name()]; exists {
+		return s, false
+	}
+
+	s := h.newPlayback(ns, authorizer, clientHandler)
+	h.streamplaybacks[ns.Name()] = s
+	return s, true
+}
+
+func (h *Handler) newPlayback(ns connection.Namespace, authorizer rbac.Authorizer, clientHandler client.SocketClientHandler) *Playback {
+	if authorizer == nil {
+		return NewPlayback(ns)
+	}
+	return NewPlaybackWithAdminPicker(ns, authorizer, clientHandler, h)
+}
+
 func (h *Handler) ReapPlayback(p *Playback) bool {
-	if sp, exists := h.streamplaybacks[p.name]; exists {
-		sp.Cleanup()
-		delete(h.streamplaybacks, sp.name)
+	h.mux.Lock()
+	sp, exists := h.streamplaybacks[p.name]
+	delete(h.streamplaybacks, p.name)
+	h.mux.Unlock()
+
+	if exists {
+		sp.Close()
 
 		// clean up composed namespace with name
 		// corresponding to the playback object's id
 		h.namespaceHandler.DeleteNamespaceByName(sp.UUID())
+
+		// roles created from the room are only usable within it
+		if sp.authorizer != nil {
+			if removed := rbac.RemoveNamespaceRoles(sp.authorizer, sp.name); removed > 0 {
+				log.Printf("INF PLAYBACK removed %v roles created by room %q\n", removed, sp.name)
+			}
+		}
 		return exists
 	}
 	return false
@@ -72,6 +110,9 @@ func (h *Handler) IsReapable(p *Playback) bool {
 }
 
 func (h *Handler) PlaybackByNamespace(ns connection.Namespace) (*Playback, bool) {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+
 	if sPlayback, exists := h.streamplaybacks[ns.Name()]; exists {
 		return sPlayback, true
 	}
@@ -80,6 +121,9 @@ func (h *Handler) PlaybackByNamespace(ns connection.Namespace) (*Playback, bool)
 }
 
 func (h *Handler) Playbacks() []*Playback {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+
 	playbacks := []*Playback{}
 	for _, p := range h.streamplaybacks {
 		playbacks = append(playbacks, p)
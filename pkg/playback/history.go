@@ -0,0 +1,136 @@
+package playback
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	api "github.com/juanvallejo/streaming-server/pkg/api/types"
+	"github.com/juanvallejo/streaming-server/pkg/stream"
+)
+
+const (
+	MaxPlaybackHistoryItems = 50 // max number of history entries to keep per room
+)
+
+// HistoryEntry is a serializable record of a stream
+// that was loaded as part of a room's Playback.
+type HistoryEntry struct {
+	Url       string              `json:"url"`
+	Range     *stream.StreamRange `json:"range,omitempty"`
+	Name      string              `json:"name"`
+	Kind      string              `json:"kind"`
+	Duration  float64             `json:"duration"`
+	QueuedBy  string              `json:"queuedBy"`
+	StartedBy string              `json:"startedBy"`
+	StartedAt time.Time           `json:"startedAt"`
+	EndedAt   time.Time           `json:"endedAt"`
+	Skipped   bool                `json:"skipped"`
+}
+
+// Ended returns a boolean (true) if an end time has been recorded for the entry
+func (e *HistoryEntry) Ended() bool {
+	return !e.EndedAt.Equal(time.Time{})
+}
+
+// History keeps a bounded, ordered list of HistoryEntry items.
+// Once its max size is exceeded, the oldest entries are discarded.
+// Implements api.ApiCodec.
+type History struct {
+	entries []*HistoryEntry
+	max     int
+
+	mux sync.Mutex
+}
+
+// Push appends a new entry to the history, dropping the
+// oldest entry if the max history size has been exceeded.
+func (h *History) Push(entry *HistoryEntry) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	h.entries = append(h.entries, entry)
+	if len(h.entries) > h.max {
+		h.entries = h.entries[len(h.entries)-h.max:]
+	}
+}
+
+// End records an end time for the most recent entry,
+// if it has not been ended already.
+// Returns a boolean (false) if there was no entry to end.
+func (h *History) End(t time.Time, skipped bool) bool {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	if len(h.entries) == 0 {
+		return false
+	}
+
+	last := h.entries[len(h.entries)-1]
+	if last.Ended() {
+		return false
+	}
+
+	last.EndedAt = t
+	last.Skipped = skipped
+	return true
+}
+
+// Entry returns the n-th most recent entry in the history,
+// where an index of 1 is the most recent entry.
+// Returns a boolean (false) if no entry exists at the given index.
+func (h *History) Entry(n int) (*HistoryEntry, bool) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	if n < 1 || n > len(h.entries) {
+		return nil, false
+	}
+
+	return h.entries[len(h.entries)-n], true
+}
+
+// List returns a copy of all entries, most recent first
+func (h *History) List() []HistoryEntry {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	items := make([]HistoryEntry, 0, len(h.entries))
+	for i := len(h.entries) - 1; i >= 0; i-- {
+		items = append(items, *h.entries[i])
+	}
+	return items
+}
+
+// Size returns the total amount of entries in the history
+func (h *History) Size() int {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	return len(h.entries)
+}
+
+// HistoryList is a serializable schema representing a room's play history
+type HistoryList struct {
+	Kind  string         `json:"kind"`
+	Items []HistoryEntry `json:"items"`
+}
+
+func (h *History) Serialize() ([]byte, error) {
+	b, err := json.Marshal(&HistoryList{
+		Kind:  api.API_TYPE_HISTORY_LIST,
+		Items: h.List(),
+	})
+	if err != nil {
+		return []byte{}, err
+	}
+
+	return b, nil
+}
+
+func NewHistory(max int) *History {
+	return &History{
+		entries: []*HistoryEntry{},
+		max:     max,
+	}
+}
@@ -0,0 +1,230 @@
+package playback
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	api "github.com/juanvallejo/streaming-server/pkg/api/types"
+	"github.com/juanvallejo/streaming-server/pkg/playback/queue"
+)
+
+const (
+	QueueAddRateWindow = 1 * time.Minute // window of time over which queue-add rate limits are calculated
+)
+
+// RoleLimits are the queue limits applied to users bound to a role
+type RoleLimits struct {
+	queue.QueueLimits
+
+	// AddsPerMinute is the max amount of streams a user may
+	// add to their queue per minute. 0 means no limit.
+	AddsPerMinute int `json:"addsPerMinute"`
+}
+
+// RoomLimits keeps track of per-role queue limits for a room, as
+// well as the times at which users in the room have queued streams,
+// in order to enforce queue-add rate limits.
+// Implements api.ApiCodec.
+type RoomLimits struct {
+	clock    Clock
+	defaults RoleLimits
+	byRole   map[string]RoleLimits
+	addTimes map[string][]time.Time
+
+	mux sync.Mutex
+}
+
+// Role returns the limits for a role, or the room's
+// default limits if none have been set for the role.
+func (l *RoomLimits) Role(role string) RoleLimits {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	return l.role(role)
+}
+
+func (l *RoomLimits) role(role string) RoleLimits {
+	if limits, exists := l.byRole[role]; exists {
+		return limits
+	}
+	return l.defaults
+}
+
+// For receives the names of all roles a user is bound to and returns
+// the most permissive limits across those roles. Returns the room's
+// default limits if no role names are given.
+func (l *RoomLimits) For(roles []string) RoleLimits {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	if len(roles) == 0 {
+		return l.defaults
+	}
+
+	limits := l.role(roles[0])
+	for _, role := range roles[1:] {
+		r := l.role(role)
+		limits.MaxItems = mostPermissiveInt(limits.MaxItems, r.MaxItems)
+		limits.AddsPerMinute = mostPermissiveInt(limits.AddsPerMinute, r.AddsPerMinute)
+		if limits.MaxDuration > 0 && (r.MaxDuration <= 0 || r.MaxDuration > limits.MaxDuration) {
+			limits.MaxDuration = r.MaxDuration
+		}
+	}
+	return limits
+}
+
+// mostPermissiveInt returns the greater of two limits,
+// where a limit of 0 is greater than any other limit.
+func mostPermissiveInt(a, b int) int {
+	if a <= 0 || b <= 0 {
+		return 0
+	}
+	if b > a {
+		return b
+	}
+	return a
+}
+
+// Set receives a role name, a limit name (maxItems, maxDuration, or
+// addsPerMinute) and a value, and updates that limit for the role.
+// Returns an error if the limit name is unknown or the value is invalid.
+func (l *RoomLimits) Set(role, limit, value string) error {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	limits := l.role(role)
+	switch limit {
+	case "maxItems":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("%q must be a positive number of items, or 0 for no limit", limit)
+		}
+		limits.MaxItems = n
+	case "maxDuration":
+		secs, err := parseLimitSeconds(value)
+		if err != nil {
+			return fmt.Errorf("%q must be a duration (90, 1h30m), or 0 for no limit", limit)
+		}
+		limits.MaxDuration = secs
+	case "addsPerMinute":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("%q must be a positive number of streams, or 0 for no limit", limit)
+		}
+		limits.AddsPerMinute = n
+	default:
+		return fmt.Errorf("unknown limit %q: must be one of maxItems, maxDuration, addsPerMinute", limit)
+	}
+
+	l.byRole[role] = limits
+	return nil
+}
+
+func parseLimitSeconds(value string) (float64, error) {
+	if secs, err := strconv.ParseFloat(value, 64); err == nil {
+		if secs < 0 {
+			return 0, fmt.Errorf("negative duration")
+		}
+		return secs, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration")
+	}
+	return d.Seconds(), nil
+}
+
+// CheckAdd returns an error if a user has already queued the max amount
+// of streams allowed by the given limits within the last QueueAddRateWindow.
+func (l *RoomLimits) CheckAdd(userId string, limits RoleLimits) error {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	if limits.AddsPerMinute <= 0 {
+		return nil
+	}
+
+	recent := l.recentAdds(userId)
+	if len(recent) >= limits.AddsPerMinute {
+		wait := QueueAddRateWindow - l.clock.Now().Sub(recent[0])
+		return fmt.Errorf("you may only add %v streams to the queue per minute. Try again in %v.", limits.AddsPerMinute, wait.Round(time.Second))
+	}
+
+	return nil
+}
+
+// RecordAdd records that a user has queued a stream
+func (l *RoomLimits) RecordAdd(userId string) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	l.addTimes[userId] = append(l.recentAdds(userId), l.clock.Now())
+}
+
+// recentAdds discards and returns a user's add times
+// that are within the current QueueAddRateWindow.
+func (l *RoomLimits) recentAdds(userId string) []time.Time {
+	now := l.clock.Now()
+
+	recent := []time.Time{}
+	for _, t := range l.addTimes[userId] {
+		if now.Sub(t) < QueueAddRateWindow {
+			recent = append(recent, t)
+		}
+	}
+
+	l.addTimes[userId] = recent
+	return recent
+}
+
+// RoomLimitsList is a serializable schema representing a room's per-role limits
+type RoomLimitsList struct {
+	Kind     string                `json:"kind"`
+	Defaults RoleLimits            `json:"defaults"`
+	Roles    map[string]RoleLimits `json:"roles"`
+}
+
+// Roles returns the names of all roles with limits set, in alphabetical order
+func (l *RoomLimits) Roles() []string {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	names := []string{}
+	for name := range l.byRole {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (l *RoomLimits) Serialize() ([]byte, error) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	b, err := json.Marshal(&RoomLimitsList{
+		Kind:     api.API_TYPE_ROOM_LIMITS,
+		Defaults: l.defaults,
+		Roles:    l.byRole,
+	})
+	if err != nil {
+		return []byte{}, err
+	}
+
+	return b, nil
+}
+
+func NewRoomLimits(clock Clock) *RoomLimits {
+	return &RoomLimits{
+		clock: clock,
+		defaults: RoleLimits{
+			QueueLimits: queue.DefaultQueueLimits(),
+		},
+		byRole:   make(map[string]RoleLimits),
+		addTimes: make(map[string][]time.Time),
+	}
+}
@@ -0,0 +1,46 @@
+package playback
+
+import "fmt"
+
+const (
+	// A loop mode of "OFF" stops playback once the
+	// current stream ends and the queue is empty.
+	LOOP_MODE_OFF LoopMode = iota
+
+	// A loop mode of "ONE" restarts the current
+	// stream once it reaches the end of its duration.
+	LOOP_MODE_ONE
+
+	// A loop mode of "QUEUE" pushes every played stream
+	// back into the queue of the user that queued it.
+	LOOP_MODE_QUEUE
+)
+
+// LoopMode represents the room's repeat behavior
+type LoopMode int
+
+func (m LoopMode) String() string {
+	switch m {
+	case LOOP_MODE_ONE:
+		return "one"
+	case LOOP_MODE_QUEUE:
+		return "queue"
+	}
+
+	return "off"
+}
+
+// ParseLoopMode receives a loop mode name and returns the corresponding LoopMode.
+// Returns an error if the name given does not match a known LoopMode.
+func ParseLoopMode(name string) (LoopMode, error) {
+	switch name {
+	case "off":
+		return LOOP_MODE_OFF, nil
+	case "one":
+		return LOOP_MODE_ONE, nil
+	case "queue":
+		return LOOP_MODE_QUEUE, nil
+	}
+
+	return LOOP_MODE_OFF, fmt.Errorf("unknown loop mode %q: must be one of off, one, queue", name)
+}
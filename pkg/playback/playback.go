@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	api "github.com/juanvallejo/streaming-server/pkg/api/types"
@@ -42,20 +43,53 @@ type PlaybackState int
 
 // Playback represents playback status for a given
 // stream - there are one or more StreamPlayback instances
-// for every one stream
+// for every one stream.
+// A Playback's state is owned by its event loop: callers
+// outside of the loop submit their work to it with Do or
+// Submit, rather than calling its methods directly.
 type Playback struct {
+	loop *eventLoop
+
 	name               string
 	queueHandler       queue.QueueHandler
 	adminPicker        AdminPicker
 	stream             stream.Stream
 	startedBy          string
 	timer              *Timer
+	history            *History
+	scheduler          *Scheduler
+	limits             *RoomLimits
 	lastUpdated        time.Time
 	lastAdminDeparture time.Time
+	clock              Clock
+
+	// authorizer is the authorizer the room's admin picker was
+	// started with, or nil if access control is not enabled
+	authorizer rbac.Authorizer
+
+	adminMux sync.Mutex
+	// connection ids that have given up admin, and
+	// are not candidates in admin elections
+	adminDeclined map[string]bool
 
 	// State indicates the current state of the
 	// room's Playback
 	state PlaybackState
+
+	// loopMode determines what happens once
+	// the current stream reaches its end
+	loopMode LoopMode
+}
+
+// Close cleans up room resources on the room's event loop,
+// and stops the loop. Events submitted afterwards are not run.
+func (p *Playback) Close() {
+	if !p.Do(func(p *Playback) {
+		p.Cleanup()
+		p.loop.stop()
+	}) {
+		p.loop.stop()
+	}
 }
 
 // Cleanup handles resource cleanup for room resources
@@ -66,10 +100,12 @@ func (p *Playback) Cleanup() {
 		p.stream.Metadata().RemoveLabelledRef(p.UUID())
 	}
 
-	if p.adminPicker != nil {
-		p.adminPicker.Stop()
+	if picker, exists := p.AdminPicker(); exists {
+		picker.Stop()
 	}
 
+	p.scheduler.CancelAll()
+
 	p.timer.Stop()
 	p.timer.callbacks = []TimerCallback{}
 	p.timer = nil
@@ -90,25 +126,30 @@ func (p *Playback) State() PlaybackState {
 	return p.state
 }
 
-// HandleAdminDeparture receives a departing connection and determines if at least
+func (p *Playback) SetLoopMode(m LoopMode) {
+	p.loopMode = m
+}
+
+// LoopMode returns the room's current repeat behavior
+func (p *Playback) LoopMode() LoopMode {
+	return p.loopMode
+}
+
+// HandleDisconnection receives a departing connection and determines if at least
 // one other connection in its namespace is bound to the admin role. If no other
 // admins are found, the adminHandler is notified.
-// If the connection had a slice of items associated with it in the queue, that slice
-// is deleted.
+// The connection's slice of items in the queue is kept, so that it may be
+// resumed; see ReleaseQueue.
 func (p *Playback) HandleDisconnection(conn connection.Connection, authorizer rbac.Authorizer, handler client.SocketClientHandler) {
-	// find queue belonging to current connection
-	var queueItemToDelete queue.QueueItem = nil
-	for _, q := range p.queueHandler.Queue().List() {
-		if q.UUID() == conn.UUID() {
-			queueItemToDelete = q
-			break
-		}
-	}
-	if queueItemToDelete != nil {
-		p.queueHandler.Queue().DeleteItem(queueItemToDelete)
+	if conn == nil {
+		return
 	}
 
-	if authorizer == nil || conn == nil {
+	p.adminMux.Lock()
+	delete(p.adminDeclined, conn.UUID())
+	p.adminMux.Unlock()
+
+	if authorizer == nil {
 		return
 	}
 
@@ -125,12 +166,27 @@ func (p *Playback) HandleDisconnection(conn connection.Connection, authorizer rb
 
 	for _, admin := range adminBinding.Subjects() {
 		if admin.UUID() == conn.UUID() {
-			p.lastAdminDeparture = time.Now()
+			p.lastAdminDeparture = p.clock.Now()
 			break
 		}
 	}
 }
 
+// ReleaseQueue receives the id of a connection that went away and did not
+// resume its session in time, and deletes its slice of items in the queue.
+func (p *Playback) ReleaseQueue(connId string) {
+	var queueItemToDelete queue.QueueItem = nil
+	for _, q := range p.queueHandler.Queue().List() {
+		if q.UUID() == connId {
+			queueItemToDelete = q
+			break
+		}
+	}
+	if queueItemToDelete != nil {
+		p.queueHandler.Queue().DeleteItem(queueItemToDelete)
+	}
+}
+
 // UpdateStartedBy receives a client and updates the
 // startedBy field with the client's current username
 func (p *Playback) UpdateStartedBy(name string) {
@@ -174,14 +230,26 @@ func (p *Playback) Play() error {
 }
 
 func (p *Playback) Stop() error {
+	p.endHistoryEntry()
 	p.SetState(PLAYBACK_STATE_ENDED)
 	p.SetLastUpdated(time.Now())
 	return p.timer.Stop()
 }
 
+// Reset rewinds the playback timer to the start
+// of the currently-playing stream's range.
 func (p *Playback) Reset() error {
 	p.SetLastUpdated(time.Now())
-	return p.timer.Set(0)
+	return p.timer.Set(p.startOffset())
+}
+
+// startOffset returns the start of the currently-playing
+// stream's range, in seconds.
+func (p *Playback) startOffset() int {
+	if p.stream == nil {
+		return 0
+	}
+	return int(stream.RangeOf(p.stream).Start)
 }
 
 func (p *Playback) SetTime(newTime int) error {
@@ -198,6 +266,59 @@ func (p *Playback) LastAdminDepartureTime() time.Time {
 	return p.lastAdminDeparture
 }
 
+// Clock returns the clock driving the room's time-dependent components
+func (p *Playback) Clock() Clock {
+	return p.clock
+}
+
+// AdminPicker returns the room's admin picker, or a
+// boolean (false) if admins are not elected in the room
+func (p *Playback) AdminPicker() (AdminPicker, bool) {
+	p.adminMux.Lock()
+	defer p.adminMux.Unlock()
+
+	return p.adminPicker, p.adminPicker != nil
+}
+
+// SetAdminPicker receives an initialized AdminPicker and
+// replaces the room's admin picker, stopping the previous one
+func (p *Playback) SetAdminPicker(picker AdminPicker) {
+	p.adminMux.Lock()
+	prev := p.adminPicker
+	p.adminPicker = picker
+	p.adminMux.Unlock()
+
+	if prev != nil {
+		prev.Stop()
+	}
+}
+
+// ElectAdmin holds an admin election without
+// waiting for the next election period
+func (p *Playback) ElectAdmin() {
+	if picker, exists := p.AdminPicker(); exists {
+		picker.Elect()
+	}
+}
+
+// DeclineAdmin receives a connection id and excludes the connection
+// from admin elections until it leaves the room
+func (p *Playback) DeclineAdmin(connId string) {
+	p.adminMux.Lock()
+	defer p.adminMux.Unlock()
+
+	p.adminDeclined[connId] = true
+}
+
+// AdminDeclined returns a boolean (true) if the given
+// connection id has given up admin in the room
+func (p *Playback) AdminDeclined(connId string) bool {
+	p.adminMux.Lock()
+	defer p.adminMux.Unlock()
+
+	return p.adminDeclined[connId]
+}
+
 func (p *Playback) GetLastUpdated() time.Time {
 	return p.lastUpdated
 }
@@ -255,11 +376,13 @@ func (p *Playback) GetQueue() queue.RoundRobinQueue {
 // PushUserQueue pushes a stream to the queue belonging to the given user
 // and adds the Playback object as the parentRef to the pushed stream.
 func (p *Playback) PushToQueue(userQueue queue.AggregatableQueue, s stream.Stream) error {
-	if err := p.queueHandler.PushToQueue(userQueue, s); err == nil {
-		// mark stream as unreapable while it is aggregated in the queue
-		if !s.Metadata().AddParentRef(p) {
-			log.Printf("INF SOCKET CLIENT duplicate attempt to set parent ref %q to stream %q\n", p.UUID(), s.UUID())
-		}
+	if err := p.queueHandler.PushToQueue(userQueue, s); err != nil {
+		return err
+	}
+
+	// mark stream as unreapable while it is aggregated in the queue
+	if !s.Metadata().AddParentRef(p) {
+		log.Printf("INF SOCKET CLIENT duplicate attempt to set parent ref %q to stream %q\n", p.UUID(), s.UUID())
 	}
 	return nil
 }
@@ -292,8 +415,39 @@ func (p *Playback) GetStream() (stream.Stream, bool) {
 	return p.stream, p.stream != nil
 }
 
-// SetStream receives a stream.Stream and sets it as the currently-playing stream
-func (p *Playback) SetStream(s stream.Stream) {
+// History returns the room's play history
+func (p *Playback) History() *History {
+	return p.history
+}
+
+// Scheduler returns the room's playback scheduler
+func (p *Playback) Scheduler() *Scheduler {
+	return p.scheduler
+}
+
+// Limits returns the room's per-role queue limits
+func (p *Playback) Limits() *RoomLimits {
+	return p.limits
+}
+
+// endHistoryEntry records an end time for the currently-playing stream's
+// history entry. The entry is marked as skipped if the playback timer had
+// not yet reached the end of the stream.
+func (p *Playback) endHistoryEntry() {
+	if p.stream == nil {
+		return
+	}
+
+	skipped := p.stream.GetDuration() <= 0 || float64(p.timer.GetTime()) < p.stream.GetDuration()
+	p.history.End(time.Now(), skipped)
+}
+
+// SetStream receives a stream.Stream and sets it as the currently-playing stream.
+// The name of the user (or "system") that loaded the stream is recorded in the
+// room's play history.
+func (p *Playback) SetStream(s stream.Stream, startedBy string) {
+	p.endHistoryEntry()
+
 	if p.stream != nil {
 		// remove Playback object from list of current stream's refs
 		p.stream.Metadata().RemoveParentRef(p)
@@ -314,43 +468,131 @@ func (p *Playback) SetStream(s stream.Stream) {
 	p.stream = s
 	p.stream.Metadata().SetLastUpdated(time.Now())
 	p.SetLastUpdated(time.Now())
+	p.pushHistoryEntry(startedBy)
+
+	// seek to the start of the stream's range
+	p.timer.Set(p.startOffset())
+}
+
+func (p *Playback) pushHistoryEntry(startedBy string) {
+	var r *stream.StreamRange
+	if rng := stream.RangeOf(p.stream); !rng.IsZero() {
+		r = &rng
+	}
+
+	p.history.Push(&HistoryEntry{
+		Url:       p.stream.GetStreamURL(),
+		Range:     r,
+		Name:      p.stream.GetName(),
+		Kind:      p.stream.GetKind(),
+		Duration:  p.stream.GetDuration(),
+		QueuedBy:  p.startedBy,
+		StartedBy: startedBy,
+		StartedAt: time.Now(),
+	})
+}
+
+// Restart rewinds the currently-playing stream to its beginning
+// and records the replay in the room's play history.
+func (p *Playback) Restart(startedBy string) error {
+	if p.stream == nil {
+		return fmt.Errorf("error: no stream is currently loaded")
+	}
+
+	p.endHistoryEntry()
+	p.pushHistoryEntry(startedBy)
+	p.stream.Metadata().SetLastUpdated(time.Now())
+	return p.Reset()
+}
+
+// Advance pops the next item from the room's queue, sets it as the
+// currently-playing stream, and resets the playback timer.
+// If the room's loop mode is LOOP_MODE_QUEUE, the previously-playing
+// stream is pushed back into the queue of the user that queued it.
+// Returns the newly loaded stream, or an error if the queue is empty.
+func (p *Playback) Advance(startedBy string) (stream.Stream, error) {
+	prev := p.stream
+
+	queueItem, err := p.GetQueue().Next()
+	if err != nil {
+		if p.loopMode != LOOP_MODE_QUEUE || prev == nil {
+			return nil, err
+		}
+
+		// the queue is empty, recycle the previous
+		// stream and play it once more
+		if err := p.requeue(prev); err != nil {
+			return nil, err
+		}
+
+		prev = nil
+		queueItem, err = p.GetQueue().Next()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	next, ok := queueItem.(stream.Stream)
+	if !ok {
+		return nil, fmt.Errorf("error: expected next queue item to implement stream.Stream")
+	}
+
+	p.SetStream(next, startedBy)
+	p.Reset()
+
+	// recycle the previous stream only after the new one has been set,
+	// so that its parent ref (removed by SetStream) is restored.
+	if p.loopMode == LOOP_MODE_QUEUE && prev != nil {
+		if err := p.requeue(prev); err != nil {
+			log.Printf("WRN PLAYBACK unable to recycle stream %q back into the queue: %v\n", prev.UUID(), err)
+		}
+	}
+
+	return next, nil
+}
+
+// requeue pushes a played stream back into the queue
+// of the user that originally queued it.
+func (p *Playback) requeue(s stream.Stream) error {
+	owner, exists := s.Metadata().GetLabelledRef(p.UUID())
+	if !exists {
+		return fmt.Errorf("unable to determine which user queued stream %q", s.UUID())
+	}
+
+	userQueue, exists, err := util.GetQueueForId(owner.UUID(), p.GetQueue())
+	if err != nil {
+		return err
+	}
+	if !exists {
+		userQueue = queue.NewAggregatableQueue(owner.UUID())
+		if err := p.GetQueue().Push(userQueue); err != nil {
+			return err
+		}
+	}
+
+	return p.PushToQueue(userQueue, s)
 }
 
 // GetOrCreateStreamFromUrl receives a stream location (path, url, or unique identifier)
 // and retrieves a corresponding stream.Stream, or creates a new one.
+// If a non-empty range is given, the returned stream plays only that range of the
+// retrieved or created stream.
 // Calls callback once a cached stream is fetched, or metadata has been fetched for a
 // newly-created stream.
-func (p *Playback) GetOrCreateStreamFromUrl(url string, user *client.Client, streamHandler stream.StreamHandler, callback PlaybackStreamMetadataCallback) (stream.Stream, error) {
-	if s, exists := streamHandler.GetStream(url); exists {
+func (p *Playback) GetOrCreateStreamFromUrl(url string, r stream.StreamRange, user *client.Client, streamHandler stream.StreamHandler, callback PlaybackStreamMetadataCallback) (stream.Stream, error) {
+	if base, exists := streamHandler.GetStream(url); exists {
 		log.Printf("INF PLAYBACK found existing stream object with url %q, retrieving...", url)
 		callback([]byte{}, false, nil)
 
-		// determine if a labelled reference has already
-		// been set for the room - only return an error
-		// if the labelled ref still has the stream
-		// in their queue.
-		ref, exists := s.Metadata().GetLabelledRef(p.UUID())
-		if exists {
-			if u, ok := ref.(*client.Client); ok {
-				if userQueue, userQueueExists, _ := util.GetUserQueue(u, p.GetQueue()); userQueueExists {
-					exists := false
-					userQueue.Visit(func(item queue.QueueItem) {
-						// determine if item we are trying to reference under a new
-						// user still exists under the previous user's queue.
-						if item.UUID() == s.UUID() {
-							exists = true
-							return
-						}
-					})
-
-					if exists {
-						if ref.UUID() == user.UUID() {
-							return nil, fmt.Errorf("error: that stream already exists in your queue")
-						}
-						return nil, fmt.Errorf("error: that stream has already added to the queue of another user in your room")
-					}
-				}
+		s := stream.NewClippedStream(base, r)
+
+		// only return an error if a user in the room
+		// still has the same stream (and range) in their queue.
+		if owner, exists := p.queuedBy(s); exists {
+			if owner == user.UUID() {
+				return nil, fmt.Errorf("error: that stream already exists in your queue")
 			}
+			return nil, fmt.Errorf("error: that stream has already added to the queue of another user in your room")
 		}
 
 		// replace labelled reference for the queueing client
@@ -359,47 +601,71 @@ func (p *Playback) GetOrCreateStreamFromUrl(url string, user *client.Client, str
 		return s, nil
 	}
 
-	s, err := streamHandler.NewStream(url)
+	base, err := streamHandler.NewStream(url)
 	if err != nil {
 		return nil, err
 	}
 
+	s := stream.NewClippedStream(base, r)
 	s.Metadata().SetCreationSource(user)
 
 	// store queueing-user info as a labelled stream reference
 	// using the Playback's id as a namespaced key
 	s.Metadata().SetLabelledRef(p.UUID(), user)
 
-	// if created new stream, fetch its duration info
-	s.FetchMetadata(func(s stream.Stream, data []byte, err error) {
-		if err != nil {
-			log.Printf("ERR PLAYBACK FETCH-INFO-CALLBACK unable to calculate video metadata. Some information, such as media duration, will not be available: %v", err)
-			callback(data, true, err)
-			return
-		}
+	// if created new stream, fetch its duration info. Fetched
+	// metadata is handled by the room's event loop.
+	base.FetchMetadata(func(s stream.Stream, data []byte, err error) {
+		p.Submit(func(p *Playback) {
+			if err != nil {
+				log.Printf("ERR PLAYBACK FETCH-INFO-CALLBACK unable to calculate video metadata. Some information, such as media duration, will not be available: %v", err)
+				callback(data, true, err)
+				return
+			}
 
-		err = s.SetInfo(data)
-		if err != nil {
-			log.Printf("ERR PLAYBACK FETCH-INFO-CALLBACK unable to set parsed stream info: %v", err)
-			callback(data, true, err)
-			return
-		}
-		callback(data, true, nil)
+			if err := s.SetInfo(data); err != nil {
+				log.Printf("ERR PLAYBACK FETCH-INFO-CALLBACK unable to set parsed stream info: %v", err)
+				callback(data, true, err)
+				return
+			}
+			callback(data, true, nil)
+		})
 	})
 
 	log.Printf("INF PLAYBACK no stream found with url %q; creating... There are now %v registered streams", url, streamHandler.GetSize())
 	return s, nil
 }
 
+// queuedBy returns the id of the user whose queue in the room contains
+// the given stream, or a boolean (false) if no user queue contains it.
+func (p *Playback) queuedBy(s stream.Stream) (string, bool) {
+	for _, item := range p.GetQueue().List() {
+		userQueue, ok := item.(queue.AggregatableQueue)
+		if !ok {
+			continue
+		}
+
+		for _, userQueueItem := range userQueue.List() {
+			if userQueueItem.UUID() == s.UUID() {
+				return userQueue.UUID(), true
+			}
+		}
+	}
+
+	return "", false
+}
+
 // PlaybackStatus is a serializable schema representing a summary of information
 // about the current state of the Playback.
 // Implements api.ApiCodec.
 type PlaybackStatus struct {
-	QueueLength int          `json:"queueLength"`
-	StartedBy   string       `json:"startedBy"`
-	CreatedBy   string       `json:"createdBy"`
-	Stream      api.ApiCodec `json:"stream"`
-	TimerStatus api.ApiCodec `json:"playback"`
+	QueueLength int                 `json:"queueLength"`
+	StartedBy   string              `json:"startedBy"`
+	CreatedBy   string              `json:"createdBy"`
+	LoopMode    string              `json:"loopMode"`
+	Range       *stream.StreamRange `json:"range,omitempty"`
+	Stream      api.ApiCodec        `json:"stream"`
+	TimerStatus *TimerStatus        `json:"playback"`
 }
 
 func (s *PlaybackStatus) Serialize() ([]byte, error) {
@@ -413,30 +679,36 @@ func (s *PlaybackStatus) Serialize() ([]byte, error) {
 
 // Returns a map compatible with json types
 // detailing the current playback status
-func (p *Playback) GetStatus() api.ApiCodec {
+func (p *Playback) GetStatus() *PlaybackStatus {
 	var streamCodec api.ApiCodec
+	var streamRange *stream.StreamRange
 	var createdBy string
 
 	s, exists := p.GetStream()
 	if exists {
 		streamCodec = s.Codec()
 		createdBy = s.Metadata().GetCreationSource().GetSourceName()
+		if r := stream.RangeOf(s); !r.IsZero() {
+			streamRange = &r
+		}
 	}
 
 	return &PlaybackStatus{
 		QueueLength: p.GetQueue().Size(),
 		StartedBy:   p.startedBy,
 		CreatedBy:   createdBy,
+		LoopMode:    p.loopMode.String(),
+		Range:       streamRange,
 		TimerStatus: p.timer.Status(),
 		Stream:      streamCodec,
 	}
 }
 
 func NewPlaybackWithAdminPicker(ns connection.Namespace, authorizer rbac.Authorizer, clientHandler client.SocketClientHandler, playbackHandler PlaybackHandler) *Playback {
-	picker := NewLeastRecentAdminPicker()
-
 	p := NewPlayback(ns)
+	picker := NewLeastRecentAdminPicker(p.clock)
 	p.adminPicker = picker
+	p.authorizer = authorizer
 
 	if err := picker.Init(ns, authorizer, clientHandler, playbackHandler); err != nil {
 		log.Printf("WRN PLAYBACK ADMIN-PICKER unable to initialize admin picker for room %q: %v\n", ns.Name(), err)
@@ -448,16 +720,36 @@ func NewPlaybackWithAdminPicker(ns connection.Namespace, authorizer rbac.Authori
 }
 
 func NewPlayback(ns connection.Namespace) *Playback {
+	return NewPlaybackWithClock(ns, NewClock())
+}
+
+// NewPlaybackWithClock returns a Playback whose scheduler, limits
+// and admin elections are driven by the given clock
+func NewPlaybackWithClock(ns connection.Namespace, clock Clock) *Playback {
 	if len(ns.Name()) == 0 {
 		panic("A namespace with a name is required to instantiate a new playback")
 	}
 
-	return &Playback{
+	p := &Playback{
+		loop:               newEventLoop(),
 		name:               ns.Name(),
 		timer:              NewTimer(),
+		history:            NewHistory(MaxPlaybackHistoryItems),
+		scheduler:          NewScheduler(clock),
+		limits:             NewRoomLimits(clock),
 		queueHandler:       queue.NewQueueHandler(queue.NewRoundRobinQueue()),
-		lastUpdated:        time.Now(),
+		lastUpdated:        clock.Now(),
 		lastAdminDeparture: time.Time{},
+		clock:              clock,
+		adminDeclined:      make(map[string]bool),
 		state:              PLAYBACK_STATE_NOT_STARTED,
+		loopMode:           LOOP_MODE_OFF,
 	}
+
+	// deliver timer ticks and scheduled starts to the event loop
+	p.timer.dispatch = p.dispatch
+	p.scheduler.dispatch = p.dispatch
+
+	go p.loop.run(p.name)
+	return p
 }
@@ -0,0 +1,82 @@
+package queue
+
+import "fmt"
+
+// QueueLimits bound the contents of an AggregatableQueue.
+// A value of 0 for any limit means that the limit is not enforced.
+type QueueLimits struct {
+	// MaxItems is the max amount of items the queue may hold
+	MaxItems int `json:"maxItems"`
+	// MaxDuration is the max total duration, in seconds,
+	// of all items in the queue with a known duration
+	MaxDuration float64 `json:"maxDuration"`
+}
+
+// DurationQueueItem is a QueueItem with a known duration, in seconds
+type DurationQueueItem interface {
+	QueueItem
+	GetDuration() float64
+}
+
+// Check receives the items currently held by a queue and an item about to
+// be pushed to it, and returns an error if pushing the item would exceed a limit.
+// Items that do not implement DurationQueueItem, or that have no known duration
+// yet, do not count towards the MaxDuration limit; use CheckDuration to check
+// a queue again once their duration is known.
+func (l QueueLimits) Check(items []QueueItem, item QueueItem) error {
+	if l.MaxItems > 0 && len(items) >= l.MaxItems {
+		return fmt.Errorf("you cannot store more than %v items in your queue.", l.MaxItems)
+	}
+
+	if item == nil {
+		return l.CheckDuration(items)
+	}
+	return l.CheckDuration(append(append([]QueueItem{}, items...), item))
+}
+
+// CheckDuration receives the items held by a queue and returns an error
+// if their total known duration exceeds the MaxDuration limit.
+func (l QueueLimits) CheckDuration(items []QueueItem) error {
+	if l.MaxDuration <= 0 {
+		return nil
+	}
+
+	total := 0.0
+	for _, i := range items {
+		total += itemDuration(i)
+	}
+	if total > l.MaxDuration {
+		return fmt.Errorf("you cannot store more than %v of streams in your queue.", formatSeconds(l.MaxDuration))
+	}
+
+	return nil
+}
+
+func itemDuration(item QueueItem) float64 {
+	if d, ok := item.(DurationQueueItem); ok && d.GetDuration() > 0 {
+		return d.GetDuration()
+	}
+	return 0
+}
+
+// formatSeconds returns a human-readable form of an amount of seconds: "1h30m0s"
+func formatSeconds(secs float64) string {
+	h := int(secs) / 3600
+	m := (int(secs) % 3600) / 60
+	s := int(secs) % 60
+	if h > 0 {
+		return fmt.Sprintf("%dh%dm%ds", h, m, s)
+	}
+	if m > 0 {
+		return fmt.Sprintf("%dm%ds", m, s)
+	}
+	return fmt.Sprintf("%ds", s)
+}
+
+// DefaultQueueLimits returns the limits applied to an AggregatableQueue
+// that has not been given any other limits.
+func DefaultQueueLimits() QueueLimits {
+	return QueueLimits{
+		MaxItems: MaxAggregatableQueueItems,
+	}
+}
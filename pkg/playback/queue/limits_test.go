@@ -0,0 +1,52 @@
+package queue
+
+import (
+	"testing"
+)
+
+type fakeItem struct {
+	id       string
+	duration float64
+}
+
+func (i *fakeItem) UUID() string {
+	return i.id
+}
+
+func (i *fakeItem) GetDuration() float64 {
+	return i.duration
+}
+
+func TestQueueLimits(t *testing.T) {
+	limits := QueueLimits{MaxItems: 3, MaxDuration: 600}
+
+	items := []QueueItem{&fakeItem{id: "a", duration: 300}, &fakeItem{id: "b"}}
+	if err := limits.Check(items, &fakeItem{id: "c", duration: 300}); err != nil {
+		t.Fatalf("expected an item filling the queue's duration to be allowed: %v", err)
+	}
+	if err := limits.Check(items, &fakeItem{id: "c", duration: 301}); err == nil {
+		t.Fatalf("expected an item exceeding the queue's duration to be refused")
+	}
+	if err := limits.Check(append(items, &fakeItem{id: "c"}), &fakeItem{id: "d"}); err == nil {
+		t.Fatalf("expected an item exceeding the queue's size to be refused")
+	}
+
+	// an item of unknown duration is allowed in, and checked
+	// again once its duration is known
+	unknown := &fakeItem{id: "c"}
+	if err := limits.Check(items, unknown); err != nil {
+		t.Fatalf("expected an item of unknown duration to be allowed: %v", err)
+	}
+	items = append(items, unknown)
+	if err := limits.CheckDuration(items); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	unknown.duration = 900
+	if err := limits.CheckDuration(items); err == nil {
+		t.Fatalf("expected a queue exceeding its duration once an item's duration is known to be refused")
+	}
+
+	if err := (QueueLimits{}).CheckDuration(items); err != nil {
+		t.Fatalf("expected no limits to be enforced by empty limits: %v", err)
+	}
+}
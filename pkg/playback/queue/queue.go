@@ -5,19 +5,19 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math/rand"
 	"sync"
 
 	api "github.com/juanvallejo/streaming-server/pkg/api/types"
 )
 
 const (
-	MaxAggregatableQueueItems = 20
+	MaxAggregatableQueueItems = 20 // default max amount of items in a user's queue
 )
 
 var (
-	ErrNoItemsInQueue       = errors.New("there are no items in the queue")
-	ErrNoSuchQueueStr       = "no queue found with id %v"
-	ErrMaxQueueSizeExceeded = fmt.Errorf("you cannot store more than %v items in your queue.", MaxAggregatableQueueItems)
+	ErrNoItemsInQueue = errors.New("there are no items in the queue")
+	ErrNoSuchQueueStr = "no queue found with id %v"
 )
 
 // TODO: break this file out into its own "queue" package
@@ -125,6 +125,10 @@ type ReorderableQueue interface {
 	// Returns an error if a list of new indices contains duplicate indices, or if any
 	// provided index is greater than the size of the Queue.
 	Reorder([]int) error
+	// Shuffle is a concurrency-safe method that receives a seed and re-orders
+	// all QueueItems in a random order. Shuffling a queue with the same items
+	// in the same order using the same seed always results in the same order.
+	Shuffle(int64) error
 }
 
 // SerializableQueue represents a queue that can be handled by a rest client
@@ -153,6 +157,9 @@ type RoundRobinQueue interface {
 	// PeekItems returns a slice containing the first item
 	// from each aggregated QueueItem in the queue.
 	PeekItems() []QueueItem
+	// Upcoming returns the items returned by PeekItems
+	// in the order they will be popped by Next.
+	Upcoming() []QueueItem
 }
 
 // AggregatableQueue is a queue that can be aggregated as a QueueItem
@@ -161,6 +168,12 @@ type AggregatableQueue interface {
 	api.ApiCodec
 	QueueItem
 	ReorderableQueue
+
+	// Limits returns the limits enforced when pushing items to the queue
+	Limits() QueueLimits
+	// SetLimits receives a set of limits to enforce when pushing items
+	// to the queue. Items already in the queue are not removed.
+	SetLimits(QueueLimits)
 }
 
 // QueueItem represents internal queue storage with a unique identifier
@@ -279,6 +292,22 @@ func (q *ReorderableQueueSchema) Reorder(newOrder []int) error {
 	q.Lock()
 	defer q.Unlock()
 
+	return q.reorder(newOrder)
+}
+
+func (q *ReorderableQueueSchema) Shuffle(seed int64) error {
+	q.Lock()
+	defer q.Unlock()
+
+	if q.Size() < 2 {
+		return nil
+	}
+
+	return q.reorder(ShuffleOrder(q.Size(), seed))
+}
+
+// reorder implements Reorder; callers must hold the queue lock.
+func (q *ReorderableQueueSchema) reorder(newOrder []int) error {
 	items := q.List()
 	seen := make(map[int]bool)
 	newQueueItemList := make([]QueueItem, 0, q.Size())
@@ -317,6 +346,13 @@ func (q *ReorderableQueueSchema) Reorder(newOrder []int) error {
 	return nil
 }
 
+// ShuffleOrder receives a length and a seed and returns a pseudo-random
+// permutation of the indices [0, length). The same length and seed
+// always produce the same permutation.
+func ShuffleOrder(length int, seed int64) []int {
+	return rand.New(rand.NewSource(seed)).Perm(length)
+}
+
 func NewReorderableQueue() ReorderableQueue {
 	return &ReorderableQueueSchema{
 		Queue: NewQueue(),
@@ -327,6 +363,8 @@ func NewReorderableQueue() ReorderableQueue {
 type AggregatableQueueSchema struct {
 	ReorderableQueue
 	QueueItem
+
+	limits QueueLimits
 }
 
 func (q *AggregatableQueueSchema) Serialize() ([]byte, error) {
@@ -341,13 +379,21 @@ func (q *AggregatableQueueSchema) Serialize() ([]byte, error) {
 }
 
 func (q *AggregatableQueueSchema) Push(item QueueItem) error {
-	if q.Size() >= MaxAggregatableQueueItems {
-		return ErrMaxQueueSizeExceeded
+	if err := q.limits.Check(q.List(), item); err != nil {
+		return err
 	}
 
 	return q.ReorderableQueue.Push(item)
 }
 
+func (q *AggregatableQueueSchema) Limits() QueueLimits {
+	return q.limits
+}
+
+func (q *AggregatableQueueSchema) SetLimits(limits QueueLimits) {
+	q.limits = limits
+}
+
 func NewAggregatableQueue(id string) AggregatableQueue {
 	if len(id) == 0 {
 		log.Panic("attempt to create QueueItem with empty id")
@@ -356,6 +402,7 @@ func NewAggregatableQueue(id string) AggregatableQueue {
 	return &AggregatableQueueSchema{
 		ReorderableQueue: NewReorderableQueue(),
 		QueueItem:        NewQueueItem(id),
+		limits:           DefaultQueueLimits(),
 	}
 }
 
@@ -510,7 +557,7 @@ func (q *RoundRobinQueueSchema) Next() (QueueItem, error) {
 	qItem := qItems[q.rrCount]
 	aggQueue, ok := qItem.(AggregatableQueue)
 	if !ok {
-		return nil, fmt.Errorf("expected QueueItem at round-robin count %q to implement AggregatableQueue", q.rrCount)
+		return nil, fmt.Errorf("expected QueueItem at round-robin count %v to implement AggregatableQueue", q.rrCount)
 	}
 
 	// get next queue - if empty,
@@ -559,12 +606,15 @@ func (q *RoundRobinQueueSchema) PeekItems() []QueueItem {
 	return items
 }
 
-func (q *RoundRobinQueueSchema) Serialize() ([]byte, error) {
+// Upcoming sorts the items returned by PeekItems by round-robin index
+func (q *RoundRobinQueueSchema) Upcoming() []QueueItem {
 	items := q.PeekItems()
+	return append(items[q.rrCount:], items[0:q.rrCount]...)
+}
 
-	// sort items by round-robin index
+func (q *RoundRobinQueueSchema) Serialize() ([]byte, error) {
 	b, err := json.Marshal(&QueueSchema{
-		Items: append(items[q.rrCount:], items[0:q.rrCount]...),
+		Items: q.Upcoming(),
 	})
 	if err != nil {
 		return []byte{}, err
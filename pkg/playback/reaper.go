@@ -33,9 +33,16 @@ func (r *PlaybackReaper) Init(handler PlaybackHandler) {
 func reap(reaper *PlaybackReaper, handler PlaybackHandler, stop chan bool) {
 	for {
 		for _, s := range handler.Playbacks() {
-			if handler.IsReapable(s) && time.Now().Sub(s.GetLastUpdated()) > reaper.maxStalePlaybackObjectLifetime {
+			var lastUpdated time.Time
+			if !s.Do(func(s *Playback) {
+				lastUpdated = s.GetLastUpdated()
+			}) {
+				continue
+			}
+
+			if handler.IsReapable(s) && time.Now().Sub(lastUpdated) > reaper.maxStalePlaybackObjectLifetime {
 				if handler.ReapPlayback(s) {
-					log.Printf("INF REAPER room with name %q has become a candidate for reaping after %v. Reaping...\n", s.name, time.Now().Sub(s.GetLastUpdated()))
+					log.Printf("INF REAPER room with name %q has become a candidate for reaping after %v. Reaping...\n", s.name, time.Now().Sub(lastUpdated))
 				}
 			}
 		}
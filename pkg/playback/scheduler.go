@@ -0,0 +1,224 @@
+package playback
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	api "github.com/juanvallejo/streaming-server/pkg/api/types"
+)
+
+const (
+	MaxScheduleLeadTime = 24 * time.Hour // furthest point in the future a stream may be scheduled for
+)
+
+// ScheduleCountdownMarks are the amounts of time remaining before a
+// scheduled start at which the countdown callback for a Schedule is called.
+var ScheduleCountdownMarks = []time.Duration{
+	1 * time.Hour,
+	30 * time.Minute,
+	10 * time.Minute,
+	5 * time.Minute,
+	1 * time.Minute,
+	30 * time.Second,
+	10 * time.Second,
+	5 * time.Second,
+	3 * time.Second,
+	2 * time.Second,
+	1 * time.Second,
+}
+
+// ScheduleCallback is called once a Schedule's start time has been reached
+type ScheduleCallback func(*Schedule)
+
+// ScheduleCountdownCallback is called with the time remaining
+// before a Schedule's start time at every countdown mark
+type ScheduleCountdownCallback func(*Schedule, time.Duration)
+
+// Schedule is a pending, scheduled start of the room's playback
+type Schedule struct {
+	Id        int       `json:"id"`
+	StartsAt  time.Time `json:"startsAt"`
+	CreatedAt time.Time `json:"createdAt"`
+	CreatedBy string    `json:"createdBy"`
+
+	cancel chan bool
+}
+
+// Scheduler keeps track of pending Schedules for a room
+// and calls their callbacks using the time provided by a Clock.
+// Implements api.ApiCodec.
+type Scheduler struct {
+	clock     Clock
+	schedules map[int]*Schedule
+	nextId    int
+	// dispatch receives every callback, to run it on the
+	// goroutine that owns the room. Callbacks are run by
+	// the Schedule's goroutine if no dispatch func is set.
+	dispatch func(func())
+
+	mux sync.Mutex
+}
+
+// Add arms a new Schedule for the given start time. The countdown callback is
+// called at every ScheduleCountdownMarks interval before the start time, and
+// the start callback is called once the start time has been reached.
+// Returns an error if the start time is not in the future, or is too far ahead.
+func (s *Scheduler) Add(startsAt time.Time, createdBy string, onCountdown ScheduleCountdownCallback, onStart ScheduleCallback) (*Schedule, error) {
+	now := s.clock.Now()
+	if !startsAt.After(now) {
+		return nil, fmt.Errorf("error: a scheduled start time must be in the future")
+	}
+	if startsAt.Sub(now) > MaxScheduleLeadTime {
+		return nil, fmt.Errorf("error: a stream may not be scheduled more than %v in advance", MaxScheduleLeadTime)
+	}
+
+	s.mux.Lock()
+	s.nextId++
+	schedule := &Schedule{
+		Id:        s.nextId,
+		StartsAt:  startsAt,
+		CreatedAt: now,
+		CreatedBy: createdBy,
+		cancel:    make(chan bool),
+	}
+	s.schedules[schedule.Id] = schedule
+	s.mux.Unlock()
+
+	go s.run(schedule, onCountdown, onStart)
+	return schedule, nil
+}
+
+func (s *Scheduler) run(schedule *Schedule, onCountdown ScheduleCountdownCallback, onStart ScheduleCallback) {
+	for _, mark := range ScheduleCountdownMarks {
+		remaining := schedule.StartsAt.Sub(s.clock.Now())
+		if mark >= remaining {
+			continue
+		}
+
+		select {
+		case <-s.clock.After(remaining - mark):
+			if onCountdown != nil {
+				mark := mark
+				s.call(func() {
+					onCountdown(schedule, mark)
+				})
+			}
+		case <-schedule.cancel:
+			return
+		}
+	}
+
+	if remaining := schedule.StartsAt.Sub(s.clock.Now()); remaining > 0 {
+		select {
+		case <-s.clock.After(remaining):
+		case <-schedule.cancel:
+			return
+		}
+	}
+
+	// a schedule that was cancelled right as it was
+	// about to start has already been removed
+	if !s.remove(schedule.Id) {
+		return
+	}
+
+	if onStart != nil {
+		s.call(func() {
+			onStart(schedule)
+		})
+	}
+}
+
+func (s *Scheduler) call(fn func()) {
+	if s.dispatch != nil {
+		s.dispatch(fn)
+		return
+	}
+	fn()
+}
+
+func (s *Scheduler) remove(id int) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if _, exists := s.schedules[id]; !exists {
+		return false
+	}
+
+	delete(s.schedules, id)
+	return true
+}
+
+// Cancel cancels a pending Schedule by its id.
+// Returns a boolean (false) if no pending Schedule exists by the given id.
+func (s *Scheduler) Cancel(id int) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	schedule, exists := s.schedules[id]
+	if !exists {
+		return false
+	}
+
+	delete(s.schedules, id)
+	close(schedule.cancel)
+	return true
+}
+
+// CancelAll cancels every pending Schedule and
+// returns the amount of Schedules cancelled.
+func (s *Scheduler) CancelAll() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	count := len(s.schedules)
+	for id, schedule := range s.schedules {
+		delete(s.schedules, id)
+		close(schedule.cancel)
+	}
+	return count
+}
+
+// Pending returns a copy of all pending Schedules, ordered by start time
+func (s *Scheduler) Pending() []Schedule {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	items := make([]Schedule, 0, len(s.schedules))
+	for _, schedule := range s.schedules {
+		items = append(items, *schedule)
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].StartsAt.Before(items[j].StartsAt)
+	})
+	return items
+}
+
+// ScheduleList is a serializable schema representing a room's pending schedules
+type ScheduleList struct {
+	Kind  string     `json:"kind"`
+	Items []Schedule `json:"items"`
+}
+
+func (s *Scheduler) Serialize() ([]byte, error) {
+	b, err := json.Marshal(&ScheduleList{
+		Kind:  api.API_TYPE_SCHEDULE_LIST,
+		Items: s.Pending(),
+	})
+	if err != nil {
+		return []byte{}, err
+	}
+
+	return b, nil
+}
+
+func NewScheduler(clock Clock) *Scheduler {
+	return &Scheduler{
+		clock:     clock,
+		schedules: make(map[int]*Schedule),
+	}
+}
@@ -0,0 +1,261 @@
+package playback
+
+import (
+	"testing"
+	"time"
+
+	"github.com/juanvallejo/streaming-server/pkg/socket/client"
+	"github.com/juanvallejo/streaming-server/pkg/socket/cmd/rbac"
+	"github.com/juanvallejo/streaming-server/pkg/socket/connection"
+)
+
+// withCountdownMarks replaces ScheduleCountdownMarks for the duration of a test
+func withCountdownMarks(t *testing.T, marks ...time.Duration) {
+	prev := ScheduleCountdownMarks
+	ScheduleCountdownMarks = marks
+	t.Cleanup(func() {
+		ScheduleCountdownMarks = prev
+	})
+}
+
+func waitStarted(t *testing.T, started chan *Schedule) *Schedule {
+	t.Helper()
+
+	select {
+	case s := <-started:
+		return s
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for the scheduled start")
+	}
+	return nil
+}
+
+func TestSchedulerRejectsInvalidStartTimes(t *testing.T) {
+	clock := NewFakeClock()
+	s := NewScheduler(clock)
+
+	tests := []struct {
+		name     string
+		startsAt time.Time
+	}{
+		{name: "now", startsAt: clock.Now()},
+		{name: "past", startsAt: clock.Now().Add(-time.Minute)},
+		{name: "too far ahead", startsAt: clock.Now().Add(MaxScheduleLeadTime + time.Second)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.Add(tt.startsAt, "alice", nil, nil); err == nil {
+				t.Fatalf("expected an error scheduling a start at %v", tt.startsAt)
+			}
+		})
+	}
+
+	if pending := s.Pending(); len(pending) != 0 {
+		t.Fatalf("expected no pending schedules, got %v", len(pending))
+	}
+}
+
+func TestSchedulerCountsDownAndStarts(t *testing.T) {
+	withCountdownMarks(t, time.Minute, 10*time.Second)
+
+	clock := NewFakeClock()
+	s := NewScheduler(clock)
+
+	countdown := make(chan time.Duration, 10)
+	started := make(chan *Schedule, 1)
+	schedule, err := s.Add(clock.Now().Add(2*time.Minute), "alice", func(_ *Schedule, remaining time.Duration) {
+		countdown <- remaining
+	}, func(s *Schedule) {
+		started <- s
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	steps := []struct {
+		advance   time.Duration
+		remaining time.Duration
+	}{
+		{advance: time.Minute, remaining: time.Minute},
+		{advance: 50 * time.Second, remaining: 10 * time.Second},
+	}
+	for _, step := range steps {
+		clock.BlockUntil(t, 1)
+		clock.Advance(step.advance)
+
+		select {
+		case remaining := <-countdown:
+			if remaining != step.remaining {
+				t.Fatalf("expected countdown mark %v, got %v", step.remaining, remaining)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for countdown mark %v", step.remaining)
+		}
+		if len(started) > 0 {
+			t.Fatalf("schedule started %v early", schedule.StartsAt.Sub(clock.Now()))
+		}
+	}
+
+	clock.BlockUntil(t, 1)
+	clock.Advance(10 * time.Second)
+	waitStarted(t, started)
+
+	if pending := s.Pending(); len(pending) != 0 {
+		t.Fatalf("expected a started schedule to no longer be pending, got %v", pending)
+	}
+	if len(countdown) > 0 {
+		t.Fatalf("unexpected extra countdown marks: %v", len(countdown))
+	}
+}
+
+func TestSchedulerFiresAtStartTime(t *testing.T) {
+	// no countdown mark falls within the schedule's lead time
+	withCountdownMarks(t, time.Minute)
+
+	clock := NewFakeClock()
+	s := NewScheduler(clock)
+
+	startedAt := make(chan time.Time, 1)
+	schedule, err := s.Add(clock.Now().Add(30*time.Second), "alice", func(*Schedule, time.Duration) {
+		t.Errorf("unexpected countdown mark")
+	}, func(*Schedule) {
+		startedAt <- clock.Now()
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	clock.BlockUntil(t, 1)
+	clock.Advance(29 * time.Second)
+	if clock.Waiters() != 1 || len(startedAt) > 0 {
+		t.Fatalf("schedule started before its start time")
+	}
+
+	clock.Advance(time.Second)
+	select {
+	case at := <-startedAt:
+		if !at.Equal(schedule.StartsAt) {
+			t.Fatalf("expected the schedule to start at %v, started at %v", schedule.StartsAt, at)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for the scheduled start")
+	}
+}
+
+func TestSchedulerCancel(t *testing.T) {
+	withCountdownMarks(t, 10*time.Second)
+
+	clock := NewFakeClock()
+	s := NewScheduler(clock)
+
+	started := make(chan *Schedule, 3)
+	onStart := func(s *Schedule) {
+		started <- s
+	}
+
+	first, err := s.Add(clock.Now().Add(30*time.Second), "alice", nil, onStart)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := s.Add(clock.Now().Add(time.Minute), "bob", nil, onStart); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := s.Add(clock.Now().Add(20*time.Second), "carol", nil, onStart); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	pending := s.Pending()
+	if len(pending) != 3 || pending[0].CreatedBy != "carol" || pending[2].CreatedBy != "bob" {
+		t.Fatalf("expected pending schedules ordered by start time, got %v", pending)
+	}
+
+	clock.BlockUntil(t, 3)
+	if !s.Cancel(first.Id) {
+		t.Fatalf("expected schedule %v to be cancelled", first.Id)
+	}
+	if s.Cancel(first.Id) {
+		t.Fatalf("expected a cancelled schedule to no longer be pending")
+	}
+	if n := s.CancelAll(); n != 2 {
+		t.Fatalf("expected 2 remaining schedules to be cancelled, got %v", n)
+	}
+
+	clock.Advance(2 * time.Minute)
+	time.Sleep(50 * time.Millisecond)
+	if len(started) > 0 {
+		t.Fatalf("cancelled schedules started")
+	}
+	if pending := s.Pending(); len(pending) != 0 {
+		t.Fatalf("expected no pending schedules, got %v", pending)
+	}
+}
+
+func TestScheduleSurvivesAdminChange(t *testing.T) {
+	withCountdownMarks(t)
+
+	clock := NewFakeClock()
+	ns := newFakeNamespace("room")
+	p := NewPlaybackWithClock(ns, clock)
+	defer p.Close()
+
+	authorizer := rbac.NewAuthorizer()
+	adminRole := rbac.NewRole(rbac.ADMIN_ROLE, []rbac.Rule{})
+	authorizer.AddRole(adminRole)
+
+	alice := newFakeConn("alice", clock)
+	clock.Advance(time.Second)
+	bob := newFakeConn("bob", clock)
+	ns.add(alice, bob)
+	authorizer.Bind(adminRole, alice)
+
+	started := make(chan *Schedule, 1)
+	if _, err := p.Scheduler().Add(clock.Now().Add(10*time.Minute), "alice", nil, func(s *Schedule) {
+		started <- s
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	clock.BlockUntil(t, 1)
+
+	// the admin who scheduled the start leaves, and another is elected
+	p.Do(func(p *Playback) {
+		p.HandleDisconnection(alice, authorizer, nil)
+	})
+	ns.remove(alice)
+	for _, b := range authorizer.Bindings() {
+		b.RemoveSubject(alice)
+	}
+
+	clock.Advance(SelectionTimePeriod)
+	var elected bool
+	p.Do(func(p *Playback) {
+		elected = electAdmin(NewMostActiveAdminPicker(clock), clock, authorizer, ns, p, client.NewHandler())
+	})
+	if !elected {
+		t.Fatalf("expected a new admin to be elected")
+	}
+	if !isBound(authorizer, bob) {
+		t.Fatalf("expected %q to be the new admin", bob.UUID())
+	}
+
+	if pending := p.Scheduler().Pending(); len(pending) != 1 {
+		t.Fatalf("expected the schedule to survive the admin change, got %v pending", len(pending))
+	}
+
+	clock.Advance(10*time.Minute - SelectionTimePeriod)
+	waitStarted(t, started)
+}
+
+// isBound returns true if a connection is bound to the admin role
+func isBound(authorizer rbac.Authorizer, conn connection.Connection) bool {
+	for _, b := range authorizer.Bindings() {
+		if b.Role().Name() != rbac.ADMIN_ROLE {
+			continue
+		}
+		for _, s := range b.Subjects() {
+			if s.UUID() == conn.UUID() {
+				return true
+			}
+		}
+	}
+	return false
+}
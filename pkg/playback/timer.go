@@ -5,13 +5,9 @@ import (
 	"fmt"
 	"log"
 	"time"
-
-	api "github.com/juanvallejo/streaming-server/pkg/api/types"
 )
 
 const (
-	MAX_TIMER_CHAN_BUFFER = 1
-
 	TIMER_PLAY = iota
 	TIMER_PAUSE
 	TIMER_STOP
@@ -19,55 +15,51 @@ const (
 
 type TimerCallback func(int)
 
-// Timer keeps track of playback time
+// Timer keeps track of playback time. A Timer is not
+// concurrency-safe; a room's Timer is only used by its event loop.
 type Timer struct {
 	time      int
 	state     int
 	callbacks []TimerCallback
-	timeChan  chan int
+
+	// stop is closed to terminate the running Increment goroutine
+	stop chan bool
+	// dispatch receives every tick, to run it on the goroutine
+	// that owns the timer. Ticks are run by the Increment
+	// goroutine itself if no dispatch func is set.
+	dispatch func(func())
 }
 
 func (t *Timer) Play() error {
-	if t.timeChan == nil {
-		panic("attempt to start a nil timer channel")
-	}
-
 	if t.state == TIMER_PLAY {
 		log.Printf("STREAM PLAYBACK TIMER attempt to play an already playing timer, ignoring...")
 		return nil
 	}
 
 	t.state = TIMER_PLAY
-	go Increment(t, t.timeChan)
+	t.stop = make(chan bool)
+	go Increment(t, t.stop)
 	return nil
 }
 
 func (t *Timer) Stop() error {
-	if t.timeChan == nil {
-		panic("attempt to stop a nil timer channel")
-	}
-
 	t.time = 0
 	if t.state != TIMER_PLAY {
 		return nil
 	}
 
 	t.state = TIMER_STOP
-	t.timeChan <- TIMER_STOP
+	close(t.stop)
 	return nil
 }
 
 func (t *Timer) Pause() error {
-	if t.timeChan == nil {
-		panic("attempt to pause a nil timer channel")
-	}
-
 	if t.state != TIMER_PLAY {
 		return nil
 	}
 
 	t.state = TIMER_PAUSE
-	t.timeChan <- TIMER_PAUSE
+	close(t.stop)
 	return nil
 }
 
@@ -110,7 +102,7 @@ func (s *TimerStatus) Serialize() ([]byte, error) {
 	return b, nil
 }
 
-func (t *Timer) Status() api.ApiCodec {
+func (t *Timer) Status() *TimerStatus {
 	return &TimerStatus{
 		IsPlaying: t.state == TIMER_PLAY,
 		IsStopped: t.state == TIMER_STOP,
@@ -120,42 +112,47 @@ func (t *Timer) Status() api.ApiCodec {
 }
 
 // Increment is a convenience function for incrementing
-// a timer's time value every second. If a timer.callback
-// func exists, it is called every increment interval.
-// Warning: this is not a concurrency-safe function
-func Increment(timer *Timer, c chan int) {
+// a timer's time value every second, until the given stop
+// channel is closed. If a timer.callback func exists, it is
+// called every increment interval.
+// Each tick is handed to the timer's dispatch func, if one is set.
+func Increment(timer *Timer, stop chan bool) {
 	if timer == nil {
 		panic("attempt to increment a nil timer")
 	}
 
-	for {
-		time.Sleep(time.Duration(1 * time.Second))
-		timer.time++
+	tick := func() {
+		// ignore ticks delivered after the timer was
+		// paused or stopped, or restarted by another run
+		if timer.state != TIMER_PLAY || timer.stop != stop {
+			return
+		}
 
-		if len(timer.callbacks) > 0 {
-			for _, c := range timer.callbacks {
-				c(timer.time)
-			}
+		timer.time++
+		for _, c := range timer.callbacks {
+			c(timer.time)
 		}
+	}
 
+	for {
 		select {
-		case sig := <-c:
-			if sig == TIMER_PAUSE || sig == TIMER_STOP {
-				log.Printf("STREAM PLAYBACK TIMER kill signal received: %v", sig)
-				return
-			}
-
-			log.Printf("STREAM PLAYBACK TIMER invalid timer signal code: %v is not a recognized channel operation", sig)
-		default:
+		case <-stop:
+			log.Printf("STREAM PLAYBACK TIMER kill signal received")
+			return
+		case <-time.After(1 * time.Second):
+		}
+
+		if timer.dispatch != nil {
+			timer.dispatch(tick)
 			continue
 		}
+		tick()
 	}
 }
 
 func NewTimer() *Timer {
 	return &Timer{
 		state:     TIMER_STOP,
-		timeChan:  make(chan int, MAX_TIMER_CHAN_BUFFER),
 		callbacks: []TimerCallback{},
 	}
 }
@@ -0,0 +1,166 @@
+package cors
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// ANY_ORIGIN allows requests from every origin, without credentials
+	ANY_ORIGIN = "*"
+
+	ALLOWED_METHODS = "GET, POST, DELETE, OPTIONS"
+	// PREFLIGHT_MAX_AGE is the amount of seconds
+	// browsers may cache a preflight response for
+	PREFLIGHT_MAX_AGE = "600"
+)
+
+// AllowedOrigins are the origins, other than the server's own, allowed to
+// open socket connections and to request the api, with credentials, e.g.
+// "https://example.com". If it holds ANY_ORIGIN, every origin may request
+// the api, and open socket connections, without credentials.
+var AllowedOrigins []string
+
+// ParseOrigins parses a comma-separated list of origins of the form
+// scheme://host[:port], or ANY_ORIGIN
+func ParseOrigins(spec string) ([]string, error) {
+	origins := []string{}
+	for _, origin := range strings.Split(spec, ",") {
+		origin = strings.TrimSpace(origin)
+		if len(origin) == 0 {
+			continue
+		}
+		if origin == ANY_ORIGIN {
+			origins = append(origins, origin)
+			continue
+		}
+
+		u, err := url.Parse(origin)
+		if err != nil || len(u.Scheme) == 0 || len(u.Host) == 0 || (len(u.Path) > 0 && u.Path != "/") {
+			return nil, fmt.Errorf("invalid origin %q: expecting scheme://host[:port]", origin)
+		}
+		origins = append(origins, normalize(u))
+	}
+	return origins, nil
+}
+
+func normalize(u *url.URL) string {
+	return strings.ToLower(u.Scheme + "://" + u.Host)
+}
+
+// requestScheme returns the scheme a request was sent with by the browser:
+// "https" if it was received over tls, or the scheme a proxy forwarding it
+// received it with, if any
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); len(proto) > 0 {
+		return strings.ToLower(strings.TrimSpace(strings.Split(proto, ",")[0]))
+	}
+	return "http"
+}
+
+// sameOrigin returns true if the scheme and host of an origin are
+// the scheme and host a request was sent with
+func sameOrigin(origin string, r *http.Request) bool {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return len(u.Host) > 0 && strings.EqualFold(u.Scheme, requestScheme(r)) && strings.EqualFold(u.Host, r.Host)
+}
+
+// listed returns true if an origin is one of AllowedOrigins, and
+// whether it may send credentials, which ANY_ORIGIN may not
+func listed(origin string) (allowed, credentials bool) {
+	u, err := url.Parse(origin)
+	if err == nil && len(u.Scheme) > 0 && len(u.Host) > 0 {
+		origin = normalize(u)
+		for _, o := range AllowedOrigins {
+			if o == origin {
+				return true, true
+			}
+		}
+	}
+
+	for _, o := range AllowedOrigins {
+		if o == ANY_ORIGIN {
+			return true, false
+		}
+	}
+	return false, false
+}
+
+// Allowed returns a boolean (true) if a request may be served. Requests
+// without an Origin header are not sent by a browser on behalf of another
+// site, and are allowed, as are requests from the server's own origin.
+func Allowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if len(origin) == 0 || sameOrigin(origin, r) {
+		return true
+	}
+
+	allowed, _ := listed(origin)
+	return allowed
+}
+
+// Credentialed returns a boolean (true) if a request may act with the
+// credentials it carries: if it was not sent on behalf of another site,
+// or was sent from an origin listed in AllowedOrigins, rather than one
+// allowed through ANY_ORIGIN.
+func Credentialed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if len(origin) == 0 || sameOrigin(origin, r) {
+		return true
+	}
+
+	_, credentials := listed(origin)
+	return credentials
+}
+
+// Handle applies the origin policy to a request, setting the CORS headers
+// of allowed cross-origin requests, and answering preflight requests.
+// Requests from origins that are not allowed are refused, and requests
+// from origins allowed through ANY_ORIGIN are stripped of their cookies. Returns a
+// boolean (false) if the request has been answered, and must not be
+// handled any further.
+func Handle(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if len(origin) == 0 || sameOrigin(origin, r) {
+		return true
+	}
+
+	w.Header().Add("Vary", "Origin")
+	allowed, credentials := listed(origin)
+	if !allowed {
+		log.Printf("WRN HTTP CORS refusing request for %q from origin %q\n", r.URL.Path, origin)
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return false
+	}
+
+	if credentials {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	} else {
+		// browsers send cookies along with some cross-origin requests,
+		// such as form posts, regardless of the response's headers
+		r.Header.Del("Cookie")
+		w.Header().Set("Access-Control-Allow-Origin", ANY_ORIGIN)
+	}
+
+	if r.Method == http.MethodOptions && len(r.Header.Get("Access-Control-Request-Method")) > 0 {
+		w.Header().Set("Access-Control-Allow-Methods", ALLOWED_METHODS)
+		if headers := r.Header.Get("Access-Control-Request-Headers"); len(headers) > 0 {
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+		}
+		w.Header().Set("Access-Control-Max-Age", PREFLIGHT_MAX_AGE)
+		w.WriteHeader(http.StatusNoContent)
+		return false
+	}
+
+	return true
+}
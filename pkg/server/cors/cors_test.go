@@ -0,0 +1,214 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// withOrigins sets AllowedOrigins for the duration of a test
+func withOrigins(t *testing.T, origins ...string) {
+	t.Helper()
+
+	previous := AllowedOrigins
+	AllowedOrigins = origins
+	t.Cleanup(func() {
+		AllowedOrigins = previous
+	})
+}
+
+func TestParseOrigins(t *testing.T) {
+	origins, err := ParseOrigins(" https://App.Example.com ,http://localhost:8080/, *,")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"https://app.example.com", "http://localhost:8080", ANY_ORIGIN}
+	if !reflect.DeepEqual(origins, expected) {
+		t.Fatalf("expected origins %v, got %v", expected, origins)
+	}
+
+	for _, spec := range []string{"example.com", "https://", "https://example.com/path"} {
+		if _, err := ParseOrigins(spec); err == nil {
+			t.Fatalf("expected an error for origin %q", spec)
+		}
+	}
+}
+
+func TestHandle(t *testing.T) {
+	tests := []struct {
+		name    string
+		origins []string
+		url     string
+		headers map[string]string
+
+		handled     bool
+		allowed     bool
+		credentials bool
+		status      int
+		allowOrigin string
+		cookie      bool
+	}{
+		{
+			name:        "listed origin",
+			origins:     []string{"https://app.example.com"},
+			url:         "https://api.example.com/api/rooms",
+			headers:     map[string]string{"Origin": "https://app.example.com", "Cookie": "session=abc"},
+			handled:     true,
+			allowed:     true,
+			credentials: true,
+			allowOrigin: "https://app.example.com",
+			cookie:      true,
+		},
+		{
+			name:    "unlisted origin",
+			origins: []string{"https://app.example.com"},
+			url:     "https://api.example.com/api/rooms",
+			headers: map[string]string{"Origin": "https://evil.example.com", "Cookie": "session=abc"},
+			status:  http.StatusForbidden,
+		},
+		{
+			name:        "same origin",
+			url:         "https://example.com/api/rooms",
+			headers:     map[string]string{"Origin": "https://example.com", "Cookie": "session=abc"},
+			handled:     true,
+			allowed:     true,
+			credentials: true,
+			cookie:      true,
+		},
+		{
+			name:        "same origin behind a tls proxy",
+			url:         "http://example.com/api/rooms",
+			headers:     map[string]string{"Origin": "https://example.com", "X-Forwarded-Proto": "https", "Cookie": "session=abc"},
+			handled:     true,
+			allowed:     true,
+			credentials: true,
+			cookie:      true,
+		},
+		{
+			name:    "same host over another scheme",
+			url:     "https://example.com/api/rooms",
+			headers: map[string]string{"Origin": "http://example.com", "Cookie": "session=abc"},
+			status:  http.StatusForbidden,
+		},
+		{
+			name:        "no origin header",
+			origins:     []string{"https://app.example.com"},
+			url:         "https://api.example.com/api/rooms",
+			headers:     map[string]string{"Cookie": "session=abc"},
+			handled:     true,
+			allowed:     true,
+			credentials: true,
+			cookie:      true,
+		},
+		{
+			name:        "any origin, without credentials",
+			origins:     []string{ANY_ORIGIN},
+			url:         "https://api.example.com/api/rooms",
+			headers:     map[string]string{"Origin": "https://evil.example.com", "Cookie": "session=abc"},
+			handled:     true,
+			allowed:     true,
+			allowOrigin: ANY_ORIGIN,
+		},
+		{
+			name:        "listed origin, alongside any origin",
+			origins:     []string{ANY_ORIGIN, "https://app.example.com"},
+			url:         "https://api.example.com/api/rooms",
+			headers:     map[string]string{"Origin": "https://app.example.com", "Cookie": "session=abc"},
+			handled:     true,
+			allowed:     true,
+			credentials: true,
+			allowOrigin: "https://app.example.com",
+			cookie:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withOrigins(t, tt.origins...)
+
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+
+			if allowed := Allowed(r); allowed != tt.allowed {
+				t.Fatalf("expected Allowed to return %v, got %v", tt.allowed, allowed)
+			}
+			if credentials := Credentialed(r); credentials != tt.credentials {
+				t.Fatalf("expected Credentialed to return %v, got %v", tt.credentials, credentials)
+			}
+
+			w := httptest.NewRecorder()
+			if handled := Handle(w, r); handled != tt.handled {
+				t.Fatalf("expected Handle to return %v, got %v", tt.handled, handled)
+			}
+			if !tt.handled && w.Code != tt.status {
+				t.Fatalf("expected status %v, got %v", tt.status, w.Code)
+			}
+
+			if allowOrigin := w.Header().Get("Access-Control-Allow-Origin"); allowOrigin != tt.allowOrigin {
+				t.Fatalf("expected Access-Control-Allow-Origin %q, got %q", tt.allowOrigin, allowOrigin)
+			}
+			allowCredentials := w.Header().Get("Access-Control-Allow-Credentials") == "true"
+			if expected := tt.credentials && len(tt.allowOrigin) > 0; allowCredentials != expected {
+				t.Fatalf("expected Access-Control-Allow-Credentials to be set: %v, got %v", expected, allowCredentials)
+			}
+			if tt.handled {
+				if cookie := len(r.Header.Get("Cookie")) > 0; cookie != tt.cookie {
+					t.Fatalf("expected the request to keep its cookies: %v, got %v", tt.cookie, cookie)
+				}
+			}
+		})
+	}
+}
+
+func TestHandlePreflight(t *testing.T) {
+	withOrigins(t, "https://app.example.com")
+
+	preflight := func(origin string) (*httptest.ResponseRecorder, bool) {
+		r := httptest.NewRequest(http.MethodOptions, "https://api.example.com/api/rooms", nil)
+		r.Header.Set("Origin", origin)
+		r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		r.Header.Set("Access-Control-Request-Headers", "Content-Type")
+
+		w := httptest.NewRecorder()
+		return w, Handle(w, r)
+	}
+
+	w, handled := preflight("https://app.example.com")
+	if handled {
+		t.Fatalf("expected a preflight request to be answered")
+	}
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected status %v, got %v", http.StatusNoContent, w.Code)
+	}
+	expected := map[string]string{
+		"Access-Control-Allow-Origin":      "https://app.example.com",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Allow-Methods":     ALLOWED_METHODS,
+		"Access-Control-Allow-Headers":     "Content-Type",
+		"Access-Control-Max-Age":           PREFLIGHT_MAX_AGE,
+		"Vary":                             "Origin",
+	}
+	for k, v := range expected {
+		if got := w.Header().Get(k); got != v {
+			t.Fatalf("expected header %s: %q, got %q", k, v, got)
+		}
+	}
+
+	w, handled = preflight("https://evil.example.com")
+	if handled || w.Code != http.StatusForbidden {
+		t.Fatalf("expected a preflight request from an unlisted origin to be refused, got status %v", w.Code)
+	}
+	if len(w.Header().Get("Access-Control-Allow-Methods")) > 0 {
+		t.Fatalf("expected a refused preflight request to allow no methods")
+	}
+
+	// an OPTIONS request that is not a preflight request is handled as usual
+	r := httptest.NewRequest(http.MethodOptions, "https://api.example.com/api/rooms", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	if !Handle(httptest.NewRecorder(), r) {
+		t.Fatalf("expected an OPTIONS request without a requested method to be handled further")
+	}
+}
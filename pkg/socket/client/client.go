@@ -4,9 +4,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/juanvallejo/streaming-server/pkg/api/endpoint/query"
 	"github.com/juanvallejo/streaming-server/pkg/socket/connection"
+	"github.com/juanvallejo/streaming-server/pkg/socket/protocol"
 )
 
 const (
@@ -21,6 +23,8 @@ var RESERVED_USERNAMES = map[string]bool{
 type Client struct {
 	connection connection.Connection
 	usernames  []string // stores MAX_USERNAME_HIST usernames; tail represents current username
+
+	mux sync.RWMutex
 }
 
 type SerializableClientList struct {
@@ -55,12 +59,12 @@ func (s *SerializableClient) Serialize() ([]byte, error) {
 // Response is a serializable schema representing
 // a response to be sent to the client
 type Response struct {
-	Id         string                 `json:"id"`
-	IsSystem   bool                   `json:"system"`
-	From       string                 `json:"user"`
-	Message    string                 `json:"message"`
-	ErrMessage string                 `json:"error"`
-	Extra      map[string]interface{} `json:"extra"`
+	Id         string      `json:"id"`
+	IsSystem   bool        `json:"system"`
+	From       string      `json:"user"`
+	Message    string      `json:"message"`
+	ErrMessage string      `json:"error"`
+	Extra      interface{} `json:"extra"`
 }
 
 func (r *Response) Serialize() ([]byte, error) {
@@ -120,6 +124,9 @@ func (c *Client) UpdateUsername(username string) error {
 		return fmt.Errorf("you may not use that username")
 	}
 
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
 	if len(c.usernames) < 2 {
 		c.usernames = append(c.usernames, username)
 		return nil
@@ -138,6 +145,9 @@ func (c *Client) UpdateUsername(username string) error {
 // GetUsername returns the currently active username for a client
 // or a bool (false) if client has no username history
 func (c *Client) GetUsername() (string, bool) {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+
 	if len(c.usernames) == 0 {
 		return "", false
 	}
@@ -145,6 +155,14 @@ func (c *Client) GetUsername() (string, bool) {
 	return c.usernames[len(c.usernames)-1], true
 }
 
+// Usernames returns a copy of a client's username history, oldest first
+func (c *Client) Usernames() []string {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+
+	return append([]string{}, c.usernames...)
+}
+
 // GetUsernameOrId retuens the currently active username for a client
 // or its unique identifier if there is no username history.
 func (c *Client) GetUsernameOrId() string {
@@ -159,6 +177,9 @@ func (c *Client) GetUsernameOrId() string {
 // GetPreviousUsername returns the last active username for a client
 // or a bool (false) if 0 or 1 total usernames have been recorded so far
 func (c *Client) GetPreviousUsername() (string, bool) {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+
 	if len(c.usernames) < 2 {
 		return "", false
 	}
@@ -168,7 +189,7 @@ func (c *Client) GetPreviousUsername() (string, bool) {
 
 // BroadcastErrorTo broadcasts an error message event to the current client
 func (c *Client) BroadcastErrorTo(err error) {
-	c.BroadcastTo("info_clienterror", &Response{
+	c.BroadcastTo(protocol.EVENT_INFO_CLIENTERROR, &Response{
 		ErrMessage: err.Error(),
 		IsSystem:   true,
 	})
@@ -186,7 +207,7 @@ func (c *Client) BroadcastAll(evt string, data connection.MessageDataCodec) {
 
 func (c *Client) BroadcastTo(evt string, data connection.MessageDataCodec) {
 	m := getBroadcastMessage(evt, data)
-	c.connection.Send(m)
+	c.connection.Send(evt, m)
 }
 
 func (c *Client) BroadcastFrom(evt string, data connection.MessageDataCodec) {
@@ -200,14 +221,17 @@ func (c *Client) BroadcastFrom(evt string, data connection.MessageDataCodec) {
 }
 
 func (c *Client) BroadcastAuthRequestTo(seg string) {
-	targetEndpoint := fmt.Sprintf("/api/auth/%s?%s=%s", seg, query.CONN_ID_KEY, c.UUID())
+	token := c.connection.Metadata().ApiToken()
+	targetEndpoint := fmt.Sprintf("/api/auth/%s?%s=%s&%s=%s", seg, query.CONN_ID_KEY, c.UUID(), query.TOKEN_KEY, token)
 
-	c.BroadcastTo("authorization", &Response{
+	// sent only to the client itself, as it carries its api token
+	c.BroadcastTo(protocol.EVENT_AUTHORIZATION, &Response{
 		Id:   c.UUID(),
 		From: c.GetUsernameOrId(),
-		Extra: map[string]interface{}{
-			"method":   "GET",
-			"endpoint": targetEndpoint,
+		Extra: &protocol.AuthorizationRequest{
+			Method:   "GET",
+			Endpoint: targetEndpoint,
+			Token:    token,
 		},
 	})
 }
@@ -222,7 +246,7 @@ func (c *Client) BroadcastSystemMessageAll(msg string) {
 // BroadcastSystemMessageFrom emits a system-level message from the current
 // client to the rest of its channel
 func (c *Client) BroadcastSystemMessageFrom(msg string) {
-	c.BroadcastFrom("chatmessage", &Response{
+	c.BroadcastFrom(protocol.EVENT_CHATMESSAGE, &Response{
 		From:     USER_SYSTEM,
 		Message:  msg,
 		IsSystem: true,
@@ -232,7 +256,7 @@ func (c *Client) BroadcastSystemMessageFrom(msg string) {
 // BroadcastSystemMessageTo emits a system-level message to the current
 // client only
 func (c *Client) BroadcastSystemMessageTo(msg string) {
-	c.BroadcastTo("chatmessage", &Response{
+	c.BroadcastTo(protocol.EVENT_CHATMESSAGE, &Response{
 		From:     USER_SYSTEM,
 		Message:  msg,
 		IsSystem: true,
@@ -244,11 +268,11 @@ func (c *Client) BroadcastChatActionTo(methodName string, args []interface{}) {
 		args = []interface{}{}
 	}
 
-	c.BroadcastTo("chatmethodaction", &Response{
+	c.BroadcastTo(protocol.EVENT_CHATMETHODACTION, &Response{
 		From: USER_SYSTEM,
-		Extra: map[string]interface{}{
-			"methodname": methodName,
-			"args":       args,
+		Extra: &protocol.ChatMethodAction{
+			MethodName: methodName,
+			Args:       args,
 		},
 	})
 }
@@ -258,11 +282,11 @@ func (c *Client) BroadcastChatActionFrom(methodName string, args []interface{})
 		args = []interface{}{}
 	}
 
-	c.BroadcastFrom("chatmethodaction", &Response{
+	c.BroadcastFrom(protocol.EVENT_CHATMETHODACTION, &Response{
 		From: USER_SYSTEM,
-		Extra: map[string]interface{}{
-			"methodname": methodName,
-			"args":       args,
+		Extra: &protocol.ChatMethodAction{
+			MethodName: methodName,
+			Args:       args,
 		},
 	})
 }
@@ -352,6 +376,27 @@ func (c *Client) Connections() []connection.Connection {
 	return c.connection.Connections()
 }
 
+// BroadcastNamespace emits an event to every connection in a namespace.
+// Unlike BroadcastAll, it does not depend on any one client remaining
+// in the namespace, and may be used by callbacks that outlive the
+// client that registered them.
+func BroadcastNamespace(ns connection.Namespace, evt string, data connection.MessageDataCodec) {
+	m := getBroadcastMessage(evt, data)
+	for _, conn := range ns.Connections() {
+		conn.Send(evt, m)
+	}
+}
+
+// BroadcastSystemMessageNamespace emits a system-level message to
+// every connection in a namespace
+func BroadcastSystemMessageNamespace(ns connection.Namespace, msg string) {
+	BroadcastNamespace(ns, protocol.EVENT_CHATMESSAGE, &Response{
+		From:     USER_SYSTEM,
+		Message:  msg,
+		IsSystem: true,
+	})
+}
+
 func getBroadcastMessage(evt string, codec connection.MessageDataCodec) []byte {
 	message := &connection.Message{
 		Event: evt,
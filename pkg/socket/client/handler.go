@@ -2,6 +2,7 @@ package client
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/juanvallejo/streaming-server/pkg/socket/connection"
 )
@@ -23,13 +24,19 @@ type SocketClientHandler interface {
 	Clients() []*Client
 }
 
-// Handler implements ClientHandler
+// Handler implements ClientHandler. Clients are created and destroyed by
+// their connections' goroutines, and looked up by every other goroutine
+// serving a room; clientsById is guarded by mux.
 type Handler struct {
+	mux         sync.RWMutex
 	clientsById map[string]*Client
 }
 
 func (h *Handler) CreateClient(socket connection.Connection) *Client {
 	c := NewClient(socket)
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
 	h.clientsById[socket.UUID()] = c
 
 	return c
@@ -37,15 +44,23 @@ func (h *Handler) CreateClient(socket connection.Connection) *Client {
 
 func (h *Handler) DestroyClient(socket connection.Connection) error {
 	id := socket.UUID()
-	if c, ok := h.clientsById[id]; ok {
+
+	h.mux.Lock()
+	c, ok := h.clientsById[id]
+	delete(h.clientsById, id)
+	h.mux.Unlock()
+
+	if ok {
 		c.UnsetNamespace()
-		delete(h.clientsById, id)
 		return nil
 	}
 	return fmt.Errorf("client with id %q does not exist", id)
 }
 
 func (h *Handler) GetClient(id string) (*Client, error) {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+
 	if c, found := h.clientsById[id]; found {
 		return c, nil
 	}
@@ -53,6 +68,9 @@ func (h *Handler) GetClient(id string) (*Client, error) {
 }
 
 func (h *Handler) Clients() []*Client {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+
 	clients := make([]*Client, 0, len(h.clientsById))
 	for _, c := range h.clientsById {
 		clients = append(clients, c)
@@ -61,6 +79,9 @@ func (h *Handler) Clients() []*Client {
 }
 
 func (h *Handler) GetClientSize() int {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+
 	return len(h.clientsById)
 }
 
@@ -0,0 +1,97 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/juanvallejo/streaming-server/pkg/socket/protocol"
+)
+
+// ErrorCode identifies the kind of error a client request failed with
+type ErrorCode string
+
+const (
+	ERR_CODE_INVALID_REQUEST ErrorCode = "invalid_request"
+	ERR_CODE_UNKNOWN_COMMAND ErrorCode = "unknown_command"
+	ERR_CODE_FORBIDDEN       ErrorCode = "forbidden"
+	ERR_CODE_MUTED           ErrorCode = "muted"
+	ERR_CODE_RATE_LIMITED    ErrorCode = "rate_limited"
+	ERR_CODE_NO_ROOM         ErrorCode = "no_room"
+	ERR_CODE_ROOM_CLOSED     ErrorCode = "room_closed"
+	ERR_CODE_COMMAND_FAILED  ErrorCode = "command_failed"
+	ERR_CODE_INTERNAL        ErrorCode = "internal"
+)
+
+// Error is an error with a code that clients can act on
+// without parsing its message
+type Error struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// NewError returns an Error with the given code and formatted message
+func NewError(code ErrorCode, format string, args ...interface{}) *Error {
+	return &Error{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// AsError receives an error and returns it as an Error. Errors
+// without a code of their own are given the specified code.
+func AsError(err error, code ErrorCode) *Error {
+	if e, ok := err.(*Error); ok {
+		return e
+	}
+	return &Error{
+		Code:    code,
+		Message: err.Error(),
+	}
+}
+
+// Reply is a serializable schema answering a client request
+// that was sent with a message id
+type Reply struct {
+	// Id is the message id of the request being answered
+	Id string `json:"id"`
+	// Event is the event of the request being answered
+	Event  string `json:"event"`
+	Ok     bool   `json:"ok"`
+	Result string `json:"result,omitempty"`
+	Error  *Error `json:"error,omitempty"`
+}
+
+func (r *Reply) Serialize() ([]byte, error) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	return b, nil
+}
+
+// Ack acknowledges the client request with the given message id
+// and event, carrying the request's result, if any
+func (c *Client) Ack(id, event, result string) {
+	c.BroadcastTo(protocol.EVENT_ACK, &Reply{
+		Id:     id,
+		Event:  event,
+		Ok:     true,
+		Result: result,
+	})
+}
+
+// ReplyError answers the client request with the given message id
+// and event with an error. Errors without a code of their own are
+// sent with the specified code.
+func (c *Client) ReplyError(id, event string, code ErrorCode, err error) {
+	c.BroadcastTo(protocol.EVENT_ERROR, &Reply{
+		Id:    id,
+		Event: event,
+		Error: AsError(err, code),
+	})
+}
@@ -0,0 +1,228 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/juanvallejo/streaming-server/pkg/playback"
+	"github.com/juanvallejo/streaming-server/pkg/socket/client"
+	"github.com/juanvallejo/streaming-server/pkg/socket/cmd/rbac"
+	"github.com/juanvallejo/streaming-server/pkg/socket/connection"
+	"github.com/juanvallejo/streaming-server/pkg/socket/protocol"
+	"github.com/juanvallejo/streaming-server/pkg/stream"
+)
+
+type AdminCmd struct {
+	Command
+}
+
+const (
+	ADMIN_NAME        = "admin"
+	ADMIN_DESCRIPTION = "hand admin over to another user, give it up, vote for the next admin, or choose how your room elects admins once it has none (requires rbac to be enabled)"
+	ADMIN_USAGE       = "Usage: /" + ADMIN_NAME + " (list|transfer &lt;user&gt;|step-down|vote &lt;user&gt;|policy [oldest|activity|vote|owner])"
+)
+
+func (h *AdminCmd) Execute(cmdHandler SocketCommandHandler, args []string, user *client.Client, clientHandler client.SocketClientHandler, playbackHandler playback.PlaybackHandler, streamHandler stream.StreamHandler) (string, error) {
+	authorizer := cmdHandler.Authorizer()
+	if authorizer == nil {
+		return "", fmt.Errorf("error: rbac is not enabled")
+	}
+
+	namespace, exists := user.Namespace()
+	if !exists {
+		return "", fmt.Errorf("error: you must be in a room to manage its admins")
+	}
+
+	sPlayback, exists := playbackHandler.PlaybackByNamespace(namespace)
+	if !exists {
+		return "", fmt.Errorf("error: no playback found for your room")
+	}
+
+	if len(args) == 0 {
+		return h.usage, nil
+	}
+
+	switch args[0] {
+	case "list":
+		return describeAdmins(authorizer, namespace, sPlayback, clientHandler), nil
+	case "transfer":
+		if len(args) < 2 {
+			return "", fmt.Errorf("%v", h.usage)
+		}
+		return transferAdmin(authorizer, namespace, sPlayback, user, clientHandler, args[1])
+	case "step-down":
+		return stepDownAdmin(authorizer, namespace, sPlayback, user)
+	case "vote":
+		if len(args) < 2 {
+			return "", fmt.Errorf("%v", h.usage)
+		}
+
+		picker, exists := sPlayback.AdminPicker()
+		voter, isVote := picker.(*playback.VoteAdminPicker)
+		if !exists || !isVote {
+			return "", fmt.Errorf("error: admins are not elected by vote in this room")
+		}
+
+		candidate, exists := clientInNamespace(clientHandler, namespace, args[1])
+		if !exists {
+			return "", fmt.Errorf("error: unable to find user %q in your room", args[1])
+		}
+
+		voter.Vote(user.UUID(), candidate.UUID())
+		votes := voter.Tally(namespace.Connections())[candidate.UUID()]
+		return fmt.Sprintf("you voted for %q to be the next admin of this room (%v votes)", candidate.GetUsernameOrId(), votes), nil
+	case "policy":
+		if len(args) < 2 {
+			picker, exists := sPlayback.AdminPicker()
+			if !exists {
+				return "admins are not elected in this room", nil
+			}
+			return fmt.Sprintf("admins are elected by %q policy in this room. Available policies: %s", picker.Policy(), strings.Join(playback.AdminPolicies, ", ")), nil
+		}
+
+		var picker playback.AdminPicker
+		var err error
+		if args[1] == playback.ADMIN_POLICY_OWNER {
+			picker, err = playback.NewOwnerAdminPicker(sPlayback.Clock(), user.Connection())
+		} else {
+			picker, err = playback.NewAdminPicker(args[1], sPlayback.Clock())
+		}
+		if err != nil {
+			return "", fmt.Errorf("error: %v", err)
+		}
+
+		if err := picker.Init(namespace, authorizer, clientHandler, playbackHandler); err != nil {
+			return "", fmt.Errorf("error: unable to start admin elections: %v", err)
+		}
+		sPlayback.SetAdminPicker(picker)
+
+		user.BroadcastSystemMessageFrom(fmt.Sprintf("%q changed how this room elects admins to the %q policy", user.GetUsernameOrId(), picker.Policy()))
+		if owner, isOwner := picker.(*playback.OwnerAdminPicker); isOwner {
+			return fmt.Sprintf("you are now the owner of this room, identified by %s, and will regain admin whenever you rejoin it", owner.Owner()), nil
+		}
+		return fmt.Sprintf("admins are now elected by %q policy in this room", picker.Policy()), nil
+	}
+
+	return "", fmt.Errorf("%v", h.usage)
+}
+
+// describeAdmins lists a room's admins and its admin election policy
+func describeAdmins(authorizer rbac.Authorizer, namespace connection.Namespace, sPlayback *playback.Playback, clientHandler client.SocketClientHandler) string {
+	admins := []string{}
+	for _, c := range namespace.Connections() {
+		if !isAdmin(authorizer, c) {
+			continue
+		}
+		if cl, err := clientHandler.GetClient(c.UUID()); err == nil {
+			admins = append(admins, fmt.Sprintf("<span class='text-hl-name'>%s</span>", cl.GetUsernameOrId()))
+		}
+	}
+
+	output := "This room has no admin."
+	if len(admins) > 0 {
+		output = "Admins in this room: " + strings.Join(admins, ", ")
+	}
+
+	picker, exists := sPlayback.AdminPicker()
+	if !exists {
+		return output
+	}
+
+	output += fmt.Sprintf("<br />Admins are elected by %q policy", picker.Policy())
+	if owner, isOwner := picker.(*playback.OwnerAdminPicker); isOwner {
+		output += fmt.Sprintf(", and the room is owned by %s", owner.Owner())
+	}
+	if voter, isVote := picker.(*playback.VoteAdminPicker); isVote {
+		tally := voter.Tally(namespace.Connections())
+		for id, votes := range tally {
+			if cl, err := clientHandler.GetClient(id); err == nil {
+				output += fmt.Sprintf("<br />%s: %v votes", cl.GetUsernameOrId(), votes)
+			}
+		}
+	}
+
+	return output
+}
+
+// transferAdmin binds the admin role to the named user in the
+// room, and removes it from the user handing admin over.
+func transferAdmin(authorizer rbac.Authorizer, namespace connection.Namespace, sPlayback *playback.Playback, user *client.Client, clientHandler client.SocketClientHandler, name string) (string, error) {
+	if !isAdmin(authorizer, user.Connection()) {
+		return "", fmt.Errorf("error: you must be an admin to hand admin over")
+	}
+
+	target, exists := clientInNamespace(clientHandler, namespace, name)
+	if !exists {
+		return "", fmt.Errorf("error: unable to find user %q in your room", name)
+	}
+	if target.UUID() == user.UUID() {
+		return "", fmt.Errorf("error: you are already an admin")
+	}
+
+	adminRole, exists := authorizer.Role(rbac.ADMIN_ROLE)
+	if !exists {
+		return "", fmt.Errorf("error: role %q not found", rbac.ADMIN_ROLE)
+	}
+
+	if !isAdmin(authorizer, target.Connection()) {
+		if err := addRole(authorizer, adminRole, target); err != nil {
+			return "", err
+		}
+		recordRoleChange(namespace, user, "bind/"+rbac.ADMIN_ROLE, target)
+	}
+	target.BroadcastSystemMessageTo(fmt.Sprintf("%q has handed admin of this room over to you.", user.GetUsernameOrId()))
+	target.BroadcastAuthRequestTo("cookie")
+
+	removeAdmin(authorizer, namespace, sPlayback, user)
+	return fmt.Sprintf("you have handed admin of this room over to %q", target.GetUsernameOrId()), nil
+}
+
+// stepDownAdmin removes the admin role from the user. If the room is
+// left without an admin, one is elected right away.
+func stepDownAdmin(authorizer rbac.Authorizer, namespace connection.Namespace, sPlayback *playback.Playback, user *client.Client) (string, error) {
+	if !isAdmin(authorizer, user.Connection()) {
+		return "", fmt.Errorf("error: you are not an admin")
+	}
+
+	removeAdmin(authorizer, namespace, sPlayback, user)
+	sPlayback.ElectAdmin()
+	return "you are no longer an admin of this room", nil
+}
+
+// removeAdmin unbinds the admin role from a user, and excludes
+// them from admin elections until they leave the room
+func removeAdmin(authorizer rbac.Authorizer, namespace connection.Namespace, sPlayback *playback.Playback, user *client.Client) {
+	for _, b := range authorizer.Bindings() {
+		if b.Role().Name() != rbac.ADMIN_ROLE {
+			continue
+		}
+		if b.RemoveSubject(user) {
+			recordRoleChange(namespace, user, "unbind/"+rbac.ADMIN_ROLE, user)
+		}
+	}
+
+	sPlayback.DeclineAdmin(user.UUID())
+	user.BroadcastAll(protocol.EVENT_INFO_USERLISTUPDATED, &client.Response{
+		Id: user.UUID(),
+	})
+	user.BroadcastAuthRequestTo("cookie")
+}
+
+func isAdmin(authorizer rbac.Authorizer, conn connection.Connection) bool {
+	for _, role := range rbac.SubjectRoleNames(authorizer.Bindings(), conn) {
+		if role == rbac.ADMIN_ROLE {
+			return true
+		}
+	}
+	return false
+}
+
+func NewCmdAdmin() SocketCommand {
+	return &AdminCmd{
+		Command{
+			name:        ADMIN_NAME,
+			description: ADMIN_DESCRIPTION,
+			usage:       ADMIN_USAGE,
+		},
+	}
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juanvallejo/streaming-server/pkg/audit"
+	"github.com/juanvallejo/streaming-server/pkg/playback"
+	"github.com/juanvallejo/streaming-server/pkg/socket/client"
+	"github.com/juanvallejo/streaming-server/pkg/stream"
+)
+
+type AuditCmd struct {
+	Command
+}
+
+const (
+	AUDIT_NAME        = "audit"
+	AUDIT_DESCRIPTION = "view the log of privileged actions taken in your room"
+	AUDIT_USAGE       = "Usage: /" + AUDIT_NAME + " [user=&lt;name&gt;] [action=&lt;prefix&gt;] [kind=&lt;command|role|auth&gt;] [since=&lt;1h|RFC3339&gt;] [until=&lt;1h|RFC3339&gt;] [limit=&lt;n&gt;]|retention [entries]"
+
+	// max number of entries to show when no limit is given
+	AUDIT_DEFAULT_LIMIT = 20
+)
+
+func (h *AuditCmd) Execute(cmdHandler SocketCommandHandler, args []string, user *client.Client, clientHandler client.SocketClientHandler, playbackHandler playback.PlaybackHandler, streamHandler stream.StreamHandler) (string, error) {
+	namespace, exists := user.Namespace()
+	if !exists {
+		return "", fmt.Errorf("error: you must be in a room to view its audit log")
+	}
+
+	auditLog := namespace.Audit()
+
+	if len(args) > 0 && args[0] == "retention" {
+		if len(args) == 1 {
+			return fmt.Sprintf("this room keeps its %v most recent audit entries", auditLog.Retention()), nil
+		}
+
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 || n > audit.MaxRetention {
+			return "", fmt.Errorf("error: retention must be a number of entries between 1 and %v", audit.MaxRetention)
+		}
+
+		auditLog.SetRetention(n)
+		return fmt.Sprintf("this room now keeps its %v most recent audit entries", n), nil
+	}
+
+	values := url.Values{}
+	for _, arg := range args {
+		segs := strings.SplitN(arg, "=", 2)
+		if len(segs) != 2 {
+			return "", fmt.Errorf("%v", h.usage)
+		}
+		values.Set(segs[0], segs[1])
+	}
+	if len(values.Get("limit")) == 0 {
+		values.Set("limit", strconv.Itoa(AUDIT_DEFAULT_LIMIT))
+	}
+
+	filter, err := audit.FilterFromValues(values)
+	if err != nil {
+		return "", fmt.Errorf("error: %v", err)
+	}
+
+	entries := auditLog.List(filter)
+	if len(entries) == 0 {
+		return "No matching audit entries.", nil
+	}
+
+	output := fmt.Sprintf("%v most recent matching audit entries:", len(entries))
+	for _, e := range entries {
+		outcome := "allowed"
+		if !e.Allowed {
+			outcome = "denied"
+		}
+
+		line := fmt.Sprintf("<br />[%s] %s <span class='text-hl-name'>%s</span> %s %q", e.Time.Format(time.Stamp), e.Kind, html.EscapeString(e.Actor), outcome, e.Action)
+		if len(e.Detail) > 0 {
+			line += fmt.Sprintf(" (%s)", html.EscapeString(e.Detail))
+		}
+		output += line
+	}
+
+	return output, nil
+}
+
+func NewCmdAudit() SocketCommand {
+	return &AuditCmd{
+		Command{
+			name:        AUDIT_NAME,
+			description: AUDIT_DESCRIPTION,
+			usage:       AUDIT_USAGE,
+		},
+	}
+}
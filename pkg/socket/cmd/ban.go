@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/juanvallejo/streaming-server/pkg/playback"
+	"github.com/juanvallejo/streaming-server/pkg/socket/client"
+	"github.com/juanvallejo/streaming-server/pkg/socket/connection"
+	"github.com/juanvallejo/streaming-server/pkg/stream"
+)
+
+type BanCmd struct {
+	Command
+}
+
+const (
+	BAN_NAME        = "ban"
+	BAN_DESCRIPTION = "disconnects a user and prevents them from rejoining the room; with " + BAN_ADDRESS_FLAG + ", also bans everyone else at their address"
+	BAN_USAGE       = "Usage: /" + BAN_NAME + " [&lt;user&gt; [" + BAN_ADDRESS_FLAG + "] [duration] [reason]]"
+
+	// BAN_ADDRESS_FLAG extends a ban to the remote address of its target
+	BAN_ADDRESS_FLAG = "--ip"
+)
+
+func (h *BanCmd) Execute(cmdHandler SocketCommandHandler, args []string, user *client.Client, clientHandler client.SocketClientHandler, playbackHandler playback.PlaybackHandler, streamHandler stream.StreamHandler) (string, error) {
+	if len(args) == 0 {
+		namespace, exists := user.Namespace()
+		if !exists {
+			return "", fmt.Errorf("error: you must be in a room to view its bans")
+		}
+		return listSanctions("bans", namespace.Moderation().Bans()), nil
+	}
+
+	target, namespace, err := moderationTarget(cmdHandler, clientHandler, user, args[0])
+	if err != nil {
+		return "", err
+	}
+
+	// addresses may be shared by a whole network; only
+	// ban the target's address when asked to explicitly
+	banAddress := len(args) > 1 && args[1] == BAN_ADDRESS_FLAG
+	if banAddress {
+		args = args[1:]
+	}
+
+	duration, reason := parseSanctionArgs(args[1:])
+	ban := newSanction(connection.SANCTION_BAN, target, user, duration, reason)
+	if banAddress {
+		ban.Address = connection.RemoteAddress(target.Connection().Request())
+	}
+
+	// the user creating the ban, and the room's admins,
+	// are never banned along with its target
+	ban.Exempt = append(ban.Exempt, user.Connection().Metadata().Identity())
+	for _, conn := range namespace.Connections() {
+		if isAdminConnection(cmdHandler, conn) {
+			ban.Exempt = append(ban.Exempt, conn.Metadata().Identity())
+		}
+	}
+	namespace.Moderation().Ban(ban)
+
+	log.Printf("INF SOCKET CMD BAN client %q with id (%s) banned client %q with id (%s) from %q for %v: %q\n", user.GetUsernameOrId(), user.UUID(), ban.Subject, target.UUID(), ban.Address, duration, reason)
+
+	// disconnect every connection the ban applies to,
+	// such as other tabs opened by the same browser
+	for _, conn := range namespace.Connections() {
+		if conn.UUID() == user.UUID() || isAdminConnection(cmdHandler, conn) {
+			continue
+		}
+		if !ban.Matches(conn.UUID(), conn.Metadata().Identity(), connection.RemoteAddress(conn.Request())) {
+			continue
+		}
+
+		c, err := clientHandler.GetClient(conn.UUID())
+		if err != nil {
+			continue
+		}
+
+		c.BroadcastSystemMessageTo(fmt.Sprintf("you have been banned from this room by %q", ban.By))
+		disconnectClient(c, "banned")
+	}
+
+	user.BroadcastSystemMessageFrom(describeSanction(ban))
+	return fmt.Sprintf("banned %q", ban.Subject), nil
+}
+
+func NewCmdBan() SocketCommand {
+	return &BanCmd{
+		Command{
+			name:        BAN_NAME,
+			description: BAN_DESCRIPTION,
+			usage:       BAN_USAGE,
+		},
+	}
+}
+
+type UnbanCmd struct {
+	Command
+}
+
+const (
+	UNBAN_NAME        = "unban"
+	UNBAN_DESCRIPTION = "lifts bans against a user or address"
+	UNBAN_USAGE       = "Usage: /" + UNBAN_NAME + " &lt;user|address&gt;"
+)
+
+func (h *UnbanCmd) Execute(cmdHandler SocketCommandHandler, args []string, user *client.Client, clientHandler client.SocketClientHandler, playbackHandler playback.PlaybackHandler, streamHandler stream.StreamHandler) (string, error) {
+	namespace, exists := user.Namespace()
+	if !exists {
+		return "", fmt.Errorf("error: you must be in a room to moderate it")
+	}
+
+	if len(args) == 0 {
+		return "", fmt.Errorf("%v<br />%s", h.usage, listSanctions("bans", namespace.Moderation().Bans()))
+	}
+
+	lifted := namespace.Moderation().Unban(args[0], user.GetUsernameOrId())
+	if len(lifted) == 0 {
+		return "", fmt.Errorf("error: no bans found for %q", args[0])
+	}
+
+	log.Printf("INF SOCKET CMD UNBAN client %q with id (%s) lifted %v ban(s) against %q\n", user.GetUsernameOrId(), user.UUID(), len(lifted), args[0])
+	user.BroadcastSystemMessageFrom(fmt.Sprintf("%q has lifted the ban against %q", user.GetUsernameOrId(), args[0]))
+	return fmt.Sprintf("lifted %v ban(s) against %q", len(lifted), args[0]), nil
+}
+
+func NewCmdUnban() SocketCommand {
+	return &UnbanCmd{
+		Command{
+			name:        UNBAN_NAME,
+			description: UNBAN_DESCRIPTION,
+			usage:       UNBAN_USAGE,
+		},
+	}
+}
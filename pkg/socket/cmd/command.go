@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/juanvallejo/streaming-server/pkg/playback"
 	"github.com/juanvallejo/streaming-server/pkg/socket/client"
@@ -59,3 +60,40 @@ func (c *Command) GetAliases() []string {
 
 	return c.aliases
 }
+
+// REDACTED_ARG replaces secret command arguments in logs
+const REDACTED_ARG = "[redacted]"
+
+// SecretArgsCommand is implemented by commands that receive secrets, such
+// as room passwords, as arguments. Secret arguments are redacted from the
+// server log and from a room's audit log.
+type SecretArgsCommand interface {
+	// SecretArgs receives the command's arguments and returns the index of
+	// the first argument holding a secret, or a boolean (false) if none do.
+	// Every argument from that index onwards is treated as secret.
+	SecretArgs([]string) (int, bool)
+}
+
+// RedactArgs receives a command and its arguments, and returns a copy of
+// the arguments with any secret argument replaced by REDACTED_ARG
+func RedactArgs(command SocketCommand, args []string) []string {
+	secrets, ok := command.(SecretArgsCommand)
+	if !ok {
+		return args
+	}
+	idx, hasSecrets := secrets.SecretArgs(args)
+	if !hasSecrets || idx < 0 || idx >= len(args) {
+		return args
+	}
+
+	return append(append([]string{}, args[:idx]...), REDACTED_ARG)
+}
+
+// RedactCommand receives a command root and its arguments, and returns the
+// command as typed, with any secret argument replaced by REDACTED_ARG
+func RedactCommand(h SocketCommandHandler, cmdRoot string, args []string) string {
+	if command, exists := resolveCommandAlias(cmdRoot, h.Commands(), h.Aliases()); exists {
+		args = RedactArgs(command, args)
+	}
+	return strings.TrimSpace(cmdRoot + " " + strings.Join(args, " "))
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"log"
 
+	"github.com/juanvallejo/streaming-server/pkg/account"
+	"github.com/juanvallejo/streaming-server/pkg/audit"
 	"github.com/juanvallejo/streaming-server/pkg/playback"
 	"github.com/juanvallejo/streaming-server/pkg/socket/client"
 	"github.com/juanvallejo/streaming-server/pkg/socket/cmd/rbac"
@@ -15,6 +17,11 @@ type SocketCommandHandler interface {
 	// returns an Authorizer if one has been set by a
 	// command handler supporting access control.
 	Authorizer() rbac.Authorizer
+	// Accounts returns a user account manager, or nil
+	// if user accounts have not been enabled.
+	Accounts() account.Manager
+	// SetAccounts receives a user account manager
+	SetAccounts(account.Manager)
 	// AddCommand receives a SocketCommand and adds it to
 	// an internal map of commands
 	AddCommand(SocketCommand)
@@ -28,16 +35,35 @@ type SocketCommandHandler interface {
 	ExecuteCommand(string, []string, *client.Client, client.SocketClientHandler, playback.PlaybackHandler, stream.StreamHandler) (string, error)
 }
 
+var (
+	errUnknownCommand = client.NewError(client.ERR_CODE_UNKNOWN_COMMAND, "error: that command does not exist")
+	errUnauthorized   = client.NewError(client.ERR_CODE_FORBIDDEN, "error: you are not authorized to perform that command")
+)
+
+// errUnauthorizable returns the error for a command no rbac rule matches
+func errUnauthorizable(usage string) error {
+	return client.NewError(client.ERR_CODE_FORBIDDEN, "error: unable to authorize the requested command\n%s", usage)
+}
+
 // Handler implements SocketCommandHandler
 type Handler struct {
 	commands map[string]SocketCommand
 	aliases  map[string]SocketCommand
+	accounts account.Manager
 }
 
 func (h *Handler) Authorizer() rbac.Authorizer {
 	return nil
 }
 
+func (h *Handler) Accounts() account.Manager {
+	return h.accounts
+}
+
+func (h *Handler) SetAccounts(accounts account.Manager) {
+	h.accounts = accounts
+}
+
 // AddCommand panics if a given command has already been added
 // or adds the new command to a map of [commandName]command
 func (h *Handler) AddCommand(cmd SocketCommand) {
@@ -74,7 +100,7 @@ func (h *Handler) Aliases() map[string]SocketCommand {
 func (h *Handler) ExecuteCommand(cmdRoot string, args []string, client *client.Client, clientHandler client.SocketClientHandler, playbackHandler playback.PlaybackHandler, streamHandler stream.StreamHandler) (string, error) {
 	command, exists := resolveCommandAlias(cmdRoot, h.commands, h.aliases)
 	if !exists {
-		return "", fmt.Errorf("error: that command does not exist")
+		return "", errUnknownCommand
 	}
 
 	return command.Execute(h, args, client, clientHandler, playbackHandler, streamHandler)
@@ -109,23 +135,50 @@ func (c *HandlerWithRBAC) Authorizer() rbac.Authorizer {
 func (c *HandlerWithRBAC) ExecuteCommand(cmdRoot string, args []string, client *client.Client, clientHandler client.SocketClientHandler, playbackHandler playback.PlaybackHandler, streamHandler stream.StreamHandler) (string, error) {
 	command, exists := resolveCommandAlias(cmdRoot, c.Commands(), c.Aliases())
 	if !exists {
-		return "", fmt.Errorf("error: that command does not exist")
+		return "", errUnknownCommand
 	}
 
 	action := util.CommandAction(command.Name(), args)
+	// secret arguments are never logged
+	redacted := util.CommandAction(command.Name(), RedactArgs(command, args))
 
-	rule, exists := rbac.RuleByAction(c.AccessController.Bindings(), action)
-	if !exists {
-		log.Printf("ERR SOCKET CMD AUTHZ unable to find rule for action %q for client %q with id (%s)", action, client.GetUsernameOrId(), client.UUID())
-		return "", fmt.Errorf("error: unable to authorize the requested command\n%s", command.GetUsage())
+	if err := c.AccessController.ValidateAction(action); err != nil {
+		log.Printf("ERR SOCKET CMD AUTHZ unable to find rule for action %q for client %q with id (%s)", redacted, client.GetUsernameOrId(), client.UUID())
+		recordCommand(client, redacted, false, "no rule matches this action")
+		return "", errUnauthorizable(command.GetUsage())
 	}
 
-	if c.AccessController.Verify(client.Connection(), rule) {
-		return command.Execute(c, args, client, clientHandler, playbackHandler, streamHandler)
+	if c.AccessController.VerifyAction(client.Connection(), action) {
+		result, err := command.Execute(c, args, client, clientHandler, playbackHandler, streamHandler)
+		detail := ""
+		if err != nil {
+			detail = fmt.Sprintf("failed: %v", err)
+		}
+		recordCommand(client, redacted, true, detail)
+		return result, err
+	}
+
+	log.Printf("ERR SOCKET CMD AUTHZ client %q with id (%s) has attempted to perform unauthorized action: %q", client.GetUsernameOrId(), client.UUID(), redacted)
+	recordCommand(client, redacted, false, "")
+	return "", errUnauthorized
+}
+
+// recordCommand adds an entry to the client's room audit log
+// describing a command the client attempted to execute.
+func recordCommand(client *client.Client, action string, allowed bool, detail string) {
+	namespace, exists := client.Namespace()
+	if !exists {
+		return
 	}
 
-	log.Printf("ERR SOCKET CMD AUTHZ client %q with id (%s) has attempted to perform unauthorized action: %q", client.GetUsernameOrId(), client.UUID(), action)
-	return "", fmt.Errorf("error: you are not authorized to perform that command")
+	namespace.Audit().Record(audit.Entry{
+		Kind:    audit.KIND_COMMAND,
+		Actor:   client.GetUsernameOrId(),
+		ActorId: client.UUID(),
+		Action:  action,
+		Allowed: allowed,
+		Detail:  detail,
+	})
 }
 
 // NewControlledHandler returns a command handler capable
@@ -141,12 +194,20 @@ func NewHandlerWithRBAC(authorizer rbac.Authorizer) SocketCommandHandler {
 // to a SocketCommand handler
 func addSocketCommands(handler SocketCommandHandler) {
 	handler.AddCommand(NewCmdRole())
+	handler.AddCommand(NewCmdAdmin())
+	handler.AddCommand(NewCmdAudit())
+	handler.AddCommand(NewCmdBan())
 	handler.AddCommand(NewCmdClear())
 	handler.AddCommand(NewCmdDebug())
 	handler.AddCommand(NewCmdHelp())
+	handler.AddCommand(NewCmdKick())
+	handler.AddCommand(NewCmdMute())
 	handler.AddCommand(NewCmdStream())
 	handler.AddCommand(NewCmdSubtitles())
 	handler.AddCommand(NewCmdQueue())
+	handler.AddCommand(NewCmdRoom())
+	handler.AddCommand(NewCmdUnban())
+	handler.AddCommand(NewCmdUnmute())
 	handler.AddCommand(NewCmdUser())
 	handler.AddCommand(NewCmdVolume())
 	handler.AddCommand(NewCmdWhoami())
@@ -169,7 +230,13 @@ func AddDefaultRoles(authz rbac.Authorizer) {
 		"debug/refresh",
 	})
 	help := rbac.NewRule("access command help", []string{"help"})
-	streamInfo := rbac.NewRule("access stream info", []string{"stream/info"})
+	streamInfo := rbac.NewRule("access stream info", []string{
+		"stream/info",
+		"stream/loop" + rbac.EXACT_ACTION_SUFFIX,
+	})
+	streamLoop := rbac.NewRule("set the room's loop mode", []string{
+		"stream/loop/*",
+	})
 	streamControl := rbac.NewRule("play/pause/skip/reset/load the stream", []string{
 		"stream/play",
 		"stream/skip",
@@ -178,6 +245,7 @@ func AddDefaultRoles(authz rbac.Authorizer) {
 		"stream/pause",
 		"stream/stop",
 		"stream/seek",
+		"stream/schedule/*",
 	})
 	subtitles := rbac.NewRule("control stream subtitles", []string{
 		"subs",
@@ -187,9 +255,11 @@ func AddDefaultRoles(authz rbac.Authorizer) {
 	})
 	queueAdd := rbac.NewRule("add streams to the queue", []string{
 		"queue/add/*",
+		"queue/replay/*",
 	})
 	queueList := rbac.NewRule("list items in the queue", []string{
 		"queue/list/*",
+		"queue/history",
 	})
 	queueClearMine := rbac.NewRule("clear items in your queue", []string{
 		"queue/clear/mine",
@@ -208,18 +278,69 @@ func AddDefaultRoles(authz rbac.Authorizer) {
 		"queue/order/mine/*",
 		"queue/order/me",
 		"queue/order/me/*",
+		"queue/shuffle/mine",
+		"queue/shuffle/mine/*",
+		"queue/shuffle/me",
+		"queue/shuffle/me/*",
 	})
 	queueOrderRoom := rbac.NewRule("re-order items in the room's queue", []string{
 		"queue/order/room",
 		"queue/order/room/*",
 		"queue/order/all",
 		"queue/order/all/*",
+		"queue/shuffle/room",
+		"queue/shuffle/room/*",
+		"queue/shuffle/all",
+		"queue/shuffle/all/*",
 		"queue/order/next/*",
 	})
 	roleEdit := rbac.NewRule("Add, replace, or remove roles for a subject", []string{
 		"role/set/*",
 		"role/add/*",
 		"role/remove/*",
+		"role/list",
+		"role/create/*",
+		"role/delete/*",
+		"role/grant/*",
+		"role/deny/*",
+		"role/revoke/*",
+	})
+	roleExplain := rbac.NewRule("explain why an action is allowed or denied", []string{
+		"role/explain/*",
+	})
+	roomInfo := rbac.NewRule("view the room's settings", []string{
+		"room/limits" + rbac.EXACT_ACTION_SUFFIX,
+	})
+	roomEdit := rbac.NewRule("change the room's settings", []string{
+		"room/limits/*",
+		"room/lock/*",
+		"room/unlock",
+		"room/invite",
+		"room/invite/*",
+	})
+	adminInfo := rbac.NewRule("view the room's admins and how they are elected", []string{
+		"admin/list",
+	})
+	adminVote := rbac.NewRule("vote for the room's next admin", []string{
+		"admin/vote/*",
+	})
+	adminManage := rbac.NewRule("hand over or give up admin, and choose how admins are elected", []string{
+		"admin/transfer/*",
+		"admin/step-down",
+		"admin/policy",
+	})
+	auditLog := rbac.NewRule("view and configure the room's audit log", []string{
+		"audit",
+		"audit/*",
+	})
+	moderate := rbac.NewRule("kick, ban and mute users", []string{
+		"kick/*",
+		"ban",
+		"ban/*",
+		"unban/*",
+		"mute",
+		"mute/*",
+		"unmute/*",
 	})
 	userUpdateName := rbac.NewRule("update a client's username", []string{
 		"user/name/*",
@@ -240,14 +361,18 @@ func AddDefaultRoles(authz rbac.Authorizer) {
 
 	// default roles
 	viewerRole := rbac.NewRole(rbac.VIEWER_ROLE, []rbac.Rule{
+		adminInfo,
 		help,
 		streamInfo,
 		queueList,
+		roleExplain,
+		roomInfo,
 		userList,
 		volume,
 		whoami,
 	})
 	userRole := rbac.NewRole(rbac.USER_ROLE, append([]rbac.Rule{
+		adminVote,
 		clearChat,
 		queueAdd,
 		queueClearMine,
@@ -255,13 +380,18 @@ func AddDefaultRoles(authz rbac.Authorizer) {
 		userUpdateName,
 	}, viewerRole.Rules()...))
 	adminRole := rbac.NewRole(rbac.ADMIN_ROLE, append([]rbac.Rule{
+		adminManage,
+		auditLog,
 		debugReload,
+		moderate,
 		subtitles,
 		queueClearRoom,
 		queueMigrate,
 		queueOrderRoom,
 		roleEdit,
+		roomEdit,
 		streamControl,
+		streamLoop,
 	}, userRole.Rules()...))
 
 	roles := []rbac.Role{
@@ -273,4 +403,9 @@ func AddDefaultRoles(authz rbac.Authorizer) {
 	for _, role := range roles {
 		authz.AddRole(role)
 	}
+
+	// every default rule's actions may be granted to custom roles
+	for _, rule := range adminRole.Rules() {
+		authz.RegisterActions(rule.Actions()...)
+	}
 }
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/juanvallejo/streaming-server/pkg/socket/cmd/rbac"
+)
+
+type fakeSubject string
+
+func (s fakeSubject) UUID() string {
+	return string(s)
+}
+
+func TestDefaultRoles(t *testing.T) {
+	authorizer := rbac.NewAuthorizer()
+	AddDefaultRoles(authorizer)
+
+	subjects := map[string]rbac.Subject{}
+	for _, roleName := range []string{rbac.VIEWER_ROLE, rbac.USER_ROLE, rbac.ADMIN_ROLE} {
+		role, exists := authorizer.Role(roleName)
+		if !exists {
+			t.Fatalf("expected default role %q to exist", roleName)
+		}
+		subjects[roleName] = fakeSubject(roleName)
+		authorizer.Bind(role, subjects[roleName])
+	}
+
+	tests := []struct {
+		action  string
+		allowed []string
+	}{
+		{action: "stream/info", allowed: []string{rbac.VIEWER_ROLE, rbac.USER_ROLE, rbac.ADMIN_ROLE}},
+		{action: "stream/loop", allowed: []string{rbac.VIEWER_ROLE, rbac.USER_ROLE, rbac.ADMIN_ROLE}},
+		{action: "stream/loop/one", allowed: []string{rbac.ADMIN_ROLE}},
+		{action: "stream/loop/queue", allowed: []string{rbac.ADMIN_ROLE}},
+		{action: "stream/loop/off", allowed: []string{rbac.ADMIN_ROLE}},
+		{action: "room/limits", allowed: []string{rbac.VIEWER_ROLE, rbac.USER_ROLE, rbac.ADMIN_ROLE}},
+		{action: "room/limits/user/maxItems/100", allowed: []string{rbac.ADMIN_ROLE}},
+		{action: "room/limits/viewer", allowed: []string{rbac.ADMIN_ROLE}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.action, func(t *testing.T) {
+			allowed := map[string]bool{}
+			for _, roleName := range tt.allowed {
+				allowed[roleName] = true
+			}
+
+			for roleName, subject := range subjects {
+				decision := authorizer.Explain(subject, tt.action)
+				if decision.Allowed != allowed[roleName] {
+					t.Fatalf("expected action %q to be allowed for role %q: %v, got: %s", tt.action, roleName, allowed[roleName], decision.Reason())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/juanvallejo/streaming-server/pkg/playback"
+	"github.com/juanvallejo/streaming-server/pkg/socket/client"
+	"github.com/juanvallejo/streaming-server/pkg/socket/connection"
+	"github.com/juanvallejo/streaming-server/pkg/stream"
+)
+
+type KickCmd struct {
+	Command
+}
+
+const (
+	KICK_NAME        = "kick"
+	KICK_DESCRIPTION = "disconnects a user from the room"
+	KICK_USAGE       = "Usage: /" + KICK_NAME + " &lt;user&gt; [reason]"
+)
+
+func (h *KickCmd) Execute(cmdHandler SocketCommandHandler, args []string, user *client.Client, clientHandler client.SocketClientHandler, playbackHandler playback.PlaybackHandler, streamHandler stream.StreamHandler) (string, error) {
+	if len(args) == 0 {
+		return "", fmt.Errorf("%v", h.usage)
+	}
+
+	target, namespace, err := moderationTarget(cmdHandler, clientHandler, user, args[0])
+	if err != nil {
+		return "", err
+	}
+
+	_, reason := parseSanctionArgs(args[1:])
+	kick := newSanction(connection.SANCTION_KICK, target, user, 0, reason)
+	namespace.Moderation().Record(kick)
+
+	log.Printf("INF SOCKET CMD KICK client %q with id (%s) kicked client %q with id (%s): %q\n", user.GetUsernameOrId(), user.UUID(), kick.Subject, target.UUID(), reason)
+
+	target.BroadcastSystemMessageTo(fmt.Sprintf("you have been kicked from this room by %q", kick.By))
+	disconnectClient(target, "kicked")
+
+	user.BroadcastSystemMessageFrom(describeSanction(kick))
+	return fmt.Sprintf("kicked %q", kick.Subject), nil
+}
+
+func NewCmdKick() SocketCommand {
+	return &KickCmd{
+		Command{
+			name:        KICK_NAME,
+			description: KICK_DESCRIPTION,
+			usage:       KICK_USAGE,
+		},
+	}
+}
@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/juanvallejo/streaming-server/pkg/socket/client"
+	"github.com/juanvallejo/streaming-server/pkg/socket/cmd/rbac"
+	"github.com/juanvallejo/streaming-server/pkg/socket/connection"
+)
+
+// clientInNamespace receives a username or connection id and returns
+// the client in the given namespace it belongs to, if any.
+func clientInNamespace(clientHandler client.SocketClientHandler, namespace connection.Namespace, name string) (*client.Client, bool) {
+	for _, c := range namespace.Connections() {
+		cl, err := clientHandler.GetClient(c.UUID())
+		if err != nil {
+			continue
+		}
+		if uName, hasName := cl.GetUsername(); (hasName && uName == name) || cl.UUID() == name {
+			return cl, true
+		}
+	}
+	return nil, false
+}
+
+// moderationTarget resolves the target of a moderation command to a
+// client in the user's room. Users may not moderate themselves or admins.
+func moderationTarget(cmdHandler SocketCommandHandler, clientHandler client.SocketClientHandler, user *client.Client, name string) (*client.Client, connection.Namespace, error) {
+	namespace, exists := user.Namespace()
+	if !exists {
+		return nil, nil, fmt.Errorf("error: you must be in a room to moderate it")
+	}
+
+	target, exists := clientInNamespace(clientHandler, namespace, name)
+	if !exists {
+		return nil, nil, fmt.Errorf("error: unable to find user %q in your room", name)
+	}
+	if target.UUID() == user.UUID() {
+		return nil, nil, fmt.Errorf("error: you cannot do that to yourself")
+	}
+	if isAdminConnection(cmdHandler, target.Connection()) {
+		return nil, nil, fmt.Errorf("error: %q is an admin and cannot be moderated", name)
+	}
+
+	return target, namespace, nil
+}
+
+// isAdminConnection returns a boolean (true) if the given
+// connection is bound to the admin role
+func isAdminConnection(cmdHandler SocketCommandHandler, conn connection.Connection) bool {
+	authorizer := cmdHandler.Authorizer()
+	if authorizer == nil {
+		return false
+	}
+
+	for _, role := range rbac.SubjectRoleNames(authorizer.Bindings(), conn) {
+		if role == rbac.ADMIN_ROLE {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSanctionArgs receives the arguments following a moderation
+// command's target, and returns an optional leading duration and
+// the remaining arguments as a reason.
+func parseSanctionArgs(args []string) (time.Duration, string) {
+	if len(args) == 0 {
+		return 0, ""
+	}
+	if d, err := time.ParseDuration(args[0]); err == nil && d > 0 {
+		return d, strings.Join(args[1:], " ")
+	}
+	return 0, strings.Join(args, " ")
+}
+
+// newSanction returns a Sanction against the given target,
+// expiring after the given duration, or never if it is 0.
+func newSanction(kind string, target, user *client.Client, duration time.Duration, reason string) *connection.Sanction {
+	now := time.Now()
+	s := &connection.Sanction{
+		Kind:         kind,
+		Subject:      target.GetUsernameOrId(),
+		ConnectionId: target.UUID(),
+		Identity:     target.Connection().Metadata().Identity(),
+		Reason:       reason,
+		By:           user.GetUsernameOrId(),
+		Created:      now,
+	}
+	if duration > 0 {
+		s.Expires = now.Add(duration)
+	}
+	return s
+}
+
+// describeSanction returns a human-readable summary of a sanction
+func describeSanction(s *connection.Sanction) string {
+	desc := fmt.Sprintf("%s %q by %q", s.Kind, s.Subject, s.By)
+	if !s.Expires.IsZero() {
+		desc += fmt.Sprintf(" until %s", s.Expires.Format(time.RFC1123))
+	}
+	if len(s.Reason) > 0 {
+		desc += fmt.Sprintf(": %s", s.Reason)
+	}
+	return desc
+}
+
+func listSanctions(title string, sanctions []*connection.Sanction) string {
+	if len(sanctions) == 0 {
+		return fmt.Sprintf("There are no %s in this room.", title)
+	}
+
+	output := fmt.Sprintf("%s in this room:", strings.Title(title))
+	for _, s := range sanctions {
+		output += fmt.Sprintf("<br />%s", describeSanction(s))
+	}
+	return output
+}
+
+// disconnectClient closes a client's connection, logging any error.
+// The client's session is discarded, so that it cannot be resumed.
+func disconnectClient(c *client.Client, reason string) {
+	if ns, exists := c.Namespace(); exists {
+		ns.Sessions().Forget(c.UUID())
+	}
+	if err := c.Connection().Disconnect(reason); err != nil {
+		log.Printf("ERR SOCKET CMD unable to disconnect client with id (%s): %v", c.UUID(), err)
+	}
+}
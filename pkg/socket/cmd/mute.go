@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/juanvallejo/streaming-server/pkg/playback"
+	"github.com/juanvallejo/streaming-server/pkg/socket/client"
+	"github.com/juanvallejo/streaming-server/pkg/socket/connection"
+	"github.com/juanvallejo/streaming-server/pkg/stream"
+)
+
+type MuteCmd struct {
+	Command
+}
+
+const (
+	MUTE_NAME        = "mute"
+	MUTE_DESCRIPTION = "prevents a user from chatting, without removing them from the room"
+	MUTE_USAGE       = "Usage: /" + MUTE_NAME + " [&lt;user&gt; [duration] [reason]]"
+)
+
+func (h *MuteCmd) Execute(cmdHandler SocketCommandHandler, args []string, user *client.Client, clientHandler client.SocketClientHandler, playbackHandler playback.PlaybackHandler, streamHandler stream.StreamHandler) (string, error) {
+	if len(args) == 0 {
+		namespace, exists := user.Namespace()
+		if !exists {
+			return "", fmt.Errorf("error: you must be in a room to view its mutes")
+		}
+		return listSanctions("mutes", namespace.Moderation().Mutes()), nil
+	}
+
+	target, namespace, err := moderationTarget(cmdHandler, clientHandler, user, args[0])
+	if err != nil {
+		return "", err
+	}
+
+	duration, reason := parseSanctionArgs(args[1:])
+	mute := newSanction(connection.SANCTION_MUTE, target, user, duration, reason)
+	namespace.Moderation().Mute(mute)
+
+	log.Printf("INF SOCKET CMD MUTE client %q with id (%s) muted client %q with id (%s) for %v: %q\n", user.GetUsernameOrId(), user.UUID(), mute.Subject, target.UUID(), duration, reason)
+
+	target.BroadcastSystemMessageTo(fmt.Sprintf("you have been muted in this room by %q", mute.By))
+	user.BroadcastSystemMessageFrom(describeSanction(mute))
+	return fmt.Sprintf("muted %q", mute.Subject), nil
+}
+
+func NewCmdMute() SocketCommand {
+	return &MuteCmd{
+		Command{
+			name:        MUTE_NAME,
+			description: MUTE_DESCRIPTION,
+			usage:       MUTE_USAGE,
+		},
+	}
+}
+
+type UnmuteCmd struct {
+	Command
+}
+
+const (
+	UNMUTE_NAME        = "unmute"
+	UNMUTE_DESCRIPTION = "allows a muted user to chat again"
+	UNMUTE_USAGE       = "Usage: /" + UNMUTE_NAME + " &lt;user&gt;"
+)
+
+func (h *UnmuteCmd) Execute(cmdHandler SocketCommandHandler, args []string, user *client.Client, clientHandler client.SocketClientHandler, playbackHandler playback.PlaybackHandler, streamHandler stream.StreamHandler) (string, error) {
+	if len(args) == 0 {
+		return "", fmt.Errorf("%v", h.usage)
+	}
+
+	namespace, exists := user.Namespace()
+	if !exists {
+		return "", fmt.Errorf("error: you must be in a room to moderate it")
+	}
+
+	// the user may have changed their name since being muted
+	lifted := namespace.Moderation().Unmute(args[0], user.GetUsernameOrId())
+	target, targetExists := clientInNamespace(clientHandler, namespace, args[0])
+	if targetExists {
+		lifted = append(lifted, namespace.Moderation().Unmute(target.UUID(), user.GetUsernameOrId())...)
+	}
+	if len(lifted) == 0 {
+		return "", fmt.Errorf("error: no mutes found for %q", args[0])
+	}
+
+	log.Printf("INF SOCKET CMD UNMUTE client %q with id (%s) lifted %v mute(s) against %q\n", user.GetUsernameOrId(), user.UUID(), len(lifted), args[0])
+
+	if targetExists {
+		target.BroadcastSystemMessageTo("you are no longer muted in this room")
+	}
+	user.BroadcastSystemMessageFrom(fmt.Sprintf("%q has unmuted %q", user.GetUsernameOrId(), args[0]))
+	return fmt.Sprintf("unmuted %q", args[0]), nil
+}
+
+func NewCmdUnmute() SocketCommand {
+	return &UnmuteCmd{
+		Command{
+			name:        UNMUTE_NAME,
+			description: UNMUTE_DESCRIPTION,
+			usage:       UNMUTE_USAGE,
+		},
+	}
+}
@@ -1,16 +1,18 @@
 package cmd
 
 import (
-	"encoding/json"
 	"fmt"
+	"html"
 	"log"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/juanvallejo/streaming-server/pkg/playback"
 	"github.com/juanvallejo/streaming-server/pkg/playback/queue"
 	playbackutil "github.com/juanvallejo/streaming-server/pkg/playback/util"
 	"github.com/juanvallejo/streaming-server/pkg/socket/client"
+	"github.com/juanvallejo/streaming-server/pkg/socket/protocol"
 	sockutil "github.com/juanvallejo/streaming-server/pkg/socket/util"
 	"github.com/juanvallejo/streaming-server/pkg/stream"
 )
@@ -22,7 +24,7 @@ type QueueCmd struct {
 const (
 	QUEUE_NAME        = "queue"
 	QUEUE_DESCRIPTION = "control the room queue"
-	QUEUE_USAGE       = "Usage: /" + QUEUE_NAME + " (migrate &lt;newQueueKey&gt;|add &lt;url&gt;|clear &lt;room|mine [url]&gt;|list &lt;mine|room&gt;|order &lt;next &lt;url&gt;|mine &lt;url newposition|0,1,2...&gt;|room &lt; url newposition|0,1,2...&gt;&gt;)"
+	QUEUE_USAGE       = "Usage: /" + QUEUE_NAME + " (migrate &lt;newQueueKey&gt;|add &lt;url&gt; [start-end]|clear &lt;room|mine [url]&gt;|list &lt;mine|room&gt;|order &lt;next &lt;url&gt;|mine &lt;url newposition|0,1,2...&gt;|room &lt; url newposition|0,1,2...&gt;&gt;|shuffle &lt;mine|room&gt; [seed]|history|replay &lt;n&gt;)"
 )
 
 var mux sync.Mutex
@@ -57,6 +59,11 @@ func (h *QueueCmd) Execute(cmdHandler SocketCommandHandler, args []string, user
 			return "", err
 		}
 
+		streamRange, err := getStreamRangeFromArgs(url, args)
+		if err != nil {
+			return "", err
+		}
+
 		userQueue, exists, err := playbackutil.GetUserQueue(user, sPlayback.GetQueue())
 		if err != nil {
 			return "", err
@@ -69,9 +76,16 @@ func (h *QueueCmd) Execute(cmdHandler SocketCommandHandler, args []string, user
 			}
 		}
 
+		// apply the room's limits for the user's role(s) to their queue
+		limits := sPlayback.Limits().For(subjectRoleNames(cmdHandler, user))
+		userQueue.SetLimits(limits.QueueLimits)
+
 		// do not create and push stream if user queue is at its storage limit
-		if userQueue.Size() >= queue.MaxAggregatableQueueItems {
-			return "", queue.ErrMaxQueueSizeExceeded
+		if err := limits.Check(userQueue.List(), nil); err != nil {
+			return "", fmt.Errorf("error: %v", err)
+		}
+		if err := sPlayback.Limits().CheckAdd(user.UUID(), limits); err != nil {
+			return "", fmt.Errorf("error: %v", err)
 		}
 
 		sendStreamSync := false
@@ -79,7 +93,11 @@ func (h *QueueCmd) Execute(cmdHandler SocketCommandHandler, args []string, user
 			sendStreamSync = true
 		}
 
-		s, err := sPlayback.GetOrCreateStreamFromUrl(url, user, streamHandler, func(user *client.Client, pback *playback.Playback, shouldSync bool) func([]byte, bool, error) {
+		// set once the stream has been pushed to the user's queue. Fetched
+		// metadata is handled by the room's event loop after this command.
+		var queued stream.Stream
+
+		s, err := sPlayback.GetOrCreateStreamFromUrl(url, streamRange, user, streamHandler, func(user *client.Client, pback *playback.Playback, shouldSync bool) func([]byte, bool, error) {
 			return func(data []byte, created bool, err error) {
 				// if a new stream was created, sync fetched metadata with client
 				if !created {
@@ -91,6 +109,28 @@ func (h *QueueCmd) Execute(cmdHandler SocketCommandHandler, args []string, user
 				if ok && len(s.GetName()) > 0 {
 					streamIdentifier = s.GetName()
 				}
+
+				// the stream's duration was unknown when it was pushed;
+				// drop it if it takes the user's queue over its limit
+				if queued != nil && queueContains(userQueue, queued) {
+					if limitErr := userQueue.Limits().CheckDuration(userQueue.List()); limitErr != nil {
+						if err := pback.ClearQueueItem(userQueue, queued); err != nil {
+							log.Printf("ERR SOCKET CLIENT PLAYBACK-FETCHMETADATA-CALLBACK unable to remove stream exceeding queue limits: %v", err)
+							return
+						}
+
+						user.BroadcastSystemMessageTo(fmt.Sprintf("error: %q was removed from your queue: %v", streamIdentifier, limitErr))
+						if err := sendQueueSyncEvent(user, pback); err != nil {
+							log.Printf("ERR SOCKET CLIENT PLAYBACK-FETCHMETADATA-CALLBACK unable to send queue-sync event to client")
+							return
+						}
+						if err := sendUserQueueSyncEvent(user, pback); err != nil {
+							log.Printf("ERR SOCKET CLIENT PLAYBACK-FETCHMETADATA-CALLBACK unable to send user-queue-sync event to client")
+						}
+						return
+					}
+				}
+
 				user.BroadcastSystemMessageFrom(fmt.Sprintf("%q has added %q to the queue", username, streamIdentifier))
 				user.BroadcastSystemMessageTo(fmt.Sprintf("successfully queued %q", streamIdentifier))
 
@@ -122,7 +162,7 @@ func (h *QueueCmd) Execute(cmdHandler SocketCommandHandler, args []string, user
 					return
 				}
 
-				user.BroadcastAll("streamsync", res)
+				user.BroadcastAll(protocol.EVENT_STREAMSYNC, res)
 			}
 		}(user, sPlayback, sendStreamSync))
 		if err != nil {
@@ -132,8 +172,10 @@ func (h *QueueCmd) Execute(cmdHandler SocketCommandHandler, args []string, user
 
 		err = sPlayback.PushToQueue(userQueue, s)
 		if err != nil {
-			return "", err
+			return "", fmt.Errorf("error: %v", err)
 		}
+		sPlayback.Limits().RecordAdd(user.UUID())
+		queued = s
 
 		err = sendQueueSyncEvent(user, sPlayback)
 		if err != nil {
@@ -151,20 +193,10 @@ func (h *QueueCmd) Execute(cmdHandler SocketCommandHandler, args []string, user
 			streamQueueMsg = fmt.Sprintf("successfully queued %q", s.GetName())
 		}
 
-		// TODO: turn this code-block into a helper (currently used here, socket/handler.go, and cmd/stream.go)
 		// if room playback state is PLAYBACK_STATE_ENDED, auto-play the next queued item (if found)
 		if sPlayback.State() == playback.PLAYBACK_STATE_ENDED || sPlayback.State() == playback.PLAYBACK_STATE_NOT_STARTED {
-			roomQueue := sPlayback.GetQueue()
-			nextQueueItem, err := roomQueue.Next()
+			_, err := sPlayback.Advance(username)
 			if err == nil {
-				nextStream, ok := nextQueueItem.(stream.Stream)
-				if !ok {
-					return fmt.Sprintf("%s - The stream will not auto-play because it does appear to be a stream.Stream (programmer error)", streamQueueMsg), nil
-				}
-
-				sPlayback.SetStream(nextStream)
-				sPlayback.Reset()
-
 				res := &client.Response{
 					Id:   user.UUID(),
 					From: username,
@@ -175,7 +207,7 @@ func (h *QueueCmd) Execute(cmdHandler SocketCommandHandler, args []string, user
 					return fmt.Sprintf("%s - The stream will not auto-play due to a serialization error: %v", streamQueueMsg, err), nil
 				}
 
-				user.BroadcastAll("streamload", res)
+				user.BroadcastAll(protocol.EVENT_STREAMLOAD, res)
 
 				// play the newly loaded stream
 				err := sPlayback.Play()
@@ -183,7 +215,7 @@ func (h *QueueCmd) Execute(cmdHandler SocketCommandHandler, args []string, user
 					return fmt.Sprintf("%s - The stream will not auto-play due to an error: %v", streamQueueMsg, err), nil
 				}
 
-				user.BroadcastAll("streamsync", res)
+				user.BroadcastAll(protocol.EVENT_STREAMSYNC, res)
 				return fmt.Sprintf("%s (auto-playing...)", streamQueueMsg), nil
 			}
 		}
@@ -203,39 +235,12 @@ func (h *QueueCmd) Execute(cmdHandler SocketCommandHandler, args []string, user
 				userQueue = queue.NewAggregatableQueue(user.UUID())
 			}
 
-			status, err := userQueue.Serialize()
-			if err != nil {
-				return "", err
-			}
-
-			m := make(map[string]interface{})
-			err = json.Unmarshal(status, &m)
-			if err != nil {
-				return "", err
-			}
-
-			mUser, exists := m["items"]
-			if !exists {
-				return "", fmt.Errorf("malformed serialized queue-stack response")
-			}
-
-			output := "Your queue:<br />" + unpackList([]interface{}{mUser}, "<br />")
+			output := "Your queue:<br />" + formatQueueItems(userQueue.List())
 			return output, nil
 		}
 
 		if args[1] == "room" || args[1] == "all" {
-			status, err := sPlayback.GetQueue().Serialize()
-			if err != nil {
-				return "", err
-			}
-
-			m := make(map[string]interface{})
-			err = json.Unmarshal(status, &m)
-			if err != nil {
-				return "", err
-			}
-
-			output := "Queue status:<br />" + unpackMap(m, "<br />")
+			output := "Queue status:<br />" + formatQueueItems(sPlayback.GetQueue().Upcoming())
 			return output, nil
 		}
 	case "clear":
@@ -250,21 +255,12 @@ func (h *QueueCmd) Execute(cmdHandler SocketCommandHandler, args []string, user
 			// if 3 agrs, treat last arg as url of stream to delete
 			// from the current round-robin lineup
 			if len(args) > 2 {
-				var itemToDelete queue.QueueItem
-				found := false
-				userQueueIdx := -1
-
-				for idx, qItem := range sPlayback.GetQueue().PeekItems() {
-					if qItem.UUID() == args[2] {
-						itemToDelete = qItem
-						found = true
-						userQueueIdx = idx
-						break
-					}
-				}
+				upcoming := sPlayback.GetQueue().PeekItems()
+				userQueueIdx, found, _ := queueItemIndex(args[2], upcoming)
 				if !found {
 					return "", fmt.Errorf("unable to find item with id %v in list of upcoming streams", args[2])
 				}
+				itemToDelete := upcoming[userQueueIdx]
 
 				queues := sPlayback.GetQueue().List()
 				userQueueItem := queues[userQueueIdx]
@@ -308,17 +304,18 @@ func (h *QueueCmd) Execute(cmdHandler SocketCommandHandler, args []string, user
 
 			// if 3 args, treat last arg as url of stream to delete
 			if len(args) > 2 {
-				s, exists := streamHandler.GetStream(args[2])
+				items := userQueue.List()
+				idx, exists, _ := queueItemIndex(args[2], items)
 				if !exists {
 					return "", fmt.Errorf("The provided stream with id %q does not exist in your queue", args[2])
 				}
 
-				err := sPlayback.ClearQueueItem(userQueue, s)
+				err := sPlayback.ClearQueueItem(userQueue, items[idx])
 				if err != nil {
 					return "", err
 				}
 
-				msg = fmt.Sprintf("deleting stream with url %q", s.GetStreamURL())
+				msg = fmt.Sprintf("deleting stream with id %q", items[idx].UUID())
 			} else {
 				sPlayback.ClearUserQueue(userQueue)
 			}
@@ -468,6 +465,114 @@ func (h *QueueCmd) Execute(cmdHandler SocketCommandHandler, args []string, user
 		}
 
 		return h.usage, nil
+	case "shuffle":
+		if len(args) < 2 {
+			return "", fmt.Errorf("%v", h.usage)
+		}
+
+		// a seed may be given in order to reproduce a previous shuffle
+		seed := time.Now().UnixNano()
+		if len(args) > 2 {
+			parsed, err := strconv.ParseInt(args[2], 10, 64)
+			if err != nil {
+				return "", fmt.Errorf("error: unable to convert shuffle seed: %v", err)
+			}
+			seed = parsed
+		}
+
+		// shuffling shares the same lock as "order" operations
+		mux.Lock()
+		defer mux.Unlock()
+
+		if args[1] == "room" || args[1] == "all" {
+			if sPlayback.GetQueue().Size() < 2 {
+				return "", fmt.Errorf("error: there must be at least two queues in the room in order to shuffle")
+			}
+
+			err := sPlayback.GetQueue().Shuffle(seed)
+			if err != nil {
+				return "", fmt.Errorf("error: unable to shuffle queue: %v", err)
+			}
+
+			err = sendQueueSyncEvent(user, sPlayback)
+			if err != nil {
+				return "", err
+			}
+
+			user.BroadcastSystemMessageFrom(fmt.Sprintf("%q has shuffled the room's queue (seed %v)", username, seed))
+			return fmt.Sprintf("shuffling the room's queue using seed %v...", seed), nil
+		}
+
+		if args[1] == "mine" || args[1] == "me" {
+			userQueue, exists, err := playbackutil.GetUserQueue(user, sPlayback.GetQueue())
+			if err != nil {
+				return "", fmt.Errorf("error: %v", err)
+			}
+			if !exists || userQueue.Size() < 2 {
+				return "", fmt.Errorf("error: there must be at least two items in your queue in order to shuffle")
+			}
+
+			err = userQueue.Shuffle(seed)
+			if err != nil {
+				return "", fmt.Errorf("error: unable to shuffle your queue: %v", err)
+			}
+
+			err = sendUserQueueSyncEvent(user, sPlayback)
+			if err != nil {
+				return "", err
+			}
+
+			err = sendQueueSyncEvent(user, sPlayback)
+			if err != nil {
+				return "", err
+			}
+
+			return fmt.Sprintf("shuffling your queue using seed %v...", seed), nil
+		}
+
+		return h.usage, nil
+	case "history":
+		entries := sPlayback.History().List()
+		if len(entries) == 0 {
+			return "nothing has been played in this room yet.", nil
+		}
+
+		output := "Recently played:<br />"
+		for idx, entry := range entries {
+			name := entry.Name
+			if len(name) == 0 {
+				name = entry.Url
+			}
+
+			status := ""
+			if entry.Skipped {
+				status = " [skipped]"
+			}
+
+			output += fmt.Sprintf("<br /><span class='text-hl-name'>%v</span>: %s - queued by %s, started by %s at %s%s", idx+1, name, entry.QueuedBy, entry.StartedBy, entry.StartedAt.Format("Jan 2 15:04:05"), status)
+		}
+		return output, nil
+	case "replay":
+		if len(args) < 2 {
+			return "", fmt.Errorf("%v", h.usage)
+		}
+
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			return "", fmt.Errorf("error: history entry must be a number: %v", err)
+		}
+
+		entry, exists := sPlayback.History().Entry(n)
+		if !exists {
+			return "", fmt.Errorf("error: no history entry found at position %v. See /%s history", n, QUEUE_NAME)
+		}
+
+		// re-queue the entry's stream as if it had been added by the user
+		addArgs := []string{"add", entry.Url}
+		if entry.Range != nil {
+			addArgs = append(addArgs, entry.Range.String())
+		}
+		return h.Execute(cmdHandler, addArgs, user, clientHandler, playbackHandler, streamHandler)
 	case "migrate":
 		if len(args) < 2 {
 			return h.usage, nil
@@ -567,6 +672,16 @@ func calculateQueueOrder(sourceIdx, destIdx, length int) ([]int, error) {
 	return append(newOrder, sourceIdx), nil
 }
 
+// queueContains returns a boolean (true) if a queue holds the given item
+func queueContains(q queue.Queue, item queue.QueueItem) bool {
+	for _, i := range q.List() {
+		if i.UUID() == item.UUID() {
+			return true
+		}
+	}
+	return false
+}
+
 func sendQueueSyncEvent(user *client.Client, sPlayback *playback.Playback) error {
 	username, hasUsername := user.GetUsername()
 	if !hasUsername {
@@ -583,7 +698,7 @@ func sendQueueSyncEvent(user *client.Client, sPlayback *playback.Playback) error
 		return err
 	}
 
-	user.BroadcastAll("queuesync", res)
+	user.BroadcastAll(protocol.EVENT_QUEUESYNC, res)
 	return nil
 }
 
@@ -613,7 +728,7 @@ func sendUserQueueSyncEvent(user *client.Client, sPlayback *playback.Playback) e
 		return err
 	}
 
-	user.BroadcastTo("stacksync", res)
+	user.BroadcastTo(protocol.EVENT_STACKSYNC, res)
 	return nil
 }
 
@@ -629,5 +744,32 @@ func queueItemIndex(id string, items []queue.QueueItem) (int, bool, error) {
 		}
 	}
 
+	// fall back to the canonical form of a stream url
+	// if an exact match for the given id is not found
+	canonicalId := stream.CanonicalId(id)
+	for idx, qItem := range items {
+		if qItem.UUID() == canonicalId {
+			return idx, true, nil
+		}
+	}
+
 	return -1, false, nil
 }
+
+// formatQueueItems formats a numbered list of queued streams,
+// escaping their names, which are set by clients
+func formatQueueItems(items []queue.QueueItem) string {
+	if len(items) == 0 {
+		return "<br />(empty)"
+	}
+
+	output := ""
+	for idx, item := range items {
+		name := item.UUID()
+		if s, ok := item.(stream.Stream); ok && len(s.GetName()) > 0 {
+			name = fmt.Sprintf("%s (%s)", s.GetName(), item.UUID())
+		}
+		output += infoField(strconv.Itoa(idx+1), html.EscapeString(name))
+	}
+	return output
+}
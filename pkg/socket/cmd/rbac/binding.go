@@ -1,5 +1,7 @@
 package rbac
 
+import "sync"
+
 // RoleBinding links an rbac Role to a set of Subjects
 type RoleBinding interface {
 	// AddSubject appends a new Subject to a list of Subjects bound
@@ -18,13 +20,18 @@ type RoleBinding interface {
 	Subjects() []Subject
 }
 
-// RoleBindingSpec implements RoleBinding
+// RoleBindingSpec implements RoleBinding. Its subjects are guarded by mux.
 type RoleBindingSpec struct {
-	roleRef  Role
+	roleRef Role
+
+	mux      sync.RWMutex
 	subjects []Subject
 }
 
 func (b *RoleBindingSpec) AddSubject(s Subject) bool {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
 	for _, sub := range b.subjects {
 		if sub.UUID() == s.UUID() {
 			return false
@@ -36,6 +43,9 @@ func (b *RoleBindingSpec) AddSubject(s Subject) bool {
 }
 
 func (b *RoleBindingSpec) RemoveSubject(s Subject) bool {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
 	idxToRemove := -1
 
 	for idx, subject := range b.subjects {
@@ -57,8 +67,12 @@ func (b *RoleBindingSpec) Role() Role {
 	return b.roleRef
 }
 
+// Subjects returns a snapshot of the subjects bound to the role
 func (b *RoleBindingSpec) Subjects() []Subject {
-	return b.subjects
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+
+	return append([]Subject{}, b.subjects...)
 }
 
 // NewRoleBinding receives a Role and a slice of Subjects to
@@ -66,6 +80,6 @@ func (b *RoleBindingSpec) Subjects() []Subject {
 func NewRoleBinding(role Role, subjects []Subject) RoleBinding {
 	return &RoleBindingSpec{
 		roleRef:  role,
-		subjects: subjects,
+		subjects: append([]Subject{}, subjects...),
 	}
 }
@@ -0,0 +1,166 @@
+package rbac
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// RuleDefinition is a serializable representation of a Rule
+type RuleDefinition struct {
+	Name    string   `json:"name" yaml:"name"`
+	Actions []string `json:"actions" yaml:"actions"`
+	// Effect is either "allow" or "deny". Defaults to "allow".
+	Effect RuleEffect `json:"effect,omitempty" yaml:"effect,omitempty"`
+}
+
+// RoleDefinition is a serializable representation of a Role
+type RoleDefinition struct {
+	Name string `json:"name" yaml:"name"`
+	// Namespace is the name of the room that defined the role.
+	// Roles with no namespace are shared by every room.
+	Namespace string           `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	Rules     []RuleDefinition `json:"rules" yaml:"rules"`
+}
+
+// RoleDefinitionList is a serializable list of role definitions, used
+// both as the format of a role definition file and as an api response.
+//
+// Example role definition file, in JSON:
+//
+//	{
+//	  "roles": [
+//	    {
+//	      "name": "dj",
+//	      "rules": [
+//	        {"name": "skip streams", "actions": ["stream/skip"]},
+//	        {"name": "no seeking", "actions": ["stream/seek"], "effect": "deny"}
+//	      ]
+//	    }
+//	  ]
+//	}
+//
+// or in YAML:
+//
+//	roles:
+//	- name: dj
+//	  rules:
+//	  - name: skip streams
+//	    actions: [stream/skip]
+//	  - name: no seeking
+//	    actions: [stream/seek]
+//	    effect: deny
+type RoleDefinitionList struct {
+	Kind  string           `json:"kind,omitempty" yaml:"kind,omitempty"`
+	Roles []RoleDefinition `json:"roles" yaml:"roles"`
+}
+
+func (l *RoleDefinitionList) Serialize() ([]byte, error) {
+	b, err := json.Marshal(l)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	return b, nil
+}
+
+// DefinitionForRole returns a serializable representation of a Role
+func DefinitionForRole(role Role) RoleDefinition {
+	def := RoleDefinition{
+		Name:      role.Name(),
+		Namespace: role.Namespace(),
+		Rules:     []RuleDefinition{},
+	}
+	for _, rule := range role.Rules() {
+		def.Rules = append(def.Rules, RuleDefinition{
+			Name:    rule.Name(),
+			Actions: rule.Actions(),
+			Effect:  rule.Effect(),
+		})
+	}
+	return def
+}
+
+// LoadRoleDefinitions reads a role definition file. Files ending
+// in ".yaml" or ".yml" are parsed as YAML; any other file as JSON.
+// Returns an error if the file cannot be read or parsed.
+func LoadRoleDefinitions(path string) ([]RoleDefinition, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &RoleDefinitionList{}
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".yaml" || ext == ".yml" {
+		err = yaml.UnmarshalStrict(data, list)
+	} else {
+		err = json.Unmarshal(data, list)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse role definition file %q: %v", path, err)
+	}
+
+	return list.Roles, nil
+}
+
+// ApplyRoleDefinitions receives a list of role definitions and adds each
+// defined role to the Authorizer. Rules defined for an existing role are
+// added to that role. Every action is validated before any change is made.
+// Returns an error if a definition is malformed, an action is invalid, or
+// a definition names an existing role defined by a different namespace.
+func ApplyRoleDefinitions(a Authorizer, defs []RoleDefinition) error {
+	for _, def := range defs {
+		if !roleNameFormat.MatchString(def.Name) {
+			return fmt.Errorf("invalid role name %q: role names may only contain letters, numbers, dashes and underscores", def.Name)
+		}
+		if role, exists := a.Role(def.Name); exists && role.Namespace() != def.Namespace {
+			if len(role.Namespace()) == 0 {
+				return fmt.Errorf("role %q is shared by every room, and cannot be changed from a room", def.Name)
+			}
+			return fmt.Errorf("a role with the name %q already exists", def.Name)
+		}
+
+		for _, rule := range def.Rules {
+			if len(rule.Name) == 0 {
+				return fmt.Errorf("role %q: every rule must have a name", def.Name)
+			}
+			if len(rule.Actions) == 0 {
+				return fmt.Errorf("role %q: rule %q must have at least one action", def.Name, rule.Name)
+			}
+			if len(rule.Effect) > 0 && rule.Effect != RULE_EFFECT_ALLOW && rule.Effect != RULE_EFFECT_DENY {
+				return fmt.Errorf("role %q: rule %q has unknown effect %q: must be one of %s, %s", def.Name, rule.Name, rule.Effect, RULE_EFFECT_ALLOW, RULE_EFFECT_DENY)
+			}
+			for _, action := range rule.Actions {
+				if err := a.ValidateAction(action); err != nil {
+					return fmt.Errorf("role %q: rule %q: %v", def.Name, rule.Name, err)
+				}
+			}
+		}
+	}
+
+	for _, def := range defs {
+		role, exists := a.Role(def.Name)
+		if !exists {
+			role = NewNamespacedRole(def.Name, def.Namespace, []Rule{})
+			a.AddRole(role)
+		}
+
+		for _, rule := range def.Rules {
+			// replace an existing rule by the same name
+			effect := rule.Effect
+			if len(effect) == 0 {
+				effect = RULE_EFFECT_ALLOW
+			}
+
+			role.RemoveRule(rule.Name)
+			role.AddRule(NewRuleWithEffect(rule.Name, rule.Actions, effect))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,220 @@
+package rbac
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var roleNameFormat = regexp.MustCompile("^[a-zA-Z0-9_-]+$")
+
+// IsDefaultRole returns a boolean (true) if the given role
+// name belongs to one of the roles assigned to new clients
+func IsDefaultRole(name string) bool {
+	return name == VIEWER_ROLE || name == USER_ROLE || name == ADMIN_ROLE
+}
+
+// NamespaceRole returns a role by name if it may be used within the
+// namespace with the given name: if it is shared by every namespace,
+// or was defined by that namespace.
+// Returns a boolean (false) if no such role exists.
+func NamespaceRole(a Authorizer, namespace, name string) (Role, bool) {
+	role, exists := a.Role(name)
+	if !exists || (len(role.Namespace()) > 0 && role.Namespace() != namespace) {
+		return nil, false
+	}
+
+	return role, true
+}
+
+// NamespaceRoles returns every role that may be used
+// within the namespace with the given name, sorted by name
+func NamespaceRoles(a Authorizer, namespace string) []Role {
+	roles := []Role{}
+	for _, role := range a.Roles() {
+		if len(role.Namespace()) == 0 || role.Namespace() == namespace {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// RemoveNamespaceRoles removes every role, and its binding, defined
+// by the namespace with the given name. Called once a namespace is
+// deleted, so that its role names may be used again.
+// Returns the amount of roles removed.
+func RemoveNamespaceRoles(a Authorizer, namespace string) int {
+	if len(namespace) == 0 {
+		return 0
+	}
+
+	removed := 0
+	for _, role := range a.Roles() {
+		if role.Namespace() == namespace && a.RemoveRole(role.Name()) {
+			removed++
+		}
+	}
+	return removed
+}
+
+// editableRole returns a role by name if it may be changed from the
+// namespace with the given name. Roles may only be changed from the
+// namespace that defined them; roles shared by every namespace may
+// only be changed by definition files, or with an empty namespace.
+func editableRole(a Authorizer, namespace, name string) (Role, error) {
+	role, exists := NamespaceRole(a, namespace, name)
+	if !exists {
+		return nil, fmt.Errorf("role %q not found", name)
+	}
+	if role.Namespace() != namespace {
+		return nil, fmt.Errorf("role %q is shared by every room, and cannot be changed from a room", name)
+	}
+
+	return role, nil
+}
+
+// CreateRole receives a role name and adds a new Role with no rules,
+// defined by the namespace with the given name. An empty namespace
+// name creates a role shared by every namespace.
+// Returns an error if the name is malformed or a role by that name exists.
+func CreateRole(a Authorizer, namespace, name string) (Role, error) {
+	if !roleNameFormat.MatchString(name) {
+		return nil, fmt.Errorf("invalid role name %q: role names may only contain letters, numbers, dashes and underscores", name)
+	}
+
+	role := NewNamespacedRole(name, namespace, []Rule{})
+	if !a.AddRole(role) {
+		return nil, fmt.Errorf("a role with the name %q already exists", name)
+	}
+
+	return role, nil
+}
+
+// DeleteRole removes a role, and its binding, by name.
+// Returns an error if the role does not exist, is a default
+// role, or may not be changed from the given namespace.
+func DeleteRole(a Authorizer, namespace, name string) error {
+	if IsDefaultRole(name) {
+		return fmt.Errorf("the default role %q cannot be deleted", name)
+	}
+	if _, err := editableRole(a, namespace, name); err != nil {
+		return err
+	}
+	if !a.RemoveRole(name) {
+		return fmt.Errorf("role %q not found", name)
+	}
+
+	return nil
+}
+
+// Grant receives a role name and a set of actions, and adds an allow rule
+// to the role for each action not already allowed by the role. Any deny
+// rule in the role for the same action is removed. Rules added this way are
+// named after the action they allow.
+// Returns an error if the role does not exist or may not be changed from
+// the given namespace, or if any action given does not match a registered
+// action; in that case no rules are added.
+func Grant(a Authorizer, namespace, roleName string, actions ...string) error {
+	return setActionEffect(a, namespace, roleName, RULE_EFFECT_ALLOW, actions)
+}
+
+// Deny receives a role name and a set of actions, and adds a deny rule
+// to the role for each action not already denied by the role. Any allow
+// rule in the role for the same action is removed. Rules added this way
+// are named "deny <action>".
+// Returns an error if the role does not exist or may not be changed from
+// the given namespace, or if any action given does not match a registered
+// action; in that case no rules are added.
+func Deny(a Authorizer, namespace, roleName string, actions ...string) error {
+	return setActionEffect(a, namespace, roleName, RULE_EFFECT_DENY, actions)
+}
+
+func setActionEffect(a Authorizer, namespace, roleName string, effect RuleEffect, actions []string) error {
+	role, err := editableRole(a, namespace, roleName)
+	if err != nil {
+		return err
+	}
+
+	for _, action := range actions {
+		if err := a.ValidateAction(action); err != nil {
+			return err
+		}
+	}
+
+	for _, action := range actions {
+		for _, rule := range role.Rules() {
+			if rule.Effect() != effect {
+				removeRuleAction(role, rule, action)
+			}
+		}
+
+		if roleHasAction(role, action, effect) {
+			continue
+		}
+
+		name := action
+		if effect == RULE_EFFECT_DENY {
+			name = "deny " + action
+		}
+		role.AddRule(NewRuleWithEffect(name, []string{action}, effect))
+	}
+
+	return nil
+}
+
+// Revoke receives a role name and an action, and removes the action from
+// every rule composed by the role. Rules left with no actions are removed.
+// Rules are copied before being changed, as they may be shared between roles.
+// Returns a boolean (false) if the role had no rule containing the action,
+// or an error if the role may not be changed from the given namespace.
+func Revoke(a Authorizer, namespace, roleName, action string) (bool, error) {
+	role, err := editableRole(a, namespace, roleName)
+	if err != nil {
+		return false, err
+	}
+
+	revoked := false
+	for _, rule := range role.Rules() {
+		if removeRuleAction(role, rule, action) {
+			revoked = true
+		}
+	}
+
+	return revoked, nil
+}
+
+// removeRuleAction replaces a rule in a role with a copy of the rule that
+// does not contain the given action, or removes the rule if the action is
+// its only action. Returns a boolean (false) if the rule did not contain
+// the given action.
+func removeRuleAction(role Role, rule Rule, action string) bool {
+	remaining := []string{}
+	for _, ruleAction := range rule.Actions() {
+		if ruleAction != action {
+			remaining = append(remaining, ruleAction)
+		}
+	}
+
+	if len(remaining) == len(rule.Actions()) {
+		return false
+	}
+
+	role.RemoveRule(rule.Name())
+	if len(remaining) > 0 {
+		role.AddRule(NewRuleWithEffect(rule.Name(), remaining, rule.Effect()))
+	}
+	return true
+}
+
+func roleHasAction(role Role, action string, effect RuleEffect) bool {
+	for _, rule := range role.Rules() {
+		if rule.Effect() != effect {
+			continue
+		}
+		for _, a := range rule.Actions() {
+			if a == action {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,211 @@
+package rbac
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func newEditTestAuthorizer() Authorizer {
+	authorizer := NewAuthorizer()
+	authorizer.RegisterActions("queue/add", "stream/skip", "stream/seek")
+	authorizer.AddRole(NewRole(USER_ROLE, []Rule{}))
+	return authorizer
+}
+
+func TestNamespaceRoles(t *testing.T) {
+	authorizer := newEditTestAuthorizer()
+	if _, err := CreateRole(authorizer, "room-a", "dj"); err != nil {
+		t.Fatalf("unexpected error creating role: %v", err)
+	}
+	if _, err := CreateRole(authorizer, "room-b", "dj"); err == nil {
+		t.Fatalf("expected an error creating a role whose name is taken by another room")
+	}
+
+	if _, exists := NamespaceRole(authorizer, "room-a", "dj"); !exists {
+		t.Fatalf("expected role created by room-a to be usable in room-a")
+	}
+	if _, exists := NamespaceRole(authorizer, "room-b", "dj"); exists {
+		t.Fatalf("expected role created by room-a not to be usable in room-b")
+	}
+	if _, exists := NamespaceRole(authorizer, "room-b", USER_ROLE); !exists {
+		t.Fatalf("expected shared role to be usable in every room")
+	}
+
+	names := func(roles []Role) []string {
+		out := []string{}
+		for _, r := range roles {
+			out = append(out, r.Name())
+		}
+		return out
+	}
+	if actual := names(NamespaceRoles(authorizer, "room-a")); !reflect.DeepEqual(actual, []string{"dj", USER_ROLE}) {
+		t.Fatalf("unexpected roles for room-a: %v", actual)
+	}
+	if actual := names(NamespaceRoles(authorizer, "room-b")); !reflect.DeepEqual(actual, []string{USER_ROLE}) {
+		t.Fatalf("unexpected roles for room-b: %v", actual)
+	}
+
+	if removed := RemoveNamespaceRoles(authorizer, "room-a"); removed != 1 {
+		t.Fatalf("expected 1 role removed, got %v", removed)
+	}
+	if _, exists := authorizer.Role("dj"); exists {
+		t.Fatalf("expected role created by room-a to be removed with it")
+	}
+	if removed := RemoveNamespaceRoles(authorizer, ""); removed != 0 {
+		t.Fatalf("expected shared roles never to be removed, got %v removed", removed)
+	}
+}
+
+func TestEditNamespacedRole(t *testing.T) {
+	authorizer := newEditTestAuthorizer()
+	if _, err := CreateRole(authorizer, "room-a", "dj"); err != nil {
+		t.Fatalf("unexpected error creating role: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		namespace string
+		role      string
+		expectErr bool
+	}{
+		{name: "own room", namespace: "room-a", role: "dj"},
+		{name: "other room", namespace: "room-b", role: "dj", expectErr: true},
+		{name: "shared role from a room", namespace: "room-a", role: USER_ROLE, expectErr: true},
+		{name: "shared role without a room", namespace: "", role: USER_ROLE},
+		{name: "missing role", namespace: "room-a", role: "missing", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Grant(authorizer, tt.namespace, tt.role, "stream/skip")
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("grant: expected error %v, got %v", tt.expectErr, err)
+			}
+			err = Deny(authorizer, tt.namespace, tt.role, "stream/seek")
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("deny: expected error %v, got %v", tt.expectErr, err)
+			}
+			_, err = Revoke(authorizer, tt.namespace, tt.role, "stream/skip")
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("revoke: expected error %v, got %v", tt.expectErr, err)
+			}
+		})
+	}
+
+	if err := DeleteRole(authorizer, "room-b", "dj"); err == nil {
+		t.Fatalf("expected an error deleting a role created by another room")
+	}
+	if err := DeleteRole(authorizer, "room-a", "dj"); err != nil {
+		t.Fatalf("unexpected error deleting role: %v", err)
+	}
+}
+
+func TestApplyNamespacedRoleDefinitions(t *testing.T) {
+	authorizer := newEditTestAuthorizer()
+	def := RoleDefinition{
+		Name:      "dj",
+		Namespace: "room-a",
+		Rules:     []RuleDefinition{{Name: "skip", Actions: []string{"stream/skip"}}},
+	}
+	if err := ApplyRoleDefinitions(authorizer, []RoleDefinition{def}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	def.Namespace = "room-b"
+	if err := ApplyRoleDefinitions(authorizer, []RoleDefinition{def}); err == nil {
+		t.Fatalf("expected an error changing a role created by another room")
+	}
+	if err := ApplyRoleDefinitions(authorizer, []RoleDefinition{{Name: USER_ROLE, Namespace: "room-a"}}); err == nil {
+		t.Fatalf("expected an error changing a shared role from a room")
+	}
+
+	role, _ := authorizer.Role("dj")
+	if actual := DefinitionForRole(role); actual.Namespace != "room-a" || len(actual.Rules) != 1 {
+		t.Fatalf("unexpected definition for role: %+v", actual)
+	}
+}
+
+func TestLoadRoleDefinitions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rbac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	expected := []RoleDefinition{
+		{
+			Name: "dj",
+			Rules: []RuleDefinition{
+				{Name: "skip streams", Actions: []string{"stream/skip"}},
+				{Name: "no seeking", Actions: []string{"stream/seek"}, Effect: RULE_EFFECT_DENY},
+			},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		file      string
+		contents  string
+		expectErr bool
+	}{
+		{
+			name: "json",
+			file: "roles.json",
+			contents: `{"roles": [{"name": "dj", "rules": [
+				{"name": "skip streams", "actions": ["stream/skip"]},
+				{"name": "no seeking", "actions": ["stream/seek"], "effect": "deny"}
+			]}]}`,
+		},
+		{
+			name: "yaml",
+			file: "roles.yaml",
+			contents: `roles:
+- name: dj
+  rules:
+  - name: skip streams
+    actions: [stream/skip]
+  - name: no seeking
+    actions: [stream/seek]
+    effect: deny
+`,
+		},
+		{
+			name: "yml",
+			file: "roles.yml",
+			contents: `roles:
+- name: dj
+  rules:
+  - {name: skip streams, actions: [stream/skip]}
+  - {name: no seeking, actions: [stream/seek], effect: deny}
+`,
+		},
+		{name: "malformed json", file: "bad.json", contents: `{"roles": [`, expectErr: true},
+		{name: "unknown yaml field", file: "bad.yaml", contents: "roles:\n- name: dj\n  rulez: []\n", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.file)
+			if err := ioutil.WriteFile(path, []byte(tt.contents), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			defs, err := LoadRoleDefinitions(path)
+			if tt.expectErr {
+				if err == nil {
+					t.Fatalf("expected an error, got definitions %+v", defs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(defs, expected) {
+				t.Fatalf("expected definitions %+v, got %+v", expected, defs)
+			}
+		})
+	}
+}
@@ -1,6 +1,11 @@
 package rbac
 
-import "strings"
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
 
 // Authorizer authorizes a Subject to perform an action based
 // on Rules defined by Roles bound to that Subject
@@ -14,25 +19,45 @@ type Authorizer interface {
 	Bind(Role, ...Subject) bool
 	// Bindings returns the role-bindings aggregated by the Authorizer
 	Bindings() []RoleBinding
+	// RegisterActions receives a set of actions that may be granted by
+	// Rules. Actions given to ValidateAction are checked against this set.
+	RegisterActions(...string)
+	// RemoveRole removes a composed Role, along with its binding, by name.
+	// Returns a boolean (false) if the role does not exist.
+	RemoveRole(string) bool
 	// Role returns a composed Role by a given name.
 	// Returns a boolean (false) if the role does not exist.
 	Role(string) (Role, bool)
-	// Verify verifies that a given subject has access to the
-	// resources defined by the given Rule.
-	// Returns a boolean (true) if the Rule given is contained
-	// within the role(s) the subject has access to.
-	Verify(Subject, Rule) bool
+	// Roles returns all composed Roles, sorted by name
+	Roles() []Role
+	// ValidateAction returns an error if the given action neither matches,
+	// nor is a pattern matching, at least one registered action.
+	ValidateAction(string) error
+	// Explain evaluates the rules in the role(s) a given subject is
+	// bound to against an action, and returns a Decision describing
+	// every matching rule, and which rule allowed or denied the action.
+	Explain(Subject, string) *Decision
+	// VerifyAction verifies that a given subject is allowed to
+	// perform an action by the role(s) the subject is bound to.
+	VerifyAction(Subject, string) bool
 }
 
 // AuthorizerSpec is a RoleHandler that provides several
 // convenience methods for managing and restricting
-// command access based on a given role.
+// command access based on a given role. Roles and bindings are
+// changed by commands, api requests and admin pickers running in
+// separate goroutines; every map is guarded by mux.
 type AuthorizerSpec struct {
+	mux                   sync.RWMutex
 	rolesByName           map[string]Role
 	roleBindingByRoleName map[string]RoleBinding
+	actions               map[string]bool
 }
 
 func (a *AuthorizerSpec) AddRole(r Role) bool {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
 	if _, exists := a.rolesByName[r.Name()]; !exists {
 		a.rolesByName[r.Name()] = r
 		return true
@@ -42,6 +67,9 @@ func (a *AuthorizerSpec) AddRole(r Role) bool {
 }
 
 func (a *AuthorizerSpec) Bind(r Role, subjects ...Subject) bool {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
 	binding, exists := a.roleBindingByRoleName[r.Name()]
 	if !exists {
 		binding = NewRoleBinding(r, subjects)
@@ -55,6 +83,9 @@ func (a *AuthorizerSpec) Bind(r Role, subjects ...Subject) bool {
 }
 
 func (a *AuthorizerSpec) Bindings() []RoleBinding {
+	a.mux.RLock()
+	defer a.mux.RUnlock()
+
 	bindings := []RoleBinding{}
 
 	for _, b := range a.roleBindingByRoleName {
@@ -64,7 +95,32 @@ func (a *AuthorizerSpec) Bindings() []RoleBinding {
 	return bindings
 }
 
+func (a *AuthorizerSpec) RegisterActions(actions ...string) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	for _, action := range actions {
+		a.actions[action] = true
+	}
+}
+
+func (a *AuthorizerSpec) RemoveRole(name string) bool {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	if _, exists := a.rolesByName[name]; !exists {
+		return false
+	}
+
+	delete(a.rolesByName, name)
+	delete(a.roleBindingByRoleName, name)
+	return true
+}
+
 func (a *AuthorizerSpec) Role(name string) (Role, bool) {
+	a.mux.RLock()
+	defer a.mux.RUnlock()
+
 	if role, exists := a.rolesByName[name]; exists {
 		return role, true
 	}
@@ -72,35 +128,64 @@ func (a *AuthorizerSpec) Role(name string) (Role, bool) {
 	return nil, false
 }
 
-func (a *AuthorizerSpec) Verify(s Subject, r Rule) bool {
-	subjectRoles := []Role{}
+func (a *AuthorizerSpec) Roles() []Role {
+	a.mux.RLock()
+	defer a.mux.RUnlock()
 
-	// calculate which roles the subject is bound to
-	for _, binding := range a.roleBindingByRoleName {
-		found := false
-		for _, subject := range binding.Subjects() {
-			if subject.UUID() == s.UUID() {
-				found = true
-				break
-			}
-		}
+	roles := []Role{}
+	for _, role := range a.rolesByName {
+		roles = append(roles, role)
+	}
+
+	sort.Slice(roles, func(i, j int) bool {
+		return roles[i].Name() < roles[j].Name()
+	})
+	return roles
+}
 
-		// given subject is bound to the role in the current binding
-		if found {
-			subjectRoles = append(subjectRoles, binding.Role())
+func (a *AuthorizerSpec) ValidateAction(action string) error {
+	// exact patterns are validated as the action they match
+	action = strings.TrimSuffix(action, EXACT_ACTION_SUFFIX)
+	if len(action) == 0 {
+		return fmt.Errorf("an action must not be empty")
+	}
+	if strings.HasPrefix(action, "/") || strings.HasSuffix(action, "/") || strings.Contains(action, "//") {
+		return fmt.Errorf("malformed action %q: actions are of the form command/arg/...", action)
+	}
+
+	a.mux.RLock()
+	defer a.mux.RUnlock()
+
+	for known := range a.actions {
+		if verifyAction(known, action) || verifyAction(action, known) {
+			return nil
 		}
 	}
 
-	// iterate through the roles the given subject has been bound to
-	// and calculate if at least one role contains the given rule.
-	for _, role := range subjectRoles {
-		for _, rule := range role.Rules() {
-			if r.Name() == rule.Name() {
-				return true
-			}
+	return fmt.Errorf("unknown action %q: it does not match any registered command action", action)
+}
+
+func (a *AuthorizerSpec) Explain(s Subject, action string) *Decision {
+	bindings := []RoleBinding{}
+	for _, binding := range a.Bindings() {
+		if bindingHasSubject(binding, s) {
+			bindings = append(bindings, binding)
 		}
 	}
 
+	return Evaluate(bindings, action)
+}
+
+func (a *AuthorizerSpec) VerifyAction(s Subject, action string) bool {
+	return a.Explain(s, action).Allowed
+}
+
+func bindingHasSubject(binding RoleBinding, s Subject) bool {
+	for _, subject := range binding.Subjects() {
+		if subject.UUID() == s.UUID() {
+			return true
+		}
+	}
 	return false
 }
 
@@ -108,37 +193,48 @@ func NewAuthorizer() Authorizer {
 	return &AuthorizerSpec{
 		rolesByName:           make(map[string]Role),
 		roleBindingByRoleName: make(map[string]RoleBinding),
+		actions:               make(map[string]bool),
 	}
 }
 
-// RuleByAction receives an action and returns the rule
-// corresponding to that action, or false if no rule is found.
-func RuleByAction(bindings []RoleBinding, action string) (Rule, bool) {
+// SubjectRoleNames receives a list of bindings and a subject and
+// returns the names of all roles the subject is bound to.
+func SubjectRoleNames(bindings []RoleBinding, subject Subject) []string {
+	names := []string{}
 	for _, binding := range bindings {
-		for _, rule := range binding.Role().Rules() {
-			for _, a := range rule.Actions() {
-				if verifyAction(a, action) {
-					return rule, true
-				}
+		for _, s := range binding.Subjects() {
+			if s.UUID() == subject.UUID() {
+				names = append(names, binding.Role().Name())
+				break
 			}
 		}
 	}
-	return nil, false
+	return names
 }
 
+// verifyAction returns a boolean (true) if a rule's action pattern matches
+// a requested action. Actions are "/"-delimited segments: "queue/add/<url>".
+//   - a "*" segment matches one or more remaining segments:
+//     "queue/*" matches "queue/add/url", but not "queue".
+//   - a "*" segment only acts as a wildcard as the last segment of a pattern;
+//     every segment after it is ignored.
+//   - a pattern with no "*" matches actions with the same segments, as well
+//     as any action it is a prefix of: "stream/info" matches "stream/info/x".
+//   - a pattern ending in "$" matches actions with the same segments only:
+//     "room/limits$" matches "room/limits", but not "room/limits/x".
 func verifyAction(existingAction, requestedAction string) bool {
-	if len(existingAction) == 0 {
-		return false
-	}
-	if len(requestedAction) < len(existingAction) {
+	if len(existingAction) == 0 || len(requestedAction) == 0 {
 		return false
 	}
 
+	exact := strings.HasSuffix(existingAction, EXACT_ACTION_SUFFIX)
+	existingAction = strings.TrimSuffix(existingAction, EXACT_ACTION_SUFFIX)
+
 	segsExisting := strings.Split(existingAction, "/")
 	segsRequested := strings.Split(requestedAction, "/")
 	for idx, segExist := range segsExisting {
 		if segExist == "*" {
-			return true
+			return idx < len(segsRequested) && len(segsRequested[idx]) > 0
 		}
 		if idx >= len(segsRequested) {
 			return false
@@ -148,5 +244,5 @@ func verifyAction(existingAction, requestedAction string) bool {
 		}
 	}
 
-	return true
+	return !exact || len(segsRequested) == len(segsExisting)
 }
@@ -0,0 +1,109 @@
+package rbac
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// RuleMatch describes a Rule whose action pattern matched a requested action
+type RuleMatch struct {
+	// Role is the name of the Role composing the matched Rule
+	Role string
+	// Rule is the matched Rule
+	Rule Rule
+	// Pattern is the Rule action that matched the requested action
+	Pattern string
+	// Specificity ranks how closely the pattern describes the requested
+	// action. Matches with a greater specificity take precedence.
+	Specificity int
+}
+
+// Decision is the result of evaluating a set of role bindings against an action
+type Decision struct {
+	Action  string
+	Allowed bool
+	// Matches contains every matching Rule, ordered by precedence
+	Matches []RuleMatch
+	// Deciding is the match that determined the decision,
+	// or nil if no rule matched the action.
+	Deciding *RuleMatch
+}
+
+// Reason returns a human-readable explanation of the decision
+func (d *Decision) Reason() string {
+	if d.Deciding == nil {
+		return fmt.Sprintf("%q is denied: no rule in your role(s) matches it", d.Action)
+	}
+
+	verb := "allowed"
+	if !d.Allowed {
+		verb = "denied"
+	}
+	return fmt.Sprintf("%q is %s by rule %q (%s %q) in role %q", d.Action, verb, d.Deciding.Rule.Name(), d.Deciding.Rule.Effect(), d.Deciding.Pattern, d.Deciding.Role)
+}
+
+// Evaluate receives the role bindings of a single subject and an action,
+// and decides whether the action is allowed. Only the rules of the given
+// bindings are considered. Among all matching rules, the one whose action
+// pattern is most specific takes precedence; if a deny and an allow rule
+// are equally specific, the deny rule takes precedence. Actions matched by
+// no rule are denied.
+func Evaluate(bindings []RoleBinding, action string) *Decision {
+	decision := &Decision{
+		Action:  action,
+		Matches: []RuleMatch{},
+	}
+
+	for _, binding := range bindings {
+		for _, rule := range binding.Role().Rules() {
+			for _, pattern := range rule.Actions() {
+				if !verifyAction(pattern, action) {
+					continue
+				}
+
+				decision.Matches = append(decision.Matches, RuleMatch{
+					Role:        binding.Role().Name(),
+					Rule:        rule,
+					Pattern:     pattern,
+					Specificity: actionSpecificity(pattern),
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(decision.Matches, func(i, j int) bool {
+		a, b := decision.Matches[i], decision.Matches[j]
+		if a.Specificity != b.Specificity {
+			return a.Specificity > b.Specificity
+		}
+		return a.Rule.Effect() == RULE_EFFECT_DENY && b.Rule.Effect() != RULE_EFFECT_DENY
+	})
+
+	if len(decision.Matches) > 0 {
+		decision.Deciding = &decision.Matches[0]
+		decision.Allowed = decision.Deciding.Rule.Effect() != RULE_EFFECT_DENY
+	}
+
+	return decision
+}
+
+// actionSpecificity ranks an action pattern by the amount of literal
+// segments it contains. A pattern with no wildcard ranks above a pattern
+// with the same amount of literal segments followed by a wildcard, and an
+// exact pattern ranks above both.
+func actionSpecificity(pattern string) int {
+	exact := strings.HasSuffix(pattern, EXACT_ACTION_SUFFIX)
+
+	specificity := 0
+	for _, seg := range strings.Split(strings.TrimSuffix(pattern, EXACT_ACTION_SUFFIX), "/") {
+		if seg == "*" {
+			return specificity
+		}
+		specificity += 2
+	}
+	if exact {
+		return specificity + 2
+	}
+	return specificity + 1
+}
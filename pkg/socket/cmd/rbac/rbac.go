@@ -11,6 +11,10 @@ const (
 	VIEWER_ROLE = "viewer"
 	USER_ROLE   = "user"
 	ADMIN_ROLE  = "admin"
+
+	// EXACT_ACTION_SUFFIX ends an action pattern that matches its own
+	// action only, rather than every action it is a prefix of
+	EXACT_ACTION_SUFFIX = "$"
 )
 
 type AuthCookieDataNs struct {
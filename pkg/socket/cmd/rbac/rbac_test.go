@@ -0,0 +1,141 @@
+package rbac
+
+import (
+	"testing"
+)
+
+type fakeSubject string
+
+func (s fakeSubject) UUID() string {
+	return string(s)
+}
+
+func TestVerifyAction(t *testing.T) {
+	tests := []struct {
+		name      string
+		pattern   string
+		requested string
+		expected  bool
+	}{
+		// exact actions
+		{name: "exact match", pattern: "queue/add", requested: "queue/add", expected: true},
+		{name: "exact mismatch", pattern: "queue/add", requested: "queue/remove"},
+		{name: "exact prefix of action", pattern: "stream/info", requested: "stream/info/url", expected: true},
+		{name: "exact longer than action", pattern: "stream/info/url", requested: "stream/info"},
+		{name: "partial segment", pattern: "queue/ad", requested: "queue/add"},
+		{name: "empty pattern", pattern: "", requested: "queue/add"},
+		{name: "empty action", pattern: "queue/add", requested: ""},
+
+		// exact patterns
+		{name: "exact pattern matches action", pattern: "stream/loop$", requested: "stream/loop", expected: true},
+		{name: "exact pattern does not match children", pattern: "stream/loop$", requested: "stream/loop/one"},
+		{name: "exact pattern longer than action", pattern: "stream/loop/one$", requested: "stream/loop"},
+		{name: "exact pattern mismatch", pattern: "stream/loop$", requested: "stream/info"},
+		{name: "exact pattern is not a literal suffix", pattern: "stream/loop$", requested: "stream/loop$"},
+
+		// "*" matches every action
+		{name: "star matches single segment", pattern: "*", requested: "help", expected: true},
+		{name: "star matches nested segments", pattern: "*", requested: "queue/add/url", expected: true},
+
+		// trailing wildcards
+		{name: "trailing wildcard matches child", pattern: "queue/*", requested: "queue/add", expected: true},
+		{name: "trailing wildcard matches descendants", pattern: "queue/*", requested: "queue/add/url", expected: true},
+		{name: "trailing wildcard requires a segment", pattern: "queue/*", requested: "queue"},
+		{name: "trailing wildcard requires a non-empty segment", pattern: "queue/*", requested: "queue/"},
+		{name: "trailing wildcard other parent", pattern: "queue/*", requested: "stream/set"},
+		{name: "segments after wildcard are ignored", pattern: "queue/*/url", requested: "queue/add/other", expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := verifyAction(tt.pattern, tt.requested); actual != tt.expected {
+				t.Fatalf("expected pattern %q matching action %q to be %v, got %v", tt.pattern, tt.requested, tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestExplain(t *testing.T) {
+	alice := fakeSubject("alice")
+	bob := fakeSubject("bob")
+
+	authorizer := NewAuthorizer()
+	for _, role := range []Role{
+		NewRole("user", []Rule{
+			NewRule("user", []string{"queue/*", "stream/info"}),
+			NewDenyRule("no-clear", []string{"queue/clear"}),
+		}),
+		NewRole("moderator", []Rule{
+			NewRule("clear", []string{"queue/clear"}),
+			NewRule("stream", []string{"stream/*"}),
+		}),
+		NewRole("muted", []Rule{
+			NewDenyRule("muted", []string{"chat/*"}),
+			NewRule("chat", []string{"chat/*"}),
+		}),
+		NewRole("everything", []Rule{
+			NewRule("everything", []string{"*"}),
+		}),
+	} {
+		authorizer.AddRole(role)
+	}
+
+	bind := func(roleName string, subjects ...Subject) {
+		role, _ := authorizer.Role(roleName)
+		authorizer.Bind(role, subjects...)
+	}
+	bind("user", alice, bob)
+	bind("moderator", bob)
+	bind("muted", alice)
+
+	tests := []struct {
+		name     string
+		subject  Subject
+		action   string
+		expected bool
+		deciding string
+	}{
+		{name: "allowed by wildcard", subject: alice, action: "queue/add", expected: true, deciding: "user"},
+		{name: "allowed by exact rule", subject: alice, action: "stream/info", expected: true, deciding: "user"},
+		{name: "no matching rule", subject: alice, action: "room/lock"},
+		{name: "more specific deny beats wildcard allow", subject: alice, action: "queue/clear", deciding: "no-clear"},
+		{name: "deny beats equally specific allow", subject: bob, action: "queue/clear", deciding: "no-clear"},
+		{name: "deny beats allow in the same role", subject: alice, action: "chat/send", deciding: "muted"},
+		{name: "more specific allow beats wildcard", subject: bob, action: "stream/info", expected: true, deciding: "user"},
+		{name: "wildcard allow of another binding", subject: bob, action: "stream/set", expected: true, deciding: "stream"},
+		{name: "other subjects' bindings are not evaluated", subject: alice, action: "stream/set"},
+		{name: "unbound roles are not evaluated", subject: bob, action: "chat/send"},
+		{name: "unbound subject", subject: fakeSubject("carol"), action: "queue/add"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := authorizer.Explain(tt.subject, tt.action)
+			if decision.Allowed != tt.expected {
+				t.Fatalf("expected %q by %q to be allowed: %v, got %v: %s", tt.action, tt.subject.UUID(), tt.expected, decision.Allowed, decision.Reason())
+			}
+			if authorizer.VerifyAction(tt.subject, tt.action) != tt.expected {
+				t.Fatalf("expected VerifyAction to agree with Explain for %q", tt.action)
+			}
+
+			if len(tt.deciding) == 0 {
+				if decision.Deciding != nil {
+					t.Fatalf("expected no deciding rule, got %q", decision.Deciding.Rule.Name())
+				}
+				return
+			}
+			if decision.Deciding == nil || decision.Deciding.Rule.Name() != tt.deciding {
+				t.Fatalf("expected rule %q to decide %q: %s", tt.deciding, tt.action, decision.Reason())
+			}
+		})
+	}
+}
+
+func TestActionSpecificity(t *testing.T) {
+	ordered := []string{"queue/clear/all", "queue/clear$", "queue/clear", "queue/clear/*", "queue", "queue/*", "*"}
+	for i := 1; i < len(ordered); i++ {
+		if actionSpecificity(ordered[i-1]) <= actionSpecificity(ordered[i]) {
+			t.Fatalf("expected %q to be more specific than %q", ordered[i-1], ordered[i])
+		}
+	}
+}
@@ -1,22 +1,37 @@
 package rbac
 
+import "sync"
+
 // Role is an object that
 type Role interface {
 	// AddRule composes a new Rule in the Role.
 	// Returns a boolean (false) if the given rule already exists.
 	AddRule(Rule) bool
+	// RemoveRule removes a composed Rule from the Role by the Rule's name.
+	// Returns a boolean (false) if no rule exists by the given name.
+	RemoveRule(string) bool
 	// Name returns the name assigned to the Role
 	Name() string
+	// Namespace returns the name of the namespace the Role was defined
+	// by, or an empty string if the Role is shared by every namespace.
+	Namespace() string
 	// Rules returns the set of rules composed by the Role
 	Rules() []Rule
 }
 
+// RoleSpec implements Role. Its rules are guarded by mux.
 type RoleSpec struct {
-	name  string
+	name      string
+	namespace string
+
+	mux   sync.RWMutex
 	rules []Rule
 }
 
 func (s *RoleSpec) AddRule(r Rule) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
 	for _, rule := range s.rules {
 		if r.Name() == rule.Name() {
 			return false
@@ -27,18 +42,51 @@ func (s *RoleSpec) AddRule(r Rule) bool {
 	return true
 }
 
+func (s *RoleSpec) RemoveRule(name string) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	for idx, rule := range s.rules {
+		if rule.Name() == name {
+			// copy remaining rules, as the underlying slice may be
+			// shared with another Role composing the same rules
+			rules := make([]Rule, 0, len(s.rules)-1)
+			rules = append(rules, s.rules[:idx]...)
+			s.rules = append(rules, s.rules[idx+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
 func (s *RoleSpec) Name() string {
 	return s.name
 }
 
+func (s *RoleSpec) Namespace() string {
+	return s.namespace
+}
+
+// Rules returns a snapshot of the rules composed by the role
 func (s *RoleSpec) Rules() []Rule {
-	return s.rules
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	return append([]Rule{}, s.rules...)
 }
 
 func NewRole(name string, rules []Rule) Role {
+	return NewNamespacedRole(name, "", rules)
+}
+
+// NewNamespacedRole returns a Role defined by, and only
+// usable within, the namespace with the given name
+func NewNamespacedRole(name, namespace string, rules []Rule) Role {
 	return &RoleSpec{
-		name:  name,
-		rules: rules,
+		name:      name,
+		namespace: namespace,
+		rules:     append([]Rule{}, rules...),
 	}
 }
 
@@ -1,17 +1,29 @@
 package rbac
 
+const (
+	RULE_EFFECT_ALLOW RuleEffect = "allow"
+	RULE_EFFECT_DENY  RuleEffect = "deny"
+)
+
+// RuleEffect determines whether a Rule allows or denies its actions
+type RuleEffect string
+
 // Rule defines a permission
 type Rule interface {
 	// Name returns the name associated with the Rule
 	Name() string
-	// Actions returns the specific set of actions for which the Rule allows access
+	// Actions returns the specific set of actions for which the Rule allows
+	// or denies access
 	Actions() []string
+	// Effect returns whether the Rule allows or denies its actions
+	Effect() RuleEffect
 }
 
 // RuleSpec implements Rule
 type RuleSpec struct {
 	actions []string
 	name    string
+	effect  RuleEffect
 }
 
 func (r *RuleSpec) Name() string {
@@ -22,9 +34,24 @@ func (r *RuleSpec) Actions() []string {
 	return r.actions
 }
 
+func (r *RuleSpec) Effect() RuleEffect {
+	return r.effect
+}
+
+// NewRule returns a Rule allowing access to the given actions
 func NewRule(name string, actions []string) Rule {
+	return NewRuleWithEffect(name, actions, RULE_EFFECT_ALLOW)
+}
+
+// NewDenyRule returns a Rule denying access to the given actions
+func NewDenyRule(name string, actions []string) Rule {
+	return NewRuleWithEffect(name, actions, RULE_EFFECT_DENY)
+}
+
+func NewRuleWithEffect(name string, actions []string, effect RuleEffect) Rule {
 	return &RuleSpec{
 		name:    name,
 		actions: actions,
+		effect:  effect,
 	}
 }
@@ -3,10 +3,14 @@ package cmd
 import (
 	"fmt"
 	"log"
+	"strings"
 
+	"github.com/juanvallejo/streaming-server/pkg/audit"
 	"github.com/juanvallejo/streaming-server/pkg/playback"
 	"github.com/juanvallejo/streaming-server/pkg/socket/client"
 	"github.com/juanvallejo/streaming-server/pkg/socket/cmd/rbac"
+	"github.com/juanvallejo/streaming-server/pkg/socket/connection"
+	"github.com/juanvallejo/streaming-server/pkg/socket/protocol"
 	"github.com/juanvallejo/streaming-server/pkg/stream"
 )
 
@@ -16,8 +20,8 @@ type RoleCmd struct {
 
 const (
 	ROLE_NAME        = "role"
-	ROLE_DESCRIPTION = "add, replace, or remove roles for a subject (requires rbac to be enabled)"
-	ROLE_USAGE       = "Usage: /" + ROLE_NAME + " &lt;add | set | remove&gt; &lt;role&gt; &lt;subject&gt;"
+	ROLE_DESCRIPTION = "add, replace, or remove roles for a subject, or create and edit roles (requires rbac to be enabled)"
+	ROLE_USAGE       = "Usage: /" + ROLE_NAME + " (&lt;add | set | remove&gt; &lt;role&gt; &lt;subject&gt;|list|create &lt;role&gt;|delete &lt;role&gt;|grant &lt;role&gt; &lt;action...&gt;|deny &lt;role&gt; &lt;action...&gt;|revoke &lt;role&gt; &lt;action&gt;|explain &lt;action&gt; [subject])"
 )
 
 func (h *RoleCmd) Execute(cmdHandler SocketCommandHandler, args []string, user *client.Client, clientHandler client.SocketClientHandler, playbackHandler playback.PlaybackHandler, streamHandler stream.StreamHandler) (string, error) {
@@ -28,6 +32,13 @@ func (h *RoleCmd) Execute(cmdHandler SocketCommandHandler, args []string, user *
 		return h.usage, nil
 	}
 
+	switch args[0] {
+	case "list", "create", "delete", "grant", "deny", "revoke":
+		return h.editRoles(cmdHandler, args, user)
+	case "explain":
+		return h.explain(cmdHandler, args, user, clientHandler)
+	}
+
 	if len(args) < 3 {
 		return h.usage, nil
 	}
@@ -72,7 +83,7 @@ func (h *RoleCmd) Execute(cmdHandler SocketCommandHandler, args []string, user *
 		return "", fmt.Errorf("error: unable to find subject %q in your namespace", subjectName)
 	}
 
-	role, exists := authorizer.Role(roleName)
+	role, exists := rbac.NamespaceRole(authorizer, namespace.Name(), roleName)
 	if !exists {
 		return "", fmt.Errorf("error: role %q not found", roleName)
 	}
@@ -88,6 +99,7 @@ func (h *RoleCmd) Execute(cmdHandler SocketCommandHandler, args []string, user *
 			}
 
 			bound = append(bound, subject.GetUsernameOrId())
+			recordRoleChange(namespace, user, "bind/"+roleName, subject)
 
 			// if no errors adding role, remove all other roles from subject
 			for _, b := range authorizer.Bindings() {
@@ -95,7 +107,9 @@ func (h *RoleCmd) Execute(cmdHandler SocketCommandHandler, args []string, user *
 					continue
 				}
 
-				b.RemoveSubject(subject)
+				if b.RemoveSubject(subject) {
+					recordRoleChange(namespace, user, "unbind/"+b.Role().Name(), subject)
+				}
 			}
 
 			subject.BroadcastAuthRequestTo("cookie")
@@ -126,6 +140,7 @@ func (h *RoleCmd) Execute(cmdHandler SocketCommandHandler, args []string, user *
 			}
 
 			bound = append(bound, subject.GetUsernameOrId())
+			recordRoleChange(namespace, user, "bind/"+roleName, subject)
 			subject.BroadcastAuthRequestTo("cookie")
 		}
 
@@ -153,8 +168,9 @@ func (h *RoleCmd) Execute(cmdHandler SocketCommandHandler, args []string, user *
 
 				removed := b.RemoveSubject(subject)
 				if removed {
+					recordRoleChange(namespace, user, "unbind/"+roleName, subject)
 					subject.BroadcastSystemMessageTo(fmt.Sprintf("You have been removed from the %q role", role.Name()))
-					subject.BroadcastAll("info_userlistupdated", &client.Response{
+					subject.BroadcastAll(protocol.EVENT_INFO_USERLISTUPDATED, &client.Response{
 						Id: subject.UUID(),
 					})
 
@@ -184,6 +200,145 @@ func (h *RoleCmd) Execute(cmdHandler SocketCommandHandler, args []string, user *
 	return h.usage, nil
 }
 
+// editRoles handles sub-commands that list, create,
+// delete, or change the rules of roles. Roles created
+// from a room may only be used and changed in that room.
+func (h *RoleCmd) editRoles(cmdHandler SocketCommandHandler, args []string, user *client.Client) (string, error) {
+	authorizer := cmdHandler.Authorizer()
+	if authorizer == nil {
+		return "", fmt.Errorf("authorizer not enabled")
+	}
+
+	namespace, exists := user.Namespace()
+	if !exists {
+		return "", fmt.Errorf("unable to obtain namespace information")
+	}
+
+	if args[0] == "list" {
+		output := "Roles:"
+		for _, role := range rbac.NamespaceRoles(authorizer, namespace.Name()) {
+			scope := ""
+			if len(role.Namespace()) > 0 {
+				scope = " (this room only)"
+			}
+			output += fmt.Sprintf("<br /><span class='text-hl-name'>%s</span>%s:", role.Name(), scope)
+			for _, rule := range role.Rules() {
+				output += fmt.Sprintf("<br />&nbsp;&nbsp;%s (%s): %v", rule.Name(), rule.Effect(), rule.Actions())
+			}
+		}
+		return output, nil
+	}
+
+	if len(args) < 2 {
+		return "", fmt.Errorf("%v", h.usage)
+	}
+
+	roleName := args[1]
+	username := user.GetUsernameOrId()
+
+	switch args[0] {
+	case "create":
+		if _, err := rbac.CreateRole(authorizer, namespace.Name(), roleName); err != nil {
+			return "", fmt.Errorf("error: %v", err)
+		}
+
+		log.Printf("INF SOCKET CMD ROLE client %q with id (%s) created role %q in room %q\n", username, user.UUID(), roleName, namespace.Name())
+		return fmt.Sprintf("created role %q for this room. Use /%s grant %s &lt;action&gt; to add rules to it.", roleName, ROLE_NAME, roleName), nil
+	case "delete":
+		if err := rbac.DeleteRole(authorizer, namespace.Name(), roleName); err != nil {
+			return "", fmt.Errorf("error: %v", err)
+		}
+
+		log.Printf("INF SOCKET CMD ROLE client %q with id (%s) deleted role %q\n", username, user.UUID(), roleName)
+		return fmt.Sprintf("deleted role %q", roleName), nil
+	case "grant":
+		if len(args) < 3 {
+			return "", fmt.Errorf("%v", h.usage)
+		}
+
+		if err := rbac.Grant(authorizer, namespace.Name(), roleName, args[2:]...); err != nil {
+			return "", fmt.Errorf("error: %v", err)
+		}
+
+		log.Printf("INF SOCKET CMD ROLE client %q with id (%s) granted actions %v to role %q\n", username, user.UUID(), args[2:], roleName)
+		return fmt.Sprintf("granted %v to role %q", args[2:], roleName), nil
+	case "deny":
+		if len(args) < 3 {
+			return "", fmt.Errorf("%v", h.usage)
+		}
+
+		if err := rbac.Deny(authorizer, namespace.Name(), roleName, args[2:]...); err != nil {
+			return "", fmt.Errorf("error: %v", err)
+		}
+
+		log.Printf("INF SOCKET CMD ROLE client %q with id (%s) denied actions %v to role %q\n", username, user.UUID(), args[2:], roleName)
+		return fmt.Sprintf("denied %v to role %q", args[2:], roleName), nil
+	case "revoke":
+		if len(args) < 3 {
+			return "", fmt.Errorf("%v", h.usage)
+		}
+
+		revoked, err := rbac.Revoke(authorizer, namespace.Name(), roleName, args[2])
+		if err != nil {
+			return "", fmt.Errorf("error: %v", err)
+		}
+		if !revoked {
+			return "", fmt.Errorf("error: role %q has no rule containing the action %q", roleName, args[2])
+		}
+
+		log.Printf("INF SOCKET CMD ROLE client %q with id (%s) revoked action %q from role %q\n", username, user.UUID(), args[2], roleName)
+		return fmt.Sprintf("revoked %q from role %q", args[2], roleName), nil
+	}
+
+	return h.usage, nil
+}
+
+// explain describes why an action is allowed or denied for
+// the calling client, or for another client in the same room.
+func (h *RoleCmd) explain(cmdHandler SocketCommandHandler, args []string, user *client.Client, clientHandler client.SocketClientHandler) (string, error) {
+	authorizer := cmdHandler.Authorizer()
+	if authorizer == nil {
+		return "", fmt.Errorf("authorizer not enabled")
+	}
+
+	if len(args) < 2 {
+		return "", fmt.Errorf("%v", h.usage)
+	}
+
+	subject := user
+	if len(args) > 2 {
+		namespace, exists := user.Namespace()
+		if !exists {
+			return "", fmt.Errorf("unable to obtain namespace information")
+		}
+
+		var found bool
+		subject, found = clientInNamespace(clientHandler, namespace, args[2])
+		if !found {
+			return "", fmt.Errorf("error: unable to find subject %q in your namespace", args[2])
+		}
+	}
+
+	// explained actions use the same format as the
+	// actions computed for commands: "stream/skip"
+	action := strings.Trim(args[1], "/")
+	decision := authorizer.Explain(subject.Connection(), action)
+
+	output := fmt.Sprintf("%s: %s", subject.GetUsernameOrId(), decision.Reason())
+	output += fmt.Sprintf("<br />roles: %v", subjectRoleNames(cmdHandler, subject))
+	if len(decision.Matches) > 0 {
+		output += "<br />matching rules, by precedence:"
+		for _, m := range decision.Matches {
+			output += fmt.Sprintf("<br />&nbsp;&nbsp;%s %q via %q in role %q (specificity %v)", m.Rule.Effect(), m.Rule.Name(), m.Pattern, m.Role, m.Specificity)
+		}
+	}
+	if err := authorizer.ValidateAction(action); err != nil {
+		output += fmt.Sprintf("<br />warning: %v", err)
+	}
+
+	return output, nil
+}
+
 func NewCmdRole() SocketCommand {
 	return &RoleCmd{
 		Command{
@@ -209,7 +364,7 @@ func addRole(authorizer rbac.Authorizer, role rbac.Role, subject *client.Client)
 		// found binding for role, but subject not bound; add
 		b.AddSubject(subject)
 		subject.BroadcastSystemMessageTo(fmt.Sprintf("You have been assigned to the %q role", role.Name()))
-		subject.BroadcastAll("info_userlistupdated", &client.Response{
+		subject.BroadcastAll(protocol.EVENT_INFO_USERLISTUPDATED, &client.Response{
 			Id: subject.UUID(),
 		})
 		return nil
@@ -221,8 +376,32 @@ func addRole(authorizer rbac.Authorizer, role rbac.Role, subject *client.Client)
 	authorizer.Bind(role, subject)
 
 	subject.BroadcastSystemMessageTo(fmt.Sprintf("You have been assigned to the %q role", role.Name()))
-	subject.BroadcastAll("info_userlistupdated", &client.Response{
+	subject.BroadcastAll(protocol.EVENT_INFO_USERLISTUPDATED, &client.Response{
 		Id: subject.UUID(),
 	})
 	return nil
 }
+
+// subjectRoleNames returns the names of all roles a client is bound to,
+// or an empty list if access control is not enabled.
+// recordRoleChange adds an entry to a namespace's audit log
+// describing a role binding change made by the given user
+func recordRoleChange(namespace connection.Namespace, user *client.Client, action string, subject *client.Client) {
+	namespace.Audit().Record(audit.Entry{
+		Kind:    audit.KIND_ROLE,
+		Actor:   user.GetUsernameOrId(),
+		ActorId: user.UUID(),
+		Action:  action,
+		Allowed: true,
+		Detail:  fmt.Sprintf("subject %q (%s)", subject.GetUsernameOrId(), subject.UUID()),
+	})
+}
+
+func subjectRoleNames(cmdHandler SocketCommandHandler, user *client.Client) []string {
+	authorizer := cmdHandler.Authorizer()
+	if authorizer == nil {
+		return []string{}
+	}
+
+	return rbac.SubjectRoleNames(authorizer.Bindings(), user.Connection())
+}
@@ -0,0 +1,184 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/juanvallejo/streaming-server/pkg/playback"
+	"github.com/juanvallejo/streaming-server/pkg/socket/client"
+	"github.com/juanvallejo/streaming-server/pkg/socket/cmd/rbac"
+	"github.com/juanvallejo/streaming-server/pkg/socket/connection"
+	sockutil "github.com/juanvallejo/streaming-server/pkg/socket/util"
+	"github.com/juanvallejo/streaming-server/pkg/stream"
+)
+
+type RoomCmd struct {
+	Command
+}
+
+const (
+	ROOM_NAME        = "room"
+	ROOM_DESCRIPTION = "view or change settings for your room"
+	ROOM_USAGE       = "Usage: /" + ROOM_NAME + " limits [&lt;role&gt; &lt;maxItems|maxDuration|addsPerMinute&gt; &lt;value&gt;]|lock &lt;password&gt;|unlock|invite [ttl] [role]"
+)
+
+// SecretArgs marks the password given to "lock" as secret
+func (h *RoomCmd) SecretArgs(args []string) (int, bool) {
+	if len(args) > 1 && args[0] == "lock" {
+		return 1, true
+	}
+	return 0, false
+}
+
+func (h *RoomCmd) Execute(cmdHandler SocketCommandHandler, args []string, user *client.Client, clientHandler client.SocketClientHandler, playbackHandler playback.PlaybackHandler, streamHandler stream.StreamHandler) (string, error) {
+	if len(args) == 0 {
+		return h.usage, nil
+	}
+
+	username := user.GetUsernameOrId()
+
+	userRoom, hasRoom := user.Namespace()
+	if !hasRoom {
+		log.Printf("ERR SOCKET CLIENT client with id %q (%s) attempted to access room settings with no room assigned", user.UUID(), username)
+		return "", fmt.Errorf("error: you must be in a room to access its settings.")
+	}
+
+	sPlayback, sPlaybackExists := playbackHandler.PlaybackByNamespace(userRoom)
+	if !sPlaybackExists {
+		log.Printf("ERR SOCKET CLIENT unable to associate client %q (%s) in room %q with any stream playback objects", user.UUID(), username, userRoom)
+		return "", fmt.Errorf("error: no stream playback is currently loaded for your room")
+	}
+
+	switch args[0] {
+	case "lock":
+		if len(args) < 2 {
+			return "", fmt.Errorf("%v", h.usage)
+		}
+
+		// passwords may contain spaces
+		if err := userRoom.Access().Lock(strings.Join(args[1:], " ")); err != nil {
+			return "", fmt.Errorf("error: %v", err)
+		}
+
+		log.Printf("INF SOCKET CMD ROOM client %q with id (%s) locked room %q\n", username, user.UUID(), userRoom.Name())
+		user.BroadcastSystemMessageFrom(fmt.Sprintf("%q has locked the room. New users will need a password or an invite to join.", username))
+		return "the room is now locked. Users already in the room are unaffected.", nil
+	case "unlock":
+		if !userRoom.Access().Locked() {
+			return "", fmt.Errorf("error: the room is not locked")
+		}
+
+		userRoom.Access().Unlock()
+
+		log.Printf("INF SOCKET CMD ROOM client %q with id (%s) unlocked room %q\n", username, user.UUID(), userRoom.Name())
+		user.BroadcastSystemMessageFrom(fmt.Sprintf("%q has unlocked the room.", username))
+		return "the room is now unlocked.", nil
+	case "invite":
+		ttl := connection.DEFAULT_INVITE_TTL
+		if len(args) > 1 {
+			parsed, err := time.ParseDuration(args[1])
+			if err != nil || parsed <= 0 {
+				return "", fmt.Errorf("error: invalid invite duration %q: use a duration such as 30m, 2h or 48h", args[1])
+			}
+			ttl = parsed
+		}
+
+		role := ""
+		if len(args) > 2 {
+			role = args[2]
+			if err := canInviteWithRole(cmdHandler, user, userRoom, role); err != nil {
+				return "", err
+			}
+		}
+
+		token, err := userRoom.Access().NewInvite(ttl, role)
+		if err != nil {
+			return "", fmt.Errorf("error: %v", err)
+		}
+
+		log.Printf("INF SOCKET CMD ROOM client %q with id (%s) created an invite to room %q expiring in %v with role %q\n", username, user.UUID(), userRoom.Name(), ttl, role)
+
+		link := fmt.Sprintf("%s%s?%s=%s", sockutil.ROOM_URL_SEGMENT, url.PathEscape(userRoom.Name()), connection.ACCESS_INVITE_KEY, url.QueryEscape(token))
+		output := fmt.Sprintf("Invite link (expires in %v): <a href='%s'>%s</a>", ttl, link, link)
+		if len(role) > 0 {
+			output += fmt.Sprintf("<br />users joining with this link will be given the role %q", role)
+		}
+		return output, nil
+	case "limits":
+		limits := sPlayback.Limits()
+
+		if len(args) == 1 {
+			roles := []string{rbac.VIEWER_ROLE, rbac.USER_ROLE, rbac.ADMIN_ROLE}
+			for _, role := range limits.Roles() {
+				if role != rbac.VIEWER_ROLE && role != rbac.USER_ROLE && role != rbac.ADMIN_ROLE {
+					roles = append(roles, role)
+				}
+			}
+
+			output := "Queue limits for this room (0 means no limit):"
+			for _, role := range roles {
+				output += fmt.Sprintf("<br /><span class='text-hl-name'>%s</span>: %s", role, formatRoleLimits(limits.Role(role)))
+			}
+			output += fmt.Sprintf("<br /><br />Your limits: %s", formatRoleLimits(limits.For(subjectRoleNames(cmdHandler, user))))
+			return output, nil
+		}
+
+		if len(args) < 4 {
+			return "", fmt.Errorf("%v", h.usage)
+		}
+
+		role, limit, value := args[1], args[2], args[3]
+		if authorizer := cmdHandler.Authorizer(); authorizer != nil {
+			if _, exists := rbac.NamespaceRole(authorizer, userRoom.Name(), role); !exists {
+				return "", fmt.Errorf("error: role %q not found", role)
+			}
+		}
+
+		if err := limits.Set(role, limit, value); err != nil {
+			return "", fmt.Errorf("error: %v", err)
+		}
+
+		user.BroadcastSystemMessageFrom(fmt.Sprintf("%q has updated queue limits for role %q: %s", username, role, formatRoleLimits(limits.Role(role))))
+		return fmt.Sprintf("updated queue limits for role %q: %s", role, formatRoleLimits(limits.Role(role))), nil
+	}
+
+	return h.usage, nil
+}
+
+// canInviteWithRole returns an error if the given role does not exist in the
+// given namespace, or if the user is neither bound to it nor an admin. Users may not hand out
+// roles they could not otherwise use.
+func canInviteWithRole(cmdHandler SocketCommandHandler, user *client.Client, namespace connection.Namespace, role string) error {
+	authorizer := cmdHandler.Authorizer()
+	if authorizer == nil {
+		return fmt.Errorf("error: roles can only be assigned to invites when rbac is enabled")
+	}
+	if _, exists := rbac.NamespaceRole(authorizer, namespace.Name(), role); !exists {
+		return fmt.Errorf("error: role %q not found", role)
+	}
+
+	for _, name := range subjectRoleNames(cmdHandler, user) {
+		if name == role || name == rbac.ADMIN_ROLE {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("error: you may only invite users with a role you have been given")
+}
+
+func formatRoleLimits(l playback.RoleLimits) string {
+	return fmt.Sprintf("maxItems %v, maxDuration %vs, addsPerMinute %v", l.MaxItems, l.MaxDuration, l.AddsPerMinute)
+}
+
+func NewCmdRoom() SocketCommand {
+	return &RoomCmd{
+		Command{
+			name:        ROOM_NAME,
+			description: ROOM_DESCRIPTION,
+			usage:       ROOM_USAGE,
+		},
+	}
+}
@@ -2,16 +2,19 @@ package cmd
 
 import (
 	"fmt"
+	"html"
 	"log"
 	"strconv"
-
-	"encoding/json"
+	"time"
 
 	"github.com/juanvallejo/streaming-server/pkg/playback"
 	"github.com/juanvallejo/streaming-server/pkg/socket/client"
 	"github.com/juanvallejo/streaming-server/pkg/socket/cmd/util"
+	"github.com/juanvallejo/streaming-server/pkg/socket/connection"
+	"github.com/juanvallejo/streaming-server/pkg/socket/protocol"
 	sockutil "github.com/juanvallejo/streaming-server/pkg/socket/util"
 	"github.com/juanvallejo/streaming-server/pkg/stream"
+	"github.com/juanvallejo/streaming-server/pkg/timeutil"
 )
 
 type StreamCmd struct {
@@ -20,8 +23,8 @@ type StreamCmd struct {
 
 const (
 	STREAM_NAME        = "stream"
-	STREAM_DESCRIPTION = "controls stream playback (info|pause|play|stop|set|seek|skip)'"
-	STREAM_USAGE       = "Usage: /" + STREAM_NAME + " (info|pause|play|stop|skip|seek &lt;seconds&gt;|set &lt;url&gt;)"
+	STREAM_DESCRIPTION = "controls stream playback (info|pause|play|stop|set|seek|skip|schedule|loop)'"
+	STREAM_USAGE       = "Usage: /" + STREAM_NAME + " (info|pause|play|stop|skip|seek &lt;seconds&gt;|set &lt;url&gt; [start-end]|schedule &lt;time|+duration|list|cancel [id]&gt;|loop [off|one|queue])"
 )
 
 var (
@@ -56,18 +59,7 @@ func (h *StreamCmd) Execute(cmdHandler SocketCommandHandler, args []string, user
 
 	switch args[0] {
 	case "info":
-		status, err := sPlayback.GetStatus().Serialize()
-		if err != nil {
-			return "", err
-		}
-
-		m := make(map[string]interface{})
-		err = json.Unmarshal(status, &m)
-		if err != nil {
-			return "", err
-		}
-
-		output := "Stream info:<br />" + unpackMap(m, "")
+		output := "Stream info:<br />" + formatPlaybackStatus(sPlayback)
 		return output, nil
 	case "play":
 		// if a stream has not been set, fallthrough - allow "play"
@@ -90,7 +82,7 @@ func (h *StreamCmd) Execute(cmdHandler SocketCommandHandler, args []string, user
 				return "", err
 			}
 
-			user.BroadcastAll("streamsync", res)
+			user.BroadcastAll(protocol.EVENT_STREAMSYNC, res)
 			return "playing stream...", nil
 		}
 
@@ -98,20 +90,11 @@ func (h *StreamCmd) Execute(cmdHandler SocketCommandHandler, args []string, user
 		fallthrough
 	case "skip":
 		// skip the currently-playing stream and replace it with the next item in the queue
-		queue := sPlayback.GetQueue()
-		queueItem, err := queue.Next()
+		nextStream, err := sPlayback.Advance(username)
 		if err != nil {
 			return "", fmt.Errorf("error: %v", err)
 		}
 
-		nextStream, ok := queueItem.(stream.Stream)
-		if !ok {
-			return "", fmt.Errorf("error: expected next queue item to implement stream.Stream")
-		}
-
-		sPlayback.SetStream(nextStream)
-		sPlayback.Reset()
-
 		if playStreamOnSkip {
 			sPlayback.Play()
 		}
@@ -131,7 +114,7 @@ func (h *StreamCmd) Execute(cmdHandler SocketCommandHandler, args []string, user
 			streamIdentifier = nextStream.GetStreamURL()
 		}
 
-		user.BroadcastAll("streamload", res)
+		user.BroadcastAll(protocol.EVENT_STREAMLOAD, res)
 		user.BroadcastSystemMessageFrom(fmt.Sprintf("%q has attempted to load the next item in the queue: %q", username, streamIdentifier))
 		return fmt.Sprintf("attempting to load the next item in the queue: %q", streamIdentifier), nil
 	case "load":
@@ -143,12 +126,17 @@ func (h *StreamCmd) Execute(cmdHandler SocketCommandHandler, args []string, user
 			return "", err
 		}
 
-		s, err := sPlayback.GetOrCreateStreamFromUrl(url, user, streamHandler, func(data []byte, created bool, err error) {})
+		streamRange, err := getStreamRangeFromArgs(url, args)
+		if err != nil {
+			return "", err
+		}
+
+		s, err := sPlayback.GetOrCreateStreamFromUrl(url, streamRange, user, streamHandler, func(data []byte, created bool, err error) {})
 		if err != nil {
 			return "", err
 		}
 
-		sPlayback.SetStream(s)
+		sPlayback.SetStream(s, username)
 		sPlayback.Reset()
 
 		res := &client.Response{
@@ -161,10 +149,101 @@ func (h *StreamCmd) Execute(cmdHandler SocketCommandHandler, args []string, user
 			return "", err
 		}
 
-		user.BroadcastAll("streamload", res)
+		user.BroadcastAll(protocol.EVENT_STREAMLOAD, res)
 		user.BroadcastSystemMessageFrom(fmt.Sprintf("%q has attempted to load a %s stream: %q", username, s.GetKind(), url))
 
 		return fmt.Sprintf("attempting to load %q", args[1]), nil
+	case "loop":
+		if len(args) < 2 {
+			return fmt.Sprintf("the current loop mode is %q", sPlayback.LoopMode()), nil
+		}
+
+		mode, err := playback.ParseLoopMode(args[1])
+		if err != nil {
+			return "", fmt.Errorf("error: %v", err)
+		}
+
+		sPlayback.SetLoopMode(mode)
+
+		res := &client.Response{
+			Id:   user.UUID(),
+			From: username,
+		}
+
+		err = sockutil.SerializeIntoResponse(sPlayback.GetStatus(), &res.Extra)
+		if err != nil {
+			return "", err
+		}
+
+		user.BroadcastAll(protocol.EVENT_STREAMSYNC, res)
+		user.BroadcastSystemMessageFrom(fmt.Sprintf("%q has set the loop mode to %q", username, mode))
+		return fmt.Sprintf("setting the loop mode to %q...", mode), nil
+	case "schedule":
+		if len(args) < 2 {
+			return "", fmt.Errorf("%v", h.usage)
+		}
+
+		scheduler := sPlayback.Scheduler()
+
+		switch args[1] {
+		case "list":
+			pending := scheduler.Pending()
+			if len(pending) == 0 {
+				return "there are no scheduled starts for this room.", nil
+			}
+
+			output := "Scheduled starts:<br />"
+			for _, schedule := range pending {
+				output += fmt.Sprintf("<br /><span class='text-hl-name'>%v</span>: %s (in %v) - scheduled by %s", schedule.Id, schedule.StartsAt.Format("Jan 2 15:04:05"), schedule.StartsAt.Sub(sPlayback.Clock().Now()).Round(time.Second), schedule.CreatedBy)
+			}
+			return output, nil
+		case "cancel":
+			if len(args) < 3 {
+				if scheduler.CancelAll() == 0 {
+					return "", fmt.Errorf("error: there are no scheduled starts to cancel")
+				}
+
+				user.BroadcastSystemMessageFrom(fmt.Sprintf("%q has cancelled all scheduled starts", username))
+				return "cancelled all scheduled starts.", nil
+			}
+
+			id, err := strconv.Atoi(args[2])
+			if err != nil {
+				return "", fmt.Errorf("error: schedule id must be a number: %v", err)
+			}
+			if !scheduler.Cancel(id) {
+				return "", fmt.Errorf("error: no scheduled start found with id %v", id)
+			}
+
+			user.BroadcastSystemMessageFrom(fmt.Sprintf("%q has cancelled scheduled start %v", username, id))
+			return fmt.Sprintf("cancelled scheduled start %v.", id), nil
+		}
+
+		startsAt, err := util.ParseStartTime(args[1], sPlayback.Clock().Now())
+		if err != nil {
+			return "", fmt.Errorf("error: %v", err)
+		}
+
+		// schedules outlive their creator's connection; broadcast to the room
+		schedule, err := scheduler.Add(startsAt, username, func(schedule *playback.Schedule, remaining time.Duration) {
+			client.BroadcastNamespace(userRoom, protocol.EVENT_STREAMSCHEDULE, &client.Response{
+				From: client.USER_SYSTEM,
+				Extra: &protocol.StreamSchedule{
+					Id:        schedule.Id,
+					StartsAt:  schedule.StartsAt,
+					Remaining: remaining.Seconds(),
+				},
+			})
+			client.BroadcastSystemMessageNamespace(userRoom, fmt.Sprintf("the stream will start in %v", remaining))
+		}, func(schedule *playback.Schedule) {
+			startScheduledPlayback(userRoom, sPlayback, schedule)
+		})
+		if err != nil {
+			return "", err
+		}
+
+		user.BroadcastSystemMessageFrom(fmt.Sprintf("%q has scheduled the stream to start at %s", username, schedule.StartsAt.Format("Jan 2 15:04:05")))
+		return fmt.Sprintf("the stream will start at %s (in %v). Use /%s schedule cancel %v to cancel.", schedule.StartsAt.Format("Jan 2 15:04:05"), schedule.StartsAt.Sub(sPlayback.Clock().Now()).Round(time.Second), STREAM_NAME, schedule.Id), nil
 	}
 
 	// require stream data to have been loaded before proceeding with cases below
@@ -187,7 +266,7 @@ func (h *StreamCmd) Execute(cmdHandler SocketCommandHandler, args []string, user
 			return "", err
 		}
 
-		user.BroadcastAll("streamsync", res)
+		user.BroadcastAll(protocol.EVENT_STREAMSYNC, res)
 		return "pausing stream...", nil
 	case "stop":
 		sPlayback.Stop()
@@ -202,7 +281,7 @@ func (h *StreamCmd) Execute(cmdHandler SocketCommandHandler, args []string, user
 			return "", err
 		}
 
-		user.BroadcastAll("streamsync", res)
+		user.BroadcastAll(protocol.EVENT_STREAMSYNC, res)
 		return "stopping stream...", nil
 	case "seek":
 		if len(args) < 2 || len(args[1]) == 0 {
@@ -220,7 +299,7 @@ func (h *StreamCmd) Execute(cmdHandler SocketCommandHandler, args []string, user
 		newTime, err := strconv.Atoi(rawTime)
 		if err != nil {
 			// if an int was not received, try to parse human-readable time format (0h0m0s)
-			newTime, err = util.HumanTimeToSeconds(rawTime)
+			newTime, err = timeutil.HumanTimeToSeconds(rawTime)
 			if err != nil {
 				return "", fmt.Errorf("error: cannot interpret %q as a valid time. Must be of the form 12345 or 0h0m0s", args[1])
 			}
@@ -250,7 +329,7 @@ func (h *StreamCmd) Execute(cmdHandler SocketCommandHandler, args []string, user
 			return "", err
 		}
 
-		user.BroadcastAll("streamsync", res)
+		user.BroadcastAll(protocol.EVENT_STREAMSYNC, res)
 		return fmt.Sprintf("%s %vs for all clients.", message, newTime), nil
 	}
 
@@ -269,6 +348,44 @@ func NewCmdStream() SocketCommand {
 	}
 }
 
+// startScheduledPlayback loads the next item in the room's queue (if any) and
+// starts playback once a scheduled start time has been reached. Updates are
+// sent to the room's namespace, as the schedule's creator may have left it.
+func startScheduledPlayback(namespace connection.Namespace, sPlayback *playback.Playback, schedule *playback.Schedule) {
+	_, err := sPlayback.Advance(schedule.CreatedBy)
+	if _, exists := sPlayback.GetStream(); err != nil && !exists {
+		log.Printf("INF SOCKET CMD SCHEDULE scheduled start %v reached, but there is nothing to play.", schedule.Id)
+		client.BroadcastSystemMessageNamespace(namespace, "the scheduled start time was reached, but there is nothing in the queue to play.")
+		return
+	}
+
+	res := &client.Response{
+		From: schedule.CreatedBy,
+	}
+
+	err = sockutil.SerializeIntoResponse(sPlayback.GetStatus(), &res.Extra)
+	if err != nil {
+		log.Printf("ERR SOCKET CMD SCHEDULE unable to serialize playback status: %v", err)
+		return
+	}
+
+	client.BroadcastNamespace(namespace, protocol.EVENT_STREAMLOAD, res)
+
+	if err := sPlayback.Play(); err != nil {
+		log.Printf("ERR SOCKET CMD SCHEDULE unable to start scheduled playback: %v", err)
+		return
+	}
+
+	err = sockutil.SerializeIntoResponse(sPlayback.GetStatus(), &res.Extra)
+	if err != nil {
+		log.Printf("ERR SOCKET CMD SCHEDULE unable to serialize playback status: %v", err)
+		return
+	}
+
+	client.BroadcastNamespace(namespace, protocol.EVENT_STREAMSYNC, res)
+	client.BroadcastSystemMessageNamespace(namespace, "the scheduled stream is starting now.")
+}
+
 // receives a list of cmd args and returns the slice of the command corresponding to a stream url.
 // Returns an error if insufficient args are provided.
 func getStreamUrlFromArgs(args []string) (string, error) {
@@ -279,37 +396,63 @@ func getStreamUrlFromArgs(args []string) (string, error) {
 	return args[1], nil
 }
 
-// unpackMap receives a map of [string]interface{} and
-// unpacks all of its nested contents into a flat string
-func unpackMap(m map[string]interface{}, listItemLineBreak string) string {
-	output := ""
-	for k, v := range m {
-		if newMap, ok := v.(map[string]interface{}); ok {
-			output += unpackMap(newMap, listItemLineBreak)
-			continue
-		} else if newList, ok := v.([]interface{}); ok {
-			output += unpackList(newList, listItemLineBreak)
-			continue
+// getStreamRangeFromArgs receives a stream url and a list of command args.
+// A range given as the third argument ("12m-18m") takes precedence over a
+// start-time parameter found in the url ("?t=90").
+// Returns an empty range if neither is found, or an error if the range given
+// cannot be parsed.
+func getStreamRangeFromArgs(url string, args []string) (stream.StreamRange, error) {
+	if len(args) > 2 {
+		r, err := stream.ParseStreamRange(args[2])
+		if err != nil {
+			return stream.StreamRange{}, fmt.Errorf("error: invalid stream range: %v", err)
 		}
-		output += fmt.Sprintf("<br /><span class='text-hl-name'>%s</span>: %v", k, v)
+		return r, nil
 	}
-	return output
+
+	loc, err := stream.Canonicalize(url)
+	if err != nil {
+		return stream.StreamRange{}, nil
+	}
+
+	return stream.StreamRange{Start: loc.StartTime}, nil
+}
+
+// infoField formats a named field of a command's output
+func infoField(name string, value interface{}) string {
+	return fmt.Sprintf("<br /><span class='text-hl-name'>%s</span>: %v", name, value)
 }
 
-// unpackList receives a list of empty interface and
-// unpacks all of its items into a flat string
-func unpackList(l []interface{}, listItemLineBreak string) string {
+// formatPlaybackStatus formats the current stream
+// and playback status of a room, field by field
+func formatPlaybackStatus(sPlayback *playback.Playback) string {
 	output := ""
-	for _, v := range l {
-		if newMap, ok := v.(map[string]interface{}); ok {
-			output += fmt.Sprintf("%v%s", listItemLineBreak, unpackMap(newMap, listItemLineBreak))
-			continue
-		} else if newList, ok := v.([]interface{}); ok {
-			output += unpackList(newList, listItemLineBreak)
-			continue
-		}
-		output += fmt.Sprintf("<br />%v", v)
+	if s, exists := sPlayback.GetStream(); exists {
+		output += formatStream(s)
+	}
+
+	status := sPlayback.GetStatus()
+	output += infoField("queueLength", status.QueueLength)
+	output += infoField("startedBy", status.StartedBy)
+	output += infoField("createdBy", status.CreatedBy)
+	output += infoField("loopMode", status.LoopMode)
+	if status.Range != nil {
+		output += infoField("range", fmt.Sprintf("%v-%v", status.Range.Start, status.Range.End))
 	}
+	output += infoField("isPlaying", status.TimerStatus.IsPlaying)
+	output += infoField("isPaused", status.TimerStatus.IsPaused)
+	output += infoField("isStopped", status.TimerStatus.IsStopped)
+	output += infoField("time", status.TimerStatus.Time)
+	return output
+}
 
+// formatStream formats the information of a stream, field by field.
+// Stream names are set by clients, and are escaped.
+func formatStream(s stream.Stream) string {
+	output := infoField("name", html.EscapeString(s.GetName()))
+	output += infoField("url", html.EscapeString(s.GetStreamURL()))
+	output += infoField("kind", s.GetKind())
+	output += infoField("duration", s.GetDuration())
+	output += infoField("startTime", s.GetStartTime())
 	return output
 }
@@ -11,6 +11,7 @@ import (
 	"github.com/juanvallejo/streaming-server/pkg/playback"
 	"github.com/juanvallejo/streaming-server/pkg/socket/client"
 	"github.com/juanvallejo/streaming-server/pkg/socket/connection"
+	"github.com/juanvallejo/streaming-server/pkg/socket/protocol"
 	"github.com/juanvallejo/streaming-server/pkg/socket/util"
 	"github.com/juanvallejo/streaming-server/pkg/stream"
 )
@@ -53,11 +54,11 @@ func (h *SubtitlesCmd) Execute(cmdHandler SocketCommandHandler, args []string, u
 			return "", fmt.Errorf("error: no subtitles filepath specified")
 		}
 	} else if args[0] == "off" {
-		user.BroadcastAll("info_subtitles", &client.Response{
+		user.BroadcastAll(protocol.EVENT_INFO_SUBTITLES, &client.Response{
 			Id:   user.UUID(),
 			From: username,
-			Extra: map[string]interface{}{
-				"on": false,
+			Extra: &protocol.SubtitlesUpdate{
+				On: false,
 			},
 		})
 
@@ -80,12 +81,12 @@ func (h *SubtitlesCmd) Execute(cmdHandler SocketCommandHandler, args []string, u
 		return "", fmt.Errorf("error: unable to parse client-relative subtitles URL")
 	}
 
-	user.BroadcastAll("info_subtitles", &client.Response{
+	user.BroadcastAll(protocol.EVENT_INFO_SUBTITLES, &client.Response{
 		Id:   user.UUID(),
 		From: username,
-		Extra: map[string]interface{}{
-			"path": path.Join("/", clientRelativeSubtitlesFilepath[1]),
-			"on":   true,
+		Extra: &protocol.SubtitlesUpdate{
+			Path: path.Join("/", clientRelativeSubtitlesFilepath[1]),
+			On:   true,
 		},
 	})
 
@@ -33,7 +33,7 @@ func (h *UserCmd) Execute(cmdHandler SocketCommandHandler, args []string, user *
 			return h.usage, nil
 		}
 
-		err := util.UpdateClientUsername(user, args[1], clientHandler)
+		err := util.UpdateClientUsername(user, args[1], clientHandler, cmdHandler.Accounts())
 		if err != nil {
 			return "", err
 		}
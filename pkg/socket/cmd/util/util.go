@@ -2,62 +2,58 @@ package util
 
 import (
 	"fmt"
-	"regexp"
 	"strconv"
 	"strings"
-)
-
-func HumanTimeToSeconds(t string) (int, error) {
-	t = strings.ToLower(t)
+	"time"
 
-	re := regexp.MustCompile("(\\d+(h|m|s))")
-	matches := re.FindAllString(t, -1)
+	"github.com/juanvallejo/streaming-server/pkg/timeutil"
+)
 
-	if len(matches) == 0 {
-		return 0, fmt.Errorf("unable to parse string... invalid format")
+// CommandAction returns an "action" string from a given
+// command root and command args. A command with no args
+// is represented by its root alone.
+func CommandAction(root string, args []string) string {
+	if len(args) == 0 {
+		return root
 	}
+	return root + "/" + strings.Join(args, "/")
+}
 
-	tsecs := 0
-
-	for _, m := range matches {
-		secs := strings.Split(m, "s")
-		mins := strings.Split(m, "m")
-		hrs := strings.Split(m, "h")
-
-		if len(secs) > 1 {
+// ParseStartTime receives a relative time ("+10m", "+90") or an
+// absolute time of day ("21:00", "9pm", "9:30pm") and returns the
+// corresponding point in time relative to the given current time.
+// Absolute times of day that have already passed are interpreted
+// as belonging to the following day.
+func ParseStartTime(t string, now time.Time) (time.Time, error) {
+	t = strings.ToLower(strings.TrimSpace(t))
+	if len(t) == 0 {
+		return time.Time{}, fmt.Errorf("unable to parse empty time")
+	}
 
-			psecs, err := strconv.Atoi(secs[0])
+	if strings.HasPrefix(t, "+") {
+		secs, err := strconv.Atoi(t[1:])
+		if err != nil {
+			secs, err = timeutil.HumanTimeToSeconds(t[1:])
 			if err != nil {
-				return 0, fmt.Errorf("unable to parse seconds rune %q", secs[0])
+				return time.Time{}, fmt.Errorf("unable to parse relative time %q: must be of the form +12345 or +0h0m0s", t)
 			}
-			tsecs += psecs
-			continue
 		}
 
-		if len(mins) > 1 {
-			pmins, err := strconv.Atoi(mins[0])
-			if err != nil {
-				return 0, fmt.Errorf("unable to parse minutes rune %q", mins[0])
-			}
-			tsecs += pmins * 60
+		return now.Add(time.Duration(secs) * time.Second), nil
+	}
+
+	for _, layout := range []string{"15:04", "3pm", "3:04pm"} {
+		parsed, err := time.ParseInLocation(layout, t, now.Location())
+		if err != nil {
 			continue
 		}
 
-		if len(hrs) > 1 {
-			phrs, err := strconv.Atoi(hrs[0])
-			if err != nil {
-				return 0, fmt.Errorf("unable to parse hours rune %q", hrs[0])
-			}
-			tsecs += phrs * 60 * 60
-			continue
+		start := time.Date(now.Year(), now.Month(), now.Day(), parsed.Hour(), parsed.Minute(), 0, 0, now.Location())
+		if !start.After(now) {
+			start = start.AddDate(0, 0, 1)
 		}
+		return start, nil
 	}
 
-	return tsecs, nil
-}
-
-// CommandAction returns an "action" string from a given
-// command root and command args.
-func CommandAction(root string, args []string) string {
-	return root + "/" + strings.Join(args, "/")
+	return time.Time{}, fmt.Errorf("unable to parse time %q: must be of the form 21:00, 9pm, 9:30pm, or +0h0m0s", t)
 }
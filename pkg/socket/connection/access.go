@@ -0,0 +1,200 @@
+package connection
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// query parameters read from a socket connection
+	// request when admitting it into a locked namespace
+	ACCESS_PASSWORD_KEY = "password"
+	ACCESS_INVITE_KEY   = "invite"
+
+	DEFAULT_INVITE_TTL = 1 * time.Hour
+	MAX_INVITE_TTL     = 7 * 24 * time.Hour
+)
+
+// NamespaceAccess controls the admission of new connections into a namespace.
+// An unlocked namespace admits every connection. A locked namespace admits
+// only connections providing its password, or a valid invite token.
+type NamespaceAccess interface {
+	// Lock receives a password and requires it from new connections
+	Lock(string) error
+	// Unlock removes any password requirement
+	Unlock()
+	// Locked returns a boolean (true) if a password is required
+	Locked() bool
+	// NewInvite receives a time-to-live and an optional role name, and
+	// returns a signed invite token that admits a connection into the
+	// namespace until it expires, regardless of its password.
+	NewInvite(time.Duration, string) (string, error)
+	// Admit receives a password and an invite token, either of which may
+	// be empty, and returns the invite the connection was admitted with,
+	// or nil. Returns an error if the connection may not be admitted.
+	Admit(string, string) (*Invite, error)
+}
+
+// Invite is the signed payload of an invite token
+type Invite struct {
+	// Namespace is the id of the namespace the invite was issued for.
+	// Invites stop being valid once their namespace is reaped.
+	Namespace string `json:"ns"`
+	Expires   int64  `json:"exp"`
+	// Role is the name of an rbac role to bind to the invited
+	// connection in place of its default role, or empty.
+	Role  string `json:"role,omitempty"`
+	Nonce string `json:"nonce"`
+}
+
+// ExpiresAt returns the time at which the invite stops being valid
+func (i *Invite) ExpiresAt() time.Time {
+	return time.Unix(i.Expires, 0)
+}
+
+// NamespaceAccessSpec implements NamespaceAccess
+type NamespaceAccessSpec struct {
+	mux sync.Mutex
+
+	namespaceId string
+	// key signs invite tokens and salts the stored password hash
+	key          []byte
+	passwordHash []byte
+}
+
+func (a *NamespaceAccessSpec) Lock(password string) error {
+	if len(password) == 0 {
+		return fmt.Errorf("a password is required to lock a room")
+	}
+
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	a.passwordHash = a.sign([]byte(password))
+	return nil
+}
+
+func (a *NamespaceAccessSpec) Unlock() {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	a.passwordHash = nil
+}
+
+func (a *NamespaceAccessSpec) Locked() bool {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	return len(a.passwordHash) > 0
+}
+
+func (a *NamespaceAccessSpec) NewInvite(ttl time.Duration, role string) (string, error) {
+	if ttl <= 0 {
+		ttl = DEFAULT_INVITE_TTL
+	}
+	if ttl > MAX_INVITE_TTL {
+		return "", fmt.Errorf("invites may not last longer than %v", MAX_INVITE_TTL)
+	}
+
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(&Invite{
+		Namespace: a.namespaceId,
+		Expires:   time.Now().Add(ttl).Unix(),
+		Role:      role,
+		Nonce:     base64.RawURLEncoding.EncodeToString(nonce),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(a.sign(payload)), nil
+}
+
+func (a *NamespaceAccessSpec) Admit(password, token string) (*Invite, error) {
+	if len(token) > 0 {
+		invite, err := a.verifyInvite(token)
+		if err != nil {
+			return nil, err
+		}
+		return invite, nil
+	}
+
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	if len(a.passwordHash) == 0 {
+		return nil, nil
+	}
+	if len(password) == 0 {
+		return nil, fmt.Errorf("this room is locked: a password or invite is required")
+	}
+	if !hmac.Equal(a.passwordHash, a.sign([]byte(password))) {
+		return nil, fmt.Errorf("incorrect room password")
+	}
+
+	return nil, nil
+}
+
+// verifyInvite receives an invite token and returns its payload.
+// Returns an error if the token was not signed by this namespace,
+// or if it has expired.
+func (a *NamespaceAccessSpec) verifyInvite(token string) (*Invite, error) {
+	segs := strings.Split(token, ".")
+	if len(segs) != 2 {
+		return nil, fmt.Errorf("malformed invite token")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(segs[0])
+	if err != nil {
+		return nil, fmt.Errorf("malformed invite token: %v", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(segs[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed invite token: %v", err)
+	}
+	if !hmac.Equal(sig, a.sign(payload)) {
+		return nil, fmt.Errorf("invalid invite token")
+	}
+
+	invite := &Invite{}
+	if err := json.Unmarshal(payload, invite); err != nil {
+		return nil, fmt.Errorf("malformed invite token: %v", err)
+	}
+	if invite.Namespace != a.namespaceId {
+		return nil, fmt.Errorf("invite token was issued for a different room")
+	}
+	if time.Now().After(invite.ExpiresAt()) {
+		return nil, fmt.Errorf("invite token expired at %v", invite.ExpiresAt().Format(time.RFC1123))
+	}
+
+	return invite, nil
+}
+
+func (a *NamespaceAccessSpec) sign(data []byte) []byte {
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// NewNamespaceAccess receives a namespace id and returns an unlocked
+// NamespaceAccess with a random signing key. Invites are only valid
+// for the NamespaceAccess that issued them.
+func NewNamespaceAccess(namespaceId string) (NamespaceAccess, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	return &NamespaceAccessSpec{
+		namespaceId: namespaceId,
+		key:         key,
+	}, nil
+}
@@ -0,0 +1,102 @@
+package connection
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// maxIdleAttemptBuckets is the amount of attempt buckets kept before
+// buckets that have refilled completely are discarded
+const maxIdleAttemptBuckets = 1024
+
+// AttemptLimiter limits attempts at an action, such as logging in, by key,
+// such as an address or a username. Each key has a token bucket; each
+// attempt takes a token from it.
+type AttemptLimiter struct {
+	limit RateLimit
+
+	mux     sync.Mutex
+	buckets map[string]*bucket
+}
+
+// Take takes a token from the bucket of every given key. Returns a
+// boolean (false) if any bucket was empty, in which case no token is
+// taken, along with the time until that bucket holds a token again.
+func (l *AttemptLimiter) Take(now time.Time, keys ...string) (bool, time.Duration) {
+	if l.limit.Rate <= 0 {
+		return true, 0
+	}
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	taken := []*bucket{}
+	for _, key := range keys {
+		b, exists := l.buckets[key]
+		if !exists {
+			b = &bucket{}
+			l.buckets[key] = b
+		}
+		if !b.take(l.limit, now) {
+			for _, t := range taken {
+				t.tokens++
+			}
+			return false, l.retryAfter(b)
+		}
+		taken = append(taken, b)
+	}
+
+	l.prune(now)
+	return true, 0
+}
+
+// Allowed returns a boolean (true) if the bucket of a key holds a token,
+// without taking it, or the time until it holds a token again.
+func (l *AttemptLimiter) Allowed(now time.Time, key string) (bool, time.Duration) {
+	if l.limit.Rate <= 0 {
+		return true, 0
+	}
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	b, exists := l.buckets[key]
+	if !exists {
+		return true, 0
+	}
+
+	peek := *b
+	if !peek.take(l.limit, now) {
+		return false, l.retryAfter(&peek)
+	}
+	return true, 0
+}
+
+// retryAfter returns the time until an empty bucket holds a token
+func (l *AttemptLimiter) retryAfter(b *bucket) time.Duration {
+	secs := math.Ceil((1 - b.tokens) / l.limit.Rate)
+	return time.Duration(secs) * time.Second
+}
+
+// prune discards buckets that have refilled completely, once
+// there are more than maxIdleAttemptBuckets of them
+func (l *AttemptLimiter) prune(now time.Time) {
+	if len(l.buckets) <= maxIdleAttemptBuckets {
+		return
+	}
+
+	for key, b := range l.buckets {
+		refilled := b.tokens + now.Sub(b.last).Seconds()*l.limit.Rate
+		if refilled >= float64(l.limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func NewAttemptLimiter(limit RateLimit) *AttemptLimiter {
+	return &AttemptLimiter{
+		limit:   limit,
+		buckets: make(map[string]*bucket),
+	}
+}
@@ -0,0 +1,55 @@
+package connection
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAttemptLimiter(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 20, 0, 0, 0, time.UTC)
+	l := NewAttemptLimiter(RateLimit{Rate: 1.0 / 60, Burst: 3})
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Take(now, "10.0.0.1", "alice"); !ok {
+			t.Fatalf("expected attempt %v to be allowed", i+1)
+		}
+	}
+
+	ok, retry := l.Take(now, "10.0.0.1", "alice")
+	if ok {
+		t.Fatalf("expected an attempt beyond the burst to be refused")
+	}
+	if retry != time.Minute {
+		t.Fatalf("expected to be told to retry in %v, got %v", time.Minute, retry)
+	}
+	if ok, _ := l.Allowed(now, "alice"); ok {
+		t.Fatalf("expected an exhausted key not to be allowed")
+	}
+
+	// a refused attempt takes no token from keys that had one
+	if ok, _ := l.Take(now, "10.0.0.2"); !ok {
+		t.Fatalf("expected a different key to be allowed")
+	}
+	if ok, _ := l.Take(now, "10.0.0.2", "alice"); ok {
+		t.Fatalf("expected an attempt naming an exhausted key to be refused")
+	}
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Take(now, "10.0.0.2"); !ok {
+			t.Fatalf("expected refused attempts not to count against other keys")
+		}
+	}
+
+	now = now.Add(time.Minute)
+	if ok, _ := l.Allowed(now, "alice"); !ok {
+		t.Fatalf("expected a key to be allowed again once a token is refilled")
+	}
+	if ok, _ := l.Allowed(now, "alice"); !ok {
+		t.Fatalf("expected Allowed not to take a token")
+	}
+	if ok, _ := l.Take(now, "alice"); !ok {
+		t.Fatalf("expected a refilled key to be allowed")
+	}
+	if ok, _ := l.Take(now, "alice"); ok {
+		t.Fatalf("expected a single token to have been refilled")
+	}
+}
@@ -1,10 +1,13 @@
 package connection
 
 import (
+	"bytes"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
 	"net/http"
 	"time"
 
@@ -19,36 +22,37 @@ type MessageDataCodec interface {
 	Serialize() ([]byte, error)
 }
 
+// MessageData is the data of a message received from a client. Event
+// callbacks decode it into the typed request of their event with
+// protocol.Decode.
 type MessageData interface {
 	MessageDataCodec
-
-	Key(string) (value interface{}, keyExists bool)
-	Set(string, interface{})
 }
 
-// MessageDataSchema implements MessageData
-type MessageDataSchema map[string]interface{}
+// MessageDataSchema implements MessageData. It holds the json
+// object a message was received with, as it was received.
+type MessageDataSchema struct {
+	raw json.RawMessage
+}
 
 func (d *MessageDataSchema) Serialize() ([]byte, error) {
-	b, err := json.Marshal(d)
-	if err != nil {
-		return []byte{}, err
+	if len(d.raw) == 0 {
+		return []byte("{}"), nil
 	}
 
-	return b, nil
+	return d.raw, nil
 }
 
-func (d *MessageDataSchema) Key(key string) (interface{}, bool) {
-	val := (*d)[key]
-	if val == nil {
-		return nil, false
+// UnmarshalJSON stores the data of a received message,
+// which must be a json object
+func (d *MessageDataSchema) UnmarshalJSON(b []byte) error {
+	trimmed := bytes.TrimSpace(b)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return fmt.Errorf("message data must be a json object")
 	}
 
-	return val, true
-}
-
-func (d *MessageDataSchema) Set(key string, val interface{}) {
-	(*d)[key] = val
+	d.raw = append(json.RawMessage{}, trimmed...)
+	return nil
 }
 
 func NewMessageData() MessageData {
@@ -56,28 +60,184 @@ func NewMessageData() MessageData {
 }
 
 type Message struct {
+	// Id is an optional identifier chosen by the client for a message
+	// it sends. Replies to the message echo it back.
+	Id    string           `json:"id,omitempty"`
 	Event string           `json:"event"`
 	Data  MessageDataCodec `json:"data"`
 }
 
+// RequestData is the data of a message received from a client, along
+// with the id the client sent the message with, if any. Event callbacks
+// receive it as their MessageDataCodec.
+type RequestData struct {
+	MessageData
+
+	Id string
+}
+
+// RequestId receives the data passed to an event callback and returns
+// the id of the message it was received with, or an empty string if
+// the message had no id.
+func RequestId(data MessageDataCodec) string {
+	if req, ok := data.(*RequestData); ok {
+		return req.Id
+	}
+	return ""
+}
+
 type ConnectionMetadata interface {
 	CreationTimestamp() time.Time
+	// LastActivity returns the time a message was last
+	// received on the connection, or its creation time
+	LastActivity() time.Time
+	// MarkActivity records that a message was received on the connection
+	MarkActivity()
+	// Invite returns the invite the connection was admitted
+	// into its namespace with, or a boolean (false) if none.
+	Invite() (*Invite, bool)
+	// SetInvite stores the invite the connection was admitted with
+	SetInvite(*Invite)
+	// Identity returns the browser identity the connection was made with
+	Identity() string
+	// SetIdentity stores the browser identity the connection was made with
+	SetIdentity(string)
+	// Account returns the username of the account logged in
+	// on the connection, or a boolean (false) if none is.
+	Account() (string, bool)
+	// SetAccount stores the username of the account logged in on
+	// the connection. An empty username logs the account out.
+	SetAccount(string)
+	// ProtocolVersion returns the socket protocol version negotiated for the connection
+	ProtocolVersion() int
+	// SetProtocolVersion stores the socket protocol version negotiated for the connection
+	SetProtocolVersion(int)
+	// Resumed returns the session the connection resumed,
+	// or a boolean (false) if it started a new one.
+	Resumed() (*ResumableSession, bool)
+	// SetResumed stores the session the connection resumed
+	SetResumed(*ResumableSession)
+	// ApiToken returns a secret known only to the server and to the
+	// client of the connection. Api requests made on behalf of the
+	// connection must carry it, since connection ids are shared with
+	// every other client in the connection's namespace.
+	ApiToken() string
 }
 
 type ConnectionMetadataSpec struct {
 	creationTimestamp time.Time
+	// unix time in nanoseconds; written by the connection's
+	// read loop, and read by other goroutines
+	lastActivity int64
+
+	// set before the connection is handled
+	protocolVersion int
+
+	// never changes once set
+	apiToken string
+
+	// guards invite, identity, account and resumed
+	mux      sync.RWMutex
+	invite   *Invite
+	identity string
+	account  string
+	resumed  *ResumableSession
 }
 
 func (m *ConnectionMetadataSpec) CreationTimestamp() time.Time {
 	return m.creationTimestamp
 }
 
+func (m *ConnectionMetadataSpec) LastActivity() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&m.lastActivity))
+}
+
+func (m *ConnectionMetadataSpec) MarkActivity() {
+	atomic.StoreInt64(&m.lastActivity, time.Now().UnixNano())
+}
+
+func (m *ConnectionMetadataSpec) Invite() (*Invite, bool) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	return m.invite, m.invite != nil
+}
+
+func (m *ConnectionMetadataSpec) SetInvite(invite *Invite) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.invite = invite
+}
+
+func (m *ConnectionMetadataSpec) Identity() string {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	return m.identity
+}
+
+func (m *ConnectionMetadataSpec) SetIdentity(identity string) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.identity = identity
+}
+
+func (m *ConnectionMetadataSpec) Account() (string, bool) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	return m.account, len(m.account) > 0
+}
+
+func (m *ConnectionMetadataSpec) SetAccount(username string) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.account = username
+}
+
+func (m *ConnectionMetadataSpec) ProtocolVersion() int {
+	return m.protocolVersion
+}
+
+func (m *ConnectionMetadataSpec) SetProtocolVersion(version int) {
+	m.protocolVersion = version
+}
+
+func (m *ConnectionMetadataSpec) Resumed() (*ResumableSession, bool) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	return m.resumed, m.resumed != nil
+}
+
+func (m *ConnectionMetadataSpec) SetResumed(session *ResumableSession) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.resumed = session
+}
+
+func (m *ConnectionMetadataSpec) ApiToken() string {
+	return m.apiToken
+}
+
 func NewConnectionMetadata() ConnectionMetadata {
+	// generate an api token for this connection, or panic
+	token, err := util.GenerateToken()
+	if err != nil {
+		log.Panic(fmt.Sprintf("unable to generate api token for new socket connection: %v", err))
+	}
+
+	now := time.Now()
 	return &ConnectionMetadataSpec{
-		creationTimestamp: time.Now(),
+		creationTimestamp: now,
+		lastActivity:      now.UnixNano(),
+		apiToken:          token,
 	}
 }
 
+// VerifyApiToken returns true if the given token
+// is the api token of the given connection
+func VerifyApiToken(conn Connection, token string) bool {
+	expected := conn.Metadata().ApiToken()
+	return len(expected) > 0 && subtle.ConstantTimeCompare([]byte(expected), []byte(token)) == 1
+}
+
 type SocketEventCallback func(MessageDataCodec)
 
 type Connection interface {
@@ -89,6 +249,18 @@ type Connection interface {
 	BroadcastFrom(string, string, []byte)
 	// Metadata returns ConnectionMetadata for the current connection
 	Metadata() ConnectionMetadata
+	// Disconnect receives a reason, and once every message already queued
+	// has been written, sends it to the connection in a close message and
+	// closes the underlying socket. Returns an error if the connection
+	// has already been closed.
+	Disconnect(string) error
+	// Close stops the connection's writer, discarding any queued
+	// messages, and closes the underlying socket
+	Close() error
+	// Enqueue receives a message type, the name of the event the message
+	// carries, and the message, and queues it to be written to the connection.
+	// Returns an error if the connection is closed or its queue is full.
+	Enqueue(int, string, []byte) error
 	// Connections returns socket connections that are in the same namespace as the connection
 	Connections() []Connection
 	// Emit iterates through all stored SocketEventCallback functions and calls
@@ -113,9 +285,11 @@ type Connection interface {
 	ResponseWriter() http.ResponseWriter
 	// Request returns the saved http.Request for this connection
 	Request() *http.Request
-	// Send receives an array of bytes to send to the connection
-	Send([]byte)
-	// WriteMessage sends a text message as an array of bytes to the connection
+	// Send receives an event name and the text message carrying it,
+	// and queues the message to be sent to the connection
+	Send(string, []byte)
+	// WriteMessage writes a message as an array of bytes to the connection
+	// right away, waiting at most WriteTimeout for the write to complete
 	WriteMessage(int, []byte) error
 }
 
@@ -130,8 +304,11 @@ type SocketConn struct {
 	httpReq    *http.Request
 	nsHandler  NamespaceHandler
 	ns         string
+	writer     *writer
 
+	// mutex serializes writes to the websocket; nsMux guards ns
 	mutex sync.Mutex
+	nsMux sync.RWMutex
 }
 
 func (c *SocketConn) On(eventName string, callback SocketEventCallback) {
@@ -158,8 +335,17 @@ func (c *SocketConn) UUID() string {
 	return c.connId
 }
 
-func (c *SocketConn) Send(data []byte) {
-	c.WriteMessage(websocket.TextMessage, data)
+func (c *SocketConn) Send(eventName string, data []byte) {
+	// messages that cannot be queued are counted as dropped
+	c.Enqueue(websocket.TextMessage, eventName, data)
+}
+
+func (c *SocketConn) Enqueue(messageType int, eventName string, data []byte) error {
+	return c.writer.enqueue(&outboundMessage{
+		messageType: messageType,
+		event:       eventName,
+		data:        data,
+	})
 }
 
 func (c *SocketConn) BroadcastFrom(roomName, eventName string, data []byte) {
@@ -171,11 +357,12 @@ func (c *SocketConn) Broadcast(roomName, eventName string, data []byte) {
 }
 
 func (c *SocketConn) Connections() []Connection {
-	if len(c.ns) == 0 {
+	ns := c.namespaceName()
+	if len(ns) == 0 {
 		return []Connection{}
 	}
 
-	namespace, exists := c.nsHandler.NamespaceByName(c.ns)
+	namespace, exists := c.nsHandler.NamespaceByName(ns)
 	if !exists {
 		return []Connection{}
 	}
@@ -187,8 +374,37 @@ func (c *SocketConn) Metadata() ConnectionMetadata {
 	return c.metadata
 }
 
+func (c *SocketConn) Disconnect(reason string) error {
+	if !c.writer.close(true, c.closeWithReason(reason)) {
+		return fmt.Errorf("connection (%s) is already closed", c.connId)
+	}
+	return nil
+}
+
+func (c *SocketConn) Close() error {
+	c.writer.close(false, nil)
+	return c.Conn.Close()
+}
+
+// closeWithReason returns a func that sends the given reason
+// to the connection in a close message, and closes the socket
+func (c *SocketConn) closeWithReason(reason string) func() {
+	return func() {
+		c.mutex.Lock()
+		err := c.Conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason), time.Now().Add(time.Second))
+		c.mutex.Unlock()
+		if err != nil {
+			log.Printf("WRN SOCKET CONN unable to send close message to connection (%s): %v", c.connId, err)
+		}
+
+		c.Conn.Close()
+	}
+}
+
 func (c *SocketConn) Join(roomName string) {
+	c.nsMux.Lock()
 	c.ns = roomName
+	c.nsMux.Unlock()
 	c.nsHandler.AddToNamespace(roomName, c)
 }
 
@@ -197,16 +413,28 @@ func (c *SocketConn) Leave(roomName string) {
 }
 
 func (c *SocketConn) Namespace() (Namespace, bool) {
-	return c.nsHandler.NamespaceByName(c.ns)
+	return c.nsHandler.NamespaceByName(c.namespaceName())
+}
+
+func (c *SocketConn) namespaceName() string {
+	c.nsMux.RLock()
+	defer c.nsMux.RUnlock()
+	return c.ns
 }
 
 func (c *SocketConn) ReadMessage() (int, []byte, error) {
-	return c.Conn.ReadMessage()
+	mType, data, err := c.Conn.ReadMessage()
+	if err == nil {
+		c.extendReadDeadline()
+	}
+	return mType, data, err
 }
 
 func (c *SocketConn) WriteMessage(messageType int, data []byte) error {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
+
+	c.Conn.SetWriteDeadline(time.Now().Add(WriteTimeout))
 	return c.Conn.WriteMessage(messageType, data)
 }
 
@@ -229,7 +457,7 @@ func NewConnection(nsHandler NamespaceHandler, ws *websocket.Conn, w http.Respon
 }
 
 func NewConnectionWithUUID(uuid string, nsHandler NamespaceHandler, ws *websocket.Conn, w http.ResponseWriter, r *http.Request) Connection {
-	return &SocketConn{
+	conn := &SocketConn{
 		Conn: ws,
 
 		metadata:   NewConnectionMetadata(),
@@ -239,4 +467,10 @@ func NewConnectionWithUUID(uuid string, nsHandler NamespaceHandler, ws *websocke
 		callbacks:  make(map[string][]SocketEventCallback),
 		nsHandler:  nsHandler,
 	}
+
+	ws.SetReadLimit(MaxMessageSize)
+	conn.initHeartbeat()
+	conn.writer = newWriter(conn)
+	go conn.writer.run()
+	return conn
 }
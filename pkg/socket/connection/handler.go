@@ -1,12 +1,16 @@
 package connection
 
 import (
-	"encoding/json"
 	"log"
 	"net/http"
+	"runtime/debug"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/juanvallejo/streaming-server/pkg/account"
+	"github.com/juanvallejo/streaming-server/pkg/account/oidc"
 	"github.com/juanvallejo/streaming-server/pkg/socket/cmd/rbac"
 )
 
@@ -14,6 +18,16 @@ import (
 type ConnectionHandler interface {
 	// Authorizer returns an RBAC authorizer or nil
 	Authorizer() rbac.Authorizer
+	// Accounts returns a user account manager or nil
+	Accounts() account.Manager
+	// SetAccounts receives a user account manager,
+	// enabling user accounts for new connections
+	SetAccounts(account.Manager)
+	// IdentityProvider returns an OpenID Connect provider or nil
+	IdentityProvider() oidc.Provider
+	// SetIdentityProvider receives an OpenID Connect provider,
+	// enabling logging in to user accounts through it
+	SetIdentityProvider(oidc.Provider)
 	// NewConnection instantiates a new Connection
 	// if a non-empty uuid string is given, a new
 	// connection is spawned with the given uuid.
@@ -33,16 +47,36 @@ type ConnectionHandler interface {
 	Handle(Connection)
 }
 
-// ConnHandler implements Handler
+// ConnHandler implements Handler. Connections are added and removed
+// by the server and their own read goroutines; connsById is guarded by mux.
 type ConnHandler struct {
 	nsHandler NamespaceHandler
+	mux       sync.RWMutex
 	connsById map[string]Connection
+	accounts  account.Manager
+	provider  oidc.Provider
 }
 
 func (h *ConnHandler) Authorizer() rbac.Authorizer {
 	return nil
 }
 
+func (h *ConnHandler) Accounts() account.Manager {
+	return h.accounts
+}
+
+func (h *ConnHandler) SetAccounts(accounts account.Manager) {
+	h.accounts = accounts
+}
+
+func (h *ConnHandler) IdentityProvider() oidc.Provider {
+	return h.provider
+}
+
+func (h *ConnHandler) SetIdentityProvider(provider oidc.Provider) {
+	h.provider = provider
+}
+
 func (h *ConnHandler) NewConnection(uuid string, ws *websocket.Conn, w http.ResponseWriter, r *http.Request) Connection {
 	var c Connection
 	if len(uuid) > 0 {
@@ -51,11 +85,17 @@ func (h *ConnHandler) NewConnection(uuid string, ws *websocket.Conn, w http.Resp
 		c = NewConnection(h.nsHandler, ws, w, r)
 	}
 
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
 	h.connsById[c.UUID()] = c
 	return c
 }
 
 func (h *ConnHandler) Connection(uuid string) (Connection, bool) {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+
 	c, exists := h.connsById[uuid]
 	if !exists {
 		return nil, exists
@@ -65,7 +105,12 @@ func (h *ConnHandler) Connection(uuid string) (Connection, bool) {
 }
 
 func (h *ConnHandler) DeleteConnection(conn Connection) {
-	if _, exists := h.connsById[conn.UUID()]; exists {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	// a resumed session reuses the uuid of the connection it replaces;
+	// only remove the entry if it still belongs to this connection
+	if c, exists := h.connsById[conn.UUID()]; exists && c == conn {
 		delete(h.connsById, conn.UUID())
 	}
 }
@@ -103,6 +148,12 @@ func NewHandlerWithRBAC(authorizer rbac.Authorizer, nsHandler NamespaceHandler)
 }
 
 func HandleConnection(handler ConnectionHandler, conn Connection) {
+	limiter := newRateLimiter(conn.Request())
+	defer limiter.close()
+
+	// flooded is set once the connection is being disconnected for
+	// flooding; messages it already sent are read, but not handled
+	var flooded bool
 	for {
 		var connClosed bool
 
@@ -111,30 +162,71 @@ func HandleConnection(handler ConnectionHandler, conn Connection) {
 			connClosed = true
 			if strings.HasPrefix(err.Error(), "websocket: close") || websocket.IsCloseError(err) {
 				mType = websocket.CloseGoingAway
+			} else if err == websocket.ErrReadLimit {
+				log.Printf("WRN WS HANDLE connection (%s) sent a message larger than %v bytes; closing...", conn.UUID(), MaxMessageSize)
+			} else if IsTimeout(err) {
+				log.Printf("INF WS HANDLE connection (%s) did not answer heartbeats for %v; closing...", conn.UUID(), PongTimeout)
 			} else {
 				log.Printf("ERR WS HANDLE %v", err)
 			}
 		}
 
 		if mType == websocket.CloseMessage || mType == websocket.CloseGoingAway || connClosed {
-			conn.Emit("disconnection", NewMessageData())
+			conn.Emit(DISCONNECTION_EVENT, NewMessageData())
 			handler.DeleteConnection(conn)
+			conn.Close()
 			break
 		}
 
 		if mType == websocket.TextMessage {
-			var message Message
-			message.Data = NewMessageData()
-			err := json.Unmarshal(data, &message)
+			message, messageData, err := DecodeMessage(data)
 			if err != nil {
-				log.Printf("ERR WS HANDLE received non-json message: %v: %v", string(data), err)
+				log.Printf("ERR WS HANDLE dropping malformed message from connection (%s): %v", conn.UUID(), err)
 				continue
 			}
 
-			conn.Emit(message.Event, message.Data)
+			if flooded {
+				continue
+			}
+			conn.Metadata().MarkActivity()
+
+			if internalEvents[message.Event] {
+				log.Printf("WRN WS HANDLE connection (%s) sent internal event %q; ignoring...", conn.UUID(), message.Event)
+				continue
+			}
+
+			verdict, abusive := limiter.check(message.Id, message.Event, isCommand(message.Event, messageData), time.Now())
+			if verdict != nil {
+				logRateLimited(conn, verdict)
+				if abusive {
+					log.Printf("INF WS HANDLE disconnecting connection (%s) for flooding", conn.UUID())
+					conn.Disconnect("rate limit exceeded")
+					flooded = true
+					continue
+				}
+				dispatch(conn, RATE_LIMITED_EVENT, verdict)
+				continue
+			}
+
+			dispatch(conn, message.Event, &RequestData{
+				MessageData: messageData,
+				Id:          message.Id,
+			})
 			continue
 		}
 
 		log.Printf("WRN WS HANDLE received non-text message from the client: %v", data)
 	}
 }
+
+// dispatch emits an event on a connection, recovering from a panic in any
+// of its callbacks, so that a malformed message cannot bring down the server
+func dispatch(conn Connection, event string, data MessageDataCodec) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("ERR WS HANDLE recovered from panic handling %q event from connection (%s): %v\n%s", event, conn.UUID(), r, debug.Stack())
+		}
+	}()
+
+	conn.Emit(event, data)
+}
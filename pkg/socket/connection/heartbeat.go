@@ -0,0 +1,59 @@
+package connection
+
+import (
+	"net"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	// PingInterval is the amount of time between ping control frames sent
+	// to each connection. Heartbeats are disabled if set to zero.
+	PingInterval = 30 * time.Second
+
+	// PongTimeout is the amount of time a connection may go without sending
+	// a pong, or any other message, before it is considered dead and closed.
+	// Must be greater than PingInterval.
+	PongTimeout = 75 * time.Second
+)
+
+// heartbeatsEnabled returns a boolean (true) if connections are pinged
+func heartbeatsEnabled() bool {
+	return PingInterval > 0 && PongTimeout > 0
+}
+
+// extendReadDeadline gives the connection another PongTimeout to send its next
+// pong or message. Called whenever the connection is heard from.
+func (c *SocketConn) extendReadDeadline() {
+	if !heartbeatsEnabled() {
+		return
+	}
+	c.Conn.SetReadDeadline(time.Now().Add(PongTimeout))
+}
+
+// initHeartbeat arms the connection's read deadline, and extends
+// it every time the connection answers a ping
+func (c *SocketConn) initHeartbeat() {
+	if !heartbeatsEnabled() {
+		return
+	}
+
+	c.extendReadDeadline()
+	c.Conn.SetPongHandler(func(string) error {
+		c.extendReadDeadline()
+		return nil
+	})
+}
+
+// ping sends a ping control frame to the connection
+func (c *SocketConn) ping() error {
+	return c.Conn.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(WriteTimeout))
+}
+
+// IsTimeout returns a boolean (true) if the given error was caused by a
+// connection failing to answer heartbeats within PongTimeout
+func IsTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
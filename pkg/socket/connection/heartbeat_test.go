@@ -0,0 +1,121 @@
+package connection
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const heartbeatTestTimeout = 5 * time.Second
+
+// serveHeartbeats serves connections pinged every interval, and closed if
+// they answer no ping within timeout. Every connection is sent a "hello"
+// message once it connects, and signals the returned channel once its
+// disconnection event is emitted.
+func serveHeartbeats(t *testing.T, interval, timeout time.Duration) (string, chan bool) {
+	origInterval, origTimeout := PingInterval, PongTimeout
+	PingInterval, PongTimeout = interval, timeout
+	t.Cleanup(func() {
+		PingInterval, PongTimeout = origInterval, origTimeout
+	})
+
+	disconnected := make(chan bool, 1)
+	nsHandler := NewNamespaceHandler()
+	handler := NewHandler(nsHandler)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("unable to upgrade connection: %v", err)
+			return
+		}
+
+		conn := NewConnection(nsHandler, ws, w, r)
+		conn.On(DISCONNECTION_EVENT, func(MessageDataCodec) {
+			disconnected <- true
+		})
+		conn.Send("hello", []byte("hello"))
+		HandleConnection(handler, conn)
+	}))
+	t.Cleanup(srv.Close)
+
+	return "ws" + strings.TrimPrefix(srv.URL, "http"), disconnected
+}
+
+// dialHeartbeats connects to a heartbeat server, and reads its hello message
+func dialHeartbeats(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("unable to connect: %v", err)
+	}
+	t.Cleanup(func() {
+		ws.Close()
+	})
+
+	ws.SetReadDeadline(time.Now().Add(heartbeatTestTimeout))
+	if _, data, err := ws.ReadMessage(); err != nil || string(data) != "hello" {
+		t.Fatalf("expected a hello message, got %q (%v)", data, err)
+	}
+	return ws
+}
+
+func TestHeartbeatTimeoutDisconnects(t *testing.T) {
+	url, disconnected := serveHeartbeats(t, 20*time.Millisecond, 100*time.Millisecond)
+	start := time.Now()
+
+	// a client that stops reading never answers pings
+	ws := dialHeartbeats(t, url)
+
+	select {
+	case <-disconnected:
+	case <-time.After(heartbeatTestTimeout):
+		t.Fatalf("expected a connection answering no pings to be disconnected")
+	}
+	if elapsed := time.Since(start); elapsed < PongTimeout {
+		t.Fatalf("expected the connection to be disconnected after %v, got %v", PongTimeout, elapsed)
+	}
+
+	// the server closed the socket
+	ws.SetReadDeadline(time.Now().Add(heartbeatTestTimeout))
+	for {
+		if _, _, err := ws.ReadMessage(); err != nil {
+			if IsTimeout(err) {
+				t.Fatalf("expected the socket to be closed by the server")
+			}
+			break
+		}
+	}
+}
+
+func TestHeartbeatKeepsAnsweringConnections(t *testing.T) {
+	url, disconnected := serveHeartbeats(t, 20*time.Millisecond, 100*time.Millisecond)
+
+	// a reading client answers every ping with a pong
+	ws := dialHeartbeats(t, url)
+	ws.SetReadDeadline(time.Time{})
+	go func() {
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-disconnected:
+		t.Fatalf("expected a connection answering pings to remain connected")
+	case <-time.After(5 * PongTimeout):
+	}
+
+	ws.Close()
+	select {
+	case <-disconnected:
+	case <-time.After(heartbeatTestTimeout):
+		t.Fatalf("expected a closed connection to be disconnected")
+	}
+}
@@ -0,0 +1,109 @@
+package connection
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+var (
+	// MaxMessageSize is the max size, in bytes, of a message received on a
+	// connection. Connections sending larger messages are closed.
+	MaxMessageSize int64 = 16 * 1024
+
+	// MaxMessageDepth is the max amount of nested objects and
+	// arrays in the data of a message received on a connection
+	MaxMessageDepth = 8
+	// MaxMessageElements is the max amount of keys and values,
+	// at any depth, in a message received on a connection
+	MaxMessageElements = 512
+
+	// MaxEventLength and MaxMessageIdLength are the max lengths
+	// of the event and id of a message received on a connection
+	MaxEventLength     = 64
+	MaxMessageIdLength = 128
+)
+
+// DecodeMessage receives the contents of a text message read from a
+// connection, and decodes it into a Message. Returns the message's data,
+// or an error if the message is not a json object carrying an event and
+// an object of data, or exceeds any of the limits above.
+func DecodeMessage(b []byte) (*Message, MessageData, error) {
+	if int64(len(b)) > MaxMessageSize {
+		return nil, nil, fmt.Errorf("message of %v bytes exceeds the max size of %v bytes", len(b), MaxMessageSize)
+	}
+	if err := checkMessageShape(b); err != nil {
+		return nil, nil, err
+	}
+
+	messageData := NewMessageData()
+	message := &Message{
+		Data: messageData,
+	}
+	if err := json.Unmarshal(b, message); err != nil {
+		return nil, nil, err
+	}
+	if message.Data == nil {
+		return nil, nil, fmt.Errorf("message has no data")
+	}
+
+	if len(message.Event) == 0 {
+		return nil, nil, fmt.Errorf("message has no event")
+	}
+	if len(message.Event) > MaxEventLength {
+		return nil, nil, fmt.Errorf("message event exceeds %v characters", MaxEventLength)
+	}
+	if len(message.Id) > MaxMessageIdLength {
+		return nil, nil, fmt.Errorf("message id exceeds %v characters", MaxMessageIdLength)
+	}
+
+	return message, messageData, nil
+}
+
+// checkMessageShape walks the json tokens of a message, without decoding
+// it, and returns an error if it nests deeper than MaxMessageDepth below
+// its data, or holds more than MaxMessageElements keys and values.
+func checkMessageShape(b []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+
+	// the message itself, and its data, are the first two levels
+	maxDepth := MaxMessageDepth + 2
+	depth := 0
+	elements := 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		switch tok {
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		default:
+			if tok == json.Delim('{') || tok == json.Delim('[') {
+				depth++
+				if depth > maxDepth {
+					return fmt.Errorf("message data nests deeper than %v levels", MaxMessageDepth)
+				}
+			}
+
+			elements++
+			if elements > MaxMessageElements {
+				return fmt.Errorf("message holds more than %v keys and values", MaxMessageElements)
+			}
+		}
+
+		if depth == 0 {
+			if dec.More() {
+				return fmt.Errorf("message holds more than one json value")
+			}
+			break
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,264 @@
+package connection
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// IDENTITY_COOKIE_NAME is the name of a cookie holding a random,
+	// long-lived identifier for a browser. It is issued alongside the
+	// auth cookie and lets bans outlive a single connection.
+	IDENTITY_COOKIE_NAME = "flicktrack_io_identity"
+
+	SANCTION_KICK   = "kick"
+	SANCTION_BAN    = "ban"
+	SANCTION_UNBAN  = "unban"
+	SANCTION_MUTE   = "mute"
+	SANCTION_UNMUTE = "unmute"
+)
+
+// Sanction is a record of a moderation action taken against a connection
+type Sanction struct {
+	Kind string `json:"kind"`
+	// Subject is the username, or id, of the sanctioned
+	// connection at the time the sanction was created
+	Subject      string    `json:"subject"`
+	ConnectionId string    `json:"connectionId,omitempty"`
+	Identity     string    `json:"identity,omitempty"`
+	Address      string    `json:"address,omitempty"`
+	Reason       string    `json:"reason,omitempty"`
+	By           string    `json:"by"`
+	Created      time.Time `json:"created"`
+	// Expires is the time at which the sanction is lifted.
+	// A zero time means the sanction lasts as long as its namespace.
+	Expires time.Time `json:"expires,omitempty"`
+	// Exempt holds the browser identities the sanction never applies
+	// to, such as those of the user who created it and of the admins
+	// in the namespace at the time, who may share the sanctioned address.
+	Exempt []string `json:"-"`
+}
+
+// Expired returns a boolean (true) if the sanction has been lifted at the given time
+func (s *Sanction) Expired(now time.Time) bool {
+	return !s.Expires.IsZero() && now.After(s.Expires)
+}
+
+// Matches returns a boolean (true) if the sanction applies to the
+// given connection id, browser identity, or remote address, and the
+// browser identity is not exempt from it
+func (s *Sanction) Matches(connId, identity, address string) bool {
+	if len(identity) > 0 {
+		for _, exempt := range s.Exempt {
+			if exempt == identity {
+				return false
+			}
+		}
+	}
+
+	return (len(s.ConnectionId) > 0 && s.ConnectionId == connId) ||
+		(len(s.Identity) > 0 && s.Identity == identity) ||
+		(len(s.Address) > 0 && s.Address == address)
+}
+
+// NamespaceModeration stores bans and mutes for a namespace, along with
+// a record of every moderation action taken in it.
+type NamespaceModeration interface {
+	// Ban stores a ban sanction
+	Ban(*Sanction)
+	// Unban receives a subject name, connection id, identity or address
+	// and lifts every ban matching it. Returns the lifted bans.
+	Unban(string, string) []*Sanction
+	// Banned receives a connection id, identity and remote address
+	// and returns the ban applying to them, if any
+	Banned(string, string, string) (*Sanction, bool)
+	// Mute stores a mute sanction
+	Mute(*Sanction)
+	// Unmute receives a subject name or connection id and lifts every
+	// mute matching it. Returns the lifted mutes.
+	Unmute(string, string) []*Sanction
+	// Muted receives a connection id and identity and
+	// returns the mute applying to them, if any
+	Muted(string, string) (*Sanction, bool)
+	// Record stores a sanction, such as a kick, that has no lasting effect
+	Record(*Sanction)
+	// Bans returns every ban currently in effect
+	Bans() []*Sanction
+	// Mutes returns every mute currently in effect
+	Mutes() []*Sanction
+	// History returns every sanction recorded for the namespace, oldest first
+	History() []*Sanction
+}
+
+// NamespaceModerationSpec implements NamespaceModeration
+type NamespaceModerationSpec struct {
+	mux sync.Mutex
+
+	bans    []*Sanction
+	mutes   []*Sanction
+	history []*Sanction
+}
+
+func (m *NamespaceModerationSpec) Ban(s *Sanction) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	s.Kind = SANCTION_BAN
+	m.bans = append(m.bans, s)
+	m.history = append(m.history, s)
+}
+
+func (m *NamespaceModerationSpec) Unban(target, by string) []*Sanction {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	var lifted []*Sanction
+	m.bans, lifted = liftSanctions(m.bans, target)
+	if len(lifted) > 0 {
+		m.history = append(m.history, &Sanction{
+			Kind:    SANCTION_UNBAN,
+			Subject: target,
+			By:      by,
+			Created: time.Now(),
+		})
+	}
+	return lifted
+}
+
+func (m *NamespaceModerationSpec) Banned(connId, identity, address string) (*Sanction, bool) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	m.bans = pruneSanctions(m.bans)
+	for _, s := range m.bans {
+		if s.Matches(connId, identity, address) {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+func (m *NamespaceModerationSpec) Mute(s *Sanction) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	s.Kind = SANCTION_MUTE
+	m.mutes = append(m.mutes, s)
+	m.history = append(m.history, s)
+}
+
+func (m *NamespaceModerationSpec) Unmute(target, by string) []*Sanction {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	var lifted []*Sanction
+	m.mutes, lifted = liftSanctions(m.mutes, target)
+	if len(lifted) > 0 {
+		m.history = append(m.history, &Sanction{
+			Kind:    SANCTION_UNMUTE,
+			Subject: target,
+			By:      by,
+			Created: time.Now(),
+		})
+	}
+	return lifted
+}
+
+func (m *NamespaceModerationSpec) Muted(connId, identity string) (*Sanction, bool) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	// mutes are never matched by address, so that
+	// muting one user does not mute a shared network
+	m.mutes = pruneSanctions(m.mutes)
+	for _, s := range m.mutes {
+		if s.Matches(connId, identity, "") {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+func (m *NamespaceModerationSpec) Record(s *Sanction) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.history = append(m.history, s)
+}
+
+func (m *NamespaceModerationSpec) Bans() []*Sanction {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	m.bans = pruneSanctions(m.bans)
+	return append([]*Sanction{}, m.bans...)
+}
+
+func (m *NamespaceModerationSpec) Mutes() []*Sanction {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	m.mutes = pruneSanctions(m.mutes)
+	return append([]*Sanction{}, m.mutes...)
+}
+
+func (m *NamespaceModerationSpec) History() []*Sanction {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return append([]*Sanction{}, m.history...)
+}
+
+// pruneSanctions returns the given sanctions that have not expired
+func pruneSanctions(sanctions []*Sanction) []*Sanction {
+	now := time.Now()
+	active := []*Sanction{}
+	for _, s := range sanctions {
+		if !s.Expired(now) {
+			active = append(active, s)
+		}
+	}
+	return active
+}
+
+// liftSanctions splits the given sanctions into those that do not
+// apply to the given target, and those that do
+func liftSanctions(sanctions []*Sanction, target string) ([]*Sanction, []*Sanction) {
+	remaining := []*Sanction{}
+	lifted := []*Sanction{}
+	for _, s := range pruneSanctions(sanctions) {
+		if s.Subject == target || s.Matches(target, target, target) {
+			lifted = append(lifted, s)
+			continue
+		}
+		remaining = append(remaining, s)
+	}
+	return remaining, lifted
+}
+
+func NewNamespaceModeration() NamespaceModeration {
+	return &NamespaceModerationSpec{
+		bans:    []*Sanction{},
+		mutes:   []*Sanction{},
+		history: []*Sanction{},
+	}
+}
+
+// RemoteAddress returns the host portion of a request's remote address
+func RemoteAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// RequestIdentity returns the browser identity stored in a request's
+// identity cookie, or an empty string if the request has none
+func RequestIdentity(r *http.Request) string {
+	cookie, err := r.Cookie(IDENTITY_COOKIE_NAME)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
@@ -0,0 +1,134 @@
+package connection
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSanctionMatches(t *testing.T) {
+	ban := &Sanction{
+		Kind:         SANCTION_BAN,
+		Subject:      "bob",
+		ConnectionId: "conn-bob",
+		Identity:     "identity-bob",
+		Address:      "10.0.0.1",
+		Exempt:       []string{"identity-alice"},
+	}
+
+	tests := []struct {
+		name     string
+		sanction *Sanction
+		connId   string
+		identity string
+		address  string
+		expected bool
+	}{
+		{name: "connection id", sanction: ban, connId: "conn-bob", expected: true},
+		{name: "identity", sanction: ban, connId: "other", identity: "identity-bob", expected: true},
+		{name: "address", sanction: ban, connId: "other", identity: "identity-carol", address: "10.0.0.1", expected: true},
+		{name: "no match", sanction: ban, connId: "other", identity: "identity-carol", address: "10.0.0.2"},
+		{name: "exempt identity at the banned address", sanction: ban, connId: "other", identity: "identity-alice", address: "10.0.0.1"},
+		{name: "exempt identity is never matched", sanction: ban, connId: "conn-bob", identity: "identity-alice"},
+		{name: "empty fields never match", sanction: &Sanction{Subject: "bob"}},
+		{name: "empty address", sanction: &Sanction{ConnectionId: "conn-bob", Identity: "identity-bob"}, connId: "other", identity: "other"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := tt.sanction.Matches(tt.connId, tt.identity, tt.address); actual != tt.expected {
+				t.Fatalf("expected match to be %v, got %v", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestSanctionExpired(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 20, 0, 0, 0, time.UTC)
+
+	if (&Sanction{}).Expired(now) {
+		t.Fatalf("expected a sanction with no expiry never to expire")
+	}
+	if (&Sanction{Expires: now.Add(time.Second)}).Expired(now) {
+		t.Fatalf("expected a sanction not to expire before its expiry")
+	}
+	if !(&Sanction{Expires: now.Add(-time.Second)}).Expired(now) {
+		t.Fatalf("expected a sanction to expire after its expiry")
+	}
+}
+
+func TestLiftSanctions(t *testing.T) {
+	bob := &Sanction{Subject: "bob", ConnectionId: "conn-bob", Identity: "identity-bob"}
+	carol := &Sanction{Subject: "carol", Address: "10.0.0.1"}
+	expired := &Sanction{Subject: "dave", Expires: time.Now().Add(-time.Minute)}
+
+	tests := []struct {
+		name      string
+		target    string
+		remaining int
+		lifted    int
+	}{
+		{name: "by subject", target: "bob", remaining: 1, lifted: 1},
+		{name: "by connection id", target: "conn-bob", remaining: 1, lifted: 1},
+		{name: "by identity", target: "identity-bob", remaining: 1, lifted: 1},
+		{name: "by address", target: "10.0.0.1", remaining: 1, lifted: 1},
+		{name: "no match", target: "erin", remaining: 2},
+		{name: "expired sanctions are dropped, not lifted", target: "dave", remaining: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remaining, lifted := liftSanctions([]*Sanction{bob, carol, expired}, tt.target)
+			if len(remaining) != tt.remaining || len(lifted) != tt.lifted {
+				t.Fatalf("expected %v remaining and %v lifted, got %v and %v", tt.remaining, tt.lifted, len(remaining), len(lifted))
+			}
+		})
+	}
+}
+
+func TestNamespaceModeration(t *testing.T) {
+	m := NewNamespaceModeration()
+	m.Ban(&Sanction{Subject: "bob", Identity: "identity-bob", Address: "10.0.0.1", By: "alice"})
+	m.Ban(&Sanction{Subject: "dave", Identity: "identity-dave", By: "alice", Expires: time.Now().Add(-time.Minute)})
+	m.Mute(&Sanction{Subject: "carol", Identity: "identity-carol", Address: "10.0.0.2", By: "alice"})
+
+	if ban, banned := m.Banned("other", "other", "10.0.0.1"); !banned || ban.Subject != "bob" || ban.Kind != SANCTION_BAN {
+		t.Fatalf("expected a connection at a banned address to be banned, got %+v", ban)
+	}
+	if _, banned := m.Banned("other", "identity-dave", ""); banned {
+		t.Fatalf("expected an expired ban not to apply")
+	}
+	if bans := m.Bans(); len(bans) != 1 {
+		t.Fatalf("expected expired bans to be pruned, got %v bans", len(bans))
+	}
+
+	if _, muted := m.Muted("other", "identity-carol"); !muted {
+		t.Fatalf("expected a muted identity to be muted")
+	}
+	if _, muted := m.Banned("other", "other", "10.0.0.2"); muted {
+		t.Fatalf("expected a mute not to ban its address")
+	}
+
+	if lifted := m.Unban("bob", "alice"); len(lifted) != 1 {
+		t.Fatalf("expected 1 ban lifted, got %v", len(lifted))
+	}
+	if _, banned := m.Banned("other", "identity-bob", ""); banned {
+		t.Fatalf("expected a lifted ban not to apply")
+	}
+	if lifted := m.Unmute("carol", "alice"); len(lifted) != 1 {
+		t.Fatalf("expected 1 mute lifted, got %v", len(lifted))
+	}
+
+	kinds := []string{}
+	for _, s := range m.History() {
+		kinds = append(kinds, s.Kind)
+	}
+	expected := []string{SANCTION_BAN, SANCTION_BAN, SANCTION_MUTE, SANCTION_UNBAN, SANCTION_UNMUTE}
+	if len(kinds) != len(expected) {
+		t.Fatalf("expected history %v, got %v", expected, kinds)
+	}
+	for i := range expected {
+		if kinds[i] != expected[i] {
+			t.Fatalf("expected history %v, got %v", expected, kinds)
+		}
+	}
+}
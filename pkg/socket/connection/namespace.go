@@ -3,13 +3,20 @@ package connection
 import (
 	"fmt"
 	"log"
+	"sync"
 
+	"github.com/juanvallejo/streaming-server/pkg/audit"
 	"github.com/juanvallejo/streaming-server/pkg/socket/connection/util"
 )
 
 type Namespace interface {
+	// Access returns the NamespaceAccess controlling
+	// which connections may join the namespace
+	Access() NamespaceAccess
 	// Add receives a Connection to compose
 	Add(Connection) error
+	// Audit returns the namespace's log of privileged actions
+	Audit() *audit.Log
 	// Connection receives a connection uuid and returns the
 	// connection associated with it, or a boolean (false)
 	// if a connection does not exist by the specified uuid.
@@ -17,23 +24,46 @@ type Namespace interface {
 	// Connections returns a slice of connections aggregated by
 	// the current namespace
 	Connections() []Connection
+	// Moderation returns the bans and mutes in effect for the namespace
+	Moderation() NamespaceModeration
 	// Name returns the given namespace name
 	Name() string
 	// Remove receives a Connection to remove from the list
 	// of composed connections. Returns an error if the
 	// connection is not aggregated by this namespace
 	Remove(Connection) error
+	// Sessions returns the resumable sessions of the namespace's connections
+	Sessions() NamespaceSessions
 	// UUID returns the unique identifier for the namespace
 	UUID() string
 }
 
 type NamespaceSpec struct {
-	name      string
-	id        string
+	name       string
+	id         string
+	access     NamespaceAccess
+	moderation NamespaceModeration
+	sessions   NamespaceSessions
+	audit      *audit.Log
+
+	// connsById is guarded by mux; connections join and leave
+	// from their own goroutines
+	mux       sync.RWMutex
 	connsById map[string]Connection
 }
 
+func (n *NamespaceSpec) Audit() *audit.Log {
+	return n.audit
+}
+
+func (n *NamespaceSpec) Access() NamespaceAccess {
+	return n.access
+}
+
 func (n *NamespaceSpec) Add(conn Connection) error {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
 	if _, exists := n.connsById[conn.UUID()]; exists {
 		return fmt.Errorf("connection with id (%s) has already been added to namespace %q", conn.UUID(), n.name)
 	}
@@ -43,6 +73,9 @@ func (n *NamespaceSpec) Add(conn Connection) error {
 }
 
 func (n *NamespaceSpec) Remove(conn Connection) error {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
 	if _, exists := n.connsById[conn.UUID()]; exists {
 		delete(n.connsById, conn.UUID())
 		return nil
@@ -52,11 +85,19 @@ func (n *NamespaceSpec) Remove(conn Connection) error {
 }
 
 func (n *NamespaceSpec) Connection(uuid string) (Connection, bool) {
+	n.mux.RLock()
+	defer n.mux.RUnlock()
+
 	c, exists := n.connsById[uuid]
 	return c, exists
 }
 
+// Connections returns a snapshot of the namespace's connections,
+// safe to iterate over while connections join and leave
 func (n *NamespaceSpec) Connections() []Connection {
+	n.mux.RLock()
+	defer n.mux.RUnlock()
+
 	conns := []Connection{}
 	for _, c := range n.connsById {
 		conns = append(conns, c)
@@ -65,6 +106,14 @@ func (n *NamespaceSpec) Connections() []Connection {
 	return conns
 }
 
+func (n *NamespaceSpec) Moderation() NamespaceModeration {
+	return n.moderation
+}
+
+func (n *NamespaceSpec) Sessions() NamespaceSessions {
+	return n.sessions
+}
+
 func (n *NamespaceSpec) Name() string {
 	return n.name
 }
@@ -79,10 +128,19 @@ func NewNamespace(name string) Namespace {
 		log.Panic(fmt.Sprintf("unable to generate namespace uuid: %v", err))
 	}
 
+	access, err := NewNamespaceAccess(id)
+	if err != nil {
+		log.Panic(fmt.Sprintf("unable to generate namespace access key: %v", err))
+	}
+
 	return &NamespaceSpec{
-		id:        id,
-		name:      name,
-		connsById: make(map[string]Connection),
+		id:         id,
+		access:     access,
+		moderation: NewNamespaceModeration(),
+		sessions:   NewNamespaceSessions(),
+		audit:      audit.NewLog(audit.DefaultRetention),
+		name:       name,
+		connsById:  make(map[string]Connection),
 	}
 }
 
@@ -115,8 +173,11 @@ type NamespaceHandler interface {
 	BroadcastFrom(int, string, string, string, []byte)
 }
 
-// NamespaceHandlerSpec implements Namespace
+// NamespaceHandlerSpec implements Namespace. Namespaces are created by
+// connection goroutines and deleted by the playback reaper; nsByName
+// is guarded by mux.
 type NamespaceHandlerSpec struct {
+	mux      sync.RWMutex
 	nsByName map[string]Namespace
 }
 
@@ -125,16 +186,13 @@ func (h *NamespaceHandlerSpec) AddToNamespace(ns string, conn Connection) {
 		return
 	}
 
-	namespace, exists := h.nsByName[ns]
-	if !exists {
-		namespace = NewNamespace(ns)
-		h.nsByName[ns] = namespace
-	}
-
-	namespace.Add(conn)
+	h.NewNamespace(ns).Add(conn)
 }
 
 func (h *NamespaceHandlerSpec) NewNamespace(ns string) Namespace {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
 	namespace, exists := h.nsByName[ns]
 	if !exists {
 		namespace = NewNamespace(ns)
@@ -145,11 +203,17 @@ func (h *NamespaceHandlerSpec) NewNamespace(ns string) Namespace {
 }
 
 func (h *NamespaceHandlerSpec) NamespaceByName(ns string) (Namespace, bool) {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+
 	conns, exist := h.nsByName[ns]
 	return conns, exist
 }
 
 func (h *NamespaceHandlerSpec) DeleteNamespaceByName(ns string) error {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
 	if _, exists := h.nsByName[ns]; exists {
 		delete(h.nsByName, ns)
 		return nil
@@ -159,7 +223,7 @@ func (h *NamespaceHandlerSpec) DeleteNamespaceByName(ns string) error {
 }
 
 func (h *NamespaceHandlerSpec) RemoveFromNamespace(ns string, conn Connection) {
-	namespace, exists := h.nsByName[ns]
+	namespace, exists := h.NamespaceByName(ns)
 	if !exists {
 		return
 	}
@@ -170,18 +234,18 @@ func (h *NamespaceHandlerSpec) RemoveFromNamespace(ns string, conn Connection) {
 }
 
 func (h *NamespaceHandlerSpec) Broadcast(messageType int, ns, eventName string, data []byte) {
-	namespace, exists := h.nsByName[ns]
+	namespace, exists := h.NamespaceByName(ns)
 	if !exists {
 		return
 	}
 
 	for _, c := range namespace.Connections() {
-		c.WriteMessage(messageType, data)
+		c.Enqueue(messageType, eventName, data)
 	}
 }
 
 func (h *NamespaceHandlerSpec) BroadcastFrom(messageType int, connId, ns, eventName string, data []byte) {
-	namespace, exists := h.nsByName[ns]
+	namespace, exists := h.NamespaceByName(ns)
 	if !exists {
 		return
 	}
@@ -190,7 +254,7 @@ func (h *NamespaceHandlerSpec) BroadcastFrom(messageType int, connId, ns, eventN
 		if c.UUID() == connId {
 			continue
 		}
-		c.WriteMessage(messageType, data)
+		c.Enqueue(messageType, eventName, data)
 	}
 }
 
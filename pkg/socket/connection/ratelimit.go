@@ -0,0 +1,477 @@
+package connection
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/juanvallejo/streaming-server/pkg/socket/protocol"
+)
+
+const (
+	// RATE_LIMIT_ANY_EVENT is the key of the limit applying to every event
+	RATE_LIMIT_ANY_EVENT = "*"
+	// RATE_LIMIT_COMMAND is the key of the limit applying to chat
+	// messages that are commands, on top of the chat message limit
+	RATE_LIMIT_COMMAND = "command"
+
+	// RATE_LIMITED_EVENT is emitted on a connection, with a RateLimitVerdict,
+	// whenever a message received on it is dropped for exceeding a limit
+	RATE_LIMITED_EVENT = "ratelimited"
+
+	// DISCONNECTION_EVENT is emitted on a connection once it is closed
+	DISCONNECTION_EVENT = "disconnection"
+
+	RATE_LIMIT_ACTION_DROP = "drop"
+	RATE_LIMIT_ACTION_WARN = "warn"
+	RATE_LIMIT_ACTION_MUTE = "mute"
+)
+
+// RateLimit is a token bucket refilled with Rate tokens per second, holding
+// at most Burst tokens. Each message takes a token. A zero Rate means
+// messages are not limited.
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+func (l RateLimit) String() string {
+	return fmt.Sprintf("%v/%v", strconv.FormatFloat(l.Rate, 'f', -1, 64), l.Burst)
+}
+
+var (
+	// RateLimits are the limits applying to each connection, by event name
+	RateLimits = map[string]RateLimit{
+		RATE_LIMIT_ANY_EVENT:               {Rate: 20, Burst: 40},
+		RATE_LIMIT_COMMAND:                 {Rate: 1, Burst: 5},
+		protocol.EVENT_REQUEST_CHATMESSAGE: {Rate: 2, Burst: 8},
+		protocol.EVENT_REQUEST_QUEUESYNC:   {Rate: 2, Burst: 10},
+		protocol.EVENT_REQUEST_STACKSYNC:   {Rate: 2, Burst: 10},
+		protocol.EVENT_REQUEST_STREAMSYNC:  {Rate: 2, Burst: 10},
+		protocol.EVENT_REQUEST_USERLIST:    {Rate: 2, Burst: 10},
+		protocol.EVENT_STREAMDATA:          {Rate: 1, Burst: 5},
+	}
+
+	// IPRateLimits are the limits applying to all connections from the
+	// same remote address, by event name. If nil, each of RateLimits is
+	// applied IPRateLimitFactor times over instead.
+	IPRateLimits map[string]RateLimit
+	// IPRateLimitFactor is the number of connections' worth of messages
+	// a single remote address may send, if IPRateLimits is nil
+	IPRateLimitFactor = 4
+
+	// FloodWindow is the period over which dropped messages
+	// are counted to detect sustained abuse
+	FloodWindow = 10 * time.Second
+	// FloodMuteStrikes is the amount of messages a connection may have
+	// dropped within FloodWindow before it is muted for FloodMuteDuration
+	FloodMuteStrikes  = 20
+	FloodMuteDuration = time.Minute
+	// FloodDisconnectStrikes is the amount of messages a connection may have
+	// dropped within FloodWindow before it is disconnected
+	FloodDisconnectStrikes = 50
+)
+
+// internalEvents are emitted by the server on connections,
+// and are never handled when received from a client
+var internalEvents = map[string]bool{
+	DISCONNECTION_EVENT: true,
+	RATE_LIMITED_EVENT:  true,
+}
+
+// ipLimits returns the limits applying to each remote address
+func ipLimits() map[string]RateLimit {
+	if IPRateLimits != nil {
+		return IPRateLimits
+	}
+
+	limits := make(map[string]RateLimit)
+	for event, l := range RateLimits {
+		limits[event] = RateLimit{
+			Rate:  l.Rate * float64(IPRateLimitFactor),
+			Burst: l.Burst * IPRateLimitFactor,
+		}
+	}
+	return limits
+}
+
+// ParseRateLimits parses a comma-separated list of limits of the form
+// <event>=<rate>/<burst>, e.g. "request_chatmessage=2/8,*=20/40".
+// A rate of 0 lifts the limit of an event.
+func ParseRateLimits(spec string) (map[string]RateLimit, error) {
+	limits := make(map[string]RateLimit)
+	if len(strings.TrimSpace(spec)) == 0 {
+		return limits, nil
+	}
+
+	for _, entry := range strings.Split(spec, ",") {
+		segs := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(segs) != 2 || len(segs[0]) == 0 {
+			return nil, fmt.Errorf("invalid rate limit %q: expecting <event>=<rate>/<burst>", entry)
+		}
+
+		values := strings.SplitN(segs[1], "/", 2)
+		if len(values) != 2 {
+			return nil, fmt.Errorf("invalid rate limit %q: expecting <event>=<rate>/<burst>", entry)
+		}
+		rate, err := strconv.ParseFloat(values[0], 64)
+		if err != nil || rate < 0 {
+			return nil, fmt.Errorf("invalid rate limit %q: rate must be a positive number of messages per second", entry)
+		}
+		burst, err := strconv.Atoi(values[1])
+		if err != nil || burst < 1 {
+			return nil, fmt.Errorf("invalid rate limit %q: burst must be a positive integer", entry)
+		}
+
+		limits[segs[0]] = RateLimit{Rate: rate, Burst: burst}
+	}
+
+	return limits, nil
+}
+
+// bucket is a token bucket
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take refills the bucket for the time elapsed since it was last used,
+// and takes a token from it. Returns a boolean (false) if it was empty.
+func (b *bucket) take(l RateLimit, now time.Time) bool {
+	if b.last.IsZero() {
+		b.tokens = float64(l.Burst)
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * l.Rate
+		if b.tokens > float64(l.Burst) {
+			b.tokens = float64(l.Burst)
+		}
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// buckets holds a token bucket per limit key
+type buckets struct {
+	mux     sync.Mutex
+	byEvent map[string]*bucket
+}
+
+// allow takes a token for every given key with a limit, and returns the
+// first key whose bucket was empty, or an empty string if none was.
+// Tokens are only taken if every bucket has one, so that a dropped
+// message does not count against the client's other limits.
+func (b *buckets) allow(limits map[string]RateLimit, keys []string, now time.Time) string {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	_, exceeded := b.reserve(limits, keys, now)
+	return exceeded
+}
+
+// reserve takes a token for every given key with a limit, and returns
+// the buckets tokens were taken from, or the first key whose bucket was
+// empty, in which case no token is taken. Must be called with mux held.
+func (b *buckets) reserve(limits map[string]RateLimit, keys []string, now time.Time) ([]*bucket, string) {
+	taken := []*bucket{}
+	for _, key := range keys {
+		l, limited := limits[key]
+		if !limited || l.Rate <= 0 {
+			continue
+		}
+
+		bk, exists := b.byEvent[key]
+		if !exists {
+			bk = &bucket{}
+			b.byEvent[key] = bk
+		}
+		if !bk.take(l, now) {
+			refund(taken)
+			return nil, key
+		}
+		taken = append(taken, bk)
+	}
+	return taken, ""
+}
+
+// refund returns a token to each of the given buckets
+func refund(taken []*bucket) {
+	for _, t := range taken {
+		t.tokens++
+	}
+}
+
+func newBuckets() *buckets {
+	return &buckets{
+		byEvent: make(map[string]*bucket),
+	}
+}
+
+// addressBuckets are the buckets shared by every connection from a remote
+// address. Entries are removed once their last connection is closed.
+var addressBuckets = struct {
+	mux    sync.Mutex
+	byAddr map[string]*addressBucket
+}{
+	byAddr: make(map[string]*addressBucket),
+}
+
+type addressBucket struct {
+	*buckets
+	conns int
+}
+
+func acquireAddressBuckets(addr string) *buckets {
+	addressBuckets.mux.Lock()
+	defer addressBuckets.mux.Unlock()
+
+	b, exists := addressBuckets.byAddr[addr]
+	if !exists {
+		b = &addressBucket{buckets: newBuckets()}
+		addressBuckets.byAddr[addr] = b
+	}
+	b.conns++
+	return b.buckets
+}
+
+func releaseAddressBuckets(addr string) {
+	addressBuckets.mux.Lock()
+	defer addressBuckets.mux.Unlock()
+
+	b, exists := addressBuckets.byAddr[addr]
+	if !exists {
+		return
+	}
+	b.conns--
+	if b.conns <= 0 {
+		delete(addressBuckets.byAddr, addr)
+	}
+}
+
+// RateLimitVerdict describes a message dropped for exceeding a rate limit.
+// Implements MessageDataCodec.
+type RateLimitVerdict struct {
+	MessageData
+
+	// Id is the id of the dropped message, if any
+	Id string
+	// Event is the event of the dropped message
+	Event string
+	// Limit is the key of the limit that was exceeded
+	Limit string
+	// Action is RATE_LIMIT_ACTION_DROP, RATE_LIMIT_ACTION_WARN
+	// or RATE_LIMIT_ACTION_MUTE
+	Action string
+	// Strikes is the amount of messages dropped within FloodWindow
+	Strikes int
+}
+
+// rateLimiter enforces rate limits on the messages received on a connection,
+// and escalates from warnings, to mutes, to disconnecting the connection
+// if it keeps exceeding them. Only used by the connection's read loop.
+type rateLimiter struct {
+	addr    string
+	conn    *buckets
+	address *buckets
+
+	windowStart time.Time
+	strikes     int
+	warned      bool
+	muted       bool
+}
+
+// check receives a message's event and whether it is a command, and returns
+// a verdict if the message must be dropped, or nil if it may be handled.
+// The boolean returned is true if the connection must be disconnected.
+func (l *rateLimiter) check(id, event string, isCommand bool, now time.Time) (*RateLimitVerdict, bool) {
+	keys := []string{RATE_LIMIT_ANY_EVENT, event}
+	if isCommand {
+		keys = append(keys, RATE_LIMIT_COMMAND)
+	}
+
+	exceeded := l.allow(keys, now)
+	if len(exceeded) == 0 {
+		return nil, false
+	}
+
+	rateLimitMetrics.limited(exceeded)
+
+	if now.Sub(l.windowStart) > FloodWindow {
+		l.windowStart = now
+		l.strikes = 0
+		l.warned = false
+		l.muted = false
+	}
+	l.strikes++
+
+	verdict := &RateLimitVerdict{
+		MessageData: NewMessageData(),
+		Id:          id,
+		Event:       event,
+		Limit:       exceeded,
+		Action:      RATE_LIMIT_ACTION_DROP,
+		Strikes:     l.strikes,
+	}
+
+	switch {
+	case FloodDisconnectStrikes > 0 && l.strikes >= FloodDisconnectStrikes:
+		atomic.AddUint64(&rateLimitMetrics.Disconnects, 1)
+		return verdict, true
+	case FloodMuteStrikes > 0 && l.strikes >= FloodMuteStrikes && !l.muted:
+		l.muted = true
+		verdict.Action = RATE_LIMIT_ACTION_MUTE
+		atomic.AddUint64(&rateLimitMetrics.Mutes, 1)
+	case !l.warned:
+		l.warned = true
+		verdict.Action = RATE_LIMIT_ACTION_WARN
+		atomic.AddUint64(&rateLimitMetrics.Warnings, 1)
+	}
+	return verdict, false
+}
+
+// allow takes a token for every given key from both the connection's and
+// its remote address' buckets, and returns the first key whose bucket was
+// empty, or an empty string if none was. Tokens are only taken if both
+// limits allow the message, so that a message dropped by the address'
+// limits does not count against the connection's, and vice versa.
+// The connection's buckets are always locked before the address' ones.
+func (l *rateLimiter) allow(keys []string, now time.Time) string {
+	l.conn.mux.Lock()
+	defer l.conn.mux.Unlock()
+	l.address.mux.Lock()
+	defer l.address.mux.Unlock()
+
+	taken, exceeded := l.conn.reserve(RateLimits, keys, now)
+	if len(exceeded) > 0 {
+		return exceeded
+	}
+	if _, exceeded = l.address.reserve(ipLimits(), keys, now); len(exceeded) > 0 {
+		refund(taken)
+		return "address:" + exceeded
+	}
+	return ""
+}
+
+// close releases the connection's share of its remote address' buckets
+func (l *rateLimiter) close() {
+	releaseAddressBuckets(l.addr)
+}
+
+func newRateLimiter(r *http.Request) *rateLimiter {
+	addr := RemoteAddress(r)
+	return &rateLimiter{
+		addr:    addr,
+		conn:    newBuckets(),
+		address: acquireAddressBuckets(addr),
+	}
+}
+
+// RateLimitMetrics counts the messages dropped by rate limits since the
+// server started, and describes the limits in effect. Implements api.ApiCodec.
+type RateLimitMetrics struct {
+	// Limited is the amount of messages dropped for exceeding a limit
+	Limited uint64 `json:"limited"`
+	// LimitedBy is the amount of messages dropped for exceeding each
+	// limit. Limits applying to a remote address are prefixed "address:".
+	LimitedBy map[string]uint64 `json:"limitedBy"`
+	// Warnings, Mutes and Disconnects are the amount of
+	// times abusive connections were escalated against
+	Warnings    uint64 `json:"warnings"`
+	Mutes       uint64 `json:"mutes"`
+	Disconnects uint64 `json:"disconnects"`
+
+	Limits   map[string]string `json:"limits"`
+	IPLimits map[string]string `json:"ipLimits"`
+
+	mux sync.Mutex
+}
+
+func (m *RateLimitMetrics) limited(key string) {
+	atomic.AddUint64(&m.Limited, 1)
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.LimitedBy[key]++
+}
+
+func (m *RateLimitMetrics) Serialize() ([]byte, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	return b, nil
+}
+
+var rateLimitMetrics = RateLimitMetrics{
+	LimitedBy: make(map[string]uint64),
+}
+
+// RateLimitStats returns the totals of messages dropped by rate limits
+func RateLimitStats() *RateLimitMetrics {
+	rateLimitMetrics.mux.Lock()
+	limitedBy := make(map[string]uint64)
+	for key, count := range rateLimitMetrics.LimitedBy {
+		limitedBy[key] = count
+	}
+	rateLimitMetrics.mux.Unlock()
+
+	return &RateLimitMetrics{
+		Limited:     atomic.LoadUint64(&rateLimitMetrics.Limited),
+		LimitedBy:   limitedBy,
+		Warnings:    atomic.LoadUint64(&rateLimitMetrics.Warnings),
+		Mutes:       atomic.LoadUint64(&rateLimitMetrics.Mutes),
+		Disconnects: atomic.LoadUint64(&rateLimitMetrics.Disconnects),
+		Limits:      describeLimits(RateLimits),
+		IPLimits:    describeLimits(ipLimits()),
+	}
+}
+
+func describeLimits(limits map[string]RateLimit) map[string]string {
+	keys := []string{}
+	for key := range limits {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	described := make(map[string]string)
+	for _, key := range keys {
+		if limits[key].Rate <= 0 {
+			described[key] = "unlimited"
+			continue
+		}
+		described[key] = limits[key].String()
+	}
+	return described
+}
+
+func logRateLimited(conn Connection, verdict *RateLimitVerdict) {
+	log.Printf("WRN WS HANDLE dropped %q message from connection (%s) exceeding rate limit %q (%v strikes); action: %s", verdict.Event, conn.UUID(), verdict.Limit, verdict.Strikes, verdict.Action)
+}
+
+// isCommand returns true if a message is a chat message running a command
+func isCommand(event string, data MessageData) bool {
+	if event != protocol.EVENT_REQUEST_CHATMESSAGE {
+		return false
+	}
+	b, err := data.Serialize()
+	if err != nil {
+		return false
+	}
+	req := &protocol.ChatMessageRequest{}
+	if err := json.Unmarshal(b, req); err != nil {
+		return false
+	}
+	return strings.HasPrefix(strings.TrimSpace(req.Message), "/")
+}
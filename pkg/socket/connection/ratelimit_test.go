@@ -0,0 +1,171 @@
+package connection
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucketTake(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 20, 0, 0, 0, time.UTC)
+	l := RateLimit{Rate: 2, Burst: 3}
+	b := &bucket{}
+
+	for i := 0; i < 3; i++ {
+		if !b.take(l, now) {
+			t.Fatalf("expected take %v to be allowed by a full bucket", i+1)
+		}
+	}
+	if b.take(l, now) {
+		t.Fatalf("expected a take beyond the burst to be refused")
+	}
+
+	// half a second refills a single token at 2 tokens per second
+	now = now.Add(500 * time.Millisecond)
+	if !b.take(l, now) {
+		t.Fatalf("expected a refilled token to be taken")
+	}
+	if b.take(l, now) {
+		t.Fatalf("expected a single token to have been refilled")
+	}
+
+	// refills never exceed the burst
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if !b.take(l, now) {
+			t.Fatalf("expected take %v to be allowed by a refilled bucket", i+1)
+		}
+	}
+	if b.take(l, now) {
+		t.Fatalf("expected a refilled bucket to hold at most its burst")
+	}
+}
+
+func TestBucketsAllow(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 20, 0, 0, 0, time.UTC)
+	limits := map[string]RateLimit{
+		RATE_LIMIT_ANY_EVENT: {Rate: 1, Burst: 3},
+		"chat":               {Rate: 1, Burst: 1},
+		"unlimited":          {Rate: 0, Burst: 1},
+	}
+	b := newBuckets()
+
+	if exceeded := b.allow(limits, []string{RATE_LIMIT_ANY_EVENT, "chat"}, now); len(exceeded) > 0 {
+		t.Fatalf("expected a first message to be allowed, exceeded %q", exceeded)
+	}
+	if exceeded := b.allow(limits, []string{RATE_LIMIT_ANY_EVENT, "chat"}, now); exceeded != "chat" {
+		t.Fatalf("expected the chat limit to be exceeded, got %q", exceeded)
+	}
+
+	// the message refused by the chat limit took no token
+	// from the limit on any event, which has 2 left
+	for i := 0; i < 2; i++ {
+		if exceeded := b.allow(limits, []string{RATE_LIMIT_ANY_EVENT, "other"}, now); len(exceeded) > 0 {
+			t.Fatalf("expected message %v to be allowed, exceeded %q", i+1, exceeded)
+		}
+	}
+	if exceeded := b.allow(limits, []string{RATE_LIMIT_ANY_EVENT, "other"}, now); exceeded != RATE_LIMIT_ANY_EVENT {
+		t.Fatalf("expected the limit on any event to be exceeded, got %q", exceeded)
+	}
+
+	// keys with no limit, or a zero rate, are never exceeded
+	for i := 0; i < 5; i++ {
+		if exceeded := b.allow(limits, []string{"unlimited", "other"}, now); len(exceeded) > 0 {
+			t.Fatalf("expected unlimited keys never to be exceeded, got %q", exceeded)
+		}
+	}
+}
+
+// withRateLimits replaces the limits in effect until the test ends
+func withRateLimits(t *testing.T, limits, ipLimits map[string]RateLimit) {
+	origLimits, origIPLimits := RateLimits, IPRateLimits
+	RateLimits, IPRateLimits = limits, ipLimits
+	t.Cleanup(func() {
+		RateLimits, IPRateLimits = origLimits, origIPLimits
+	})
+}
+
+func TestRateLimiterChecksBothLimitsBeforeTaking(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 20, 0, 0, 0, time.UTC)
+	withRateLimits(t,
+		map[string]RateLimit{RATE_LIMIT_ANY_EVENT: {Rate: 0.001, Burst: 2}},
+		map[string]RateLimit{RATE_LIMIT_ANY_EVENT: {Rate: 1, Burst: 3}},
+	)
+
+	address := newBuckets()
+	first := &rateLimiter{conn: newBuckets(), address: address}
+	second := &rateLimiter{conn: newBuckets(), address: address}
+
+	for i := 0; i < 2; i++ {
+		if verdict, _ := first.check("", "event", false, now); verdict != nil {
+			t.Fatalf("expected message %v to be allowed, got %+v", i+1, verdict)
+		}
+	}
+	if verdict, _ := first.check("", "event", false, now); verdict == nil || verdict.Limit != RATE_LIMIT_ANY_EVENT {
+		t.Fatalf("expected the connection's limit to be exceeded, got %+v", verdict)
+	}
+
+	// the address' last token is taken by the second connection
+	if verdict, _ := second.check("", "event", false, now); verdict != nil {
+		t.Fatalf("expected the address' last token to be taken, got %+v", verdict)
+	}
+	if verdict, _ := second.check("", "event", false, now); verdict == nil || verdict.Limit != "address:"+RATE_LIMIT_ANY_EVENT {
+		t.Fatalf("expected the address' limit to be exceeded, got %+v", verdict)
+	}
+
+	// once the address' bucket refills, the second connection still holds
+	// the token of the message its address' limit refused
+	now = now.Add(time.Second)
+	if verdict, _ := second.check("", "event", false, now); verdict != nil {
+		t.Fatalf("expected a message refused by the address' limit not to take a connection token, got %+v", verdict)
+	}
+}
+
+func TestRateLimiterEscalation(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 20, 0, 0, 0, time.UTC)
+	withRateLimits(t,
+		map[string]RateLimit{RATE_LIMIT_ANY_EVENT: {Rate: 0.001, Burst: 1}},
+		map[string]RateLimit{},
+	)
+	origWindow, origMute, origDisconnect := FloodWindow, FloodMuteStrikes, FloodDisconnectStrikes
+	FloodWindow, FloodMuteStrikes, FloodDisconnectStrikes = 10*time.Second, 3, 5
+	defer func() {
+		FloodWindow, FloodMuteStrikes, FloodDisconnectStrikes = origWindow, origMute, origDisconnect
+	}()
+
+	l := &rateLimiter{conn: newBuckets(), address: newBuckets()}
+	if verdict, _ := l.check("", "event", false, now); verdict != nil {
+		t.Fatalf("expected a first message to be allowed, got %+v", verdict)
+	}
+
+	tests := []struct {
+		action     string
+		disconnect bool
+	}{
+		{action: RATE_LIMIT_ACTION_WARN},
+		{action: RATE_LIMIT_ACTION_DROP},
+		{action: RATE_LIMIT_ACTION_MUTE},
+		{action: RATE_LIMIT_ACTION_DROP},
+		{action: RATE_LIMIT_ACTION_DROP, disconnect: true},
+	}
+
+	for i, tt := range tests {
+		verdict, disconnect := l.check("id", "event", false, now)
+		if verdict == nil {
+			t.Fatalf("strike %v: expected the message to be dropped", i+1)
+		}
+		if verdict.Action != tt.action || disconnect != tt.disconnect {
+			t.Fatalf("strike %v: expected action %q (disconnect %v), got %q (disconnect %v)", i+1, tt.action, tt.disconnect, verdict.Action, disconnect)
+		}
+		if verdict.Strikes != i+1 || verdict.Id != "id" || verdict.Event != "event" {
+			t.Fatalf("strike %v: unexpected verdict %+v", i+1, verdict)
+		}
+		now = now.Add(time.Second)
+	}
+
+	// strikes are forgotten, and escalation restarts, once the window passes
+	now = now.Add(FloodWindow + time.Second)
+	verdict, disconnect := l.check("", "event", false, now)
+	if verdict == nil || verdict.Action != RATE_LIMIT_ACTION_WARN || verdict.Strikes != 1 || disconnect {
+		t.Fatalf("expected strikes to restart with a warning after the flood window, got %+v", verdict)
+	}
+}
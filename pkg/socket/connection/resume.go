@@ -0,0 +1,214 @@
+package connection
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// RESUME_TOKEN_KEY is the name of the query parameter
+	// carrying a resume token on a socket connection request
+	RESUME_TOKEN_KEY = "resume"
+)
+
+// ResumeGracePeriod is the amount of time a connection that went away
+// may reconnect within, and resume its session. Sessions are not kept
+// if set to zero.
+var ResumeGracePeriod = 30 * time.Second
+
+// SessionClock tells the time, and schedules the expiry of sessions
+type SessionClock interface {
+	// Now returns the current time
+	Now() time.Time
+	// AfterFunc waits for the given duration to elapse and then calls
+	// the given func in its own goroutine, unless the returned
+	// timer is stopped first.
+	AfterFunc(time.Duration, func()) SessionTimer
+}
+
+// SessionTimer is a func call scheduled by a SessionClock
+type SessionTimer interface {
+	// Stop prevents the timer's func from being called. Returns a
+	// boolean (false) if it was already called or stopped.
+	Stop() bool
+}
+
+// realSessionClock implements SessionClock using the time package
+type realSessionClock struct{}
+
+func (c *realSessionClock) Now() time.Time {
+	return time.Now()
+}
+
+func (c *realSessionClock) AfterFunc(d time.Duration, f func()) SessionTimer {
+	return time.AfterFunc(d, f)
+}
+
+// ResumableSession is the state of a connection that is kept after it goes
+// away, so that it may be restored if the connection comes back in time.
+type ResumableSession struct {
+	Token        string
+	ConnectionId string
+	// Identity is the browser identity the session was issued to.
+	// A session may only be resumed by the same browser.
+	Identity string
+	// Usernames is the connection's username history, oldest first
+	Usernames []string
+	// Roles are the names of the rbac roles bound to the connection
+	Roles  []string
+	Invite *Invite
+	// Expires is the time at which the session may no longer be resumed.
+	// A zero time means the connection has not gone away yet.
+	Expires time.Time
+
+	expiry SessionTimer
+}
+
+// NamespaceSessions issues resume tokens to the connections in a namespace,
+// and keeps their sessions for ResumeGracePeriod once they go away.
+type NamespaceSessions interface {
+	// Issue receives a connection and returns a new resume token for it,
+	// replacing any token previously issued to the connection. Returns an
+	// error if sessions are disabled.
+	Issue(Connection) (string, error)
+	// Detach receives the id of a connection that went away, along with its
+	// username history and role names, and keeps its session for
+	// ResumeGracePeriod. The given func is called if the session is not
+	// resumed in time. Returns a boolean (false) if no token was issued to
+	// the connection, in which case the func is never called.
+	Detach(string, []string, []string, func()) bool
+	// Resume receives a resume token and the browser identity presenting it,
+	// and returns the session it was issued for. A session may only be
+	// resumed once. Returns an error if the token is unknown or expired, or
+	// if its connection has not gone away.
+	Resume(string, string) (*ResumableSession, error)
+	// Forget receives a connection id and discards its session, so
+	// that it cannot be resumed. Its expiry func is not called.
+	Forget(string)
+}
+
+// NamespaceSessionsSpec implements NamespaceSessions
+type NamespaceSessionsSpec struct {
+	clock       SessionClock
+	mux         sync.Mutex
+	byToken     map[string]*ResumableSession
+	tokenByConn map[string]string
+}
+
+func (s *NamespaceSessionsSpec) Issue(conn Connection) (string, error) {
+	if ResumeGracePeriod <= 0 {
+		return "", fmt.Errorf("session resume is disabled")
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	invite, _ := conn.Metadata().Invite()
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.forget(conn.UUID())
+	s.byToken[token] = &ResumableSession{
+		Token:        token,
+		ConnectionId: conn.UUID(),
+		Identity:     conn.Metadata().Identity(),
+		Invite:       invite,
+	}
+	s.tokenByConn[conn.UUID()] = token
+	return token, nil
+}
+
+func (s *NamespaceSessionsSpec) Detach(connId string, usernames, roles []string, onExpire func()) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	session, exists := s.byToken[s.tokenByConn[connId]]
+	if !exists || !session.Expires.IsZero() {
+		return false
+	}
+
+	session.Usernames = append([]string{}, usernames...)
+	session.Roles = append([]string{}, roles...)
+	session.Expires = s.clock.Now().Add(ResumeGracePeriod)
+	session.expiry = s.clock.AfterFunc(ResumeGracePeriod, func() {
+		s.mux.Lock()
+		current, exists := s.byToken[session.Token]
+		expired := exists && current == session
+		if expired {
+			s.forget(connId)
+		}
+		s.mux.Unlock()
+
+		if expired && onExpire != nil {
+			onExpire()
+		}
+	})
+	return true
+}
+
+func (s *NamespaceSessionsSpec) Resume(token, identity string) (*ResumableSession, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	session, exists := s.byToken[token]
+	if !exists {
+		return nil, fmt.Errorf("unknown or expired resume token")
+	}
+	if session.Expires.IsZero() {
+		return nil, fmt.Errorf("the session's connection (%s) is still connected", session.ConnectionId)
+	}
+	if s.clock.Now().After(session.Expires) {
+		return nil, fmt.Errorf("unknown or expired resume token")
+	}
+	if len(session.Identity) > 0 && session.Identity != identity {
+		return nil, fmt.Errorf("resume token was issued to another browser")
+	}
+
+	session.expiry.Stop()
+	s.forget(session.ConnectionId)
+
+	resumed := *session
+	resumed.expiry = nil
+	return &resumed, nil
+}
+
+func (s *NamespaceSessionsSpec) Forget(connId string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.forget(connId)
+}
+
+// forget discards a connection's session. Requires mux to be held.
+func (s *NamespaceSessionsSpec) forget(connId string) {
+	token, exists := s.tokenByConn[connId]
+	if !exists {
+		return
+	}
+
+	if session, exists := s.byToken[token]; exists && session.expiry != nil {
+		session.expiry.Stop()
+	}
+	delete(s.byToken, token)
+	delete(s.tokenByConn, connId)
+}
+
+func NewNamespaceSessions() NamespaceSessions {
+	return NewNamespaceSessionsWithClock(&realSessionClock{})
+}
+
+// NewNamespaceSessionsWithClock returns NamespaceSessions whose
+// grace periods are timed and expired by the given clock
+func NewNamespaceSessionsWithClock(clock SessionClock) NamespaceSessions {
+	return &NamespaceSessionsSpec{
+		clock:       clock,
+		byToken:     make(map[string]*ResumableSession),
+		tokenByConn: make(map[string]string),
+	}
+}
@@ -0,0 +1,248 @@
+package connection
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSessionClock implements SessionClock. Its time only moves
+// when advanced, calling the func of every timer that is due.
+type fakeSessionClock struct {
+	mux    sync.Mutex
+	now    time.Time
+	timers []*fakeSessionTimer
+}
+
+type fakeSessionTimer struct {
+	clock   *fakeSessionClock
+	at      time.Time
+	f       func()
+	stopped bool
+}
+
+func (t *fakeSessionTimer) Stop() bool {
+	t.clock.mux.Lock()
+	defer t.clock.mux.Unlock()
+
+	wasPending := !t.stopped
+	t.stopped = true
+	return wasPending
+}
+
+func (c *fakeSessionClock) Now() time.Time {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	return c.now
+}
+
+func (c *fakeSessionClock) AfterFunc(d time.Duration, f func()) SessionTimer {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	t := &fakeSessionTimer{clock: c, at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the clock forward, and calls the func of every timer that is due
+func (c *fakeSessionClock) Advance(d time.Duration) {
+	c.mux.Lock()
+	c.now = c.now.Add(d)
+	due := []*fakeSessionTimer{}
+	pending := []*fakeSessionTimer{}
+	for _, t := range c.timers {
+		switch {
+		case t.stopped:
+		case t.at.After(c.now):
+			pending = append(pending, t)
+		default:
+			t.stopped = true
+			due = append(due, t)
+		}
+	}
+	c.timers = pending
+	c.mux.Unlock()
+
+	for _, t := range due {
+		t.f()
+	}
+}
+
+func newFakeSessionClock() *fakeSessionClock {
+	return &fakeSessionClock{
+		now: time.Date(2020, time.January, 1, 20, 0, 0, 0, time.UTC),
+	}
+}
+
+type fakeSessionConn struct {
+	Connection
+
+	id       string
+	metadata ConnectionMetadata
+}
+
+func (c *fakeSessionConn) UUID() string {
+	return c.id
+}
+
+func (c *fakeSessionConn) Metadata() ConnectionMetadata {
+	return c.metadata
+}
+
+func newFakeSessionConn(id, identity string) *fakeSessionConn {
+	metadata := NewConnectionMetadata()
+	metadata.SetIdentity(identity)
+	return &fakeSessionConn{
+		id:       id,
+		metadata: metadata,
+	}
+}
+
+// expiryCounter counts the calls of the expiry funcs it returns
+type expiryCounter struct {
+	mux   sync.Mutex
+	calls int
+}
+
+func (c *expiryCounter) onExpire() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.calls++
+}
+
+func (c *expiryCounter) count() int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.calls
+}
+
+func TestSessionResume(t *testing.T) {
+	clock := newFakeSessionClock()
+	sessions := NewNamespaceSessionsWithClock(clock)
+	released := &expiryCounter{}
+
+	token, err := sessions.Issue(newFakeSessionConn("conn-alice", "identity-alice"))
+	if err != nil {
+		t.Fatalf("unable to issue a token: %v", err)
+	}
+	if _, err := sessions.Resume(token, "identity-alice"); err == nil {
+		t.Fatalf("expected a session whose connection is still connected not to be resumed")
+	}
+
+	if !sessions.Detach("conn-alice", []string{"alice", "ally"}, []string{"user"}, released.onExpire) {
+		t.Fatalf("expected the connection's session to be kept")
+	}
+	if sessions.Detach("conn-alice", nil, nil, released.onExpire) {
+		t.Fatalf("expected a detached session not to be detached again")
+	}
+
+	clock.Advance(ResumeGracePeriod / 2)
+	if _, err := sessions.Resume(token, "identity-bob"); err == nil {
+		t.Fatalf("expected a session not to be resumed by another browser")
+	}
+	if _, err := sessions.Resume("unknown", "identity-alice"); err == nil {
+		t.Fatalf("expected an unknown token to be refused")
+	}
+
+	session, err := sessions.Resume(token, "identity-alice")
+	if err != nil {
+		t.Fatalf("unable to resume session: %v", err)
+	}
+	if session.ConnectionId != "conn-alice" || len(session.Usernames) != 2 || session.Usernames[1] != "ally" || len(session.Roles) != 1 {
+		t.Fatalf("unexpected resumed session %+v", session)
+	}
+	if expected := clock.Now().Add(ResumeGracePeriod / 2); !session.Expires.Equal(expected) {
+		t.Fatalf("expected the session to expire at %v, got %v", expected, session.Expires)
+	}
+
+	// a session is resumed only once, and its expiry func is never called
+	if _, err := sessions.Resume(token, "identity-alice"); err == nil {
+		t.Fatalf("expected a resumed session not to be resumed again")
+	}
+	clock.Advance(ResumeGracePeriod)
+	if calls := released.count(); calls != 0 {
+		t.Fatalf("expected the queue of a resumed session not to be released, got %v releases", calls)
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	clock := newFakeSessionClock()
+	sessions := NewNamespaceSessionsWithClock(clock)
+	released := &expiryCounter{}
+
+	token, err := sessions.Issue(newFakeSessionConn("conn-alice", "identity-alice"))
+	if err != nil {
+		t.Fatalf("unable to issue a token: %v", err)
+	}
+	sessions.Detach("conn-alice", nil, nil, released.onExpire)
+
+	// the queue is released only once the grace period elapses
+	clock.Advance(ResumeGracePeriod - time.Second)
+	if calls := released.count(); calls != 0 {
+		t.Fatalf("expected the queue not to be released within the grace period, got %v releases", calls)
+	}
+	clock.Advance(time.Second)
+	if calls := released.count(); calls != 1 {
+		t.Fatalf("expected the queue to be released once the grace period elapsed, got %v releases", calls)
+	}
+	if _, err := sessions.Resume(token, "identity-alice"); err == nil {
+		t.Fatalf("expected an expired session not to be resumed")
+	}
+
+	clock.Advance(ResumeGracePeriod)
+	if calls := released.count(); calls != 1 {
+		t.Fatalf("expected the queue to be released once, got %v releases", calls)
+	}
+}
+
+func TestSessionForgetAndReissue(t *testing.T) {
+	clock := newFakeSessionClock()
+	sessions := NewNamespaceSessionsWithClock(clock)
+	released := &expiryCounter{}
+
+	conn := newFakeSessionConn("conn-alice", "identity-alice")
+	first, err := sessions.Issue(conn)
+	if err != nil {
+		t.Fatalf("unable to issue a token: %v", err)
+	}
+	second, err := sessions.Issue(conn)
+	if err != nil {
+		t.Fatalf("unable to issue a token: %v", err)
+	}
+	if first == second {
+		t.Fatalf("expected a new token to be issued")
+	}
+
+	sessions.Detach("conn-alice", nil, nil, released.onExpire)
+	if _, err := sessions.Resume(first, "identity-alice"); err == nil {
+		t.Fatalf("expected a replaced token to be refused")
+	}
+
+	// a forgotten session is never resumed, nor its expiry func called
+	sessions.Forget("conn-alice")
+	if _, err := sessions.Resume(second, "identity-alice"); err == nil {
+		t.Fatalf("expected a forgotten session not to be resumed")
+	}
+	clock.Advance(ResumeGracePeriod)
+	if calls := released.count(); calls != 0 {
+		t.Fatalf("expected the expiry func of a forgotten session not to be called, got %v calls", calls)
+	}
+
+	if sessions.Detach("conn-bob", nil, nil, released.onExpire) {
+		t.Fatalf("expected a connection with no token not to be detached")
+	}
+}
+
+func TestSessionsDisabled(t *testing.T) {
+	origGracePeriod := ResumeGracePeriod
+	ResumeGracePeriod = 0
+	defer func() {
+		ResumeGracePeriod = origGracePeriod
+	}()
+
+	if _, err := NewNamespaceSessionsWithClock(newFakeSessionClock()).Issue(newFakeSessionConn("conn-alice", "identity-alice")); err == nil {
+		t.Fatalf("expected no token to be issued with sessions disabled")
+	}
+}
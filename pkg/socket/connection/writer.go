@@ -0,0 +1,286 @@
+package connection
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/juanvallejo/streaming-server/pkg/socket/protocol"
+)
+
+var (
+	// WriteQueueSize is the max amount of messages waiting to be written
+	// to a connection. A connection whose queue overflows is too slow to
+	// keep up with its room, and is disconnected.
+	WriteQueueSize = 256
+
+	// WriteTimeout is the amount of time a single write to a connection may
+	// take. A connection that does not accept a message in time is closed.
+	WriteTimeout = 10 * time.Second
+
+	// CoalescedEvents are events whose messages carry the full current
+	// state of a room. A newer message for one of these events supersedes
+	// any message for the same event still waiting to be written.
+	CoalescedEvents = map[string]bool{
+		protocol.EVENT_STREAMSYNC: true,
+		protocol.EVENT_QUEUESYNC:  true,
+	}
+)
+
+// WriterMetrics counts the messages handled by connection writers
+// since the server started. Implements api.ApiCodec.
+type WriterMetrics struct {
+	// Queued is the amount of messages accepted into a write queue
+	Queued uint64 `json:"queued"`
+	// Written is the amount of messages written to a connection
+	Written uint64 `json:"written"`
+	// Coalesced is the amount of queued messages superseded by newer ones
+	Coalesced uint64 `json:"coalesced"`
+	// Dropped is the amount of messages discarded without being written,
+	// because their connection overflowed, failed, or was closed
+	Dropped uint64 `json:"dropped"`
+	// Overflows is the amount of connections disconnected
+	// for letting their write queue fill up
+	Overflows uint64 `json:"overflows"`
+	// Failures is the amount of writes that failed or timed out
+	Failures uint64 `json:"failures"`
+}
+
+func (m *WriterMetrics) Serialize() ([]byte, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	return b, nil
+}
+
+var writerMetrics WriterMetrics
+
+// OutboundMetrics returns the totals of messages handled by every connection writer
+func OutboundMetrics() *WriterMetrics {
+	return &WriterMetrics{
+		Queued:    atomic.LoadUint64(&writerMetrics.Queued),
+		Written:   atomic.LoadUint64(&writerMetrics.Written),
+		Coalesced: atomic.LoadUint64(&writerMetrics.Coalesced),
+		Dropped:   atomic.LoadUint64(&writerMetrics.Dropped),
+		Overflows: atomic.LoadUint64(&writerMetrics.Overflows),
+		Failures:  atomic.LoadUint64(&writerMetrics.Failures),
+	}
+}
+
+type outboundMessage struct {
+	messageType int
+	event       string
+	data        []byte
+}
+
+// writerConn is the socket a writer writes to. Implemented by SocketConn.
+type writerConn interface {
+	UUID() string
+	WriteMessage(int, []byte) error
+	// Close closes the socket right away
+	Close() error
+
+	ping() error
+	closeWithReason(string) func()
+}
+
+// writer writes a connection's queued messages from its own goroutine,
+// so that broadcasting to a room never waits on a slow connection.
+type writer struct {
+	conn writerConn
+
+	mux    sync.Mutex
+	queue  []*outboundMessage
+	closed bool
+	// flush is set if messages queued before the writer
+	// was closed are still to be written
+	flush bool
+	// onClose is called by the writer goroutine once it exits
+	onClose func()
+	dropped uint64
+
+	wake chan bool
+}
+
+// enqueue adds a message to the write queue, replacing any queued message
+// for the same event if the event is coalesced. Returns an error if the
+// writer is closed, or if the queue is full, in which case the connection
+// is disconnected.
+func (w *writer) enqueue(m *outboundMessage) error {
+	w.mux.Lock()
+	if w.closed {
+		w.dropped++
+		w.mux.Unlock()
+		atomic.AddUint64(&writerMetrics.Dropped, 1)
+		return fmt.Errorf("connection (%s) is closed", w.conn.UUID())
+	}
+
+	if len(m.event) > 0 && CoalescedEvents[m.event] {
+		for i, queued := range w.queue {
+			if queued.event == m.event {
+				w.queue = append(w.queue[:i], w.queue[i+1:]...)
+				atomic.AddUint64(&writerMetrics.Coalesced, 1)
+				break
+			}
+		}
+	}
+
+	if len(w.queue) >= WriteQueueSize {
+		w.mux.Unlock()
+		atomic.AddUint64(&writerMetrics.Overflows, 1)
+		log.Printf("WRN SOCKET CONN WRITER write queue for connection (%s) is full (%v messages); disconnecting...", w.conn.UUID(), WriteQueueSize)
+		w.close(false, w.conn.closeWithReason("write queue overflow"))
+
+		// the message itself was not queued
+		w.mux.Lock()
+		w.dropped++
+		w.mux.Unlock()
+		atomic.AddUint64(&writerMetrics.Dropped, 1)
+		return fmt.Errorf("write queue for connection (%s) is full", w.conn.UUID())
+	}
+
+	w.queue = append(w.queue, m)
+	w.mux.Unlock()
+	atomic.AddUint64(&writerMetrics.Queued, 1)
+
+	w.signal()
+	return nil
+}
+
+func (w *writer) signal() {
+	select {
+	case w.wake <- true:
+	default:
+	}
+}
+
+// close stops the writer. If flush is true, messages already queued are
+// written first. onClose is called by the writer goroutine once it exits.
+// Returns a boolean (false) if the writer was already closed.
+func (w *writer) close(flush bool, onClose func()) bool {
+	w.mux.Lock()
+	if w.closed {
+		w.mux.Unlock()
+		return false
+	}
+	w.closed = true
+	w.flush = flush
+	w.onClose = onClose
+	w.mux.Unlock()
+
+	w.signal()
+	return true
+}
+
+// next returns the next message to write, and a boolean (true) if there
+// was one. The first boolean returned is false if the writer has nothing
+// left to write and should exit.
+func (w *writer) next() (*outboundMessage, bool, bool) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	if w.closed && !w.flush {
+		w.discard()
+		return nil, false, false
+	}
+	if len(w.queue) == 0 {
+		return nil, !w.closed, false
+	}
+
+	m := w.queue[0]
+	w.queue[0] = nil
+	w.queue = w.queue[1:]
+	return m, true, true
+}
+
+// discard drops every queued message. Requires mux to be held.
+func (w *writer) discard() {
+	if len(w.queue) == 0 {
+		return
+	}
+
+	w.dropped += uint64(len(w.queue))
+	atomic.AddUint64(&writerMetrics.Dropped, uint64(len(w.queue)))
+	w.queue = nil
+}
+
+// run writes queued messages as they are added, and pings
+// the connection every PingInterval, until the writer is closed
+func (w *writer) run() {
+	var heartbeat <-chan time.Time
+	if heartbeatsEnabled() {
+		ticker := time.NewTicker(PingInterval)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+
+	for {
+		select {
+		case <-heartbeat:
+			if err := w.conn.ping(); err != nil {
+				w.fail(fmt.Errorf("unable to send ping: %v", err))
+				return
+			}
+			continue
+		case <-w.wake:
+		}
+
+		for {
+			m, running, hasMessage := w.next()
+			if !running {
+				w.exit()
+				return
+			}
+			if !hasMessage {
+				break
+			}
+
+			if err := w.conn.WriteMessage(m.messageType, m.data); err != nil {
+				w.fail(err)
+				return
+			}
+			atomic.AddUint64(&writerMetrics.Written, 1)
+		}
+	}
+}
+
+// fail discards every queued message after a failed write,
+// closes the connection's socket, and stops the writer
+func (w *writer) fail(err error) {
+	atomic.AddUint64(&writerMetrics.Failures, 1)
+	log.Printf("ERR SOCKET CONN WRITER unable to write to connection (%s): %v; closing...", w.conn.UUID(), err)
+
+	w.close(false, func() {
+		w.conn.Close()
+	})
+	w.mux.Lock()
+	w.flush = false
+	w.discard()
+	w.mux.Unlock()
+	w.exit()
+}
+
+func (w *writer) exit() {
+	w.mux.Lock()
+	onClose, dropped := w.onClose, w.dropped
+	w.mux.Unlock()
+
+	if dropped > 0 {
+		log.Printf("INF SOCKET CONN WRITER writer for connection (%s) stopped; %v messages were dropped", w.conn.UUID(), dropped)
+	}
+	if onClose != nil {
+		onClose()
+	}
+}
+
+func newWriter(conn writerConn) *writer {
+	return &writer{
+		conn: conn,
+		wake: make(chan bool, 1),
+	}
+}
@@ -0,0 +1,269 @@
+package connection
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/juanvallejo/streaming-server/pkg/socket/protocol"
+)
+
+const writerTestTimeout = 5 * time.Second
+
+// fakeWriterConn records the messages written to it,
+// or fails every write with writeErr if it is set.
+type fakeWriterConn struct {
+	mux      sync.Mutex
+	written  []string
+	writeErr error
+
+	// closed is closed once the socket is closed, with or without a reason
+	closed chan bool
+	reason string
+}
+
+func (c *fakeWriterConn) UUID() string {
+	return "fake"
+}
+
+func (c *fakeWriterConn) WriteMessage(messageType int, data []byte) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.writeErr != nil {
+		return c.writeErr
+	}
+	c.written = append(c.written, string(data))
+	return nil
+}
+
+func (c *fakeWriterConn) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func (c *fakeWriterConn) ping() error {
+	return nil
+}
+
+func (c *fakeWriterConn) closeWithReason(reason string) func() {
+	return func() {
+		c.mux.Lock()
+		c.reason = reason
+		c.mux.Unlock()
+		c.Close()
+	}
+}
+
+// awaitClosed waits for the socket to be closed, and returns the messages written to it
+func (c *fakeWriterConn) awaitClosed(t *testing.T) []string {
+	t.Helper()
+
+	select {
+	case <-c.closed:
+	case <-time.After(writerTestTimeout):
+		t.Fatalf("timed out waiting for the socket to be closed")
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return append([]string{}, c.written...)
+}
+
+func newFakeWriterConn() *fakeWriterConn {
+	return &fakeWriterConn{
+		closed: make(chan bool),
+	}
+}
+
+func enqueueAll(t *testing.T, w *writer, messages ...[2]string) {
+	t.Helper()
+
+	for _, m := range messages {
+		if err := w.enqueue(&outboundMessage{messageType: websocket.TextMessage, event: m[0], data: []byte(m[1])}); err != nil {
+			t.Fatalf("unable to enqueue %q: %v", m[1], err)
+		}
+	}
+}
+
+// writerMetricsDelta returns the metrics counted since the given totals
+func writerMetricsDelta(since *WriterMetrics) WriterMetrics {
+	now := OutboundMetrics()
+	return WriterMetrics{
+		Queued:    now.Queued - since.Queued,
+		Written:   now.Written - since.Written,
+		Coalesced: now.Coalesced - since.Coalesced,
+		Dropped:   now.Dropped - since.Dropped,
+		Overflows: now.Overflows - since.Overflows,
+		Failures:  now.Failures - since.Failures,
+	}
+}
+
+func expectWritten(t *testing.T, expected, actual []string) {
+	t.Helper()
+
+	if strings.Join(actual, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected messages %v to be written, got %v", expected, actual)
+	}
+}
+
+func TestWriterCoalescesAndFlushes(t *testing.T) {
+	since := OutboundMetrics()
+	conn := newFakeWriterConn()
+	w := newWriter(conn)
+
+	// messages are queued before the writer runs, so none is written early
+	enqueueAll(t, w,
+		[2]string{protocol.EVENT_QUEUESYNC, "queue-1"},
+		[2]string{protocol.EVENT_STREAMSYNC, "stream-1"},
+		[2]string{"chatmessage", "chat-1"},
+		[2]string{protocol.EVENT_STREAMSYNC, "stream-2"},
+		[2]string{"chatmessage", "chat-2"},
+		[2]string{protocol.EVENT_QUEUESYNC, "queue-2"},
+	)
+
+	// closing with a flush, as Disconnect does, writes queued messages first
+	if !w.close(true, conn.closeWithReason("bye")) {
+		t.Fatalf("expected the writer to be open")
+	}
+	if w.close(true, nil) {
+		t.Fatalf("expected a closed writer not to be closed again")
+	}
+	go w.run()
+
+	expectWritten(t, []string{"chat-1", "stream-2", "chat-2", "queue-2"}, conn.awaitClosed(t))
+	if conn.reason != "bye" {
+		t.Fatalf("expected the socket to be closed with reason %q, got %q", "bye", conn.reason)
+	}
+
+	delta := writerMetricsDelta(since)
+	if delta.Queued != 6 || delta.Coalesced != 2 || delta.Written != 4 || delta.Dropped != 0 {
+		t.Fatalf("expected 6 queued, 2 coalesced, 4 written and none dropped, got %+v", delta)
+	}
+
+	if err := w.enqueue(&outboundMessage{event: "chatmessage", data: []byte("late")}); err == nil {
+		t.Fatalf("expected a message enqueued after the writer closed to be refused")
+	}
+	if delta := writerMetricsDelta(since); delta.Dropped != 1 {
+		t.Fatalf("expected the refused message to be counted as dropped, got %+v", delta)
+	}
+}
+
+func TestWriterOverflowDisconnects(t *testing.T) {
+	origSize := WriteQueueSize
+	WriteQueueSize = 2
+	defer func() {
+		WriteQueueSize = origSize
+	}()
+
+	since := OutboundMetrics()
+	conn := newFakeWriterConn()
+	w := newWriter(conn)
+
+	enqueueAll(t, w,
+		[2]string{"chatmessage", "chat-1"},
+		[2]string{protocol.EVENT_STREAMSYNC, "stream-1"},
+		// coalesced messages replace a queued one, and never overflow
+		[2]string{protocol.EVENT_STREAMSYNC, "stream-2"},
+	)
+	if err := w.enqueue(&outboundMessage{event: "chatmessage", data: []byte("chat-2")}); err == nil {
+		t.Fatalf("expected a message overflowing the queue to be refused")
+	}
+	if err := w.enqueue(&outboundMessage{event: "chatmessage", data: []byte("chat-3")}); err == nil {
+		t.Fatalf("expected a message enqueued after an overflow to be refused")
+	}
+	go w.run()
+
+	// the overflowing connection is disconnected without flushing its queue
+	expectWritten(t, nil, conn.awaitClosed(t))
+	if conn.reason != "write queue overflow" {
+		t.Fatalf("expected the socket to be closed for overflowing, got reason %q", conn.reason)
+	}
+
+	delta := writerMetricsDelta(since)
+	if delta.Overflows != 1 || delta.Coalesced != 1 || delta.Dropped != 4 || delta.Written != 0 {
+		t.Fatalf("expected 1 overflow, 1 coalesced, 4 dropped and none written, got %+v", delta)
+	}
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	if w.dropped != 4 {
+		t.Fatalf("expected the writer to count 4 dropped messages, got %v", w.dropped)
+	}
+}
+
+func TestWriterFailureDiscardsQueue(t *testing.T) {
+	since := OutboundMetrics()
+	conn := newFakeWriterConn()
+	conn.writeErr = fmt.Errorf("broken pipe")
+	w := newWriter(conn)
+
+	enqueueAll(t, w,
+		[2]string{"chatmessage", "chat-1"},
+		[2]string{"chatmessage", "chat-2"},
+		[2]string{"chatmessage", "chat-3"},
+	)
+	go w.run()
+	w.signal()
+
+	expectWritten(t, nil, conn.awaitClosed(t))
+	if len(conn.reason) > 0 {
+		t.Fatalf("expected a failed socket to be closed without a reason, got %q", conn.reason)
+	}
+
+	// the message whose write failed is not counted as dropped
+	delta := writerMetricsDelta(since)
+	if delta.Failures != 1 || delta.Dropped != 2 || delta.Written != 0 {
+		t.Fatalf("expected 1 failure, 2 dropped and none written, got %+v", delta)
+	}
+}
+
+func TestDisconnectFlushesQueuedMessages(t *testing.T) {
+	released := make(chan bool)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("unable to upgrade connection: %v", err)
+			return
+		}
+
+		conn := NewConnection(NewNamespaceHandler(), ws, w, r)
+		for i := 0; i < 3; i++ {
+			conn.Send("chatmessage", []byte(fmt.Sprintf("chat-%v", i+1)))
+		}
+		if err := conn.Disconnect("bye"); err != nil {
+			t.Errorf("unable to disconnect: %v", err)
+		}
+		if err := conn.Disconnect("bye"); err == nil {
+			t.Errorf("expected a closed connection not to be disconnected again")
+		}
+		<-released
+	}))
+	defer srv.Close()
+	defer close(released)
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("unable to connect: %v", err)
+	}
+	defer ws.Close()
+	ws.SetReadDeadline(time.Now().Add(writerTestTimeout))
+
+	received := []string{}
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			closeErr, ok := err.(*websocket.CloseError)
+			if !ok || closeErr.Code != websocket.ClosePolicyViolation || closeErr.Text != "bye" {
+				t.Fatalf("expected the connection to be closed with reason %q, got %v", "bye", err)
+			}
+			break
+		}
+		received = append(received, string(data))
+	}
+	expectWritten(t, []string{"chat-1", "chat-2", "chat-3"}, received)
+}
@@ -0,0 +1,102 @@
+package socket
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/juanvallejo/streaming-server/pkg/socket/cmd"
+	"github.com/juanvallejo/streaming-server/pkg/socket/connection"
+	"github.com/juanvallejo/streaming-server/pkg/socket/protocol"
+)
+
+// nestedMessage returns a chat message whose data nests depth arrays
+func nestedMessage(depth int) []byte {
+	return []byte(`{"event":"request_chatmessage","data":{"message":"hi","nested":` + strings.Repeat("[", depth) + strings.Repeat("]", depth) + `}}`)
+}
+
+// FuzzInboundMessage fuzzes the path a message received on a socket
+// connection takes before reaching a room: decoding and limiting the
+// message, decoding and validating its payload as every inbound event,
+// and parsing chat messages as commands and media. Any panic is a bug.
+// The seed corpus runs with go test; fuzz it with:
+//
+//	go test -run '^$' -fuzz FuzzInboundMessage ./pkg/socket
+func FuzzInboundMessage(f *testing.F) {
+	seeds := [][]byte{
+		{},
+		[]byte(`{}`),
+		[]byte(`null`),
+		[]byte(`{"event":"request_chatmessage"}`),
+		[]byte(`{"event":"request_chatmessage","data":null}`),
+		[]byte(`{"event":"request_chatmessage","data":[]}`),
+		[]byte(`{"event":"request_chatmessage","data":{"user":"alice","message":"hello https://example.com/a.png there"}}`),
+		[]byte(`{"id":"1","event":"request_chatmessage","data":{"message":"/queue add https://example.com/video.mp4 10-20"}}`),
+		[]byte(`{"event":"request_chatmessage","data":{"message":"/room lock secret"}}`),
+		// empty commands
+		[]byte(`{"event":"request_chatmessage","data":{"message":"/"}}`),
+		[]byte(`{"event":"request_chatmessage","data":{"message":"/   "}}`),
+		[]byte(`{"event":"request_chatmessage","data":{"message":""}}`),
+		[]byte(`{"event":"request_chatmessage","data":{"message":7}}`),
+		[]byte(`{"event":"request_updateusername","data":{"user":""}}`),
+		[]byte(`{"event":"streamdata","data":{"name":"video","duration":-1,"thumb":"javascript:alert(1)"}}`),
+		[]byte(`{"event":"streamdata","data":{"duration":1e400}}`),
+		[]byte(`{"event":"","data":{}}`),
+		[]byte(`{"event":"request_userlist","data":{},"id":` + `"` + strings.Repeat("x", connection.MaxMessageIdLength+1) + `"}`),
+		[]byte("{\"event\":\"request_chatmessage\",\"data\":{\"message\":\"\xff\xfe\"}}"),
+		// deeply nested json
+		nestedMessage(connection.MaxMessageDepth - 1),
+		nestedMessage(connection.MaxMessageDepth + 1),
+		nestedMessage(10000),
+		[]byte(strings.Repeat("[", 10000)),
+		// oversized frames
+		[]byte(`{"event":"request_chatmessage","data":{"message":"` + strings.Repeat("a", int(connection.MaxMessageSize)) + `"}}`),
+		[]byte(`{"event":"request_userlist","data":{"items":[` + strings.Repeat("0,", connection.MaxMessageElements) + `0]}}`),
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	h := &Handler{}
+	commands := cmd.NewHandler()
+	f.Fuzz(func(t *testing.T, data []byte) {
+		message, messageData, err := connection.DecodeMessage(data)
+		if err != nil {
+			return
+		}
+
+		if int64(len(data)) > connection.MaxMessageSize {
+			t.Fatalf("expected a message of %v bytes to be refused", len(data))
+		}
+		if len(message.Event) == 0 || len(message.Event) > connection.MaxEventLength {
+			t.Fatalf("expected a message with event %q to be refused", message.Event)
+		}
+		b, err := messageData.Serialize()
+		if err != nil {
+			t.Fatalf("unable to serialize decoded message data: %v", err)
+		}
+		if !json.Valid(b) || b[0] != '{' {
+			t.Fatalf("expected decoded message data to be a json object, got %q", b)
+		}
+
+		requests := []protocol.Request{
+			&protocol.EmptyRequest{},
+			&protocol.ChatMessageRequest{},
+			&protocol.UpdateUsernameRequest{},
+			&protocol.StreamDataRequest{},
+		}
+		for _, req := range requests {
+			protocol.Decode(messageData, req)
+		}
+
+		chat := &protocol.ChatMessageRequest{}
+		if protocol.Decode(messageData, chat) != nil {
+			return
+		}
+		if command, isCommand := h.ParseCommandMessage(chat.Message); isCommand {
+			cmdRoot, cmdArgs := SplitCommand(command)
+			cmd.RedactCommand(commands, cmdRoot, cmdArgs)
+		}
+		h.ParseMessageMedia(chat.Message)
+	})
+}
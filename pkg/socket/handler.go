@@ -14,7 +14,9 @@ import (
 	playbackutil "github.com/juanvallejo/streaming-server/pkg/playback/util"
 	"github.com/juanvallejo/streaming-server/pkg/socket/client"
 	"github.com/juanvallejo/streaming-server/pkg/socket/cmd"
+	"github.com/juanvallejo/streaming-server/pkg/socket/cmd/rbac"
 	"github.com/juanvallejo/streaming-server/pkg/socket/connection"
+	"github.com/juanvallejo/streaming-server/pkg/socket/protocol"
 	socketserver "github.com/juanvallejo/streaming-server/pkg/socket/server"
 	"github.com/juanvallejo/streaming-server/pkg/socket/util"
 	"github.com/juanvallejo/streaming-server/pkg/stream"
@@ -40,32 +42,42 @@ func (h *Handler) HandleClientConnection(conn connection.Connection) {
 	h.RegisterClient(conn)
 	log.Printf("INF SOCKET currently %v clients registered\n", h.clientHandler.GetClientSize())
 
-	conn.On("disconnection", func(data connection.MessageDataCodec) {
+	conn.On(connection.DISCONNECTION_EVENT, func(data connection.MessageDataCodec) {
 		log.Printf("INF DCONN SOCKET client with id %q has disconnected\n", conn.UUID())
 
+		var (
+			ns        connection.Namespace
+			usernames []string
+			roles     []string
+		)
 		if c, err := h.clientHandler.GetClient(conn.UUID()); err == nil {
 			userName, exists := c.GetUsername()
 			if !exists {
 				userName = c.UUID()
 			}
-			c.BroadcastFrom("info_clientleft", &client.Response{
+			c.BroadcastFrom(protocol.EVENT_INFO_CLIENTLEFT, &client.Response{
 				Id:   conn.UUID(),
 				From: userName,
 			})
 
-			ns, exists := c.Namespace()
+			ns, exists = c.Namespace()
 			if exists {
+				authorizer := h.CommandHandler.Authorizer()
 				sPlayback, sPlaybackExists := h.PlaybackHandler.PlaybackByNamespace(ns)
 				if sPlaybackExists {
-					// update room's last updated time to give buffer
-					// between last client leaving and room reaping.
-					sPlayback.SetLastUpdated(time.Now())
+					sPlayback.Do(func(sPlayback *playback.Playback) {
+						// update room's last updated time to give buffer
+						// between last client leaving and room reaping.
+						sPlayback.SetLastUpdated(time.Now())
+						sPlayback.HandleDisconnection(c.Connection(), authorizer, h.clientHandler)
+					})
 				}
 
-				// remove user from authorizer role-bindings
-				authorizer := h.CommandHandler.Authorizer()
-				sPlayback.HandleDisconnection(c.Connection(), authorizer, h.clientHandler)
+				// remove user from authorizer role-bindings,
+				// keeping their names in case the session is resumed
+				usernames = c.Usernames()
 				if authorizer != nil {
+					roles = rbac.SubjectRoleNames(authorizer.Bindings(), c.Connection())
 					for _, b := range authorizer.Bindings() {
 						b.RemoveSubject(c.Connection())
 					}
@@ -76,125 +88,124 @@ func (h *Handler) HandleClientConnection(conn connection.Connection) {
 		if err := h.DeregisterClient(conn); err != nil {
 			log.Printf("ERR SOCKET %v", err)
 		}
+
+		if ns != nil {
+			h.detachSession(ns, conn.UUID(), usernames, roles)
+		}
 	})
 
-	// this event is received when a client is requesting a username update
-	conn.On("request_updateusername", func(data connection.MessageDataCodec) {
-		messageData, ok := data.(connection.MessageData)
+	// this event is emitted by the connection when a message received
+	// on it is dropped for exceeding a rate limit
+	conn.On(connection.RATE_LIMITED_EVENT, func(data connection.MessageDataCodec) {
+		verdict, ok := data.(*connection.RateLimitVerdict)
 		if !ok {
-			log.Printf("ERR SOCKET CLIENT socket connection event handler for event %q received data of wrong type. Expecting connection.MessageData", "request_chatmessage")
+			log.Printf("ERR SOCKET CLIENT socket connection event handler for event %q received data of wrong type. Expecting *connection.RateLimitVerdict", connection.RATE_LIMITED_EVENT)
 			return
 		}
 
-		rawUsername, ok := messageData.Key("user")
-		if !ok {
-			log.Printf("ERR SOCKET CLIENT client %q sent malformed request to update username. Ignoring request.", conn.UUID())
+		c, err := h.clientHandler.GetClient(conn.UUID())
+		if err != nil {
+			log.Printf("ERR SOCKET CLIENT could not retrieve rate limited client: %v", err)
 			return
 		}
 
-		username, ok := rawUsername.(string)
-		if !ok {
-			log.Printf("ERR SOCKET CLIENT client %q sent a non-string value for the field %q", conn.UUID(), "username")
+		if verdict.Action == connection.RATE_LIMIT_ACTION_MUTE {
+			h.muteFlooder(c)
+		}
+
+		if len(verdict.Id) > 0 {
+			c.ReplyError(verdict.Id, verdict.Event, client.ERR_CODE_RATE_LIMITED, fmt.Errorf("error: too many %q messages; slow down", verdict.Event))
 			return
 		}
+		if verdict.Action == connection.RATE_LIMIT_ACTION_WARN {
+			c.BroadcastSystemMessageTo("You are sending messages too quickly; messages will be dropped until you slow down.")
+		}
+	})
 
+	// this event is received when a client is requesting a username update
+	conn.On(protocol.EVENT_REQUEST_UPDATEUSERNAME, func(data connection.MessageDataCodec) {
 		c, err := h.clientHandler.GetClient(conn.UUID())
 		if err != nil {
-			log.Printf("ERR SOCKET CLIENT %v. Broadcasting as info_clienterror event", err)
-			c.BroadcastErrorTo(err)
+			log.Printf("ERR SOCKET CLIENT %v. Ignoring request_updateusername request", err)
 			return
 		}
 
-		err = util.UpdateClientUsername(c, username, h.clientHandler)
-		if err != nil {
-			log.Printf("ERR SOCKET CLIENT %v. Broadcasting as \"info_clienterror\" event", err)
-			c.BroadcastErrorTo(err)
+		req := &protocol.UpdateUsernameRequest{}
+		if err := protocol.Decode(data, req); err != nil {
+			log.Printf("ERR SOCKET CLIENT client %q sent malformed request to update username: %v", conn.UUID(), err)
+			replyError(c, protocol.EVENT_REQUEST_UPDATEUSERNAME, data, client.ERR_CODE_INVALID_REQUEST, err, c.BroadcastErrorTo)
 			return
 		}
-	})
 
-	// this event is received when a client is requesting to broadcast a chat message
-	conn.On("request_chatmessage", func(data connection.MessageDataCodec) {
-		messageData, ok := data.(connection.MessageData)
-		if !ok {
-			log.Printf("ERR SOCKET CLIENT socket connection event handler for event %q received data of wrong type. Expecting connection.MessageData", "request_chatmessage")
+		err = util.UpdateClientUsername(c, req.User, h.clientHandler, h.CommandHandler.Accounts())
+		if err != nil {
+			log.Printf("ERR SOCKET CLIENT %v. Broadcasting as \"info_clienterror\" event", err)
+			replyError(c, protocol.EVENT_REQUEST_UPDATEUSERNAME, data, client.ERR_CODE_INVALID_REQUEST, err, c.BroadcastErrorTo)
 			return
 		}
 
-		username, ok := messageData.Key("user")
-		if ok {
-			log.Printf("INF SOCKET CLIENT client with id %q requested a chat message broadcast with name %q", conn.UUID(), username)
-		}
+		replyResult(c, protocol.EVENT_REQUEST_UPDATEUSERNAME, data, "")
+	})
 
+	// this event is received when a client is requesting to broadcast a chat message
+	conn.On(protocol.EVENT_REQUEST_CHATMESSAGE, func(data connection.MessageDataCodec) {
 		c, err := h.clientHandler.GetClient(conn.UUID())
 		if err != nil {
 			log.Printf("ERR SOCKET CLIENT could not retrieve client. Ignoring request_chatmessage request: %v", err)
 			return
 		}
 
-		command, isCommand, err := h.ParseCommandMessage(c, messageData)
-		if err != nil {
-			log.Printf("ERR SOCKET CLIENT unable to parse client chat message as command: %v", err)
-			c.BroadcastSystemMessageTo(err.Error())
+		req := &protocol.ChatMessageRequest{}
+		if err := protocol.Decode(data, req); err != nil {
+			log.Printf("ERR SOCKET CLIENT client with id %q sent a malformed chat message: %v", conn.UUID(), err)
+			replyError(c, protocol.EVENT_REQUEST_CHATMESSAGE, data, client.ERR_CODE_INVALID_REQUEST, err, systemMessageTo(c))
 			return
 		}
+		username := req.User
+		log.Printf("INF SOCKET CLIENT client with id %q requested a chat message broadcast with name %q", conn.UUID(), username)
 
-		if isCommand {
-			cmdSegments := strings.Split(command, " ")
-			cmdArgs := []string{}
-			if len(cmdSegments) > 1 {
-				cmdArgs = cmdSegments[1:]
-			}
+		if command, isCommand := h.ParseCommandMessage(req.Message); isCommand {
+			cmdRoot, cmdArgs := SplitCommand(command)
+			redacted := cmd.RedactCommand(h.CommandHandler, cmdRoot, cmdArgs)
 
-			log.Printf("INF SOCKET CLIENT interpreting chat message as user command %q for client id (%q) with name %q", command, conn.UUID(), username)
-			result, err := h.CommandHandler.ExecuteCommand(cmdSegments[0], cmdArgs, c, h.clientHandler, h.PlaybackHandler, h.StreamHandler)
+			log.Printf("INF SOCKET CLIENT interpreting chat message as user command %q for client id (%q) with name %q", redacted, conn.UUID(), username)
+			result, err := h.executeCommand(c, cmdRoot, cmdArgs)
 			if err != nil {
-				log.Printf("ERR SOCKET CLIENT unable to execute command with id %q: %v", command, err)
-				c.BroadcastSystemMessageTo(err.Error())
+				log.Printf("ERR SOCKET CLIENT unable to execute command with id %q: %v", redacted, err)
+				replyError(c, protocol.EVENT_REQUEST_CHATMESSAGE, data, client.ERR_CODE_COMMAND_FAILED, err, systemMessageTo(c))
 				return
 			}
 
-			if len(result) > 0 {
-				c.BroadcastSystemMessageTo(result)
-			}
+			replyResult(c, protocol.EVENT_REQUEST_CHATMESSAGE, data, result)
 			return
 		}
 
-		images, err := h.ParseMessageMedia(messageData)
-		if err != nil {
-			log.Printf("ERR SOCKET CLIENT unable to parse client chat message media: %v", err)
-			return
+		// muted clients may still run commands and watch, but not chat
+		if ns, exists := c.Namespace(); exists {
+			if mute, muted := ns.Moderation().Muted(c.UUID(), conn.Metadata().Identity()); muted {
+				log.Printf("INF SOCKET CLIENT dropping chat message from muted client with id %q (%s)", conn.UUID(), c.GetUsernameOrId())
+				replyError(c, protocol.EVENT_REQUEST_CHATMESSAGE, data, client.ERR_CODE_MUTED, fmt.Errorf("%s", mutedMessage(mute)), systemMessageTo(c))
+				return
+			}
 		}
 
+		// image urls extracted from the message are removed from its text
+		images, text := h.ParseMessageMedia(req.Message)
 		res := &client.Response{
-			Id:    c.UUID(),
-			From:  "system",
-			Extra: make(map[string]interface{}),
-		}
-
-		// if images could be extracted from message, add to response
-		if len(images) > 0 {
-			res.Extra["images"] = images
-		}
-
-		b, err := data.Serialize()
-		if err != nil {
-			log.Printf("ERR SOCKET CLIENT unable to serialize client chat message data: %v", err)
-			return
-		}
-
-		err = json.Unmarshal(b, res)
-		if err != nil {
-			log.Printf("ERR SOCKET CLIENT unable to de-serialize client chat message into client response: %v", err)
-			return
+			Id:      c.UUID(),
+			From:    username,
+			Message: text,
+			Extra: &protocol.ChatMessageExtra{
+				Images: images,
+			},
 		}
 
-		c.BroadcastAll("chatmessage", res)
-		fmt.Printf("INF SOCKET CLIENT chatmessage received %v\n", data)
+		c.BroadcastAll(protocol.EVENT_CHATMESSAGE, res)
+		replyResult(c, protocol.EVENT_REQUEST_CHATMESSAGE, data, "")
 	})
 
 	// this event is received when a client is requesting authorization endpoint information
-	conn.On("request_authorization", func(data connection.MessageDataCodec) {
+	conn.On(protocol.EVENT_REQUEST_AUTHORIZATION, func(data connection.MessageDataCodec) {
 		log.Printf("INF SOCKET CLIENT AUTHZ client with id %q requested authorization information", conn.UUID())
 
 		// send an httprequest event to the client with authz endpoint information
@@ -205,10 +216,11 @@ func (h *Handler) HandleClientConnection(conn connection.Connection) {
 		}
 
 		c.BroadcastAuthRequestTo("init")
+		replyResult(c, protocol.EVENT_REQUEST_AUTHORIZATION, data, "")
 	})
 
 	// this event is received when a client is requesting the current queue state
-	conn.On("request_queuesync", func(data connection.MessageDataCodec) {
+	conn.On(protocol.EVENT_REQUEST_QUEUESYNC, func(data connection.MessageDataCodec) {
 		log.Printf("INF SOCKET CLIENT client with id %q requested a queue-sync", conn.UUID())
 
 		c, err := h.clientHandler.GetClient(conn.UUID())
@@ -220,7 +232,7 @@ func (h *Handler) HandleClientConnection(conn connection.Connection) {
 		sPlayback, err := h.getPlaybackFromClient(c)
 		if err != nil {
 			log.Printf("ERR SOCKET CLIENT %v", err)
-			c.BroadcastErrorTo(err)
+			replyError(c, protocol.EVENT_REQUEST_QUEUESYNC, data, client.ERR_CODE_NO_ROOM, err, c.BroadcastErrorTo)
 			return
 		}
 
@@ -229,21 +241,27 @@ func (h *Handler) HandleClientConnection(conn connection.Connection) {
 			From: "system",
 		}
 
-		b, err := sPlayback.GetQueue().Serialize()
-		if err != nil {
+		var b []byte
+		sPlayback.Do(func(sPlayback *playback.Playback) {
+			b, err = sPlayback.GetQueue().Serialize()
+		})
+		if err != nil || len(b) == 0 {
+			replyError(c, protocol.EVENT_REQUEST_QUEUESYNC, data, client.ERR_CODE_INTERNAL, fmt.Errorf("error: unable to serialize queue: %v", err), nil)
 			return
 		}
 
 		err = json.Unmarshal(b, &res.Extra)
 		if err != nil {
+			replyError(c, protocol.EVENT_REQUEST_QUEUESYNC, data, client.ERR_CODE_INTERNAL, fmt.Errorf("error: unable to serialize queue: %v", err), nil)
 			return
 		}
 
-		c.BroadcastTo("queuesync", res)
+		c.BroadcastTo(protocol.EVENT_QUEUESYNC, res)
+		replyResult(c, protocol.EVENT_REQUEST_QUEUESYNC, data, "")
 	})
 
 	// this event is received when a client is requesting the current queue state for a specific Queue stack
-	conn.On("request_stacksync", func(data connection.MessageDataCodec) {
+	conn.On(protocol.EVENT_REQUEST_STACKSYNC, func(data connection.MessageDataCodec) {
 		log.Printf("INF SOCKET CLIENT client with id %q requested a queue-stack-sync", conn.UUID())
 
 		c, err := h.clientHandler.GetClient(conn.UUID())
@@ -255,7 +273,7 @@ func (h *Handler) HandleClientConnection(conn connection.Connection) {
 		sPlayback, err := h.getPlaybackFromClient(c)
 		if err != nil {
 			log.Printf("ERR SOCKET CLIENT %v", err)
-			c.BroadcastErrorTo(err)
+			replyError(c, protocol.EVENT_REQUEST_STACKSYNC, data, client.ERR_CODE_NO_ROOM, err, c.BroadcastErrorTo)
 			return
 		}
 
@@ -264,29 +282,36 @@ func (h *Handler) HandleClientConnection(conn connection.Connection) {
 			From: "system",
 		}
 
-		userQueue, exists, err := playbackutil.GetUserQueue(c, sPlayback.GetQueue())
-		if err != nil {
-			return
-		}
-		if !exists {
-			userQueue = queue.NewAggregatableQueue(c.UUID())
-		}
+		var b []byte
+		sPlayback.Do(func(sPlayback *playback.Playback) {
+			userQueue, exists, qErr := playbackutil.GetUserQueue(c, sPlayback.GetQueue())
+			if qErr != nil {
+				err = qErr
+				return
+			}
+			if !exists {
+				userQueue = queue.NewAggregatableQueue(c.UUID())
+			}
 
-		b, err := userQueue.Serialize()
-		if err != nil {
+			b, err = userQueue.Serialize()
+		})
+		if err != nil || len(b) == 0 {
+			replyError(c, protocol.EVENT_REQUEST_STACKSYNC, data, client.ERR_CODE_INTERNAL, fmt.Errorf("error: unable to serialize queue: %v", err), nil)
 			return
 		}
 
 		err = json.Unmarshal(b, &res.Extra)
 		if err != nil {
+			replyError(c, protocol.EVENT_REQUEST_STACKSYNC, data, client.ERR_CODE_INTERNAL, fmt.Errorf("error: unable to serialize queue: %v", err), nil)
 			return
 		}
 
-		c.BroadcastTo("stacksync", res)
+		c.BroadcastTo(protocol.EVENT_STACKSYNC, res)
+		replyResult(c, protocol.EVENT_REQUEST_STACKSYNC, data, "")
 	})
 
 	// this event is received when a client is requesting current stream state information
-	conn.On("request_streamsync", func(data connection.MessageDataCodec) {
+	conn.On(protocol.EVENT_REQUEST_STREAMSYNC, func(data connection.MessageDataCodec) {
 		log.Printf("INF SOCKET CLIENT client with id %q requested a streamsync", conn.UUID())
 
 		c, err := h.clientHandler.GetClient(conn.UUID())
@@ -298,7 +323,7 @@ func (h *Handler) HandleClientConnection(conn connection.Connection) {
 		sPlayback, err := h.getPlaybackFromClient(c)
 		if err != nil {
 			log.Printf("ERR SOCKET CLIENT %v", err)
-			c.BroadcastErrorTo(err)
+			replyError(c, protocol.EVENT_REQUEST_STREAMSYNC, data, client.ERR_CODE_NO_ROOM, err, c.BroadcastErrorTo)
 			return
 		}
 
@@ -306,17 +331,21 @@ func (h *Handler) HandleClientConnection(conn connection.Connection) {
 			Id: c.UUID(),
 		}
 
-		err = util.SerializeIntoResponse(sPlayback.GetStatus(), &res.Extra)
+		sPlayback.Do(func(sPlayback *playback.Playback) {
+			err = util.SerializeIntoResponse(sPlayback.GetStatus(), &res.Extra)
+		})
 		if err != nil {
 			log.Printf("ERR SOCKET CLIENT unable to serialize playback status: %v", err)
+			replyError(c, protocol.EVENT_REQUEST_STREAMSYNC, data, client.ERR_CODE_INTERNAL, fmt.Errorf("error: unable to serialize playback status: %v", err), nil)
 			return
 		}
 
-		c.BroadcastTo("streamsync", res)
+		c.BroadcastTo(protocol.EVENT_STREAMSYNC, res)
+		replyResult(c, protocol.EVENT_REQUEST_STREAMSYNC, data, "")
 	})
 
 	// this event is received when a client is requesting current stream user information
-	conn.On("request_userlist", func(data connection.MessageDataCodec) {
+	conn.On(protocol.EVENT_REQUEST_USERLIST, func(data connection.MessageDataCodec) {
 		log.Printf("INF SOCKET CLIENT client with id %q requested a userlist", conn.UUID())
 
 		c, err := h.clientHandler.GetClient(conn.UUID())
@@ -328,7 +357,7 @@ func (h *Handler) HandleClientConnection(conn connection.Connection) {
 		ns, exists := c.Namespace()
 		if !exists {
 			log.Printf("ERR SOCKET CLIENT client with id %q requested a user list for room, but client is not currently in a room. Broadcasting error...", conn.UUID())
-			c.BroadcastErrorTo(fmt.Errorf("error: unable to get user list - you are not currently in a room"))
+			replyError(c, protocol.EVENT_REQUEST_USERLIST, data, client.ERR_CODE_NO_ROOM, fmt.Errorf("error: unable to get user list - you are not currently in a room"), c.BroadcastErrorTo)
 			return
 		}
 
@@ -360,11 +389,12 @@ func (h *Handler) HandleClientConnection(conn connection.Connection) {
 			})
 		}
 
-		c.BroadcastTo("userlist", userList)
+		c.BroadcastTo(protocol.EVENT_USERLIST, userList)
+		replyResult(c, protocol.EVENT_REQUEST_USERLIST, data, "")
 	})
 
 	// this event is received when a client is requesting to update stream state information in the server
-	conn.On("streamdata", func(data connection.MessageDataCodec) {
+	conn.On(protocol.EVENT_STREAMDATA, func(data connection.MessageDataCodec) {
 		c, err := h.clientHandler.GetClient(conn.UUID())
 		if err != nil {
 			log.Printf("ERR SOCKET CLIENT unable to retrieve client from connection id. Ignoring request_streamsync request: %v", err)
@@ -374,86 +404,164 @@ func (h *Handler) HandleClientConnection(conn connection.Connection) {
 		ns, exists := c.Namespace()
 		if !exists {
 			log.Printf("ERR SOCKET CLIENT client with id (%q) has no room association. Ignoring streamsync request.", c.UUID())
+			replyError(c, protocol.EVENT_STREAMDATA, data, client.ERR_CODE_NO_ROOM, fmt.Errorf("error: you are not currently in a room"), nil)
 			return
 		}
 
 		sPlayback, exists := h.PlaybackHandler.PlaybackByNamespace(ns)
 		if !exists {
 			log.Printf("ERR SOCKET CLIENT client with id (%q) requested a streamsync but no Playback could be found associated with that client.", c.UUID())
-			c.BroadcastErrorTo(fmt.Errorf("Warning: could not update stream playback. No room could be detected."))
+			replyError(c, protocol.EVENT_STREAMDATA, data, client.ERR_CODE_NO_ROOM, fmt.Errorf("Warning: could not update stream playback. No room could be detected."), c.BroadcastErrorTo)
 			return
 		}
 
-		s, exists := sPlayback.GetStream()
-		if !exists {
-			log.Printf("ERR SOCKET CLIENT client with id (%q) sent updated streamdata but no stream could be found associated with the current playback.", c.UUID())
+		req := &protocol.StreamDataRequest{}
+		if err := protocol.Decode(data, req); err != nil {
+			log.Printf("ERR SOCKET CLIENT client with id (%q) sent malformed streamdata: %v", c.UUID(), err)
+			replyError(c, protocol.EVENT_STREAMDATA, data, client.ERR_CODE_INVALID_REQUEST, err, nil)
 			return
 		}
 
-		jsonData, err := data.Serialize()
+		jsonData, err := req.Serialize()
 		if err != nil {
 			log.Printf("ERR SOCKET CLIENT unable to convert received data map into json string: %v", err)
 		}
 
-		log.Printf("INF SOCKET CLIENT received streaminfo from client with id (%q). Updating stream information...", c.UUID())
-		err = s.SetInfo(jsonData)
+		sPlayback.Do(func(sPlayback *playback.Playback) {
+			s, exists := sPlayback.GetStream()
+			if !exists {
+				log.Printf("ERR SOCKET CLIENT client with id (%q) sent updated streamdata but no stream could be found associated with the current playback.", c.UUID())
+				err = fmt.Errorf("error: there is no stream playing in your room")
+				return
+			}
+
+			log.Printf("INF SOCKET CLIENT received streaminfo from client with id (%q). Updating stream information...", c.UUID())
+			if err = s.SetInfo(jsonData); err != nil {
+				log.Printf("ERR SOCKET CLIENT error updating stream data: %v", err)
+			}
+		})
 		if err != nil {
-			log.Printf("ERR SOCKET CLIENT error updating stream data: %v", err)
+			replyError(c, protocol.EVENT_STREAMDATA, data, client.ERR_CODE_INVALID_REQUEST, err, nil)
 			return
 		}
+
+		replyResult(c, protocol.EVENT_STREAMDATA, data, "")
 	})
 }
 
-// ParseMessageMedia receives connection.MessageData and parses
-// image urls in the "message" key, removing urls from the
-// text message, and returning them as a slice of strings
-func (h *Handler) ParseMessageMedia(data connection.MessageData) ([]string, error) {
-	message, ok := data.Key("message")
-	if !ok {
-		return []string{}, fmt.Errorf("error: invalid client message format; message field empty")
+// replyResult answers a request that was handled successfully. Requests sent
+// with a message id are acknowledged with the result; requests sent without
+// one, by older clients, receive a non-empty result as a system message.
+func replyResult(c *client.Client, event string, data connection.MessageDataCodec, result string) {
+	if id := connection.RequestId(data); len(id) > 0 {
+		c.Ack(id, event, result)
+		return
+	}
+
+	if len(result) > 0 {
+		c.BroadcastSystemMessageTo(result)
+	}
+}
+
+// replyError answers a request that failed. Requests sent with a message id
+// receive a typed error, with the given code unless err carries its own.
+// Requests sent without one, by older clients, receive err through legacy,
+// if given.
+func replyError(c *client.Client, event string, data connection.MessageDataCodec, code client.ErrorCode, err error, legacy func(error)) {
+	if id := connection.RequestId(data); len(id) > 0 {
+		c.ReplyError(id, event, code, err)
+		return
+	}
+
+	if legacy != nil {
+		legacy(err)
+	}
+}
+
+// systemMessageTo returns a func sending an error to a client as a system message
+func systemMessageTo(c *client.Client) func(error) {
+	return func(err error) {
+		c.BroadcastSystemMessageTo(err.Error())
+	}
+}
+
+// muteFlooder mutes a client that keeps exceeding its rate limits for
+// connection.FloodMuteDuration, unless it is already muted
+func (h *Handler) muteFlooder(c *client.Client) {
+	ns, exists := c.Namespace()
+	if !exists {
+		return
+	}
+
+	identity := c.Connection().Metadata().Identity()
+	if _, muted := ns.Moderation().Muted(c.UUID(), identity); muted {
+		return
+	}
+
+	now := time.Now()
+	mute := &connection.Sanction{
+		Kind:         connection.SANCTION_MUTE,
+		Subject:      c.GetUsernameOrId(),
+		ConnectionId: c.UUID(),
+		Identity:     identity,
+		Reason:       "flooding",
+		By:           "server",
+		Created:      now,
+		Expires:      now.Add(connection.FloodMuteDuration),
 	}
+	ns.Moderation().Mute(mute)
+
+	log.Printf("INF SOCKET CLIENT muted client with id %q (%s) for flooding until %s", c.UUID(), c.GetUsernameOrId(), mute.Expires.Format(time.RFC1123))
+	c.BroadcastSystemMessageTo(mutedMessage(mute))
+}
 
-	rawText, ok := message.(string)
-	if !ok {
-		return []string{}, fmt.Errorf("error: client message media parse error; unable to cast message to string")
+func mutedMessage(mute *connection.Sanction) string {
+	msg := "you have been muted in this room"
+	if !mute.Expires.IsZero() {
+		msg += fmt.Sprintf(" until %s", mute.Expires.Format(time.Kitchen))
+	}
+	if len(mute.Reason) > 0 {
+		msg += fmt.Sprintf(": %s", mute.Reason)
 	}
+	return msg
+}
 
+// ParseMessageMedia receives the text of a chat message and parses
+// image urls in it, returning them as a slice of strings, along with
+// the text with the urls removed
+func (h *Handler) ParseMessageMedia(message string) ([]string, string) {
 	re := regexp.MustCompile("(http(s)?://[^ ]+\\.(jpg|png|gif|jpeg))( )?")
-	urls := re.FindAllString(rawText, -1)
+	urls := re.FindAllString(message, -1)
 	if urls == nil || len(urls) == 0 {
-		return []string{}, nil
+		return []string{}, message
 	}
 
-	newText := re.ReplaceAllString(rawText, "")
-	data.Set("message", newText)
-
-	return urls, nil
+	return urls, re.ReplaceAllString(message, "")
 }
 
-// ParseCommandMessage receives a client pointer and a data map sent by a client
-// and determines whether the "message" field in the client data map contains a
-// valid client command. An error is returned if there are any errors while parsing
-// the message field. A boolean (true) is returned if the message field contains a
-// valid client command, along with a string ("command") containing a StreamCommand id
+// ParseCommandMessage receives the text of a chat message sent by a client
+// and determines whether it contains a client command. A boolean (true) is
+// returned if it does, along with a string ("command") containing a
+// StreamCommand id
 //
 // A valid client command will always begin with a "/" and never contain more than
 // one "/" character.
-func (h *Handler) ParseCommandMessage(client *client.Client, data connection.MessageData) (string, bool, error) {
-	message, ok := data.Key("message")
-	if !ok {
-		return "", false, fmt.Errorf("error: invalid client command format; message field empty")
+func (h *Handler) ParseCommandMessage(message string) (string, bool) {
+	if !strings.HasPrefix(message, "/") {
+		return "", false
 	}
 
-	command, ok := message.(string)
-	if !ok {
-		return "", false, fmt.Errorf("error: client command parse error; unable to cast message to string")
-	}
+	return message[1:], true
+}
 
-	if string(command[0]) != "/" {
-		return "", false, nil
+// SplitCommand receives a command parsed from a chat message and returns
+// its name and arguments. Runs of spaces do not produce empty arguments.
+func SplitCommand(command string) (string, []string) {
+	segs := strings.Fields(command)
+	if len(segs) == 0 {
+		return "", []string{}
 	}
-
-	return command[1:], true, nil
+	return segs[0], segs[1:]
 }
 
 // RegisterClient receives a socket connection, creates a new client, and assigns the client to a room.
@@ -462,15 +570,30 @@ func (h *Handler) ParseCommandMessage(client *client.Client, data connection.Mes
 // the client's room name.
 // If a streamPlayback already exists for the current "room" and the streamPlayback has a reference to a
 // stream.Stream, a "streamload" event is sent to the client with the current stream.Stream information.
-// This method is not concurrency-safe.
+// Playback state is only read and updated on the room's event loop.
 func (h *Handler) RegisterClient(conn connection.Connection) {
 	log.Printf("INF SOCKET CLIENT registering client with id %q\n", conn.UUID())
 
 	c := h.clientHandler.CreateClient(conn)
-	c.BroadcastFrom("info_clientjoined", &client.Response{
+	c.BroadcastTo(protocol.EVENT_PROTOCOL, &protocol.ProtocolInfo{
+		Version:    conn.Metadata().ProtocolVersion(),
+		MinVersion: protocol.MIN_VERSION,
+		MaxVersion: protocol.VERSION,
+	})
+	c.BroadcastFrom(protocol.EVENT_INFO_CLIENTJOINED, &client.Response{
 		Id: c.UUID(),
 	})
 
+	// clients resuming a session start out with its username history;
+	// clients logged in to an account start out with its username
+	if session, resumed := conn.Metadata().Resumed(); resumed && len(session.Usernames) > 0 {
+		h.restoreUsernames(c, session.Usernames)
+	} else if username, loggedIn := conn.Metadata().Account(); loggedIn {
+		if err := util.UpdateClientUsername(c, username, h.clientHandler, h.CommandHandler.Accounts()); err != nil {
+			log.Printf("WRN SOCKET CLIENT unable to assign account username %q to client with id %q: %v", username, conn.UUID(), err)
+		}
+	}
+
 	namespace, nsExists := c.Namespace()
 	if !nsExists {
 		log.Printf("INF SOCKET SERVER client registration error: invalid or unknown namespace for connection with id (%s)", conn.UUID())
@@ -482,102 +605,132 @@ func (h *Handler) RegisterClient(conn connection.Connection) {
 		return
 	}
 
+	h.issueResumeToken(namespace, c)
+
 	// TODO: use a handler to broadcast to namespace
 
-	sPlayback, exists := h.PlaybackHandler.PlaybackByNamespace(namespace)
-	if !exists {
-		log.Printf("INF SOCKET CLIENT Playback did not exist for room with namespace %v. Creating...", namespace)
-		sPlayback = h.PlaybackHandler.NewPlayback(namespace, h.CommandHandler.Authorizer(), h.clientHandler)
-		sPlayback.OnTick(func(currentTime int) {
-			currPlayback, exists := h.PlaybackHandler.PlaybackByNamespace(namespace)
-			if !exists {
-				log.Printf("ERR CALLBACK-PLAYBACK SOCKET CLIENT attempted to send streamsync event to client, but stream playback does not exist.")
-				return
-			}
+	sPlayback, created := h.PlaybackHandler.GetOrCreatePlayback(namespace, h.CommandHandler.Authorizer(), h.clientHandler)
+	if created {
+		log.Printf("INF SOCKET CLIENT Playback did not exist for room with namespace %v. Created...", namespace)
+		// tick callbacks are run by the room's event loop
+		sPlayback.Do(func(sPlayback *playback.Playback) {
+			h.addTickHandler(sPlayback, namespace, c)
+		})
+		return
+	}
 
-			if currentTime%2 == 0 {
-				currStream, streamExists := currPlayback.GetStream()
-				if streamExists {
-					// if stream exists and playback timer >= playback stream duration, stop stream
-					// or queue the next item in the playback queue (if queue not empty)
-					if currStream.GetDuration() > 0 && float64(currPlayback.GetTime()) >= currStream.GetDuration() {
-						queue := currPlayback.GetQueue()
-						queueItem, err := queue.Next()
-						if err == nil {
-							log.Printf("INF CALLBACK-PLAYBACK SOCKET CLIENT detected end of stream. Auto-queuing next stream...")
-
-							nextStream, ok := queueItem.(stream.Stream)
-							if !ok {
-								log.Printf("ERR CALLBACK-PLAYBACK SOCKET CLIENT expected next queue item to implement stream.Stream... Unable to advance the queue.")
-								return
-							}
+	sPlayback.Do(func(sPlayback *playback.Playback) {
+		h.syncJoinedClient(sPlayback, namespace, c)
+	})
+}
+
+// addTickHandler registers a room's timer tick callback, which advances
+// the room's queue once the current stream ends and periodically sends
+// playback status to the room. Called from within the room's event loop.
+func (h *Handler) addTickHandler(sPlayback *playback.Playback, namespace connection.Namespace, c *client.Client) {
+	sPlayback.OnTick(func(currentTime int) {
+		currPlayback, exists := h.PlaybackHandler.PlaybackByNamespace(namespace)
+		if !exists {
+			log.Printf("ERR CALLBACK-PLAYBACK SOCKET CLIENT attempted to send streamsync event to client, but stream playback does not exist.")
+			return
+		}
+
+		if currentTime%2 == 0 {
+			currStream, streamExists := currPlayback.GetStream()
+			if streamExists {
+				// if stream exists and playback timer >= playback stream duration, stop stream
+				// or queue the next item in the playback queue (if queue not empty)
+				if currStream.GetDuration() > 0 && float64(currPlayback.GetTime()) >= currStream.GetDuration() {
+					if currPlayback.LoopMode() == playback.LOOP_MODE_ONE {
+						log.Printf("INF CALLBACK-PLAYBACK SOCKET CLIENT detected end of stream. Looping current stream...")
+						currPlayback.Restart(client.USER_SYSTEM)
+					} else if _, err := currPlayback.Advance(client.USER_SYSTEM); err == nil {
+						log.Printf("INF CALLBACK-PLAYBACK SOCKET CLIENT detected end of stream. Auto-queuing next stream...")
+
+						res := &client.Response{
+							Id:   c.UUID(),
+							From: "system",
+						}
+
+						err = util.SerializeIntoResponse(currPlayback.GetStatus(), &res.Extra)
+						if err != nil {
+							log.Printf("ERR CALLBACK-PLAYBACK SOCKET CLIENT unable to serialize nextStream codec: %v", err)
+							return
+						}
 
-							currPlayback.SetStream(nextStream)
-							currPlayback.Reset()
+						c.BroadcastAll(protocol.EVENT_STREAMLOAD, res)
 
-							res := &client.Response{
+						// played streams are recycled into the queue when looping the queue
+						if currPlayback.LoopMode() == playback.LOOP_MODE_QUEUE {
+							queueRes := &client.Response{
 								Id:   c.UUID(),
 								From: "system",
 							}
 
-							err = util.SerializeIntoResponse(currPlayback.GetStatus(), &res.Extra)
+							err = util.SerializeIntoResponse(currPlayback.GetQueue(), &queueRes.Extra)
 							if err != nil {
-								log.Printf("ERR CALLBACK-PLAYBACK SOCKET CLIENT unable to serialize nextStream codec: %v", err)
+								log.Printf("ERR CALLBACK-PLAYBACK SOCKET CLIENT unable to serialize queue: %v", err)
 								return
 							}
 
-							c.BroadcastAll("streamload", res)
-						} else {
-							log.Printf("INF CALLBACK-PLAYBACK SOCKET CLIENT detected end of stream and no queue items. Stopping stream...")
-							currPlayback.Stop()
-						}
-
-						// emit updated playback state to client if stream has ended
-						log.Printf("INF CALLBACK-PLAYBACK SOCKET CLIENT stream has ended after %v seconds.", currentTime)
-						res := &client.Response{
-							Id: c.UUID(),
+							c.BroadcastAll(protocol.EVENT_QUEUESYNC, queueRes)
 						}
+					} else {
+						log.Printf("INF CALLBACK-PLAYBACK SOCKET CLIENT detected end of stream and no queue items. Stopping stream...")
+						currPlayback.Stop()
+					}
 
-						err = util.SerializeIntoResponse(currPlayback.GetStatus(), &res.Extra)
-						if err != nil {
-							log.Printf("ERR CALLBACK-PLAYBACK SOCKET CLIENT unable to serialize playback status: %v", err)
-							return
-						}
+					// emit updated playback state to client if stream has ended
+					log.Printf("INF CALLBACK-PLAYBACK SOCKET CLIENT stream has ended after %v seconds.", currentTime)
+					res := &client.Response{
+						Id: c.UUID(),
+					}
 
-						c.BroadcastAll("streamsync", res)
+					err := util.SerializeIntoResponse(currPlayback.GetStatus(), &res.Extra)
+					if err != nil {
+						log.Printf("ERR CALLBACK-PLAYBACK SOCKET CLIENT unable to serialize playback status: %v", err)
+						return
 					}
-				}
-			}
 
-			// if stream timer has not reached its duration, wait until next ROOM_DEFAULT_STREAMSYNC_RATE tick
-			// before updating client with playback information
-			if currentTime%ROOM_DEFAULT_STREAMSYNC_RATE != 0 {
-				return
+					c.BroadcastAll(protocol.EVENT_STREAMSYNC, res)
+				}
 			}
+		}
 
-			// log in 50 second intervals
-			if currentTime%ROOM_DEFAULT_STREAMSYNC_LOGGING_RATE == 0 {
-				log.Printf("INF CALLBACK-PLAYBACK SOCKET CLIENT streamsync event sent after %v seconds", currentTime)
-			}
+		// if stream timer has not reached its duration, wait until next ROOM_DEFAULT_STREAMSYNC_RATE tick
+		// before updating client with playback information
+		if currentTime%ROOM_DEFAULT_STREAMSYNC_RATE != 0 {
+			return
+		}
 
-			res := &client.Response{
-				Id: c.UUID(),
-			}
+		// log in 50 second intervals
+		if currentTime%ROOM_DEFAULT_STREAMSYNC_LOGGING_RATE == 0 {
+			log.Printf("INF CALLBACK-PLAYBACK SOCKET CLIENT streamsync event sent after %v seconds", currentTime)
+		}
 
-			err := util.SerializeIntoResponse(currPlayback.GetStatus(), &res.Extra)
-			if err != nil {
-				log.Printf("ERR CALLBACK-PLAYBACK SOCKET CLIENT unable to serialize playback status: %v", err)
-				return
-			}
+		res := &client.Response{
+			Id: c.UUID(),
+		}
 
-			c.BroadcastAll("streamsync", res)
-		})
+		err := util.SerializeIntoResponse(currPlayback.GetStatus(), &res.Extra)
+		if err != nil {
+			log.Printf("ERR CALLBACK-PLAYBACK SOCKET CLIENT unable to serialize playback status: %v", err)
+			return
+		}
 
-		return
-	}
+		c.BroadcastAll(protocol.EVENT_STREAMSYNC, res)
+	})
+}
 
+// syncJoinedClient sends a client joining an existing room the room's
+// current stream. Called from within the room's event loop.
+func (h *Handler) syncJoinedClient(sPlayback *playback.Playback, namespace connection.Namespace, c *client.Client) {
 	sPlayback.SetLastUpdated(time.Now())
 
+	// hold an admin election right away, so that a room's owner
+	// regains admin as soon as they rejoin it
+	sPlayback.ElectAdmin()
+
 	log.Printf("INF SOCKET CLIENT found Playback for room with name %q", namespace.Name())
 
 	pStream, exists := sPlayback.GetStream()
@@ -593,8 +746,89 @@ func (h *Handler) RegisterClient(conn connection.Connection) {
 			return
 		}
 
-		c.BroadcastTo("streamload", res)
+		c.BroadcastTo(protocol.EVENT_STREAMLOAD, res)
+	}
+}
+
+// executeCommand runs a client command on the event loop of the client's
+// room, or right away if the client is not in a room with a Playback.
+func (h *Handler) executeCommand(c *client.Client, cmdRoot string, args []string) (string, error) {
+	sPlayback, err := h.getPlaybackFromClient(c)
+	if err != nil {
+		return h.CommandHandler.ExecuteCommand(cmdRoot, args, c, h.clientHandler, h.PlaybackHandler, h.StreamHandler)
+	}
+
+	var result string
+	if !sPlayback.Do(func(*playback.Playback) {
+		result, err = h.CommandHandler.ExecuteCommand(cmdRoot, args, c, h.clientHandler, h.PlaybackHandler, h.StreamHandler)
+	}) {
+		return "", client.NewError(client.ERR_CODE_ROOM_CLOSED, "error: your room has been closed")
+	}
+	return result, err
+}
+
+// restoreUsernames replays a resumed session's username history onto a client
+func (h *Handler) restoreUsernames(c *client.Client, usernames []string) {
+	for _, username := range usernames[:len(usernames)-1] {
+		c.UpdateUsername(username)
+	}
+
+	username := usernames[len(usernames)-1]
+	if err := util.UpdateClientUsername(c, username, h.clientHandler, h.CommandHandler.Accounts()); err != nil {
+		log.Printf("WRN SOCKET CLIENT unable to restore username %q to client with id %q: %v", username, c.UUID(), err)
+	}
+}
+
+// issueResumeToken binds the roles of a resumed session back to a client's
+// connection, and sends the client a token it may present to resume its
+// session, should its connection go away.
+func (h *Handler) issueResumeToken(namespace connection.Namespace, c *client.Client) {
+	conn := c.Connection()
+	session, resumed := conn.Metadata().Resumed()
+	if authorizer := h.CommandHandler.Authorizer(); resumed && authorizer != nil {
+		for _, name := range session.Roles {
+			if role, exists := rbac.NamespaceRole(authorizer, namespace.Name(), name); exists && authorizer.Bind(role, conn) {
+				log.Printf("INF SOCKET CLIENT restored role %q to resumed connection with id (%s)", name, conn.UUID())
+			}
+		}
+	}
+
+	token, err := namespace.Sessions().Issue(conn)
+	if err != nil {
+		log.Printf("INF SOCKET CLIENT not issuing a resume token to client with id %q: %v", c.UUID(), err)
+		return
+	}
+
+	c.BroadcastTo(protocol.EVENT_RESUMETOKEN, &client.Response{
+		Id:   c.UUID(),
+		From: "system",
+		Extra: &protocol.ResumeToken{
+			Token:       token,
+			Resumed:     resumed,
+			GracePeriod: connection.ResumeGracePeriod.Seconds(),
+		},
+	})
+}
+
+// detachSession keeps the session of a connection that went away, so that
+// it may be resumed. The connection's slice of the room's queue is deleted
+// once its session can no longer be resumed.
+func (h *Handler) detachSession(ns connection.Namespace, connId string, usernames, roles []string) {
+	releaseQueue := func() {
+		sPlayback, exists := h.PlaybackHandler.PlaybackByNamespace(ns)
+		if !exists {
+			return
+		}
+		sPlayback.Submit(func(sPlayback *playback.Playback) {
+			sPlayback.ReleaseQueue(connId)
+		})
+	}
+
+	if !ns.Sessions().Detach(connId, usernames, roles, releaseQueue) {
+		releaseQueue()
+		return
 	}
+	log.Printf("INF DCONN SOCKET keeping session of connection with id %q for %v", connId, connection.ResumeGracePeriod)
 }
 
 func (h *Handler) DeregisterClient(conn connection.Connection) error {
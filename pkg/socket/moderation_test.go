@@ -0,0 +1,230 @@
+package socket
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/juanvallejo/streaming-server/pkg/playback"
+	"github.com/juanvallejo/streaming-server/pkg/socket/client"
+	"github.com/juanvallejo/streaming-server/pkg/socket/cmd"
+	"github.com/juanvallejo/streaming-server/pkg/socket/cmd/rbac"
+	"github.com/juanvallejo/streaming-server/pkg/socket/connection"
+	"github.com/juanvallejo/streaming-server/pkg/socket/protocol"
+	"github.com/juanvallejo/streaming-server/pkg/stream"
+)
+
+// moderationServer serves a socket handler with rbac enabled. Every
+// connection is bound to the user role, or to the admin role if the
+// name it is dialed with is listed as an admin. Every connection is
+// made from the same address.
+type moderationServer struct {
+	srv       *httptest.Server
+	nsHandler connection.NamespaceHandler
+
+	mux    sync.Mutex
+	admins map[string]bool
+	next   string
+}
+
+func newModerationServer(t *testing.T, admins ...string) *moderationServer {
+	authorizer := rbac.NewAuthorizer()
+	cmd.AddDefaultRoles(authorizer)
+
+	s := &moderationServer{
+		nsHandler: connection.NewNamespaceHandler(),
+		admins:    make(map[string]bool),
+	}
+	for _, name := range admins {
+		s.admins[name] = true
+	}
+
+	h := NewHandler(
+		s.nsHandler,
+		connection.NewHandlerWithRBAC(authorizer, s.nsHandler),
+		cmd.NewHandlerWithRBAC(authorizer),
+		client.NewHandler(),
+		playback.NewGarbageCollectedHandler(s.nsHandler),
+		stream.NewGarbageCollectedHandler(),
+	)
+
+	userRole, _ := authorizer.Role(rbac.USER_ROLE)
+	adminRole, _ := authorizer.Role(rbac.ADMIN_ROLE)
+	h.server.On("connection", func(conn connection.Connection) {
+		s.mux.Lock()
+		defer s.mux.Unlock()
+
+		if s.admins[s.next] {
+			authorizer.Bind(adminRole, conn)
+			return
+		}
+		authorizer.Bind(userRole, conn)
+	})
+
+	s.srv = httptest.NewServer(h)
+	t.Cleanup(s.srv.Close)
+	return s
+}
+
+// dial connects a client to a room, sending the given cookies, and sets
+// its username. Returns the cookies the server set on the connection.
+func (s *moderationServer) dial(t *testing.T, room, name string, cookies ...*http.Cookie) (*stressClient, []*http.Cookie) {
+	t.Helper()
+
+	c, res, err := s.tryDial(room, name, cookies...)
+	if err != nil {
+		t.Fatalf("%s: unable to connect to room %q: %v", name, room, err)
+	}
+	t.Cleanup(func() {
+		c.ws.Close()
+	})
+
+	if err := c.send(name+"-username", protocol.EVENT_REQUEST_UPDATEUSERNAME, &protocol.UpdateUsernameRequest{User: name}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.awaitReplies(name + "-username"); err != nil {
+		t.Fatal(err)
+	}
+	return c, res.Cookies()
+}
+
+func (s *moderationServer) tryDial(room, name string, cookies ...*http.Cookie) (*stressClient, *http.Response, error) {
+	header := http.Header{}
+	for _, cookie := range cookies {
+		header.Add("Cookie", cookie.String())
+	}
+
+	s.mux.Lock()
+	s.next = name
+	s.mux.Unlock()
+
+	u := "ws" + strings.TrimPrefix(s.srv.URL, "http") + "/v/" + room + "?" + protocol.PROTOCOL_VERSION_KEY + "=" + fmt.Sprintf("%v", protocol.VERSION)
+	ws, res, err := websocket.DefaultDialer.Dial(u, header)
+	if err != nil {
+		return nil, res, err
+	}
+	return &stressClient{name: name, ws: ws}, res, nil
+}
+
+// command sends a chat command and waits for it to be acknowledged
+func command(t *testing.T, c *stressClient, message string) {
+	t.Helper()
+
+	id := fmt.Sprintf("%s-%v", c.name, time.Now().UnixNano())
+	if err := c.send(id, protocol.EVENT_REQUEST_CHATMESSAGE, &protocol.ChatMessageRequest{User: c.name, Message: message}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.awaitReplies(id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expectConnected fails if a client's requests are no longer answered
+func expectConnected(t *testing.T, c *stressClient) {
+	t.Helper()
+
+	id := fmt.Sprintf("%s-ping-%v", c.name, time.Now().UnixNano())
+	if err := c.send(id, protocol.EVENT_REQUEST_USERLIST, &protocol.EmptyRequest{}); err != nil {
+		t.Fatalf("%s: expected to remain connected: %v", c.name, err)
+	}
+	if err := c.awaitReplies(id); err != nil {
+		t.Fatalf("%s: expected to remain connected: %v", c.name, err)
+	}
+}
+
+// expectDisconnected fails unless a client's connection is closed by the server
+func expectDisconnected(t *testing.T, c *stressClient) {
+	t.Helper()
+
+	c.ws.SetReadDeadline(time.Now().Add(stressTimeout))
+	for {
+		if _, _, err := c.ws.ReadMessage(); err != nil {
+			if _, closed := err.(*websocket.CloseError); !closed {
+				t.Fatalf("%s: expected to be disconnected, got %v", c.name, err)
+			}
+			return
+		}
+	}
+}
+
+func TestBanSkipsIssuerAndAdmins(t *testing.T) {
+	s := newModerationServer(t, "alice", "dave")
+	alice, aliceCookies := s.dial(t, "room", "alice")
+	dave, daveCookies := s.dial(t, "room", "dave")
+	bob, _ := s.dial(t, "room", "bob")
+	carol, _ := s.dial(t, "room", "carol")
+
+	// without --ip, only the target is banned, though
+	// every connection is made from the same address
+	command(t, alice, "/ban bob")
+	expectDisconnected(t, bob)
+	expectConnected(t, carol)
+	expectConnected(t, dave)
+
+	// with --ip, everyone at the address is banned,
+	// except for the issuer and the room's admins
+	command(t, alice, "/ban carol "+cmd.BAN_ADDRESS_FLAG+" 1h spam")
+	expectDisconnected(t, carol)
+	expectConnected(t, alice)
+	expectConnected(t, dave)
+
+	bans := s.namespace(t, "room").Moderation().Bans()
+	if len(bans) != 2 || len(bans[0].Address) > 0 || len(bans[1].Address) == 0 {
+		t.Fatalf("expected only the second ban to name an address, got %+v, %+v", bans[0], bans[1])
+	}
+	if bans[1].Expires.IsZero() || bans[1].Reason != "spam" {
+		t.Fatalf("expected the ban's duration and reason to follow the flag, got %+v", bans[1])
+	}
+
+	// the address ban is enforced when connecting,
+	// except for the issuer and the room's admins
+	if _, res, err := s.tryDial("room", "erin"); err == nil || res == nil || res.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected a new browser at the banned address to be refused")
+	}
+	for name, cookies := range map[string][]*http.Cookie{"alice": aliceCookies, "dave": daveCookies} {
+		c, _, err := s.tryDial("room", name, cookies...)
+		if err != nil {
+			t.Fatalf("expected %s to be admitted at the banned address: %v", name, err)
+		}
+		c.ws.Close()
+	}
+}
+
+func TestBannedAddressAdmitsAdmins(t *testing.T) {
+	s := newModerationServer(t, "alice")
+	_, aliceCookies := s.dial(t, "room", "alice")
+	_, bobCookies := s.dial(t, "room", "bob")
+
+	// a ban naming no exemptions, such as one created before alice became admin
+	s.namespace(t, "room").Moderation().Ban(&connection.Sanction{
+		Subject: "someone",
+		Address: "127.0.0.1",
+		By:      "system",
+		Created: time.Now(),
+	})
+
+	if _, _, err := s.tryDial("room", "bob", bobCookies...); err == nil {
+		t.Fatalf("expected a user at the banned address to be refused")
+	}
+	c, _, err := s.tryDial("room", "alice", aliceCookies...)
+	if err != nil {
+		t.Fatalf("expected a browser with an admin connection in the room to be admitted: %v", err)
+	}
+	c.ws.Close()
+}
+
+func (s *moderationServer) namespace(t *testing.T, name string) connection.Namespace {
+	t.Helper()
+
+	ns, exists := s.nsHandler.NamespaceByName(name)
+	if !exists {
+		t.Fatalf("expected room %q to exist", name)
+	}
+	return ns
+}
@@ -0,0 +1,253 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/juanvallejo/streaming-server/pkg/validation"
+)
+
+const (
+	// MAX_STREAM_NAME_LENGTH and MAX_URL_LENGTH are the max amount
+	// of characters in stream names and urls sent by clients
+	MAX_STREAM_NAME_LENGTH = 256
+	MAX_URL_LENGTH         = 2048
+)
+
+// Events sent by clients to the server
+const (
+	EVENT_REQUEST_AUTHORIZATION  = "request_authorization"
+	EVENT_REQUEST_CHATMESSAGE    = "request_chatmessage"
+	EVENT_REQUEST_QUEUESYNC      = "request_queuesync"
+	EVENT_REQUEST_STACKSYNC      = "request_stacksync"
+	EVENT_REQUEST_STREAMSYNC     = "request_streamsync"
+	EVENT_REQUEST_UPDATEUSERNAME = "request_updateusername"
+	EVENT_REQUEST_USERLIST       = "request_userlist"
+	EVENT_STREAMDATA             = "streamdata"
+)
+
+// Events sent by the server to clients
+const (
+	EVENT_ACK                  = "ack"
+	EVENT_AUTHORIZATION        = "authorization"
+	EVENT_CHATMESSAGE          = "chatmessage"
+	EVENT_CHATMETHODACTION     = "chatmethodaction"
+	EVENT_ERROR                = "error"
+	EVENT_INFO_CLIENTERROR     = "info_clienterror"
+	EVENT_INFO_CLIENTJOINED    = "info_clientjoined"
+	EVENT_INFO_CLIENTLEFT      = "info_clientleft"
+	EVENT_INFO_SUBTITLES       = "info_subtitles"
+	EVENT_INFO_UPDATEUSERNAME  = "info_updateusername"
+	EVENT_INFO_USERLISTUPDATED = "info_userlistupdated"
+	EVENT_PROTOCOL             = "protocol"
+	EVENT_QUEUESYNC            = "queuesync"
+	EVENT_RESUMETOKEN          = "resumetoken"
+	EVENT_STACKSYNC            = "stacksync"
+	EVENT_STREAMLOAD           = "streamload"
+	EVENT_STREAMSCHEDULE       = "streamschedule"
+	EVENT_STREAMSYNC           = "streamsync"
+	EVENT_UPDATEUSERNAME       = "updateusername"
+	EVENT_USERLIST             = "userlist"
+)
+
+// Request is the payload of an event sent by a client
+type Request interface {
+	// Validate returns an error describing the first
+	// invalid field of the request, if any
+	Validate() error
+}
+
+// Serializer is implemented by the data of a received socket message
+type Serializer interface {
+	Serialize() ([]byte, error)
+}
+
+// Decode receives the data of a received socket message, and decodes and
+// validates it into the given request. Fields the request does not define
+// are ignored, so that older clients sending extra fields keep working.
+func Decode(data Serializer, req Request) error {
+	b, err := data.Serialize()
+	if err != nil {
+		return fmt.Errorf("error: unable to read request: %v", err)
+	}
+
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(req); err != nil {
+		if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+			return fmt.Errorf("error: invalid request: field %q must be of type %s, not %s", typeErr.Field, jsonType(typeErr.Type), typeErr.Value)
+		}
+		return fmt.Errorf("error: invalid request: %v", err)
+	}
+
+	return req.Validate()
+}
+
+// jsonType returns the name of the json type a go type is decoded from
+func jsonType(t reflect.Type) string {
+	schema := SchemaOf(reflect.Zero(t).Interface())
+	if len(schema.Type) == 0 {
+		return t.String()
+	}
+	return schema.Type
+}
+
+func tooLong(field string, max int) error {
+	return fmt.Errorf("error: invalid request: field %q must not be longer than %v characters", field, max)
+}
+
+// EmptyRequest is the payload of events that carry no data
+type EmptyRequest struct{}
+
+func (r *EmptyRequest) Validate() error {
+	return nil
+}
+
+// ChatMessageRequest is the payload of a request_chatmessage event. Messages
+// beginning with "/" are interpreted as commands.
+type ChatMessageRequest struct {
+	// User is the name the message is shown as sent by
+	User    string `json:"user"`
+	Message string `json:"message"`
+}
+
+func (r *ChatMessageRequest) Validate() error {
+	if len(r.Message) == 0 {
+		return fmt.Errorf("error: invalid request: field %q must not be empty", "message")
+	}
+	if utf8.RuneCountInString(r.User) > validation.MaxClientUsernameLength {
+		return tooLong("user", validation.MaxClientUsernameLength)
+	}
+	if err := validation.ValidateChatMessage(r.Message); err != nil {
+		return fmt.Errorf("error: invalid request: %v", strings.TrimPrefix(err.Error(), "error: "))
+	}
+	return nil
+}
+
+// UpdateUsernameRequest is the payload of a request_updateusername event
+type UpdateUsernameRequest struct {
+	User string `json:"user"`
+}
+
+func (r *UpdateUsernameRequest) Validate() error {
+	if len(r.User) == 0 {
+		return fmt.Errorf("error: invalid request: field %q must not be empty", "user")
+	}
+	if utf8.RuneCountInString(r.User) > validation.MaxClientUsernameLength {
+		return tooLong("user", validation.MaxClientUsernameLength)
+	}
+	return nil
+}
+
+// StreamDataRequest is the payload of a streamdata event, carrying stream
+// information that is only known once the stream has loaded on a client.
+// Fields left out are not updated.
+type StreamDataRequest struct {
+	Name      *string  `json:"name,omitempty"`
+	Duration  *float64 `json:"duration,omitempty"`
+	Thumbnail *string  `json:"thumb,omitempty"`
+}
+
+func (r *StreamDataRequest) Validate() error {
+	if r.Duration != nil && (*r.Duration < 0 || math.IsNaN(*r.Duration) || math.IsInf(*r.Duration, 0)) {
+		return fmt.Errorf("error: invalid request: field %q must be a positive number of seconds", "duration")
+	}
+	if r.Name != nil && utf8.RuneCountInString(*r.Name) > MAX_STREAM_NAME_LENGTH {
+		return tooLong("name", MAX_STREAM_NAME_LENGTH)
+	}
+	if r.Thumbnail != nil && utf8.RuneCountInString(*r.Thumbnail) > MAX_URL_LENGTH {
+		return tooLong("thumb", MAX_URL_LENGTH)
+	}
+	return nil
+}
+
+func (r *StreamDataRequest) Serialize() ([]byte, error) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	return b, nil
+}
+
+// ProtocolInfo is the payload of the protocol event, sent to
+// every client once it connects
+type ProtocolInfo struct {
+	// Version is the protocol version negotiated for the connection
+	Version    int `json:"version"`
+	MinVersion int `json:"minVersion"`
+	MaxVersion int `json:"maxVersion"`
+}
+
+func (p *ProtocolInfo) Serialize() ([]byte, error) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	return b, nil
+}
+
+// The types below are carried in the "extra" field of outbound events
+
+// ChatMessageExtra accompanies a chatmessage event
+type ChatMessageExtra struct {
+	// Images are image urls found in, and removed from, the message
+	Images []string `json:"images,omitempty"`
+}
+
+// ChatMethodAction accompanies a chatmethodaction event, asking
+// the client to call a method of its chat
+type ChatMethodAction struct {
+	MethodName string        `json:"methodname"`
+	Args       []interface{} `json:"args"`
+}
+
+// AuthorizationRequest accompanies an authorization event, asking the
+// client to request the given endpoint to obtain its rbac roles
+type AuthorizationRequest struct {
+	Method   string `json:"method"`
+	Endpoint string `json:"endpoint"`
+	// Token is the api token of the client's connection. It must be
+	// sent, along with the connection's id, with every api request
+	// made on behalf of the connection.
+	Token string `json:"token"`
+}
+
+// UsernameUpdate accompanies an info_updateusername event
+type UsernameUpdate struct {
+	OldUser string `json:"oldUser"`
+	// IsNewUser is "true" if the client had no previous username
+	IsNewUser string `json:"isNewUser"`
+}
+
+// SubtitlesUpdate accompanies an info_subtitles event
+type SubtitlesUpdate struct {
+	// Path is the url of the subtitles file to load, if On
+	Path string `json:"path,omitempty"`
+	On   bool   `json:"on"`
+}
+
+// StreamSchedule accompanies a streamschedule event, sent
+// while counting down to a scheduled stream start
+type StreamSchedule struct {
+	Id       int       `json:"id"`
+	StartsAt time.Time `json:"startsAt"`
+	// Remaining is the amount of seconds until the stream starts
+	Remaining float64 `json:"remaining"`
+}
+
+// ResumeToken accompanies a resumetoken event
+type ResumeToken struct {
+	// Token may be passed as the "resume" query parameter when
+	// reconnecting, to resume the connection's session
+	Token string `json:"token"`
+	// Resumed is true if the connection resumed a previous session
+	Resumed bool `json:"resumed"`
+	// GracePeriod is the amount of seconds a client has to reconnect
+	GracePeriod float64 `json:"gracePeriod"`
+}
@@ -0,0 +1,224 @@
+package protocol
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	SCHEMA_DRAFT = "http://json-schema.org/draft-07/schema#"
+)
+
+// Schema is a JSON Schema describing a json value
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// SchemaOf returns the schema of the json encoding of a value. Interface
+// fields are described by the value they hold; nil interface fields may
+// hold any json value.
+func SchemaOf(v interface{}) *Schema {
+	if v == nil {
+		return &Schema{}
+	}
+	return schemaOf(reflect.ValueOf(v), reflect.TypeOf(v), map[reflect.Type]bool{})
+}
+
+// schemaOf describes a type, using the given value, if valid, to describe
+// the values held by interfaces. Types in seen are being described by a
+// caller, and are not described again, so that recursive types terminate.
+func schemaOf(v reflect.Value, t reflect.Type, seen map[reflect.Type]bool) *Schema {
+	switch t.Kind() {
+	case reflect.Ptr:
+		if v.IsValid() && !v.IsNil() {
+			return schemaOf(v.Elem(), t.Elem(), seen)
+		}
+		return schemaOf(reflect.Value{}, t.Elem(), seen)
+	case reflect.Interface:
+		if v.IsValid() && !v.IsNil() {
+			return schemaOf(v.Elem(), v.Elem().Type(), seen)
+		}
+		return &Schema{}
+	case reflect.Struct:
+		if t == timeType {
+			return &Schema{Type: "string", Format: "date-time"}
+		}
+		if seen[t] {
+			return &Schema{Type: "object"}
+		}
+		seen[t] = true
+		defer delete(seen, t)
+
+		s := &Schema{
+			Type:       "object",
+			Properties: make(map[string]*Schema),
+		}
+		addFields(s, v, t, seen)
+		sort.Strings(s.Required)
+		return s
+	case reflect.Map:
+		var elem reflect.Value
+		if v.IsValid() && v.Len() > 0 {
+			elem = v.MapIndex(v.MapKeys()[0])
+		}
+		return &Schema{
+			Type:                 "object",
+			AdditionalProperties: schemaOf(elem, t.Elem(), seen),
+		}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		var elem reflect.Value
+		if v.IsValid() && v.Len() > 0 {
+			elem = v.Index(0)
+		}
+		return &Schema{
+			Type:  "array",
+			Items: schemaOf(elem, t.Elem(), seen),
+		}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	}
+
+	return &Schema{}
+}
+
+// addFields adds the json-encoded fields of a struct to its schema. Fields of
+// embedded structs without a json name are promoted, as encoding/json does.
+func addFields(s *Schema, v reflect.Value, t reflect.Type, seen map[reflect.Type]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		var fieldValue reflect.Value
+		if v.IsValid() {
+			fieldValue = v.Field(i)
+		}
+
+		name, opts := tag, ""
+		if idx := strings.Index(tag, ","); idx >= 0 {
+			name, opts = tag[:idx], tag[idx+1:]
+		}
+
+		if field.Anonymous && len(name) == 0 {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+				if fieldValue.IsValid() {
+					if fieldValue.IsNil() {
+						fieldValue = reflect.Value{}
+					} else {
+						fieldValue = fieldValue.Elem()
+					}
+				}
+			}
+			if embedded.Kind() == reflect.Struct {
+				addFields(s, fieldValue, embedded, seen)
+				continue
+			}
+		}
+		if len(field.PkgPath) > 0 {
+			// unexported
+			continue
+		}
+
+		if len(name) == 0 {
+			name = field.Name
+		}
+		s.Properties[name] = schemaOf(fieldValue, field.Type, seen)
+		if !strings.Contains(opts, "omitempty") {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
+
+// Event describes the payload of a socket event
+type Event struct {
+	Description string  `json:"description"`
+	Data        *Schema `json:"data"`
+}
+
+// Document describes every event of the socket protocol. Implements api.ApiCodec.
+type Document struct {
+	Schema      string  `json:"$schema"`
+	Title       string  `json:"title"`
+	Description string  `json:"description"`
+	Version     int     `json:"version"`
+	MinVersion  int     `json:"minVersion"`
+	Message     *Schema `json:"message"`
+	// Inbound are the events clients send to the server
+	Inbound map[string]*Event `json:"inbound"`
+	// Outbound are the events the server sends to clients
+	Outbound map[string]*Event `json:"outbound"`
+}
+
+// AddInbound describes an event sent by clients, given a sample of its payload
+func (d *Document) AddInbound(event, description string, sample interface{}) {
+	d.Inbound[event] = &Event{
+		Description: description,
+		Data:        SchemaOf(sample),
+	}
+}
+
+// AddOutbound describes an event sent by the server, given a sample of its payload
+func (d *Document) AddOutbound(event, description string, sample interface{}) {
+	d.Outbound[event] = &Event{
+		Description: description,
+		Data:        SchemaOf(sample),
+	}
+}
+
+func (d *Document) Serialize() ([]byte, error) {
+	b, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return []byte{}, err
+	}
+
+	return b, nil
+}
+
+// NewDocument returns a protocol Document with no events described
+func NewDocument() *Document {
+	return &Document{
+		Schema:      SCHEMA_DRAFT,
+		Title:       "streaming-server socket protocol",
+		Description: "Every socket message is a json object with an \"event\" name and its \"data\". Clients may add an \"id\" to a message to have the server answer it with an \"ack\" or \"error\" event echoing it.",
+		Version:     VERSION,
+		MinVersion:  MIN_VERSION,
+		Message: &Schema{
+			Type: "object",
+			Properties: map[string]*Schema{
+				"id":    {Type: "string", Description: "optional message id, echoed by replies"},
+				"event": {Type: "string"},
+				"data":  {Type: "object"},
+			},
+			Required: []string{"data", "event"},
+		},
+		Inbound:  make(map[string]*Event),
+		Outbound: make(map[string]*Event),
+	}
+}
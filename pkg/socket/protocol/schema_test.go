@@ -0,0 +1,188 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/juanvallejo/streaming-server/pkg/validation"
+)
+
+// expectSchema fails unless the schema of a value encodes to the given json
+func expectSchema(t *testing.T, v interface{}, golden string) {
+	t.Helper()
+
+	b, err := json.Marshal(SchemaOf(v))
+	if err != nil {
+		t.Fatalf("unable to encode schema: %v", err)
+	}
+
+	var expected, actual interface{}
+	if err := json.Unmarshal([]byte(golden), &expected); err != nil {
+		t.Fatalf("invalid golden schema: %v", err)
+	}
+	if err := json.Unmarshal(b, &actual); err != nil {
+		t.Fatalf("unable to decode schema: %v", err)
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("expected schema\n%s\ngot\n%s", golden, b)
+	}
+}
+
+func TestRequestSchema(t *testing.T) {
+	expectSchema(t, &StreamDataRequest{}, `{
+		"type": "object",
+		"properties": {
+			"name": {"type": "string"},
+			"duration": {"type": "number"},
+			"thumb": {"type": "string"}
+		}
+	}`)
+
+	expectSchema(t, &ChatMessageRequest{}, `{
+		"type": "object",
+		"properties": {
+			"user": {"type": "string"},
+			"message": {"type": "string"}
+		},
+		"required": ["message", "user"]
+	}`)
+}
+
+func TestResponseSchema(t *testing.T) {
+	expectSchema(t, &StreamSchedule{}, `{
+		"type": "object",
+		"properties": {
+			"id": {"type": "integer"},
+			"startsAt": {"type": "string", "format": "date-time"},
+			"remaining": {"type": "number"}
+		},
+		"required": ["id", "remaining", "startsAt"]
+	}`)
+
+	// interfaces are described by the values they hold
+	expectSchema(t, &ChatMethodAction{MethodName: "clear", Args: []interface{}{"all"}}, `{
+		"type": "object",
+		"properties": {
+			"methodname": {"type": "string"},
+			"args": {"type": "array", "items": {"type": "string"}}
+		},
+		"required": ["args", "methodname"]
+	}`)
+	expectSchema(t, &ChatMethodAction{}, `{
+		"type": "object",
+		"properties": {
+			"methodname": {"type": "string"},
+			"args": {"type": "array", "items": {}}
+		},
+		"required": ["args", "methodname"]
+	}`)
+}
+
+type recursiveResponse struct {
+	Name     string               `json:"name"`
+	Children []*recursiveResponse `json:"children,omitempty"`
+	Hidden   string               `json:"-"`
+	hidden   string
+	embeddedExtra
+}
+
+type embeddedExtra struct {
+	Extra map[string]int `json:"extra,omitempty"`
+}
+
+func TestSchemaOfRecursiveAndEmbeddedTypes(t *testing.T) {
+	expectSchema(t, &recursiveResponse{}, `{
+		"type": "object",
+		"properties": {
+			"name": {"type": "string"},
+			"children": {"type": "array", "items": {"type": "object"}},
+			"extra": {"type": "object", "additionalProperties": {"type": "integer"}}
+		},
+		"required": ["name"]
+	}`)
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		requested string
+		expected  int
+		expectErr bool
+	}{
+		{requested: "", expected: MIN_VERSION},
+		{requested: fmt.Sprintf("%v", MIN_VERSION), expected: MIN_VERSION},
+		{requested: fmt.Sprintf("%v", VERSION), expected: VERSION},
+		{requested: fmt.Sprintf("%v", MIN_VERSION-1), expectErr: true},
+		{requested: fmt.Sprintf("%v", VERSION+1), expectErr: true},
+		{requested: "-1", expectErr: true},
+		{requested: "1.0", expectErr: true},
+		{requested: "latest", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.requested, func(t *testing.T) {
+			version, err := Negotiate(tt.requested)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("expected error %v, got %v", tt.expectErr, err)
+			}
+			if version != tt.expected {
+				t.Fatalf("expected version %v, got %v", tt.expected, version)
+			}
+		})
+	}
+}
+
+// rawData is the data of a received socket message
+type rawData string
+
+func (d rawData) Serialize() ([]byte, error) {
+	return []byte(d), nil
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		req       Request
+		expectErr string
+	}{
+		{name: "valid chat message", data: `{"user": "alice", "message": "hi"}`, req: &ChatMessageRequest{}},
+		{name: "unknown fields are ignored", data: `{"message": "hi", "color": "red"}`, req: &ChatMessageRequest{}},
+		{name: "empty chat message", data: `{"user": "alice"}`, req: &ChatMessageRequest{}, expectErr: `field "message" must not be empty`},
+		{name: "chat message too long", data: `{"message": "` + strings.Repeat("a", validation.MaxChatMessageLength+1) + `"}`, req: &ChatMessageRequest{}, expectErr: "may not be longer than"},
+		{name: "wrong field type", data: `{"message": 1}`, req: &ChatMessageRequest{}, expectErr: `field "message" must be of type string, not number`},
+		{name: "malformed json", data: `{"message": `, req: &ChatMessageRequest{}, expectErr: "invalid request"},
+		{name: "username too long", data: `{"user": "` + strings.Repeat("a", validation.MaxClientUsernameLength+1) + `"}`, req: &UpdateUsernameRequest{}, expectErr: `field "user" must not be longer than`},
+		{name: "empty username", data: `{}`, req: &UpdateUsernameRequest{}, expectErr: `field "user" must not be empty`},
+		{name: "partial stream data", data: `{"duration": 12.5}`, req: &StreamDataRequest{}},
+		{name: "negative duration", data: `{"duration": -1}`, req: &StreamDataRequest{}, expectErr: `field "duration" must be a positive number`},
+		{name: "duration of the wrong type", data: `{"duration": "long"}`, req: &StreamDataRequest{}, expectErr: `field "duration" must be of type number, not string`},
+		{name: "stream name too long", data: `{"name": "` + strings.Repeat("a", MAX_STREAM_NAME_LENGTH+1) + `"}`, req: &StreamDataRequest{}, expectErr: `field "name" must not be longer than`},
+		{name: "empty request", data: `{"anything": true}`, req: &EmptyRequest{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Decode(rawData(tt.data), tt.req)
+			if len(tt.expectErr) == 0 {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.expectErr) {
+				t.Fatalf("expected an error containing %q, got %v", tt.expectErr, err)
+			}
+		})
+	}
+
+	req := &StreamDataRequest{}
+	if err := Decode(rawData(`{"name": "movie", "thumb": "http://example.com/thumb.png"}`), req); err != nil {
+		t.Fatalf("unable to decode stream data: %v", err)
+	}
+	if req.Name == nil || *req.Name != "movie" || req.Duration != nil || req.Thumbnail == nil {
+		t.Fatalf("expected only the fields sent to be set, got %+v", req)
+	}
+}
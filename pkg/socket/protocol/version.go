@@ -0,0 +1,37 @@
+package protocol
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const (
+	// VERSION is the newest socket protocol version spoken by the server
+	VERSION = 1
+	// MIN_VERSION is the oldest socket protocol version still spoken by the server
+	MIN_VERSION = 1
+
+	// PROTOCOL_VERSION_KEY is the name of the query parameter a client
+	// requests a protocol version with on a socket connection request
+	PROTOCOL_VERSION_KEY = "protocol"
+)
+
+// Negotiate receives the protocol version requested by a client and returns
+// the version the connection will speak. Clients that request no version
+// speak the oldest supported version. Returns an error if the requested
+// version is not supported.
+func Negotiate(requested string) (int, error) {
+	if len(requested) == 0 {
+		return MIN_VERSION, nil
+	}
+
+	version, err := strconv.Atoi(requested)
+	if err != nil {
+		return 0, fmt.Errorf("invalid protocol version %q: expecting an integer", requested)
+	}
+	if version < MIN_VERSION || version > VERSION {
+		return 0, fmt.Errorf("unsupported protocol version %v: this server supports versions %v through %v", version, MIN_VERSION, VERSION)
+	}
+
+	return version, nil
+}
@@ -0,0 +1,194 @@
+package socket
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/juanvallejo/streaming-server/pkg/socket/client"
+	"github.com/juanvallejo/streaming-server/pkg/socket/protocol"
+)
+
+type receivedMessage struct {
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// sendWithoutId sends a message the way older clients do, with no id field
+func (c *stressClient) sendWithoutId(t *testing.T, event string, data interface{}) {
+	t.Helper()
+
+	b, err := json.Marshal(map[string]interface{}{
+		"event": event,
+		"data":  data,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ws.WriteMessage(websocket.TextMessage, b); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// awaitReply reads messages until the request with the given id is answered.
+// Returns the event carrying the reply, the reply, and every other message
+// received before it.
+func (c *stressClient) awaitReply(t *testing.T, id string) (string, *client.Reply, []receivedMessage) {
+	t.Helper()
+
+	seen := []receivedMessage{}
+	c.ws.SetReadDeadline(time.Now().Add(stressTimeout))
+	for {
+		_, b, err := c.ws.ReadMessage()
+		if err != nil {
+			t.Fatalf("%s: unable to read reply to %q: %v", c.name, id, err)
+		}
+
+		message := receivedMessage{}
+		if err := json.Unmarshal(b, &message); err != nil {
+			t.Fatalf("%s: received a malformed message %q: %v", c.name, b, err)
+		}
+		if message.Event == protocol.EVENT_ACK || message.Event == protocol.EVENT_ERROR {
+			reply := &client.Reply{}
+			if err := json.Unmarshal(message.Data, reply); err != nil {
+				t.Fatalf("%s: received a malformed reply %q: %v", c.name, b, err)
+			}
+			if reply.Id == id {
+				return message.Event, reply, seen
+			}
+		}
+		seen = append(seen, message)
+	}
+}
+
+func TestRepliesEchoRequestIds(t *testing.T) {
+	s := newModerationServer(t, "alice")
+	s.dial(t, "room", "alice")
+	bob, _ := s.dial(t, "room", "bob")
+
+	tests := []struct {
+		name   string
+		id     string
+		event  string
+		data   interface{}
+		result string
+		code   client.ErrorCode
+	}{
+		{
+			name:  "acknowledged request",
+			id:    "rename",
+			event: protocol.EVENT_REQUEST_UPDATEUSERNAME,
+			data:  &protocol.UpdateUsernameRequest{User: "robert"},
+		},
+		{
+			name:   "acknowledged command with a result",
+			id:     "help",
+			event:  protocol.EVENT_REQUEST_CHATMESSAGE,
+			data:   &protocol.ChatMessageRequest{User: "robert", Message: "/help"},
+			result: "Commands",
+		},
+		{
+			name:  "invalid request",
+			id:    "empty-username",
+			event: protocol.EVENT_REQUEST_UPDATEUSERNAME,
+			data:  &protocol.UpdateUsernameRequest{},
+			code:  client.ERR_CODE_INVALID_REQUEST,
+		},
+		{
+			name:  "malformed request",
+			id:    "wrong-type",
+			event: protocol.EVENT_REQUEST_CHATMESSAGE,
+			data:  map[string]interface{}{"message": 1},
+			code:  client.ERR_CODE_INVALID_REQUEST,
+		},
+		{
+			name:  "unknown command",
+			id:    "unknown",
+			event: protocol.EVENT_REQUEST_CHATMESSAGE,
+			data:  &protocol.ChatMessageRequest{User: "robert", Message: "/nosuchcommand"},
+			code:  client.ERR_CODE_UNKNOWN_COMMAND,
+		},
+		{
+			name:  "forbidden command",
+			id:    "forbidden",
+			event: protocol.EVENT_REQUEST_CHATMESSAGE,
+			data:  &protocol.ChatMessageRequest{User: "robert", Message: "/ban alice"},
+			code:  client.ERR_CODE_FORBIDDEN,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := bob.send(tt.id, tt.event, tt.data); err != nil {
+				t.Fatal(err)
+			}
+			event, reply, _ := bob.awaitReply(t, tt.id)
+			if reply.Event != tt.event {
+				t.Fatalf("expected the reply to echo event %q, got %q", tt.event, reply.Event)
+			}
+
+			if len(tt.code) == 0 {
+				if event != protocol.EVENT_ACK || !reply.Ok || reply.Error != nil {
+					t.Fatalf("expected the request to be acknowledged, got %q event with %+v", event, reply)
+				}
+				if !strings.Contains(reply.Result, tt.result) {
+					t.Fatalf("expected the result to contain %q, got %q", tt.result, reply.Result)
+				}
+				return
+			}
+
+			if event != protocol.EVENT_ERROR || reply.Ok || reply.Error == nil {
+				t.Fatalf("expected an error reply, got %q event with %+v", event, reply)
+			}
+			if reply.Error.Code != tt.code || len(reply.Error.Message) == 0 {
+				t.Fatalf("expected an error with code %q, got %+v", tt.code, reply.Error)
+			}
+		})
+	}
+}
+
+func TestRequestsWithoutIdsAreNotAcknowledged(t *testing.T) {
+	s := newModerationServer(t)
+	bob, _ := s.dial(t, "room", "bob")
+
+	// an older client's requests are answered as they were before
+	// message ids existed, with system messages and client errors
+	bob.sendWithoutId(t, protocol.EVENT_REQUEST_UPDATEUSERNAME, &protocol.UpdateUsernameRequest{User: "robert"})
+	bob.sendWithoutId(t, protocol.EVENT_REQUEST_UPDATEUSERNAME, &protocol.UpdateUsernameRequest{})
+	bob.sendWithoutId(t, protocol.EVENT_REQUEST_CHATMESSAGE, &protocol.ChatMessageRequest{User: "robert", Message: "/nosuchcommand"})
+	bob.sendWithoutId(t, protocol.EVENT_REQUEST_CHATMESSAGE, &protocol.ChatMessageRequest{User: "robert", Message: "hello"})
+
+	// messages are handled in order, so every reply to the requests
+	// above is received before the reply to this one
+	if err := bob.send("sentinel", protocol.EVENT_REQUEST_USERLIST, &protocol.EmptyRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	_, _, seen := bob.awaitReply(t, "sentinel")
+
+	var clientErrors, systemMessages, chatMessages int
+	for _, message := range seen {
+		switch message.Event {
+		case protocol.EVENT_ACK, protocol.EVENT_ERROR:
+			t.Fatalf("expected requests without an id not to be replied to, got %q event: %s", message.Event, message.Data)
+		case protocol.EVENT_INFO_CLIENTERROR:
+			clientErrors++
+		case protocol.EVENT_CHATMESSAGE:
+			res := &client.Response{}
+			if err := json.Unmarshal(message.Data, res); err != nil {
+				t.Fatalf("received a malformed chat message %s: %v", message.Data, err)
+			}
+			if res.IsSystem {
+				systemMessages++
+				continue
+			}
+			chatMessages++
+		}
+	}
+
+	if clientErrors != 1 || systemMessages != 1 || chatMessages != 1 {
+		t.Fatalf("expected 1 client error, 1 system message and 1 chat message, got %v, %v and %v", clientErrors, systemMessages, chatMessages)
+	}
+}
@@ -3,17 +3,24 @@ package server
 import (
 	"log"
 	"net/http"
-	"strings"
+	"time"
 
 	"github.com/gorilla/websocket"
 
+	"github.com/juanvallejo/streaming-server/pkg/account"
+	"github.com/juanvallejo/streaming-server/pkg/server/cors"
+	"github.com/juanvallejo/streaming-server/pkg/socket/cmd/rbac"
 	"github.com/juanvallejo/streaming-server/pkg/socket/connection"
+	"github.com/juanvallejo/streaming-server/pkg/socket/protocol"
 	"github.com/juanvallejo/streaming-server/pkg/socket/util"
 )
 
 const (
 	DEFAULT_NAMESPACE = "lobby"
 
+	// MAX_READ_BUF_SIZE and MAX_WRITE_BUF_SIZE are the sizes of the buffers
+	// used to read and write frames, not limits on message sizes; messages
+	// received are limited to connection.MaxMessageSize
 	MAX_READ_BUF_SIZE  = 1024
 	MAX_WRITE_BUF_SIZE = 1024
 )
@@ -60,12 +67,16 @@ func (s *Server) Emit(eventName string, conn connection.Connection) {
 
 // ServeHTTP handles a connection upgrade request, and handles socket connection admission
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	origin := getClientOrigin(r)
-	log.Printf("INF SOCKET handling socket request for ref %q\n", origin)
-
-	// allow specific request origin access with credentials
-	w.Header().Set("Access-Control-Allow-Origin", origin)
-	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	origin := r.Header.Get("Origin")
+	log.Printf("INF SOCKET handling socket request from origin %q\n", origin)
+
+	// browsers send cookies along with websocket requests from any site;
+	// refuse other sites before the request's cookies are acted upon
+	if !cors.Allowed(r) {
+		log.Printf("WRN SOCKET SERVER refusing connection from %q with origin %q\n", r.RemoteAddr, origin)
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
 
 	nsName, err := util.NamespaceFromRequest(r)
 	if err != nil {
@@ -73,25 +84,155 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		log.Printf("ERR SOCKET SERVER unable to obtain a room. Defaulting to %v\n", nsName)
 	}
 
+	// rooms are only created once a connection to them has been
+	// admitted, so that refused requests do not leave empty rooms behind
 	namespace, exists := s.nsHandler.NamespaceByName(nsName)
-	if !exists {
-		log.Printf("INF SOCKET SERVER namespace with name %q did not exist; creating...", nsName)
-		namespace = s.nsHandler.NewNamespace(nsName)
+
+	// identify the browser making the request, so that bans
+	// can apply to it across connections
+	identity := connection.RequestIdentity(r)
+	if len(identity) == 0 {
+		identity, err = util.GenerateIdentity()
+		if err != nil {
+			log.Printf("ERR SOCKET SERVER unable to generate a browser identity: %v\n", err)
+		} else {
+			http.SetCookie(w, &http.Cookie{
+				Name:     connection.IDENTITY_COOKIE_NAME,
+				Value:    identity,
+				Path:     "/",
+				HttpOnly: true,
+				Expires:  time.Now().Add(util.IdentityCookieLifetime),
+			})
+		}
+	}
+
+	version, err := protocol.Negotiate(r.URL.Query().Get(protocol.PROTOCOL_VERSION_KEY))
+	if err != nil {
+		log.Printf("WRN SOCKET SERVER refusing connection to room %q from %q: %v\n", nsName, r.RemoteAddr, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// a connection presenting a valid resume token takes over the
+	// session of a connection that went away from the same browser
+	var resumed *connection.ResumableSession
+	if token := r.URL.Query().Get(connection.RESUME_TOKEN_KEY); len(token) > 0 && exists {
+		resumed, err = namespace.Sessions().Resume(token, identity)
+		if err != nil {
+			log.Printf("WRN SOCKET SERVER unable to resume session in room %q for %q: %v; starting a new one...\n", nsName, r.RemoteAddr, err)
+		}
+	}
+
+	connId := ""
+	if resumed != nil {
+		connId = resumed.ConnectionId
+	}
+
+	// admit the connection before upgrading it, so that a
+	// rejected request receives a plain http error response.
+	// A resumed session was already admitted, and a room that
+	// does not exist yet has no bans, password or invites.
+	var invite *connection.Invite
+	if exists {
+		if ban, banned := namespace.Moderation().Banned(connId, identity, connection.RemoteAddress(r)); banned && !s.isRoomAdmin(namespace, identity, r) {
+			log.Printf("WRN SOCKET SERVER refusing connection to room %q from banned client %q (%s)\n", nsName, ban.Subject, r.RemoteAddr)
+			http.Error(w, "you have been banned from this room", http.StatusForbidden)
+			return
+		}
+
+		if resumed != nil {
+			invite = resumed.Invite
+		} else {
+			invite, err = namespace.Access().Admit(r.URL.Query().Get(connection.ACCESS_PASSWORD_KEY), r.URL.Query().Get(connection.ACCESS_INVITE_KEY))
+			if err != nil {
+				log.Printf("WRN SOCKET SERVER refusing connection to room %q from %q: %v\n", nsName, r.RemoteAddr, err)
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+		}
+	} else if len(r.URL.Query().Get(connection.ACCESS_INVITE_KEY)) > 0 {
+		log.Printf("WRN SOCKET SERVER refusing connection to room %q from %q: invite for a room that does not exist\n", nsName, r.RemoteAddr)
+		http.Error(w, "invalid invite token", http.StatusForbidden)
+		return
 	}
 
 	conn, err := websocket.Upgrade(w, r, w.Header(), MAX_READ_BUF_SIZE, MAX_WRITE_BUF_SIZE)
 	if err != nil {
-		log.Printf("ERR SOCKET SERVER unable to upgrade connection for %q: %v\n", r.URL.String(), err)
+		// the request's query may hold a room password or invite; do not log it
+		log.Printf("ERR SOCKET SERVER unable to upgrade connection for %q: %v\n", r.URL.Path, err)
 		return
 	}
 
-	socketConn := s.connHandler.NewConnection("", conn, w, r)
+	if !exists {
+		log.Printf("INF SOCKET SERVER namespace with name %q did not exist; creating...", nsName)
+		namespace = s.nsHandler.NewNamespace(nsName)
+	}
+
+	socketConn := s.connHandler.NewConnection(connId, conn, w, r)
+	socketConn.Metadata().SetIdentity(identity)
+	socketConn.Metadata().SetProtocolVersion(version)
+	if resumed != nil {
+		log.Printf("INF SOCKET SERVER connection with id (%s) resumed its session in room %q\n", socketConn.UUID(), nsName)
+		socketConn.Metadata().SetResumed(resumed)
+	}
+	if accounts := s.connHandler.Accounts(); accounts != nil && cors.Credentialed(r) {
+		if cookie, err := r.Cookie(account.SESSION_COOKIE_NAME); err == nil {
+			if a, ok := accounts.Authenticate(cookie.Value); ok {
+				log.Printf("INF SOCKET SERVER connection with id (%s) authenticated as account %q\n", socketConn.UUID(), a.Username)
+				socketConn.Metadata().SetAccount(a.Username)
+			}
+		}
+	}
+	if invite != nil {
+		log.Printf("INF SOCKET SERVER connection with id (%s) admitted into room %q with an invite\n", socketConn.UUID(), nsName)
+		socketConn.Metadata().SetInvite(invite)
+	}
 	socketConn.Join(namespace.Name())
 
 	s.Emit("connection", socketConn)
 	s.connHandler.Handle(socketConn)
 }
 
+// isRoomAdmin returns a boolean (true) if a request is made on behalf of
+// an admin of the given namespace: from a browser with a connection bound
+// to the admin role in it, or with the session of an account whose roles
+// in it include admin. Admins are never refused by bans, which may cover
+// an address they share with the banned user.
+func (s *Server) isRoomAdmin(namespace connection.Namespace, identity string, r *http.Request) bool {
+	authorizer := s.connHandler.Authorizer()
+	if authorizer == nil {
+		return false
+	}
+
+	isAdmin := func(roles []string) bool {
+		for _, role := range roles {
+			if role == rbac.ADMIN_ROLE {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(identity) > 0 {
+		for _, conn := range namespace.Connections() {
+			if conn.Metadata().Identity() == identity && isAdmin(rbac.SubjectRoleNames(authorizer.Bindings(), conn)) {
+				return true
+			}
+		}
+	}
+
+	if accounts := s.connHandler.Accounts(); accounts != nil && cors.Credentialed(r) {
+		if cookie, err := r.Cookie(account.SESSION_COOKIE_NAME); err == nil {
+			if a, ok := accounts.Authenticate(cookie.Value); ok {
+				roles, _ := accounts.RoomRoles(a.Username, namespace.Name())
+				return isAdmin(roles)
+			}
+		}
+	}
+
+	return false
+}
+
 func NewServer(handler connection.ConnectionHandler, nsHandler connection.NamespaceHandler) *Server {
 	return &Server{
 		callbacks:   make(map[string][]ServerEventCallback),
@@ -99,20 +240,3 @@ func NewServer(handler connection.ConnectionHandler, nsHandler connection.Namesp
 		nsHandler:   nsHandler,
 	}
 }
-
-// retrieve a client's origin consisting of
-// protocol://hostname:port for a given request.
-// if a given request had no easily disernable
-// origin path, a wildcard origin is returned.
-func getClientOrigin(r *http.Request) string {
-	origin := "*"
-	clientPath := r.Referer()
-
-	clientProto := strings.Split(clientPath, "://")
-	if len(clientProto) > 1 {
-		clientHost := strings.Split(clientProto[1], "/")
-		origin = clientProto[0] + "://" + clientHost[0]
-	}
-
-	return origin
-}
@@ -0,0 +1,280 @@
+package socket
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/juanvallejo/streaming-server/pkg/playback"
+	"github.com/juanvallejo/streaming-server/pkg/socket/client"
+	"github.com/juanvallejo/streaming-server/pkg/socket/cmd"
+	"github.com/juanvallejo/streaming-server/pkg/socket/cmd/rbac"
+	"github.com/juanvallejo/streaming-server/pkg/socket/connection"
+	"github.com/juanvallejo/streaming-server/pkg/socket/protocol"
+	"github.com/juanvallejo/streaming-server/pkg/stream"
+)
+
+const (
+	stressConnections = 300
+	stressRooms       = 10
+	stressTimeout     = 60 * time.Second
+)
+
+// newStressServer serves a socket handler wired the way the server
+// wires it with rbac authorization enabled
+func newStressServer(t *testing.T) (*Handler, *httptest.Server) {
+	// every simulated connection comes from the same address
+	factor := connection.IPRateLimitFactor
+	connection.IPRateLimitFactor = stressConnections
+	t.Cleanup(func() {
+		connection.IPRateLimitFactor = factor
+	})
+
+	authorizer := rbac.NewAuthorizer()
+	cmd.AddDefaultRoles(authorizer)
+
+	nsHandler := connection.NewNamespaceHandler()
+	h := NewHandler(
+		nsHandler,
+		connection.NewHandlerWithRBAC(authorizer, nsHandler),
+		cmd.NewHandlerWithRBAC(authorizer),
+		client.NewHandler(),
+		playback.NewGarbageCollectedHandler(nsHandler),
+		stream.NewGarbageCollectedHandler(),
+	)
+
+	// browsers fetch their roles once connected; bind
+	// the user role to every connection instead
+	userRole, _ := authorizer.Role(rbac.USER_ROLE)
+	h.server.On("connection", func(conn connection.Connection) {
+		authorizer.Bind(userRole, conn)
+	})
+
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return h, srv
+}
+
+// stressClient is a simulated browser connected to a room
+type stressClient struct {
+	name string
+	ws   *websocket.Conn
+}
+
+func dialStressClient(srv *httptest.Server, room, name string) (*stressClient, error) {
+	u := "ws" + strings.TrimPrefix(srv.URL, "http") + "/v/" + room + "?" + protocol.PROTOCOL_VERSION_KEY + "=" + fmt.Sprintf("%v", protocol.VERSION)
+	ws, _, err := websocket.DefaultDialer.Dial(u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: unable to connect to room %q: %v", name, room, err)
+	}
+	return &stressClient{
+		name: name,
+		ws:   ws,
+	}, nil
+}
+
+func (c *stressClient) send(id, event string, data interface{}) error {
+	b, err := json.Marshal(map[string]interface{}{
+		"id":    id,
+		"event": event,
+		"data":  data,
+	})
+	if err != nil {
+		return err
+	}
+	return c.ws.WriteMessage(websocket.TextMessage, b)
+}
+
+// awaitReplies reads messages until every request with the given ids
+// has been acknowledged, failing on any error reply
+func (c *stressClient) awaitReplies(ids ...string) error {
+	pending := map[string]bool{}
+	for _, id := range ids {
+		pending[id] = true
+	}
+
+	c.ws.SetReadDeadline(time.Now().Add(stressTimeout))
+	for len(pending) > 0 {
+		_, b, err := c.ws.ReadMessage()
+		if err != nil {
+			return fmt.Errorf("%s: unable to read replies to %v: %v", c.name, pending, err)
+		}
+
+		message := struct {
+			Event string          `json:"event"`
+			Data  json.RawMessage `json:"data"`
+		}{}
+		if err := json.Unmarshal(b, &message); err != nil {
+			return fmt.Errorf("%s: received a malformed message %q: %v", c.name, b, err)
+		}
+		if message.Event != protocol.EVENT_ACK && message.Event != protocol.EVENT_ERROR {
+			continue
+		}
+
+		reply := &client.Reply{}
+		if err := json.Unmarshal(message.Data, reply); err != nil {
+			return fmt.Errorf("%s: received a malformed reply %q: %v", c.name, b, err)
+		}
+		if !reply.Ok {
+			return fmt.Errorf("%s: request %q (%s) failed: %v", c.name, reply.Id, reply.Event, reply.Error)
+		}
+		delete(pending, reply.Id)
+	}
+	return nil
+}
+
+// run sends a burst of every kind of request a browser makes
+// while watching a room, and waits for every reply
+func (c *stressClient) run() error {
+	requests := []struct {
+		event string
+		data  interface{}
+	}{
+		{event: protocol.EVENT_REQUEST_UPDATEUSERNAME, data: &protocol.UpdateUsernameRequest{User: c.name}},
+		{event: protocol.EVENT_REQUEST_CHATMESSAGE, data: &protocol.ChatMessageRequest{User: c.name, Message: "hello from " + c.name}},
+		{event: protocol.EVENT_REQUEST_USERLIST, data: &protocol.EmptyRequest{}},
+		{event: protocol.EVENT_REQUEST_STREAMSYNC, data: &protocol.EmptyRequest{}},
+		{event: protocol.EVENT_REQUEST_QUEUESYNC, data: &protocol.EmptyRequest{}},
+		{event: protocol.EVENT_REQUEST_STACKSYNC, data: &protocol.EmptyRequest{}},
+		{event: protocol.EVENT_REQUEST_CHATMESSAGE, data: &protocol.ChatMessageRequest{User: c.name, Message: "/help"}},
+	}
+
+	ids := []string{}
+	for i, req := range requests {
+		id := fmt.Sprintf("%s-%v", c.name, i)
+		if err := c.send(id, req.event, req.data); err != nil {
+			return fmt.Errorf("%s: unable to send %q: %v", c.name, req.event, err)
+		}
+		ids = append(ids, id)
+	}
+	return c.awaitReplies(ids...)
+}
+
+// waitForClients waits until the amount of registered clients is n
+func waitForClients(t *testing.T, h *Handler, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(stressTimeout)
+	for h.clientHandler.GetClientSize() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %v clients to be registered, got %v", n, h.clientHandler.GetClientSize())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestStressConcurrentConnections connects hundreds of clients to a
+// handful of rooms at once, has them all send requests concurrently,
+// and disconnects them, half abruptly. Run it with -race.
+func TestStressConcurrentConnections(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping stress test in short mode")
+	}
+
+	h, srv := newStressServer(t)
+
+	var (
+		wg      sync.WaitGroup
+		mux     sync.Mutex
+		clients []*stressClient
+	)
+	t.Cleanup(func() {
+		for _, c := range clients {
+			c.ws.Close()
+		}
+	})
+	errs := make(chan error, stressConnections)
+	for i := 0; i < stressConnections; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			c, err := dialStressClient(srv, fmt.Sprintf("room-%v", i%stressRooms), fmt.Sprintf("user%v", i))
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			mux.Lock()
+			clients = append(clients, c)
+			mux.Unlock()
+
+			if err := c.run(); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+	if t.Failed() {
+		t.FailNow()
+	}
+	waitForClients(t, h, stressConnections)
+
+	// leave concurrently, while every other client is still being sent
+	// the departures; half the clients go away without a close frame
+	for i, c := range clients {
+		wg.Add(1)
+		go func(i int, c *stressClient) {
+			defer wg.Done()
+			if i%2 == 0 {
+				c.ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			}
+			c.ws.Close()
+		}(i, c)
+	}
+	wg.Wait()
+
+	waitForClients(t, h, 0)
+}
+
+// TestStressConnectionChurn has clients repeatedly join and leave rooms
+// while others in them keep sending requests. Run it with -race.
+func TestStressConnectionChurn(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping stress test in short mode")
+	}
+
+	h, srv := newStressServer(t)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, stressConnections)
+	for i := 0; i < stressConnections; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			// each simulated browser reconnects once
+			for j := 0; j < 2; j++ {
+				c, err := dialStressClient(srv, fmt.Sprintf("room-%v", i%stressRooms), fmt.Sprintf("user%vx%v", i, j))
+				if err != nil {
+					errs <- err
+					return
+				}
+				err = c.run()
+				c.ws.Close()
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+	waitForClients(t, h, 0)
+}
@@ -1,6 +1,8 @@
 package util
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -8,25 +10,48 @@ import (
 	"path"
 	"runtime"
 	"strings"
+	"sync"
 
 	"time"
 
+	"github.com/juanvallejo/streaming-server/pkg/account"
 	api "github.com/juanvallejo/streaming-server/pkg/api/types"
 	"github.com/juanvallejo/streaming-server/pkg/socket/client"
 	"github.com/juanvallejo/streaming-server/pkg/socket/cmd/rbac"
 	"github.com/juanvallejo/streaming-server/pkg/socket/connection"
+	"github.com/juanvallejo/streaming-server/pkg/socket/protocol"
 	"github.com/juanvallejo/streaming-server/pkg/validation"
 )
 
 const ROOM_URL_SEGMENT = "/v/"
 
-// TODO: make this function concurrency-safe
-func UpdateClientUsername(c *client.Client, username string, clientHandler client.SocketClientHandler) error {
+// IdentityCookieLifetime is the amount of time a browser identity cookie is kept for
+var IdentityCookieLifetime = 24 * time.Hour * 365
+
+// usernameMux serializes username updates, so that two clients
+// cannot both claim a free username at the same time
+var usernameMux sync.Mutex
+
+// UpdateClientUsername validates and sets a client's username. Updates
+// are serialized across all clients.
+// If user accounts are enabled, usernames belonging to an account
+// may only be used by connections logged in to that account.
+func UpdateClientUsername(c *client.Client, username string, clientHandler client.SocketClientHandler, accounts account.Manager) error {
 	err := validation.ValidateClientUsername(username)
 	if err != nil {
 		return err
 	}
 
+	if accounts != nil && accounts.IsReserved(username) {
+		loggedIn, hasAccount := c.Connection().Metadata().Account()
+		if !hasAccount || !strings.EqualFold(loggedIn, username) {
+			return fmt.Errorf("error: the username %q belongs to a registered account; log in to use it", username)
+		}
+	}
+
+	usernameMux.Lock()
+	defer usernameMux.Unlock()
+
 	prevName, hasPrevName := c.GetUsername()
 
 	log.Printf("INF SOCKET CLIENT client with id %q requested a username update (%q -> %q)", c.UUID(), prevName, username)
@@ -56,7 +81,7 @@ func UpdateClientUsername(c *client.Client, username string, clientHandler clien
 	}
 
 	log.Printf("INF SOCKET CLIENT sending \"updateusername\" event to client with id %q (%s)\n", c.UUID(), username)
-	c.BroadcastTo("updateusername", &client.Response{
+	c.BroadcastTo(protocol.EVENT_UPDATEUSERNAME, &client.Response{
 		From: username,
 	})
 
@@ -65,12 +90,12 @@ func UpdateClientUsername(c *client.Client, username string, clientHandler clien
 		isNewUser = "true"
 	}
 
-	c.BroadcastFrom("info_updateusername", &client.Response{
+	c.BroadcastFrom(protocol.EVENT_INFO_UPDATEUSERNAME, &client.Response{
 		Id:   c.UUID(),
 		From: username,
-		Extra: map[string]interface{}{
-			"oldUser":   prevName,
-			"isNewUser": isNewUser,
+		Extra: &protocol.UsernameUpdate{
+			OldUser:   prevName,
+			IsNewUser: isNewUser,
 		},
 		IsSystem: true,
 	})
@@ -81,7 +106,7 @@ func UpdateClientUsername(c *client.Client, username string, clientHandler clien
 // GetRoomNameFromRequest receives a socket connection request and returns
 // a fully-qualified room name from the request's referer information
 func NamespaceFromRequest(req *http.Request) (string, error) {
-	segs := strings.Split(req.URL.String(), ROOM_URL_SEGMENT)
+	segs := strings.Split(req.URL.Path, ROOM_URL_SEGMENT)
 	if len(segs) > 1 {
 		return segs[1], nil
 	}
@@ -126,7 +151,7 @@ func rolesFromCookie(r *http.Request, authorizer rbac.Authorizer, namespace conn
 		//}
 
 		for _, r := range ns.Roles {
-			if role, exists := authorizer.Role(r); exists {
+			if role, exists := rbac.NamespaceRole(authorizer, namespace.Name(), r); exists {
 				roles = append(roles, role)
 			}
 		}
@@ -144,12 +169,21 @@ func rolesFromCookie(r *http.Request, authorizer rbac.Authorizer, namespace conn
 // The following rules will be followed (in the given order) when determining which roles to return:
 //  - If no other connections are bound to the given namespace, an "admin" role will be assigned
 //    and forced onto the connection - regardless of previously stored data on an existing auth cookie.
+//  - If the connection resumed the session of a connection that went away, and the computed role
+//    based on the namespace state is not "admin", the roles bound to the previous connection will
+//    be forced onto the connection.
+//  - If the connection was admitted into the namespace with an invite that names a role, and the
+//    computed role based on the namespace state is not "admin", the invited role will be forced
+//    onto the connection.
+//  - If the connection is logged in to an account with roles stored for the namespace, or with
+//    roles mapped from its identity provider groups, and the computed role based on the namespace
+//    state is not "admin", the account's roles will be forced onto the connection.
 //  - If auth information was previously stored in an auth cookie, and the computed role based on
 //    the namespace state is not "admin", the stored roles will be forced onto the connection.
 //  - If there is no previously stored information for the given namespace in an auth cookie, or
 //    the auth cookie does not exist, and there is at least one other connection assigned to the
 //    given namespace, a "user" role will be forced onto the connection.
-func DefaultRoles(r *http.Request, authorizer rbac.Authorizer, connUUID string, namespace connection.Namespace) ([]rbac.Role, error) {
+func DefaultRoles(r *http.Request, authorizer rbac.Authorizer, accounts account.Manager, connUUID string, namespace connection.Namespace) ([]rbac.Role, error) {
 	if authorizer == nil {
 		return []rbac.Role{}, fmt.Errorf("attempt to assign default roles to user (%s) with no authorizer enabled", connUUID)
 	}
@@ -178,6 +212,55 @@ func DefaultRoles(r *http.Request, authorizer rbac.Authorizer, connUUID string,
 		return []rbac.Role{role}, nil
 	}
 
+	// a resumed session keeps the roles its connection had before going away
+	if conn, exists := namespace.Connection(connUUID); exists {
+		if session, resumed := conn.Metadata().Resumed(); resumed && len(session.Roles) > 0 {
+			roles := []rbac.Role{}
+			for _, name := range session.Roles {
+				if r, found := rbac.NamespaceRole(authorizer, namespace.Name(), name); found {
+					roles = append(roles, r)
+				}
+			}
+			if len(roles) > 0 {
+				log.Printf("INF SOCKET SERVER AUTHZ connection with id (%s) resumed its session. Restoring its previous roles...", connUUID)
+				return roles, nil
+			}
+		}
+	}
+
+	// an invite naming a role takes precedence over roles stored in the
+	// auth cookie, as it was issued specifically for this namespace.
+	if conn, exists := namespace.Connection(connUUID); exists {
+		if invite, invited := conn.Metadata().Invite(); invited && len(invite.Role) > 0 {
+			if invitedRole, found := rbac.NamespaceRole(authorizer, namespace.Name(), invite.Role); found {
+				log.Printf("INF SOCKET SERVER AUTHZ connection with id (%s) was invited with role %q. Ignoring previously stored roles in auth cookie...", connUUID, invite.Role)
+				return []rbac.Role{invitedRole}, nil
+			}
+			log.Printf("WRN SOCKET SERVER AUTHZ connection with id (%s) was invited with role %q, but no such role exists", connUUID, invite.Role)
+		}
+	}
+
+	// roles stored for a logged-in account take precedence over roles
+	// stored in the auth cookie, which only identifies a browser.
+	if accounts != nil {
+		if conn, exists := namespace.Connection(connUUID); exists {
+			if username, loggedIn := conn.Metadata().Account(); loggedIn {
+				if names, hasRoles := accounts.RoomRoles(username, namespace.Name()); hasRoles {
+					roles := []rbac.Role{}
+					for _, name := range names {
+						if r, found := rbac.NamespaceRole(authorizer, namespace.Name(), name); found {
+							roles = append(roles, r)
+						}
+					}
+					if len(roles) > 0 {
+						log.Printf("INF SOCKET SERVER AUTHZ found roles for account %q in room %q. Ignoring previously stored roles in auth cookie...", username, namespace.Name())
+						return roles, nil
+					}
+				}
+			}
+		}
+	}
+
 	// return role data saved in cookie - if not,
 	// compute default roles based on given data
 	roles, err := rolesFromCookie(r, authorizer, namespace)
@@ -190,6 +273,15 @@ func DefaultRoles(r *http.Request, authorizer rbac.Authorizer, connUUID string,
 	return []rbac.Role{role}, nil
 }
 
+// GenerateIdentity returns a random identifier for a browser
+func GenerateIdentity() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
 func GenerateAuthCookie(cookieData *rbac.AuthCookieData) (*http.Cookie, error) {
 	data, err := cookieData.Serialize()
 	if err != nil {
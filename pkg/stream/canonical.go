@@ -0,0 +1,208 @@
+package stream
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/juanvallejo/streaming-server/pkg/timeutil"
+)
+
+// StreamLocation is the canonical form of a stream resource location.
+// Differently-formatted urls pointing to the same resource (youtu.be/ID,
+// m.youtube.com/watch?v=ID, ...) resolve to the same canonical Url, which
+// is used as the stream's unique identifier.
+type StreamLocation struct {
+	// Url is the canonical resource locator for a stream
+	Url string
+	// StartTime is an optional offset, in seconds, parsed
+	// from a start-time parameter found in the original url
+	StartTime float64
+}
+
+// Canonicalize receives a stream resource location (web url or filepath)
+// and returns its canonical StreamLocation. Resource locations that are
+// not web urls are returned unchanged.
+// Returns an error if a web url for a known provider is malformed.
+func Canonicalize(streamUrl string) (*StreamLocation, error) {
+	streamUrl = strings.TrimSpace(streamUrl)
+
+	u, err := url.Parse(streamUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	if scheme != "http" && scheme != "https" {
+		return &StreamLocation{Url: streamUrl}, nil
+	}
+
+	switch canonicalHost(u.Host) {
+	case "youtube.com", "youtu.be", "youtube-nocookie.com":
+		return canonicalizeYouTube(u)
+	case "soundcloud.com":
+		return canonicalizeSoundCloud(u)
+	case "twitch.tv":
+		return canonicalizeTwitch(u)
+	case "clips-media-assets.twitch.tv":
+		return canonicalizeTwitchClip(u)
+	}
+
+	// remote urls keep their query parameters, as they may be part of
+	// the resource's location (signed urls, etc.). Only the fragment
+	// is dropped, and parsed as a media-fragment start time.
+	loc := &StreamLocation{}
+	loc.StartTime = startTimeFromFragment(u.Fragment)
+
+	u.Scheme = scheme
+	u.Host = strings.ToLower(u.Host)
+	u.Fragment = ""
+	loc.Url = u.String()
+	return loc, nil
+}
+
+// CanonicalId receives a stream id or resource location and returns
+// the unique identifier of the stream it refers to, or the given id
+// unchanged if it cannot be canonicalized.
+func CanonicalId(id string) string {
+	loc, err := Canonicalize(id)
+	if err != nil {
+		return id
+	}
+	return loc.Url
+}
+
+// canonicalHost lowercases a url host and
+// removes any port and "www." or "m." prefixes
+func canonicalHost(host string) string {
+	host = strings.ToLower(host)
+	if idx := strings.LastIndex(host, ":"); idx >= 0 {
+		host = host[:idx]
+	}
+
+	for _, prefix := range []string{"www.", "m.", "music."} {
+		if strings.HasPrefix(host, prefix) {
+			return strings.TrimPrefix(host, prefix)
+		}
+	}
+	return host
+}
+
+func canonicalizeYouTube(u *url.URL) (*StreamLocation, error) {
+	params := u.Query()
+	segs := strings.Split(strings.Trim(u.Path, "/"), "/")
+
+	id := ""
+	if canonicalHost(u.Host) == "youtu.be" {
+		id = segs[0]
+	} else if segs[0] == "watch" {
+		id = params.Get("v")
+	} else if len(segs) > 1 {
+		switch segs[0] {
+		case "embed", "v", "shorts", "live":
+			id = segs[1]
+		}
+	}
+
+	if len(id) == 0 {
+		return nil, fmt.Errorf("unable to find a video id in YouTube url %q", u.String())
+	}
+
+	loc := &StreamLocation{
+		Url: "https://www.youtube.com/watch?v=" + id,
+	}
+
+	for _, key := range []string{"t", "start"} {
+		if t := params.Get(key); len(t) > 0 {
+			loc.StartTime = parseStartTime(t)
+			break
+		}
+	}
+	if loc.StartTime == 0 {
+		loc.StartTime = startTimeFromFragment(u.Fragment)
+	}
+
+	return loc, nil
+}
+
+func canonicalizeSoundCloud(u *url.URL) (*StreamLocation, error) {
+	path := strings.TrimRight(u.Path, "/")
+	if len(path) == 0 {
+		return nil, fmt.Errorf("unable to find a track in SoundCloud url %q", u.String())
+	}
+
+	// SoundCloud urls use a "#t=1:23" fragment as a start-time
+	return &StreamLocation{
+		Url:       "https://soundcloud.com" + path,
+		StartTime: startTimeFromFragment(u.Fragment),
+	}, nil
+}
+
+func canonicalizeTwitch(u *url.URL) (*StreamLocation, error) {
+	path := strings.ToLower(strings.TrimRight(u.Path, "/"))
+	if len(path) == 0 {
+		return nil, fmt.Errorf("unable to find a video or channel in Twitch url %q", u.String())
+	}
+
+	loc := &StreamLocation{
+		Url: "https://www.twitch.tv" + path,
+	}
+	if t := u.Query().Get("t"); len(t) > 0 {
+		loc.StartTime = parseStartTime(t)
+	}
+
+	return loc, nil
+}
+
+func canonicalizeTwitchClip(u *url.URL) (*StreamLocation, error) {
+	clip := u.Query().Get("clip")
+	if len(clip) == 0 {
+		return nil, fmt.Errorf("invalid Twitch clip url. Missing ?clip= parameter")
+	}
+
+	return &StreamLocation{
+		Url: "https://clips-media-assets.twitch.tv" + u.Path + "?clip=" + url.QueryEscape(clip),
+	}, nil
+}
+
+// startTimeFromFragment parses a "t=<time>" url fragment
+// and returns its value in seconds, or 0 if none is found.
+func startTimeFromFragment(fragment string) float64 {
+	for _, pair := range strings.Split(fragment, "&") {
+		if strings.HasPrefix(pair, "t=") {
+			// media fragments may define a range: "t=10,20"
+			return parseStartTime(strings.Split(strings.TrimPrefix(pair, "t="), ",")[0])
+		}
+	}
+
+	return 0
+}
+
+// parseStartTime receives a start time in one of the formats
+// "90", "90s", "1m30s", "1h2m3s" or "1:30", and returns its
+// value in seconds. Unparseable start times are ignored.
+func parseStartTime(t string) float64 {
+	if secs, err := strconv.ParseFloat(t, 64); err == nil && secs > 0 {
+		return secs
+	}
+
+	if strings.Contains(t, ":") {
+		secs := 0
+		for _, seg := range strings.Split(t, ":") {
+			n, err := strconv.Atoi(seg)
+			if err != nil || n < 0 {
+				return 0
+			}
+			secs = secs*60 + n
+		}
+		return float64(secs)
+	}
+
+	secs, err := timeutil.HumanTimeToSeconds(t)
+	if err != nil {
+		return 0
+	}
+
+	return float64(secs)
+}
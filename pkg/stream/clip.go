@@ -0,0 +1,208 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	api "github.com/juanvallejo/streaming-server/pkg/api/types"
+)
+
+// StreamRange describes the portion of a stream to play,
+// as start and end offsets in seconds. An end offset of 0
+// means the stream plays until the end of its duration.
+type StreamRange struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+// IsZero returns a boolean (true) if the range covers the entire stream
+func (r StreamRange) IsZero() bool {
+	return r.Start == 0 && r.End == 0
+}
+
+func (r StreamRange) String() string {
+	if r.End == 0 {
+		return fmt.Sprintf("%v-", r.Start)
+	}
+	return fmt.Sprintf("%v-%v", r.Start, r.End)
+}
+
+// ParseStreamRange receives a range of the form "<start>-<end>", where
+// both offsets may use any format accepted by a url start-time parameter
+// ("90", "1m30s", "1:30"), and either offset may be omitted: "12m-18m",
+// "12m-", "-18m". A single offset with no "-" is treated as a start offset.
+// Returns an error if an offset cannot be parsed, or if the end offset
+// is not after the start offset.
+func ParseStreamRange(r string) (StreamRange, error) {
+	segs := strings.SplitN(r, "-", 2)
+
+	var err error
+	rng := StreamRange{}
+	if rng.Start, err = parseOffset(segs[0]); err != nil {
+		return StreamRange{}, err
+	}
+	if len(segs) > 1 {
+		if rng.End, err = parseOffset(segs[1]); err != nil {
+			return StreamRange{}, err
+		}
+	}
+
+	if rng.End > 0 && rng.End <= rng.Start {
+		return StreamRange{}, fmt.Errorf("the end of a range (%v) must come after its start (%v)", segs[1], segs[0])
+	}
+
+	return rng, nil
+}
+
+func parseOffset(t string) (float64, error) {
+	if len(t) == 0 {
+		return 0, nil
+	}
+	if secs, err := strconv.ParseFloat(t, 64); err == nil && secs >= 0 {
+		return secs, nil
+	}
+
+	secs := parseStartTime(t)
+	if secs == 0 {
+		return 0, fmt.Errorf("unable to parse time offset %q", t)
+	}
+	return secs, nil
+}
+
+// RangeOf returns the playable range of the given stream.
+// Returns an empty range if the stream is not a ClippedStream.
+func RangeOf(s Stream) StreamRange {
+	if clip, ok := s.(*ClippedStream); ok {
+		return clip.Range
+	}
+	return StreamRange{}
+}
+
+// ClippedStream implements Stream and wraps a Stream with a range to play.
+// Clips of the same stream with different ranges have different ids, and
+// can coexist in a queue. A ClippedStream keeps its own labelled refs, so
+// that each clip tracks the user that queued it.
+type ClippedStream struct {
+	Stream
+	Range StreamRange
+
+	meta StreamMeta
+}
+
+// UUID returns the wrapped stream's id, suffixed with a media-fragment
+// describing the clip range.
+func (s *ClippedStream) UUID() string {
+	if s.Range.End == 0 {
+		return fmt.Sprintf("%s#t=%s", s.Stream.UUID(), strconv.FormatFloat(s.Range.Start, 'f', -1, 64))
+	}
+	return fmt.Sprintf("%s#t=%s,%s", s.Stream.UUID(), strconv.FormatFloat(s.Range.Start, 'f', -1, 64), strconv.FormatFloat(s.Range.End, 'f', -1, 64))
+}
+
+// GetStartTime returns the start of the clip range
+func (s *ClippedStream) GetStartTime() float64 {
+	return s.Range.Start
+}
+
+// GetDuration returns the clip's end offset as the stream's
+// effective duration, if it ends before the wrapped stream does.
+func (s *ClippedStream) GetDuration() float64 {
+	duration := s.Stream.GetDuration()
+	if s.Range.End > 0 && (duration <= 0 || s.Range.End < duration) {
+		return s.Range.End
+	}
+	return duration
+}
+
+func (s *ClippedStream) Metadata() StreamMeta {
+	return s.meta
+}
+
+func (s *ClippedStream) Codec() api.ApiCodec {
+	return s
+}
+
+func (s *ClippedStream) Serialize() ([]byte, error) {
+	return json.Marshal(s)
+}
+
+// MarshalJSON serializes the wrapped stream's fields,
+// along with the clip's id and range.
+func (s *ClippedStream) MarshalJSON() ([]byte, error) {
+	data, err := s.Stream.Codec().Serialize()
+	if err != nil {
+		return []byte{}, err
+	}
+
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return []byte{}, err
+	}
+
+	fields["id"] = s.UUID()
+	fields["startTime"] = s.Range.Start
+	fields["range"] = s.Range
+	return json.Marshal(fields)
+}
+
+// clippedStreamMeta implements StreamMeta. Parent refs and
+// update times are shared with the wrapped stream's metadata,
+// so that a clipped stream keeps its source from being reaped.
+// labelledRefs is guarded by mux.
+type clippedStreamMeta struct {
+	StreamMeta
+
+	mux          sync.RWMutex
+	labelledRefs map[string]StreamRef
+}
+
+func (m *clippedStreamMeta) SetLabelledRef(key string, value StreamRef) bool {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	_, exists := m.labelledRefs[key]
+	m.labelledRefs[key] = value
+	return !exists
+}
+
+func (m *clippedStreamMeta) GetLabelledRef(key string) (StreamRef, bool) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+
+	ref, exists := m.labelledRefs[key]
+	return ref, exists
+}
+
+func (m *clippedStreamMeta) RemoveLabelledRef(key string) bool {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if _, exists := m.labelledRefs[key]; exists {
+		delete(m.labelledRefs, key)
+		return true
+	}
+	return false
+}
+
+// NewClippedStream receives a Stream and a range and returns a Stream
+// that plays only the given range. The given Stream is returned unchanged
+// if the range covers the entire stream.
+func NewClippedStream(s Stream, r StreamRange) Stream {
+	if r.IsZero() {
+		return s
+	}
+	if clip, ok := s.(*ClippedStream); ok {
+		s = clip.Stream
+	}
+
+	return &ClippedStream{
+		Stream: s,
+		Range:  r,
+		meta: &clippedStreamMeta{
+			StreamMeta:   s.Metadata(),
+			labelledRefs: make(map[string]StreamRef),
+		},
+	}
+}
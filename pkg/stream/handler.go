@@ -6,13 +6,14 @@ import (
 	"net/url"
 	"os"
 	"strings"
+	"sync"
 
 	paths "github.com/juanvallejo/streaming-server/pkg/server/path"
 )
 
 type StreamHandler interface {
 	// GetStream returns a registered stream by the given url
-	// a url is used as a stream's unique identifier.
+	// a canonical url is used as a stream's unique identifier.
 	// Returns a Stream object or a bool (false) if a stream
 	// does not exist by the given url.
 	GetStream(string) (Stream, bool)
@@ -31,30 +32,44 @@ type StreamHandler interface {
 }
 
 // Handler provides a convenience set of methods for
-// managing supported stream instances
+// managing supported stream instances. Streams are created
+// by command goroutines and removed by the stream reaper;
+// streams is guarded by mux.
 type Handler struct {
 	isGarbageCollected bool
 	garbageCollector   *StreamReaper
-	streams            map[string]Stream
+
+	mux     sync.RWMutex
+	streams map[string]Stream
 }
 
 // GetStream retrieves a stream by its assigned url
 // or a bool (false) if a stream does not exist by the
-// given resource location
+// given resource location. The given url is canonicalized
+// before it is looked up.
 func (h *Handler) GetStream(url string) (Stream, bool) {
-	s, exists := h.streams[url]
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+
+	s, exists := h.streams[CanonicalId(url)]
 	return s, exists
 }
 
 func (h *Handler) ReapStream(s Stream) bool {
-	if _, exists := h.streams[s.GetStreamURL()]; exists {
-		delete(h.streams, s.GetStreamURL())
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	if _, exists := h.streams[s.UUID()]; exists {
+		delete(h.streams, s.UUID())
 		return exists
 	}
 	return false
 }
 
 func (h *Handler) GetStreams() []Stream {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+
 	streams := []Stream{}
 	for _, s := range h.streams {
 		streams = append(streams, s)
@@ -63,6 +78,9 @@ func (h *Handler) GetStreams() []Stream {
 }
 
 func (h *Handler) GetSize() int {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+
 	return len(h.streams)
 }
 
@@ -83,8 +101,31 @@ func (h *Handler) initGarbageCollector() {
 }
 
 // NewStream receives a url and resolves it
-// into a specific supported stream type
-func (h *Handler) NewStream(streamUrl string) (Stream, error) {
+// into a specific supported stream type.
+// Streams are registered under their canonical url.
+func (h *Handler) NewStream(rawUrl string) (Stream, error) {
+	loc, err := Canonicalize(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	// hold the lock from the existence check in newStream until
+	// the stream is registered, so a url is only registered once
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	s, err := h.newStream(loc.Url)
+	if err != nil {
+		return nil, err
+	}
+
+	s.SetStartTime(loc.StartTime)
+	h.streams[s.UUID()] = s
+	return s, nil
+}
+
+// newStream resolves a url into a stream; callers must hold the handler lock
+func (h *Handler) newStream(streamUrl string) (Stream, error) {
 	if _, exists := h.streams[streamUrl]; exists {
 		return nil, fmt.Errorf("error: a stream with resource location %q has already been registered", streamUrl)
 	}
@@ -95,34 +136,20 @@ func (h *Handler) NewStream(streamUrl string) (Stream, error) {
 	}
 
 	if u.Scheme == "http" || u.Scheme == "https" {
-		host := u.Host
-		segs := strings.Split(u.Host, "www.")
-		if len(segs) > 1 {
-			host = segs[1]
-		}
-
-		switch host {
-		case "youtube.com", "youtu.be", "m.youtube.com":
-			s := NewYouTubeStream(streamUrl)
-			h.streams[streamUrl] = s
-			return s, nil
+		switch canonicalHost(u.Host) {
+		case "youtube.com", "youtu.be", "youtube-nocookie.com":
+			return NewYouTubeStream(streamUrl), nil
 		case "api.soundcloud.com", "soundcloud.com":
-			s := NewSoundCloudStream(streamUrl)
-			h.streams[streamUrl] = s
-			return s, nil
+			return NewSoundCloudStream(streamUrl), nil
 		case "twitch.tv":
-			s := NewTwitchStream(streamUrl)
-			h.streams[streamUrl] = s
-			return s, nil
+			return NewTwitchStream(streamUrl), nil
 		case "clips-media-assets.twitch.tv":
 			params := u.Query()
 			if len(params.Get("clip")) == 0 {
 				return nil, fmt.Errorf("invalid Twitch clip url. Missing ?clip= parameter")
 			}
 
-			s := NewTwitchClipStream(streamUrl)
-			h.streams[streamUrl] = s
-			return s, nil
+			return NewTwitchClipStream(streamUrl), nil
 		default:
 			// handle remote urls
 			supportedFormats := map[string]bool{
@@ -133,9 +160,7 @@ func (h *Handler) NewStream(streamUrl string) (Stream, error) {
 
 			format := paths.FileExtensionFromFilePath(u.Path)
 			if supported, ok := supportedFormats[strings.ToLower(format)]; ok && supported {
-				s := NewRemoteVideoStream(streamUrl)
-				h.streams[streamUrl] = s
-				return s, nil
+				return NewRemoteVideoStream(streamUrl), nil
 			}
 		}
 
@@ -160,9 +185,7 @@ func (h *Handler) NewStream(streamUrl string) (Stream, error) {
 		return nil, fmt.Errorf("unable to load %q: %v", streamUrl, err)
 	}
 
-	s := NewLocalVideoStream(streamUrl)
-	h.streams[streamUrl] = s
-	return s, nil
+	return NewLocalVideoStream(streamUrl), nil
 }
 
 func NewHandler() StreamHandler {
@@ -8,6 +8,7 @@ import (
 	"net/url"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/imkira/go-libav/avformat"
@@ -15,7 +16,7 @@ import (
 	apiconfig "github.com/juanvallejo/streaming-server/pkg/api/config"
 	api "github.com/juanvallejo/streaming-server/pkg/api/types"
 	pathutil "github.com/juanvallejo/streaming-server/pkg/server/path"
-	"github.com/juanvallejo/streaming-server/pkg/socket/cmd/util"
+	"github.com/juanvallejo/streaming-server/pkg/timeutil"
 )
 
 const (
@@ -95,7 +96,8 @@ type StreamMeta interface {
 	GetLabelledRef(string) (StreamRef, bool)
 }
 
-// StreamMetaSchema implements StreamMeta
+// StreamMetaSchema implements StreamMeta. Streams are shared by every
+// room playing them, and read by the stream reaper; fields are guarded by mux.
 type StreamMetaSchema struct {
 	// CreationSource is extra info about the stream source
 	CreationSource StreamCreationSource
@@ -106,25 +108,42 @@ type StreamMetaSchema struct {
 	// LabelledRefs store an object reference to the
 	// Stream object under a given string label key.
 	LabelledRefs map[string]StreamRef
+
+	mux sync.RWMutex
 }
 
 func (s *StreamMetaSchema) GetCreationSource() StreamCreationSource {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
 	return s.CreationSource
 }
 
 func (s *StreamMetaSchema) SetCreationSource(source StreamCreationSource) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
 	s.CreationSource = source
 }
 
 func (s *StreamMetaSchema) SetLastUpdated(t time.Time) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
 	s.LastUpdated = t
 }
 
 func (s *StreamMetaSchema) GetLastUpdated() time.Time {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
 	return s.LastUpdated
 }
 
 func (s *StreamMetaSchema) GetParentRefs() []StreamRef {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
 	refs := []StreamRef{}
 	for _, r := range s.ParentRefs {
 		refs = append(refs, r)
@@ -133,6 +152,9 @@ func (s *StreamMetaSchema) GetParentRefs() []StreamRef {
 }
 
 func (s *StreamMetaSchema) AddParentRef(ref StreamRef) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
 	if _, exists := s.ParentRefs[ref.UUID()]; exists {
 		return false
 	}
@@ -141,6 +163,9 @@ func (s *StreamMetaSchema) AddParentRef(ref StreamRef) bool {
 }
 
 func (s *StreamMetaSchema) RemoveParentRef(ref StreamRef) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
 	if _, exists := s.ParentRefs[ref.UUID()]; exists {
 		delete(s.ParentRefs, ref.UUID())
 		return true
@@ -149,6 +174,9 @@ func (s *StreamMetaSchema) RemoveParentRef(ref StreamRef) bool {
 }
 
 func (s *StreamMetaSchema) SetLabelledRef(key string, value StreamRef) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
 	if _, exists := s.LabelledRefs[key]; exists {
 		s.LabelledRefs[key] = value
 		return false
@@ -159,6 +187,9 @@ func (s *StreamMetaSchema) SetLabelledRef(key string, value StreamRef) bool {
 }
 
 func (s *StreamMetaSchema) GetLabelledRef(key string) (StreamRef, bool) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
 	if ref, exists := s.LabelledRefs[key]; exists {
 		return ref, true
 	}
@@ -167,6 +198,9 @@ func (s *StreamMetaSchema) GetLabelledRef(key string) (StreamRef, bool) {
 }
 
 func (s *StreamMetaSchema) RemoveLabelledRef(key string) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
 	if _, exists := s.LabelledRefs[key]; exists {
 		delete(s.LabelledRefs, key)
 		return true
@@ -175,6 +209,24 @@ func (s *StreamMetaSchema) RemoveLabelledRef(key string) bool {
 	return false
 }
 
+// MarshalJSON serializes the metadata's fields while holding its lock
+func (s *StreamMetaSchema) MarshalJSON() ([]byte, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	return json.Marshal(&struct {
+		CreationSource StreamCreationSource
+		LastUpdated    time.Time `json:"lastUpdated"`
+		ParentRefs     map[string]StreamRef
+		LabelledRefs   map[string]StreamRef
+	}{
+		CreationSource: s.CreationSource,
+		LastUpdated:    s.LastUpdated,
+		ParentRefs:     s.ParentRefs,
+		LabelledRefs:   s.LabelledRefs,
+	})
+}
+
 func NewStreamMeta() StreamMeta {
 	return &StreamMetaSchema{
 		CreationSource: &UnknownStreamCreationSourceSchema{},
@@ -201,6 +253,11 @@ type Stream interface {
 	GetKind() string
 	// GetDuration returns the stream's saved duration
 	GetDuration() float64
+	// GetStartTime returns the start-time offset, in seconds,
+	// parsed from the url the stream was created from
+	GetStartTime() float64
+	// SetStartTime receives a start-time offset in seconds
+	SetStartTime(float64)
 	// Codec returns a serializable representation of the
 	// current stream
 	Codec() api.ApiCodec
@@ -225,38 +282,84 @@ type StreamSchema struct {
 	Url string `json:"url"`
 	// Duration is the total time for the current stream
 	Duration float64 `json:"duration"`
+	// StartTime is an offset, in seconds, parsed from a
+	// start-time parameter in the stream's original url
+	StartTime float64 `json:"startTime"`
 	// Thumbnail is a url pointing to a still of the stream
 	Thumbnail string `json:"thumb"`
 	// Metadata stores Stream abject meta information
 	Meta StreamMeta `json:"metadata"`
+
+	// mux guards the fields above against SetInfo, as
+	// streams are shared by every room playing them
+	mux sync.RWMutex
+}
+
+// streamInfo holds the fields of a StreamSchema set by SetInfo
+type streamInfo struct {
+	Kind      string  `json:"kind"`
+	Name      string  `json:"name"`
+	Url       string  `json:"url"`
+	Duration  float64 `json:"duration"`
+	StartTime float64 `json:"startTime"`
+	Thumbnail string  `json:"thumb"`
 }
 
 func (s *StreamSchema) GetStreamURL() string {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
 	return s.Url
 }
 
 func (s *StreamSchema) UUID() string {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
 	return s.Url
 }
 
 func (s *StreamSchema) GetName() string {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
 	return s.Name
 }
 
 func (s *StreamSchema) GetKind() string {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
 	return s.Kind
 }
 
 func (s *StreamSchema) GetDuration() float64 {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
 	return s.Duration
 }
 
+func (s *StreamSchema) GetStartTime() float64 {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	return s.StartTime
+}
+
+func (s *StreamSchema) SetStartTime(t float64) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.StartTime = t
+}
+
 func (s *StreamSchema) Metadata() StreamMeta {
 	return s.Meta
 }
 
 func (s *StreamSchema) FetchMetadata(callback StreamMetadataCallback) {
-	callback(s, nil, fmt.Errorf("Stream schema of kind %q has no FetchMetadata method implemented.", s.Kind))
+	callback(s, nil, fmt.Errorf("Stream schema of kind %q has no FetchMetadata method implemented.", s.GetKind()))
 }
 
 func (s *StreamSchema) Serialize() ([]byte, error) {
@@ -268,9 +371,54 @@ func (s *StreamSchema) Serialize() ([]byte, error) {
 	return b, nil
 }
 
+// MarshalJSON serializes the stream's fields under its lock, as
+// streams are also serialized as items of every queue holding them
+func (s *StreamSchema) MarshalJSON() ([]byte, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	return json.Marshal(&struct {
+		streamInfo
+		Meta StreamMeta `json:"metadata"`
+	}{
+		streamInfo: streamInfo{
+			Kind:      s.Kind,
+			Name:      s.Name,
+			Url:       s.Url,
+			Duration:  s.Duration,
+			StartTime: s.StartTime,
+			Thumbnail: s.Thumbnail,
+		},
+		Meta: s.Meta,
+	})
+}
+
 func (s *StreamSchema) SetInfo(data []byte) error {
 	s.Meta.SetLastUpdated(time.Now())
-	return json.Unmarshal(data, s)
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	// stream metadata is not set from stream info
+	info := &streamInfo{
+		Kind:      s.Kind,
+		Name:      s.Name,
+		Url:       s.Url,
+		Duration:  s.Duration,
+		StartTime: s.StartTime,
+		Thumbnail: s.Thumbnail,
+	}
+	if err := json.Unmarshal(data, info); err != nil {
+		return err
+	}
+
+	s.Kind = info.Kind
+	s.Name = info.Name
+	s.Url = info.Url
+	s.Duration = info.Duration
+	s.StartTime = info.StartTime
+	s.Thumbnail = info.Thumbnail
+	return nil
 }
 
 func (s *StreamSchema) Codec() api.ApiCodec {
@@ -314,7 +462,7 @@ func (yt *YouTubeVideoItem) ParseDuration() error {
 		return fmt.Errorf("invalid time format")
 	}
 
-	timeSecs, err := util.HumanTimeToSeconds(segs[1])
+	timeSecs, err := timeutil.HumanTimeToSeconds(segs[1])
 	if err != nil {
 		return err
 	}
@@ -324,7 +472,7 @@ func (yt *YouTubeVideoItem) ParseDuration() error {
 }
 
 func (s *YouTubeStream) FetchMetadata(callback StreamMetadataCallback) {
-	videoId, err := ytVideoIdFromUrl(s.Url)
+	videoId, err := ytVideoIdFromUrl(s.GetStreamURL())
 	if err != nil {
 		callback(s, []byte{}, err)
 		return
@@ -413,7 +561,7 @@ type LocalVideoStream struct {
 
 func (s *LocalVideoStream) FetchMetadata(callback StreamMetadataCallback) {
 	go func(s *LocalVideoStream, callback StreamMetadataCallback) {
-		data, err := FetchVideoMetadata(pathutil.StreamDataFilePathFromUrl(s.Url))
+		data, err := FetchVideoMetadata(pathutil.StreamDataFilePathFromUrl(s.GetStreamURL()))
 		if err != nil {
 			callback(s, []byte{}, err)
 			return
@@ -467,7 +615,7 @@ func NewLocalVideoStream(filepath string) Stream {
 
 func (s *RemoteVideoStream) FetchMetadata(callback StreamMetadataCallback) {
 	go func(s *RemoteVideoStream, callback StreamMetadataCallback) {
-		data, err := FetchVideoMetadata(s.Url)
+		data, err := FetchVideoMetadata(s.GetStreamURL())
 		if err != nil {
 			callback(s, []byte{}, err)
 			return
@@ -518,7 +666,7 @@ type TwitchResponseItemThumbnail struct {
 type TwitchVideoItem map[string]interface{}
 
 func (s *TwitchStream) FetchMetadata(callback StreamMetadataCallback) {
-	videoId, err := twitchVideoIdFromUrl(s.Url)
+	videoId, err := twitchVideoIdFromUrl(s.GetStreamURL())
 	if err != nil {
 		callback(s, []byte{}, err)
 		return
@@ -616,7 +764,7 @@ type TwitchClipResponseVod struct {
 type TwitchClipItem map[string]interface{}
 
 func (s *TwitchClipStream) FetchMetadata(callback StreamMetadataCallback) {
-	videoId, err := twitchClipIdFromUrl(s.Url)
+	videoId, err := twitchClipIdFromUrl(s.GetStreamURL())
 	if err != nil {
 		callback(s, []byte{}, err)
 		return
@@ -744,7 +892,7 @@ func (s *SoundCloudStream) FetchMetadata(callback StreamMetadataCallback) {
 		}
 
 		callback(s, jsonData, nil)
-	}(s.Url, s.apiKey, callback)
+	}(s.GetStreamURL(), s.apiKey, callback)
 }
 
 func NewSoundCloudStream(videoUrl string) Stream {
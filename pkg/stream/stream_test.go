@@ -0,0 +1,101 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeRef string
+
+func (r fakeRef) UUID() string {
+	return string(r)
+}
+
+// TestSharedStreamConcurrentAccess exercises a stream shared by several
+// rooms and the reaper at once; run with -race.
+func TestSharedStreamConcurrentAccess(t *testing.T) {
+	s := NewRemoteVideoStream("http://example.com/video.mp4")
+	clip := NewClippedStream(s, StreamRange{Start: 10, End: 20})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		room := fakeRef(fmt.Sprintf("room-%v", i))
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				s.Metadata().AddParentRef(room)
+				s.Metadata().SetLabelledRef(room.UUID(), room)
+				clip.Metadata().SetLabelledRef(room.UUID(), room)
+				if err := s.SetInfo([]byte(fmt.Sprintf(`{"name": "video %v", "duration": %v}`, j, j))); err != nil {
+					t.Errorf("unexpected error: %v", err)
+					return
+				}
+				s.Metadata().RemoveLabelledRef(room.UUID())
+				clip.Metadata().RemoveLabelledRef(room.UUID())
+				s.Metadata().RemoveParentRef(room)
+			}
+		}()
+	}
+
+	// the reaper and api readers
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < 200; j++ {
+			s.Metadata().GetParentRefs()
+			s.Metadata().GetLastUpdated()
+			s.GetDuration()
+			clip.GetDuration()
+			clip.Metadata().GetLabelledRef("room-0")
+			if _, err := clip.Codec().Serialize(); err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			// queues serialize the streams they hold as items
+			if _, err := json.Marshal([]Stream{s, clip}); err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+
+	if refs := s.Metadata().GetParentRefs(); len(refs) != 0 {
+		t.Fatalf("expected every parent ref to have been removed, got %v", len(refs))
+	}
+}
+
+func TestSetInfoKeepsMetadata(t *testing.T) {
+	s := NewRemoteVideoStream("http://example.com/video.mp4")
+	s.Metadata().AddParentRef(fakeRef("room"))
+	before := s.Metadata().GetLastUpdated()
+	time.Sleep(time.Millisecond)
+
+	if err := s.SetInfo([]byte(`{"name": "video", "duration": 42, "metadata": {"ParentRefs": {}}}`)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.GetName() != "video" || s.GetDuration() != 42 {
+		t.Fatalf("expected stream info to be set, got name %q and duration %v", s.GetName(), s.GetDuration())
+	}
+	if s.GetStreamURL() != "http://example.com/video.mp4" {
+		t.Fatalf("expected fields missing from stream info to be kept, got url %q", s.GetStreamURL())
+	}
+	if len(s.Metadata().GetParentRefs()) != 1 {
+		t.Fatalf("expected stream info not to replace stream metadata")
+	}
+	if !s.Metadata().GetLastUpdated().After(before) {
+		t.Fatalf("expected setting stream info to update the stream's last update time")
+	}
+
+	if err := s.SetInfo([]byte(`{"duration": "long"}`)); err == nil {
+		t.Fatalf("expected an error for malformed stream info")
+	}
+	if s.GetDuration() != 42 {
+		t.Fatalf("expected malformed stream info to be discarded, got duration %v", s.GetDuration())
+	}
+}
@@ -3,14 +3,25 @@ package validation
 import (
 	"fmt"
 	"regexp"
+	"unicode/utf8"
 )
 
 var ClientValidationPattern string = "^[a-zA-Z_0-9]+$"
 var ClientValidation *regexp.Regexp
 
+const (
+	// MaxClientUsernameLength is the max amount of characters in a username
+	MaxClientUsernameLength = 32
+	// MaxChatMessageLength is the max amount of characters in a chat message
+	MaxChatMessageLength = 1000
+)
+
 // ValidateClientUsername receives a username and returns an error if it does not comply
-// with ClientValidationPattern
+// with ClientValidationPattern, or is longer than MaxClientUsernameLength
 func ValidateClientUsername(name string) error {
+	if utf8.RuneCountInString(name) > MaxClientUsernameLength {
+		return fmt.Errorf("error: usernames may not be longer than %v characters", MaxClientUsernameLength)
+	}
 	if !ClientValidation.MatchString(name) {
 		return fmt.Errorf("error: username %q is invalid", name)
 	}
@@ -18,6 +29,19 @@ func ValidateClientUsername(name string) error {
 	return nil
 }
 
+// ValidateChatMessage receives the text of a chat message and returns
+// an error if it is empty, or longer than MaxChatMessageLength
+func ValidateChatMessage(message string) error {
+	if len(message) == 0 {
+		return fmt.Errorf("error: chat messages may not be empty")
+	}
+	if utf8.RuneCountInString(message) > MaxChatMessageLength {
+		return fmt.Errorf("error: chat messages may not be longer than %v characters", MaxChatMessageLength)
+	}
+
+	return nil
+}
+
 func init() {
 	var err error
 	ClientValidation, err = regexp.Compile(ClientValidationPattern)